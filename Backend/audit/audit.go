@@ -0,0 +1,279 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"main/helpers"
+	"main/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Append-only security audit trail (logins, token refreshes, password changes, status changes and admin actions)
+
+Every entry stores the hash of the previous one and its own hash (sha256 of the previous hash + its fields)
+so editing or deleting a row in the middle breaks the chain, Verify walks the chain to detect it.
+UPDATE and DELETE are also blocked by triggers on the table.
+*/
+
+// Audited actions
+const (
+	ActionRegister       = "auth.register"
+	ActionLoginSuccess   = "auth.login_success"
+	ActionLoginFailure   = "auth.login_failure"
+	ActionTokenRefresh   = "auth.token_refresh"
+	ActionPasswordChange = "auth.password_change"
+	ActionUnauthorized   = "auth.unauthorized" // Invalid / expired token on a protected route (requests without one aren't audited)
+	ActionForbidden      = "auth.forbidden"    // Valid token without the required role
+
+	ActionSelfExclusion = "player.self_exclusion"
+	ActionCoolOff       = "player.cool_off"
+
+	ActionReferralRejected = "player.referral_rejected" // Registration with a referral code that looked like a self-referral
+
+	ActionAdminRequest      = "admin.request" // Every request made by staff
+	ActionStatusChange      = "admin.status_change"
+	ActionAdjustment        = "admin.adjustment_propose"
+	ActionAdjustmentApply   = "admin.adjustment_approve"
+	ActionAdjustmentDeny    = "admin.adjustment_reject"
+	ActionBonusGrant        = "admin.bonus_grant"
+	ActionPromoCreate       = "admin.promo_create"
+	ActionCashbackRun       = "admin.cashback_run"
+	ActionTournamentCreate  = "admin.tournament_create"
+	ActionWithdrawalApprove = "admin.withdrawal_approve"
+	ActionWithdrawalReject  = "admin.withdrawal_reject"
+	ActionKYCReview         = "admin.kyc_review"
+	ActionCurrencyUpdate    = "admin.currency_update"
+	ActionRiskFlagReview    = "admin.risk_flag_review"
+)
+
+// Hash of the "previous entry" of the first entry
+const genesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+type Entry struct {
+	ID        int    `json:"id"`
+	ActorID   int    `json:"actorId"`  // Player / staff who did it (0 if unknown, e.g. failed login)
+	Action    string `json:"action"`   // One of the Action constants
+	TargetID  int    `json:"targetId"` // Player affected by the action (0 if none)
+	IP        string `json:"ip"`
+	UserAgent string `json:"userAgent"`
+	Details   string `json:"details"`   // JSON object with action specific data
+	CreatedAt string `json:"createdAt"` // RFC3339, stored as text so it hashes the same way it was written
+	PrevHash  string `json:"prevHash"`
+	Hash      string `json:"hash"`
+}
+
+type Filter struct {
+	ActorID  int
+	TargetID int
+	Action   string // Prefix match, e.g. "admin." for every admin action
+	IP       string
+	From     time.Time // Zero for no lower bound
+	To       time.Time // Zero for no upper bound
+	Limit    int
+	Offset   int
+}
+
+// Entries must be chained in insertion order
+var mu sync.Mutex
+
+// InitializeTable creates the audit table and the triggers that make it append-only
+func InitializeTable() {
+	query := `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		actorId INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		targetId INTEGER NOT NULL,
+		ip TEXT NOT NULL,
+		userAgent TEXT NOT NULL,
+		details TEXT NOT NULL,
+		createdAt TEXT NOT NULL,
+		prevHash TEXT NOT NULL,
+		hash TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS audit_log_actor ON audit_log (actorId);
+	CREATE INDEX IF NOT EXISTS audit_log_target ON audit_log (targetId);
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit log is append-only');
+	END;
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit log is append-only');
+	END;`
+
+	_, err := models.DB.Exec(query)
+	if err != nil {
+		log.Fatal("Error creating audit_log table:", err)
+	}
+
+	fmt.Println("TABLE Audit Log Initialized Successfully")
+}
+
+// Record appends an entry to the audit trail, the request (optional) provides the IP and user agent
+// Failing to audit is logged but doesn't fail the audited action
+func Record(r *http.Request, actorID int, action string, targetID int, details map[string]interface{}) {
+	entry := Entry{
+		ActorID:  actorID,
+		Action:   action,
+		TargetID: targetID,
+		Details:  "{}",
+	}
+
+	if r != nil {
+		entry.IP = helpers.ClientIP(r)
+		entry.UserAgent = r.UserAgent()
+	}
+
+	if details != nil {
+		if stringifiedDetails, err := json.Marshal(details); err == nil {
+			entry.Details = string(stringifiedDetails)
+		}
+	}
+
+	if err := appendEntry(entry); err != nil {
+		log.Println("Error recording audit entry:", action, err)
+	}
+}
+
+func appendEntry(entry Entry) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	entry.PrevHash = genesisHash
+	err := models.DB.QueryRow(`SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1;`).Scan(&entry.PrevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	entry.CreatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	entry.Hash = computeHash(entry)
+
+	query := `INSERT INTO audit_log (actorId, action, targetId, ip, userAgent, details, createdAt, prevHash, hash)
+	          VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	_, err = models.DB.Exec(query, entry.ActorID, entry.Action, entry.TargetID, entry.IP, entry.UserAgent, entry.Details, entry.CreatedAt, entry.PrevHash, entry.Hash)
+
+	return err
+}
+
+// computeHash hashes the previous hash together with every field of the entry
+func computeHash(entry Entry) string {
+	fields := []string{
+		entry.PrevHash,
+		strconv.Itoa(entry.ActorID),
+		entry.Action,
+		strconv.Itoa(entry.TargetID),
+		entry.IP,
+		entry.UserAgent,
+		entry.Details,
+		entry.CreatedAt,
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x1f"))) // Unit separator so fields can't be shifted around
+	return hex.EncodeToString(sum[:])
+}
+
+// Format the from / to bounds are compared in, what SQLite's strftime gives for the stored timestamps
+// (createdAt is RFC3339Nano, which drops trailing zeros, so its text doesn't sort by time)
+const boundFormat = "2006-01-02T15:04:05.000"
+
+// Query returns the entries matching the filter, newest first
+func Query(filter Filter) ([]Entry, error) {
+	from, to := "", ""
+	if !filter.From.IsZero() {
+		from = filter.From.UTC().Format(boundFormat)
+	}
+	if !filter.To.IsZero() {
+		to = filter.To.UTC().Format(boundFormat)
+	}
+
+	query := `SELECT id, actorId, action, targetId, ip, userAgent, details, createdAt, prevHash, hash
+	          FROM audit_log
+	          WHERE (? = 0 OR actorId = ?)
+	            AND (? = 0 OR targetId = ?)
+	            AND (? = '' OR action LIKE ? || '%')
+	            AND (? = '' OR ip = ?)
+	            AND (? = '' OR strftime('%Y-%m-%dT%H:%M:%f', createdAt) >= ?)
+	            AND (? = '' OR strftime('%Y-%m-%dT%H:%M:%f', createdAt) <= ?)
+	          ORDER BY id DESC LIMIT ? OFFSET ?;`
+
+	rows, err := models.DB.Query(query,
+		filter.ActorID, filter.ActorID,
+		filter.TargetID, filter.TargetID,
+		filter.Action, filter.Action,
+		filter.IP, filter.IP,
+		from, from,
+		to, to,
+		filter.Limit, filter.Offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching audit entries: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var entry Entry
+		if err := rows.Scan(&entry.ID, &entry.ActorID, &entry.Action, &entry.TargetID, &entry.IP, &entry.UserAgent, &entry.Details, &entry.CreatedAt, &entry.PrevHash, &entry.Hash); err != nil {
+			return nil, fmt.Errorf("error reading audit entry: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// Verify walks the whole chain and returns the ID of the first tampered entry (0 if the chain is intact)
+// and how many entries were checked
+func Verify() (int, int, error) {
+	// Highest ID ever handed out, to notice entries removed from the end of the chain
+	var lastIssuedID int
+	err := models.DB.QueryRow(`SELECT seq FROM sqlite_sequence WHERE name = 'audit_log';`).Scan(&lastIssuedID)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, 0, fmt.Errorf("error fetching audit sequence: %v", err)
+	}
+
+	rows, err := models.DB.Query(`SELECT id, actorId, action, targetId, ip, userAgent, details, createdAt, prevHash, hash FROM audit_log ORDER BY id;`)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error fetching audit entries: %v", err)
+	}
+	defer rows.Close()
+
+	previousHash := genesisHash
+	previousID := 0
+	checked := 0
+	for rows.Next() {
+		var entry Entry
+		if err := rows.Scan(&entry.ID, &entry.ActorID, &entry.Action, &entry.TargetID, &entry.IP, &entry.UserAgent, &entry.Details, &entry.CreatedAt, &entry.PrevHash, &entry.Hash); err != nil {
+			return 0, checked, fmt.Errorf("error reading audit entry: %v", err)
+		}
+		checked++
+
+		// Either the entry was edited or the one before it was removed / edited
+		if entry.ID != previousID+1 || entry.PrevHash != previousHash || computeHash(entry) != entry.Hash {
+			return entry.ID, checked, nil
+		}
+
+		previousHash = entry.Hash
+		previousID = entry.ID
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, checked, err
+	}
+
+	if lastIssuedID > previousID {
+		return previousID + 1, checked, nil
+	}
+
+	return 0, checked, nil
+}
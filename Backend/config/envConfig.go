@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -18,6 +21,64 @@ var (
 	PROCESSING_DURATION     float32
 	JWT_SECRET              string
 	JWT_DURATION_IN_HOURS   float32
+
+	ADJUSTMENT_APPROVAL_THRESHOLD float32
+
+	LIMIT_INCREASE_COOLING_OFF_HOURS float32
+	REALITY_CHECK_INTERVAL_MINUTES   float32
+
+	BONUS_WAGERING_MULTIPLIER float32
+	BONUS_EXPIRY_DAYS         float32
+
+	REFERRAL_REFERRER_REWARD  float32
+	REFERRAL_REFERRED_REWARD  float32
+	REFERRAL_QUALIFYING_WAGER float32
+
+	LOYALTY_TIER_WINDOW_DAYS float32
+
+	CASHBACK_DESTINATION            string
+	CASHBACK_CHECK_INTERVAL_MINUTES float32
+
+	TOURNAMENT_PAYOUT_TABLE           []float32
+	TOURNAMENT_CHECK_INTERVAL_SECONDS float32
+
+	DICE_ROOMS           []string
+	ROOM_BETTING_SECONDS float32
+
+	CRASH_BETTING_SECONDS   float32
+	CRASH_HOUSE_EDGE        float32
+	CRASH_TICK_MILLISECONDS int
+
+	JACKPOT_CONTRIBUTION_PERCENT float32
+	JACKPOT_ODDS                 int
+	JACKPOT_SEED                 float32
+
+	TRANSFER_DAILY_LIMIT float32
+
+	PAYMENT_PROVIDER                  string
+	PAYMENT_WEBHOOK_SECRET            string
+	PAYMENT_WEBHOOK_URL               string
+	MOCK_GATEWAY_PORT                 string
+	MOCK_GATEWAY_AUTO_CONFIRM_SECONDS float32
+
+	WITHDRAWAL_AUTO_APPROVE_THRESHOLD float32
+
+	KYC_WITHDRAWAL_THRESHOLD float32
+	KYC_UPLOAD_DIR           string
+	KYC_MAX_DOCUMENT_MB      float32
+
+	BASE_CURRENCY string
+	CURRENCIES    []string
+
+	RISK_RULES_FILE string
+
+	HTTP_RATE_LIMITS             map[string]RateLimit
+	WS_RATE_LIMITS               map[string]RateLimit
+	WS_RATE_LIMIT_MAX_VIOLATIONS int
+
+	ALLOWED_ORIGINS []string
+
+	WS_TICKET_SECONDS float32
 )
 
 // LoadConfig reads environment variables from .env file
@@ -65,6 +126,277 @@ func LoadConfig() {
 		JWT_DURATION_IN_HOURS = 1 // Default timeout
 	}
 
+	// Manual balance adjustments above this amount (credit or debit) need a second admin's approval
+	if value, err := strconv.ParseFloat(os.Getenv("ADJUSTMENT_APPROVAL_THRESHOLD"), 32); err == nil {
+		ADJUSTMENT_APPROVAL_THRESHOLD = float32(value)
+	} else {
+		ADJUSTMENT_APPROVAL_THRESHOLD = 100 // Default threshold
+	}
+
+	// Responsible gaming limit increases / removals only take effect after this cooling-off period
+	if value, err := strconv.ParseFloat(os.Getenv("LIMIT_INCREASE_COOLING_OFF_HOURS"), 32); err == nil {
+		LIMIT_INCREASE_COOLING_OFF_HOURS = float32(value)
+	} else {
+		LIMIT_INCREASE_COOLING_OFF_HOURS = 24 // Default cooling-off
+	}
+
+	// Reality check reminders pushed over the play / wallet sockets (0 disables them)
+	if value, err := strconv.ParseFloat(os.Getenv("REALITY_CHECK_INTERVAL_MINUTES"), 32); err == nil {
+		REALITY_CHECK_INTERVAL_MINUTES = float32(value)
+	} else {
+		REALITY_CHECK_INTERVAL_MINUTES = 60 // Default interval
+	}
+
+	// Bonus money must be staked this many times before it's released to the bet balance
+	if value, err := strconv.ParseFloat(os.Getenv("BONUS_WAGERING_MULTIPLIER"), 32); err == nil {
+		BONUS_WAGERING_MULTIPLIER = float32(value)
+	} else {
+		BONUS_WAGERING_MULTIPLIER = 30 // Default wagering requirement
+	}
+
+	// Bonuses whose wagering isn't completed within this period expire
+	if value, err := strconv.ParseFloat(os.Getenv("BONUS_EXPIRY_DAYS"), 32); err == nil {
+		BONUS_EXPIRY_DAYS = float32(value)
+	} else {
+		BONUS_EXPIRY_DAYS = 30 // Default expiry
+	}
+
+	// Referral rewards, credited to both players once the referred player qualifies
+	if value, err := strconv.ParseFloat(os.Getenv("REFERRAL_REFERRER_REWARD"), 32); err == nil {
+		REFERRAL_REFERRER_REWARD = float32(value)
+	} else {
+		REFERRAL_REFERRER_REWARD = 10 // Default reward
+	}
+
+	if value, err := strconv.ParseFloat(os.Getenv("REFERRAL_REFERRED_REWARD"), 32); err == nil {
+		REFERRAL_REFERRED_REWARD = float32(value)
+	} else {
+		REFERRAL_REFERRED_REWARD = 5 // Default reward
+	}
+
+	// The referred player qualifies once they've wagered this much in total (0 = on their first deposit)
+	if value, err := strconv.ParseFloat(os.Getenv("REFERRAL_QUALIFYING_WAGER"), 32); err == nil {
+		REFERRAL_QUALIFYING_WAGER = float32(value)
+	} else {
+		REFERRAL_QUALIFYING_WAGER = 0 // Default to the first deposit
+	}
+
+	// VIP tiers are computed from the volume wagered over this rolling window
+	if value, err := strconv.ParseFloat(os.Getenv("LOYALTY_TIER_WINDOW_DAYS"), 32); err == nil {
+		LOYALTY_TIER_WINDOW_DAYS = float32(value)
+	} else {
+		LOYALTY_TIER_WINDOW_DAYS = 30 // Default window
+	}
+
+	// Weekly cashback is granted as a bonus ("bonus") or added to the wallet ("cash")
+	CASHBACK_DESTINATION = os.Getenv("CASHBACK_DESTINATION")
+	if CASHBACK_DESTINATION != "cash" {
+		CASHBACK_DESTINATION = "bonus" // Default destination
+	}
+
+	// How often the cashback scheduler checks for a completed week to pay
+	if value, err := strconv.ParseFloat(os.Getenv("CASHBACK_CHECK_INTERVAL_MINUTES"), 32); err == nil && value > 0 {
+		CASHBACK_CHECK_INTERVAL_MINUTES = float32(value)
+	} else {
+		CASHBACK_CHECK_INTERVAL_MINUTES = 60 // Default interval
+	}
+
+	// Default payout table of the tournaments, % of the prize pool by rank
+	TOURNAMENT_PAYOUT_TABLE = []float32{}
+	for _, share := range strings.Split(os.Getenv("TOURNAMENT_PAYOUT_TABLE"), ",") {
+		if value, err := strconv.ParseFloat(strings.TrimSpace(share), 32); err == nil && value > 0 {
+			TOURNAMENT_PAYOUT_TABLE = append(TOURNAMENT_PAYOUT_TABLE, float32(value))
+		}
+	}
+	if len(TOURNAMENT_PAYOUT_TABLE) == 0 {
+		TOURNAMENT_PAYOUT_TABLE = []float32{50, 30, 20} // Default payout table
+	}
+
+	// How often the tournament scheduler starts, ends and settles tournaments
+	if value, err := strconv.ParseFloat(os.Getenv("TOURNAMENT_CHECK_INTERVAL_SECONDS"), 32); err == nil && value > 0 {
+		TOURNAMENT_CHECK_INTERVAL_SECONDS = float32(value)
+	} else {
+		TOURNAMENT_CHECK_INTERVAL_SECONDS = 10 // Default interval
+	}
+
+	// Shared dice rooms, each one runs its own rounds
+	DICE_ROOMS = []string{}
+	for _, room := range strings.Split(os.Getenv("DICE_ROOMS"), ",") {
+		if room = strings.TrimSpace(room); room != "" {
+			DICE_ROOMS = append(DICE_ROOMS, room)
+		}
+	}
+	if len(DICE_ROOMS) == 0 {
+		DICE_ROOMS = []string{"main"} // Default room
+	}
+
+	// How long the rounds of the dice rooms take bets before the die is rolled
+	if value, err := strconv.ParseFloat(os.Getenv("ROOM_BETTING_SECONDS"), 32); err == nil && value > 0 {
+		ROOM_BETTING_SECONDS = float32(value)
+	} else {
+		ROOM_BETTING_SECONDS = 15 // Default betting window
+	}
+
+	// How long the crash rounds take bets before the multiplier starts
+	if value, err := strconv.ParseFloat(os.Getenv("CRASH_BETTING_SECONDS"), 32); err == nil && value > 0 {
+		CRASH_BETTING_SECONDS = float32(value)
+	} else {
+		CRASH_BETTING_SECONDS = 10 // Default betting window
+	}
+
+	// House edge (%) built into the crash points
+	if value, err := strconv.ParseFloat(os.Getenv("CRASH_HOUSE_EDGE"), 32); err == nil && value >= 0 && value < 100 {
+		CRASH_HOUSE_EDGE = float32(value)
+	} else {
+		CRASH_HOUSE_EDGE = 1 // Default house edge
+	}
+
+	// Interval between the multiplier ticks streamed to the crash socket
+	if value, err := strconv.Atoi(os.Getenv("CRASH_TICK_MILLISECONDS")); err == nil && value > 0 {
+		CRASH_TICK_MILLISECONDS = value
+	} else {
+		CRASH_TICK_MILLISECONDS = 100 // Default tick interval
+	}
+
+	// Part (%) of every stake fed to the progressive jackpot (0 turns the jackpot off)
+	if value, err := strconv.ParseFloat(os.Getenv("JACKPOT_CONTRIBUTION_PERCENT"), 32); err == nil && value >= 0 && value < 100 {
+		JACKPOT_CONTRIBUTION_PERCENT = float32(value)
+	} else {
+		JACKPOT_CONTRIBUTION_PERCENT = 1 // Default contribution
+	}
+
+	// Each contributing bet wins the jackpot with a chance of 1 in JACKPOT_ODDS
+	if value, err := strconv.Atoi(os.Getenv("JACKPOT_ODDS")); err == nil && value > 0 {
+		JACKPOT_ODDS = value
+	} else {
+		JACKPOT_ODDS = 100000 // Default odds
+	}
+
+	// Pool put in by the house when the jackpot starts and after each win
+	if value, err := strconv.ParseFloat(os.Getenv("JACKPOT_SEED"), 32); err == nil && value >= 0 {
+		JACKPOT_SEED = float32(value)
+	} else {
+		JACKPOT_SEED = 1000 // Default seed
+	}
+
+	// Most a player can send to other players in a day (0 = no cap)
+	if value, err := strconv.ParseFloat(os.Getenv("TRANSFER_DAILY_LIMIT"), 32); err == nil && value >= 0 {
+		TRANSFER_DAILY_LIMIT = float32(value)
+	} else {
+		TRANSFER_DAILY_LIMIT = 500 // Default daily cap
+	}
+
+	// Provider deposits and payouts go through ("mock" = the local stand-in gateway)
+	PAYMENT_PROVIDER = os.Getenv("PAYMENT_PROVIDER")
+	if PAYMENT_PROVIDER == "" {
+		PAYMENT_PROVIDER = "mock" // Default provider
+	}
+
+	// Secret shared with the provider to sign its webhooks
+	PAYMENT_WEBHOOK_SECRET = os.Getenv("PAYMENT_WEBHOOK_SECRET")
+	if PAYMENT_WEBHOOK_SECRET == "" {
+		PAYMENT_WEBHOOK_SECRET = JWT_SECRET // Default secret
+	}
+
+	// Where the provider sends its webhooks
+	PAYMENT_WEBHOOK_URL = os.Getenv("PAYMENT_WEBHOOK_URL")
+	if PAYMENT_WEBHOOK_URL == "" {
+		PAYMENT_WEBHOOK_URL = "http://localhost" + PORT + "/payments/webhook/" + PAYMENT_PROVIDER // Default URL
+	}
+
+	// Port of the mock gateway
+	MOCK_GATEWAY_PORT = os.Getenv("MOCK_GATEWAY_PORT")
+	if MOCK_GATEWAY_PORT == "" {
+		MOCK_GATEWAY_PORT = ":8090" // Default port
+	}
+
+	// The mock gateway confirms pending payments by itself after this delay (0 = only through its checkout)
+	if value, err := strconv.ParseFloat(os.Getenv("MOCK_GATEWAY_AUTO_CONFIRM_SECONDS"), 32); err == nil && value >= 0 {
+		MOCK_GATEWAY_AUTO_CONFIRM_SECONDS = float32(value)
+	} else {
+		MOCK_GATEWAY_AUTO_CONFIRM_SECONDS = 3 // Default delay
+	}
+
+	// Withdrawals up to this amount are approved without an admin (0 = all need an admin)
+	if value, err := strconv.ParseFloat(os.Getenv("WITHDRAWAL_AUTO_APPROVE_THRESHOLD"), 32); err == nil && value >= 0 {
+		WITHDRAWAL_AUTO_APPROVE_THRESHOLD = float32(value)
+	} else {
+		WITHDRAWAL_AUTO_APPROVE_THRESHOLD = 100 // Default threshold
+	}
+
+	// Withdrawing more than this in total needs a verified identity (0 = every withdrawal needs it)
+	if value, err := strconv.ParseFloat(os.Getenv("KYC_WITHDRAWAL_THRESHOLD"), 32); err == nil && value >= 0 {
+		KYC_WITHDRAWAL_THRESHOLD = float32(value)
+	} else {
+		KYC_WITHDRAWAL_THRESHOLD = 2000 // Default threshold
+	}
+
+	// Where the KYC documents are stored
+	KYC_UPLOAD_DIR = os.Getenv("KYC_UPLOAD_DIR")
+	if KYC_UPLOAD_DIR == "" {
+		KYC_UPLOAD_DIR = "uploads/kyc"
+	}
+
+	// Largest KYC document accepted
+	if value, err := strconv.ParseFloat(os.Getenv("KYC_MAX_DOCUMENT_MB"), 32); err == nil && value > 0 {
+		KYC_MAX_DOCUMENT_MB = float32(value)
+	} else {
+		KYC_MAX_DOCUMENT_MB = 5 // Default size
+	}
+
+	// Currency of the reports, leaderboards and every amount set by the platform (bonuses, limits, prizes, ...)
+	BASE_CURRENCY = strings.ToUpper(strings.TrimSpace(os.Getenv("BASE_CURRENCY")))
+	if BASE_CURRENCY == "" {
+		BASE_CURRENCY = "EUR"
+	}
+
+	// Currencies the players can hold wallets in (the base currency always is one of them)
+	CURRENCIES = []string{BASE_CURRENCY}
+	for _, currency := range strings.Split(os.Getenv("CURRENCIES"), ",") {
+		if currency = strings.ToUpper(strings.TrimSpace(currency)); currency != "" && !slices.Contains(CURRENCIES, currency) {
+			CURRENCIES = append(CURRENCIES, currency)
+		}
+	}
+
+	// JSON file with the risk rules, read again whenever it changes (see risk/rules.go)
+	RISK_RULES_FILE = os.Getenv("RISK_RULES_FILE")
+	if RISK_RULES_FILE == "" {
+		RISK_RULES_FILE = "risk_rules.json"
+	}
+
+	// Token buckets per route pattern ("/auth/login=5/60" = bursts of 5, refilled over 60 seconds, "default" for the other routes)
+	// Entries are added to / override the defaults, a burst of 0 turns the limit off
+	HTTP_RATE_LIMITS = parseRateLimits("default=120/60,/auth/login=5/60,/auth/register=3/300,/auth/refresh=10/60,/player/me/password=5/300,"+
+		"/player/me/wallet/deposit=10/60,/player/me/wallet/withdraw=10/60,/player/me/transfers=20/60", os.Getenv("HTTP_RATE_LIMITS"))
+
+	// Same for socket messages, by socket and action ("crash.cashout"), socket ("crash") or "default"
+	WS_RATE_LIMITS = parseRateLimits("default=30/10,play.bet=10/10,room.bet=10/10,crash.bet=10/10,end-play.cashIn=10/10", os.Getenv("WS_RATE_LIMITS"))
+
+	// Rate limited socket messages allowed in a minute before the socket is closed
+	if value, err := strconv.Atoi(os.Getenv("WS_RATE_LIMIT_MAX_VIOLATIONS")); err == nil && value > 0 {
+		WS_RATE_LIMIT_MAX_VIOLATIONS = value
+	} else {
+		WS_RATE_LIMIT_MAX_VIOLATIONS = 10 // Default violations
+	}
+
+	// Browser origins allowed to open sockets and make cross-origin requests ("*" = any, requests from the server's own origin are always allowed)
+	ALLOWED_ORIGINS = []string{}
+	for _, origin := range strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			ALLOWED_ORIGINS = append(ALLOWED_ORIGINS, origin)
+		}
+	}
+	if len(ALLOWED_ORIGINS) == 0 {
+		ALLOWED_ORIGINS = []string{"http://localhost:3000"} // Default origin (local frontend)
+	}
+
+	// One-time socket tickets (POST /auth/ws-ticket) must be used within this many seconds
+	if value, err := strconv.ParseFloat(os.Getenv("WS_TICKET_SECONDS"), 32); err == nil && value > 0 {
+		WS_TICKET_SECONDS = float32(value)
+	} else {
+		WS_TICKET_SECONDS = 30 // Default ticket lifetime
+	}
+
 	fmt.Println("CONFIG LOADED:")
 	fmt.Println("	PORT:", PORT)
 	fmt.Println("	RIGGED DICE NUMBER:", RIGGED_DICE_NUMBER)
@@ -73,5 +405,77 @@ func LoadConfig() {
 	fmt.Println("	PROCESSING DURATION:", PROCESSING_DURATION)
 	fmt.Println("	JWT SECRET:", JWT_SECRET)
 	fmt.Println("	JWT DURATION IN HOURS:", JWT_DURATION_IN_HOURS)
-	fmt.Println("\n\n")
+	fmt.Println("	ADJUSTMENT APPROVAL THRESHOLD:", ADJUSTMENT_APPROVAL_THRESHOLD)
+	fmt.Println("	LIMIT INCREASE COOLING OFF HOURS:", LIMIT_INCREASE_COOLING_OFF_HOURS)
+	fmt.Println("	REALITY CHECK INTERVAL MINUTES:", REALITY_CHECK_INTERVAL_MINUTES)
+	fmt.Println("	BONUS WAGERING MULTIPLIER:", BONUS_WAGERING_MULTIPLIER)
+	fmt.Println("	BONUS EXPIRY DAYS:", BONUS_EXPIRY_DAYS)
+	fmt.Println("	REFERRAL REFERRER REWARD:", REFERRAL_REFERRER_REWARD)
+	fmt.Println("	REFERRAL REFERRED REWARD:", REFERRAL_REFERRED_REWARD)
+	fmt.Println("	REFERRAL QUALIFYING WAGER:", REFERRAL_QUALIFYING_WAGER)
+	fmt.Println("	LOYALTY TIER WINDOW DAYS:", LOYALTY_TIER_WINDOW_DAYS)
+	fmt.Println("	CASHBACK DESTINATION:", CASHBACK_DESTINATION)
+	fmt.Println("	CASHBACK CHECK INTERVAL MINUTES:", CASHBACK_CHECK_INTERVAL_MINUTES)
+	fmt.Println("	TOURNAMENT PAYOUT TABLE:", TOURNAMENT_PAYOUT_TABLE)
+	fmt.Println("	TOURNAMENT CHECK INTERVAL SECONDS:", TOURNAMENT_CHECK_INTERVAL_SECONDS)
+	fmt.Println("	DICE ROOMS:", DICE_ROOMS)
+	fmt.Println("	ROOM BETTING SECONDS:", ROOM_BETTING_SECONDS)
+	fmt.Println("	CRASH BETTING SECONDS:", CRASH_BETTING_SECONDS)
+	fmt.Println("	CRASH HOUSE EDGE:", CRASH_HOUSE_EDGE)
+	fmt.Println("	CRASH TICK MILLISECONDS:", CRASH_TICK_MILLISECONDS)
+	fmt.Println("	JACKPOT CONTRIBUTION PERCENT:", JACKPOT_CONTRIBUTION_PERCENT)
+	fmt.Println("	JACKPOT ODDS:", JACKPOT_ODDS)
+	fmt.Println("	JACKPOT SEED:", JACKPOT_SEED)
+	fmt.Println("	TRANSFER DAILY LIMIT:", TRANSFER_DAILY_LIMIT)
+	fmt.Println("	PAYMENT PROVIDER:", PAYMENT_PROVIDER)
+	fmt.Println("	PAYMENT WEBHOOK URL:", PAYMENT_WEBHOOK_URL)
+	fmt.Println("	MOCK GATEWAY PORT:", MOCK_GATEWAY_PORT)
+	fmt.Println("	MOCK GATEWAY AUTO CONFIRM SECONDS:", MOCK_GATEWAY_AUTO_CONFIRM_SECONDS)
+	fmt.Println("	WITHDRAWAL AUTO APPROVE THRESHOLD:", WITHDRAWAL_AUTO_APPROVE_THRESHOLD)
+	fmt.Println("	KYC WITHDRAWAL THRESHOLD:", KYC_WITHDRAWAL_THRESHOLD)
+	fmt.Println("	KYC UPLOAD DIR:", KYC_UPLOAD_DIR)
+	fmt.Println("	KYC MAX DOCUMENT MB:", KYC_MAX_DOCUMENT_MB)
+	fmt.Println("	BASE CURRENCY:", BASE_CURRENCY)
+	fmt.Println("	CURRENCIES:", CURRENCIES)
+	fmt.Println("	RISK RULES FILE:", RISK_RULES_FILE)
+	fmt.Println("	HTTP RATE LIMITS:", HTTP_RATE_LIMITS)
+	fmt.Println("	WS RATE LIMITS:", WS_RATE_LIMITS)
+	fmt.Println("	WS RATE LIMIT MAX VIOLATIONS:", WS_RATE_LIMIT_MAX_VIOLATIONS)
+	fmt.Println("	ALLOWED ORIGINS:", ALLOWED_ORIGINS)
+	fmt.Println("	WS TICKET SECONDS:", WS_TICKET_SECONDS)
+	fmt.Print("\n\n\n")
+}
+
+// RateLimit allows bursts of Burst requests / messages, refilled over Period
+type RateLimit struct {
+	Burst  int
+	Period time.Duration
+}
+
+func (l RateLimit) String() string {
+	return fmt.Sprintf("%d/%v", l.Burst, l.Period)
+}
+
+// parseRateLimits reads "key=burst/seconds" entries separated by commas, the overrides replace the defaults (invalid entries are skipped)
+func parseRateLimits(defaults string, overrides string) map[string]RateLimit {
+	limits := make(map[string]RateLimit)
+
+	for _, entry := range strings.Split(defaults+","+overrides, ",") {
+		separator := strings.LastIndex(entry, "=")
+		if separator == -1 {
+			continue
+		}
+
+		key := strings.TrimSpace(entry[:separator])
+		burst, seconds, _ := strings.Cut(entry[separator+1:], "/")
+		burstValue, burstErr := strconv.Atoi(strings.TrimSpace(burst))
+		secondsValue, secondsErr := strconv.ParseFloat(strings.TrimSpace(seconds), 64)
+		if key == "" || burstErr != nil || secondsErr != nil || burstValue < 0 || secondsValue <= 0 {
+			continue
+		}
+
+		limits[key] = RateLimit{Burst: burstValue, Period: time.Duration(secondsValue * float64(time.Second))}
+	}
+
+	return limits
 }
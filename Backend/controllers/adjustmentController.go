@@ -0,0 +1,326 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"main/audit"
+	"main/config"
+	"main/helpers"
+	"main/models"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Manual balance adjustments (goodwill, chargeback corrections, ...)
+
+POST /admin/adjustments               -> Admin proposes an adjustment {"playerId": int, "amount": float, "currency": string, "reasonCode": string, "note": string}
+GET  /admin/adjustments?status=&playerId= -> Lists adjustments (support can read)
+GET  /admin/adjustments/{id}          -> Single adjustment (support can read)
+POST /admin/adjustments/{id}/approve  -> A different admin approves and applies it {"note": string}
+POST /admin/adjustments/{id}/reject   -> Any admin rejects it {"note": string}
+
+! Adjustments up to ADJUSTMENT_APPROVAL_THRESHOLD are applied right away, above it a second admin must approve them
+! Staff can't propose or approve adjustments of their own account
+! The amount is in the wallet of its currency (the player's current one if not given), whichever one the player plays in later
+? Applying notifies the wallet socket
+*/
+
+// How long applying an adjustment waits for a bet in progress to end
+const adjustmentLockTimeout = 5 * time.Second
+
+type AdjustmentReqBody struct {
+	PlayerID   int     `json:"playerId"`
+	Amount     float32 `json:"amount"`
+	Currency   string  `json:"currency"` // Optional, the currency the player plays in if empty
+	ReasonCode string  `json:"reasonCode"`
+	Note       string  `json:"note"`
+}
+
+type AdjustmentReviewReqBody struct {
+	Note string `json:"note"`
+}
+
+func HandleAdminAdjustments(w http.ResponseWriter, r *http.Request, staff *models.Player) {
+	switch r.Method {
+	case http.MethodGet:
+		listAdjustments(w, r)
+	case http.MethodPost:
+		// Support staff can only read
+		if staff.Role != models.RoleAdmin {
+			helpers.WriteJSONResponse(w, http.StatusForbidden, map[string]interface{}{"message": "Only admins can propose adjustments"})
+			return
+		}
+		proposeAdjustment(w, r, staff)
+	default:
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+	}
+}
+
+func HandleAdminGetAdjustment(w http.ResponseWriter, r *http.Request, staff *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	adjustment, ok := findAdjustmentFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{"adjustment": adjustment})
+}
+
+func HandleAdminApproveAdjustment(w http.ResponseWriter, r *http.Request, admin *models.Player) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	adjustment, ok := findAdjustmentFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var reviewReqBody AdjustmentReviewReqBody
+	json.NewDecoder(r.Body).Decode(&reviewReqBody) // The note is optional
+
+	if adjustment.Status != models.AdjustmentPending {
+		helpers.WriteJSONResponse(w, http.StatusConflict, map[string]interface{}{"message": "Adjustment is already " + adjustment.Status})
+		return
+	}
+
+	// Four-eyes principle, the proposer can only retry adjustments that didn't need approval
+	if adjustment.RequiresApproval && adjustment.ProposedBy == admin.ID {
+		helpers.WriteJSONResponse(w, http.StatusForbidden, map[string]interface{}{"message": "Adjustment must be approved by a different admin"})
+		return
+	}
+
+	// Nor can staff approve an adjustment of their own account (or one proposed for the proposer's own)
+	if adjustment.PlayerID == admin.ID || adjustment.PlayerID == adjustment.ProposedBy {
+		helpers.WriteJSONResponse(w, http.StatusForbidden, map[string]interface{}{"message": "Staff can't approve adjustments of their own account"})
+		return
+	}
+
+	statusCode, err := applyAdjustment(adjustment, &admin.ID, reviewReqBody.Note)
+	if err != nil {
+		helpers.WriteJSONResponse(w, statusCode, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	audit.Record(r, admin.ID, audit.ActionAdjustmentApply, adjustment.PlayerID, map[string]interface{}{
+		"adjustmentId": adjustment.ID,
+		"amount":       adjustment.Amount,
+		"proposedBy":   adjustment.ProposedBy,
+	})
+
+	adjustment, _ = models.GetBalanceAdjustmentByID(adjustment.ID)
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message":    "Adjustment approved and applied",
+		"adjustment": adjustment,
+	})
+}
+
+func HandleAdminRejectAdjustment(w http.ResponseWriter, r *http.Request, admin *models.Player) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	adjustment, ok := findAdjustmentFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var reviewReqBody AdjustmentReviewReqBody
+	json.NewDecoder(r.Body).Decode(&reviewReqBody) // The note is optional
+
+	wasPending, err := models.ReviewBalanceAdjustment(adjustment.ID, models.AdjustmentRejected, &admin.ID, reviewReqBody.Note)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	if !wasPending {
+		helpers.WriteJSONResponse(w, http.StatusConflict, map[string]interface{}{"message": "Adjustment is already " + adjustment.Status})
+		return
+	}
+
+	audit.Record(r, admin.ID, audit.ActionAdjustmentDeny, adjustment.PlayerID, map[string]interface{}{
+		"adjustmentId": adjustment.ID,
+		"amount":       adjustment.Amount,
+		"proposedBy":   adjustment.ProposedBy,
+	})
+
+	adjustment, _ = models.GetBalanceAdjustmentByID(adjustment.ID)
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message":    "Adjustment rejected",
+		"adjustment": adjustment,
+	})
+}
+
+func listAdjustments(w http.ResponseWriter, r *http.Request) {
+	limit, offset := helpers.ParsePagination(r)
+
+	// Optional filters
+	status := r.URL.Query().Get("status")
+	playerID, _ := strconv.Atoi(r.URL.Query().Get("playerId"))
+
+	adjustments, err := models.GetBalanceAdjustments(status, playerID, limit, offset)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"adjustments": adjustments,
+		"limit":       limit,
+		"offset":      offset,
+	})
+}
+
+func proposeAdjustment(w http.ResponseWriter, r *http.Request, admin *models.Player) {
+	var adjustmentReqBody AdjustmentReqBody
+	err := json.NewDecoder(r.Body).Decode(&adjustmentReqBody)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Request is missing data (playerId, amount, reasonCode)"})
+		return
+	}
+	defer r.Body.Close()
+
+	// Error List
+	errorList := []string{}
+
+	if adjustmentReqBody.Amount == 0 {
+		errorList = append(errorList, "amount must be different from 0")
+	}
+
+	if !models.IsValidAdjustmentReasonCode(adjustmentReqBody.ReasonCode) {
+		errorList = append(errorList, fmt.Sprintf("reasonCode must be one of %v", models.AdjustmentReasonCodes))
+	}
+
+	targetPlayer, err := models.GetPlayerByID(adjustmentReqBody.PlayerID)
+	if err != nil {
+		errorList = append(errorList, err.Error())
+	} else if adjustmentReqBody.Currency == "" {
+		adjustmentReqBody.Currency = targetPlayer.Currency
+	}
+
+	if adjustmentReqBody.Currency != "" && !models.IsSupportedCurrency(adjustmentReqBody.Currency) {
+		errorList = append(errorList, fmt.Sprintf("currency must be one of %s", strings.Join(config.CURRENCIES, ", ")))
+	}
+
+	if adjustmentReqBody.PlayerID == admin.ID {
+		errorList = append(errorList, "admins can't adjust their own balance")
+	}
+
+	if len(errorList) > 0 {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"message":    "Invalid adjustment, check error list",
+			"errorsList": errorList,
+		})
+		return
+	}
+
+	// The threshold is in the base currency
+	amountInBase := models.ToBaseCurrency(float32(math.Abs(float64(adjustmentReqBody.Amount))), adjustmentReqBody.Currency)
+	requiresApproval := amountInBase > config.ADJUSTMENT_APPROVAL_THRESHOLD
+
+	adjustmentID, err := models.CreateBalanceAdjustment(models.BalanceAdjustment{
+		PlayerID:         adjustmentReqBody.PlayerID,
+		Amount:           adjustmentReqBody.Amount,
+		Currency:         adjustmentReqBody.Currency,
+		ReasonCode:       adjustmentReqBody.ReasonCode,
+		Note:             adjustmentReqBody.Note,
+		RequiresApproval: requiresApproval,
+		ProposedBy:       admin.ID,
+	})
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	adjustment, err := models.GetBalanceAdjustmentByID(adjustmentID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	audit.Record(r, admin.ID, audit.ActionAdjustment, adjustment.PlayerID, map[string]interface{}{
+		"adjustmentId":     adjustment.ID,
+		"amount":           adjustment.Amount,
+		"currency":         adjustment.Currency,
+		"reasonCode":       adjustment.ReasonCode,
+		"requiresApproval": adjustment.RequiresApproval,
+	})
+
+	if requiresApproval {
+		helpers.WriteJSONResponse(w, http.StatusCreated, map[string]interface{}{
+			"message":    "Adjustment is above the approval threshold and awaits a second admin's approval",
+			"adjustment": adjustment,
+		})
+		return
+	}
+
+	// Below the threshold the adjustment is applied right away
+	statusCode, err := applyAdjustment(adjustment, nil, "Applied without approval (below threshold)")
+	if err != nil {
+		helpers.WriteJSONResponse(w, statusCode, map[string]interface{}{
+			"message":    fmt.Sprintf("Adjustment saved as pending but could not be applied (%s), approve it to retry", err.Error()),
+			"adjustment": adjustment,
+		})
+		return
+	}
+
+	adjustment, _ = models.GetBalanceAdjustmentByID(adjustmentID)
+	helpers.WriteJSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"message":    "Adjustment applied",
+		"adjustment": adjustment,
+	})
+}
+
+// applyAdjustment marks the adjustment as applied and updates the player's wallet in its currency
+// Uses the betting status as a processing lock like the other balance updates
+// Returns the HTTP status code to use on error
+func applyAdjustment(adjustment *models.BalanceAdjustment, reviewerID *int, reviewNote string) (int, error) {
+	if err := waitForPlayerBetting(adjustment.PlayerID, adjustmentLockTimeout); err != nil {
+		return http.StatusConflict, errors.New("player is in Betting Process, try again later")
+	}
+	defer models.UpdatePlayerBettingStatus(adjustment.PlayerID, false)
+
+	applied, err := models.ApplyBalanceAdjustment(adjustment, reviewerID, reviewNote)
+	if errors.Is(err, models.ErrAdjustmentInsufficientFunds) {
+		return http.StatusBadRequest, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if !applied {
+		return http.StatusConflict, errors.New("adjustment was already reviewed")
+	}
+
+	return http.StatusOK, nil
+}
+
+// findAdjustmentFromPath loads the adjustment referenced by the {id} path value
+// Writes the error response itself and returns false if it can't
+func findAdjustmentFromPath(w http.ResponseWriter, r *http.Request) (*models.BalanceAdjustment, bool) {
+	adjustmentID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Invalid adjustment id"})
+		return nil, false
+	}
+
+	adjustment, err := models.GetBalanceAdjustmentByID(adjustmentID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusNotFound, map[string]interface{}{"message": err.Error()})
+		return nil, false
+	}
+
+	return adjustment, true
+}
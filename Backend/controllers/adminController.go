@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"main/audit"
+	"main/helpers"
+	"main/models"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+Admin API, every handler is wrapped by middleware.Authorize in main.go
+Support staff can use the read-only handlers, mutating handlers are admin only
+
+GET /admin/players?search=&limit=&offset=  -> Lists / searches players (balances and betting lock status)
+GET /admin/players/{id}                    -> Single player
+GET /admin/players/{id}/bets               -> Player's bet history
+GET /admin/players/{id}/transactions       -> Player's transaction history
+GET /admin/players/{id}/status-history     -> Player's account status changes
+POST /admin/players/{id}/status            -> Changes the account status {"status": string, "reason": string} (admin only)
+
+? Changing the status closes all the player's open WebSocket sessions so the new status applies right away
+*/
+
+type PlayerStatusReqBody struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+func HandleAdminListPlayers(w http.ResponseWriter, r *http.Request, staff *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, offset := helpers.ParsePagination(r)
+
+	players, total, err := models.SearchPlayers(r.URL.Query().Get("search"), limit, offset)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"players": players,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+func HandleAdminGetPlayer(w http.ResponseWriter, r *http.Request, staff *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	player, ok := findPlayerFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{"player": player})
+}
+
+func HandleAdminPlayerBets(w http.ResponseWriter, r *http.Request, staff *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	player, ok := findPlayerFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	limit, offset := helpers.ParsePagination(r)
+
+	bets, err := models.GetBetsByPlayerID(player.ID, limit, offset)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"bets":   bets,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+func HandleAdminPlayerTransactions(w http.ResponseWriter, r *http.Request, staff *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	player, ok := findPlayerFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	limit, offset := helpers.ParsePagination(r)
+
+	transactions, err := models.GetTransactionsByPlayerID(player.ID, limit, offset)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"transactions": transactions,
+		"limit":        limit,
+		"offset":       offset,
+	})
+}
+
+func HandleAdminPlayerStatusHistory(w http.ResponseWriter, r *http.Request, staff *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	player, ok := findPlayerFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	statusChanges, err := models.GetPlayerStatusChanges(player.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{"statusChanges": statusChanges})
+}
+
+func HandleAdminUpdatePlayerStatus(w http.ResponseWriter, r *http.Request, admin *models.Player) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	player, ok := findPlayerFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var statusReqBody PlayerStatusReqBody
+	err := json.NewDecoder(r.Body).Decode(&statusReqBody)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Request is missing data (status, reason)"})
+		return
+	}
+	defer r.Body.Close()
+
+	// Error List
+	errorList := []string{}
+
+	if !models.IsValidPlayerStatus(statusReqBody.Status) {
+		errorList = append(errorList, fmt.Sprintf("status must be one of %v", models.PlayerStatuses))
+	}
+
+	if strings.TrimSpace(statusReqBody.Reason) == "" {
+		errorList = append(errorList, "reason is required")
+	}
+
+	if player.ID == admin.ID {
+		errorList = append(errorList, "admins can't change their own status")
+	}
+
+	if len(errorList) > 0 {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"message":    "Invalid status change, check error list",
+			"errorsList": errorList,
+		})
+		return
+	}
+
+	err = models.UpdatePlayerStatus(player.ID, statusReqBody.Status, statusReqBody.Reason, admin.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	audit.Record(r, admin.ID, audit.ActionStatusChange, player.ID, map[string]interface{}{
+		"oldStatus": player.Status,
+		"newStatus": statusReqBody.Status,
+		"reason":    statusReqBody.Reason,
+	})
+
+	// Kick the player out of every socket, they have to reconnect under the new status
+	closedSessions := helpers.Sessions.CloseAll(player.ID, websocket.ClosePolicyViolation, "Account status changed to "+statusReqBody.Status)
+
+	player, _ = models.GetPlayerByID(player.ID)
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message":        "Player status updated",
+		"player":         player,
+		"closedSessions": closedSessions,
+	})
+}
+
+// findPlayerFromPath loads the player referenced by the {id} path value
+// Writes the error response itself and returns false if it can't
+func findPlayerFromPath(w http.ResponseWriter, r *http.Request) (*models.Player, bool) {
+	playerID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Invalid player id"})
+		return nil, false
+	}
+
+	player, err := models.GetPlayerByID(playerID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusNotFound, map[string]interface{}{"message": err.Error()})
+		return nil, false
+	}
+
+	return player, true
+}
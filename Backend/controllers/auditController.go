@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"main/audit"
+	"main/helpers"
+	"main/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/*
+Audit trail (support can read)
+
+GET /admin/audit?actorId=&targetId=&action=&ip=&from=&to=&limit=&offset= -> Lists audit entries, newest first
+	action is a prefix (e.g. "auth." or "admin."), from / to are RFC3339 timestamps (any offset, 400 if invalid)
+GET /admin/audit/verify -> Walks the hash chain and reports the first tampered entry
+*/
+
+func HandleAdminAuditLog(w http.ResponseWriter, r *http.Request, staff *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, offset := helpers.ParsePagination(r)
+	query := r.URL.Query()
+
+	// Optional filters
+	actorID, _ := strconv.Atoi(query.Get("actorId"))
+	targetID, _ := strconv.Atoi(query.Get("targetId"))
+
+	// Error List
+	errorList := []string{}
+
+	var from, to time.Time
+	var err error
+	if value := query.Get("from"); value != "" {
+		if from, err = time.Parse(time.RFC3339, value); err != nil {
+			errorList = append(errorList, "from must be an RFC3339 timestamp (e.g. 2024-01-31T00:00:00Z)")
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if to, err = time.Parse(time.RFC3339, value); err != nil {
+			errorList = append(errorList, "to must be an RFC3339 timestamp (e.g. 2024-01-31T23:59:59+01:00)")
+		}
+	}
+
+	if len(errorList) > 0 {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"message":    "Invalid filters, check error list",
+			"errorsList": errorList,
+		})
+		return
+	}
+
+	entries, err := audit.Query(audit.Filter{
+		ActorID:  actorID,
+		TargetID: targetID,
+		Action:   query.Get("action"),
+		IP:       query.Get("ip"),
+		From:     from,
+		To:       to,
+		Limit:    limit,
+		Offset:   offset,
+	})
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"entries": entries,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+func HandleAdminAuditVerify(w http.ResponseWriter, r *http.Request, staff *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tamperedEntryID, checkedEntries, err := audit.Verify()
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	response := map[string]interface{}{
+		"valid":          tamperedEntryID == 0,
+		"checkedEntries": checkedEntries,
+		"message":        "Audit trail is intact",
+	}
+
+	if tamperedEntryID != 0 {
+		response["tamperedEntryId"] = tamperedEntryID
+		response["message"] = "Audit trail was tampered with"
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, response)
+}
@@ -3,10 +3,14 @@ package controllers
 import (
 	"encoding/json"
 	"fmt"
+	"log"
+	"main/audit"
 	"main/config"
 	"main/helpers"
+	"main/middleware"
 	"main/models"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -18,6 +22,16 @@ type PlayerLogin struct {
 	Password string `json:"password"`
 }
 
+type PlayerRegistration struct {
+	Name         string `json:"name"`
+	Password     string `json:"password"`
+	ReferralCode string `json:"referralCode"` // Optional, code of the player who referred them
+	Currency     string `json:"currency"`     // Optional, currency the player starts playing in (BASE_CURRENCY if empty)
+}
+
+// Optional header identifying the player's device, used against self-referrals
+const deviceIDHeader = "X-Device-ID"
+
 // Register a new player
 func HandleRegister(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
@@ -27,7 +41,7 @@ func HandleRegister(w http.ResponseWriter, r *http.Request) {
 
 	var response = map[string]interface{}{"message": "Player registered successfully"}
 
-	var newPlayerData PlayerLogin
+	var newPlayerData PlayerRegistration
 	err := json.NewDecoder(r.Body).Decode(&newPlayerData)
 	if err != nil {
 		response["message"] = "Invalid request payload"
@@ -40,6 +54,24 @@ func HandleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close() // Close body after reading
 
+	var referrer *models.Player
+	if newPlayerData.ReferralCode != "" {
+		referrer, err = models.GetPlayerByReferralCode(newPlayerData.ReferralCode)
+		if err != nil {
+			helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Invalid referral code"})
+			return
+		}
+	}
+
+	currency := strings.ToUpper(newPlayerData.Currency)
+	if currency == "" {
+		currency = config.BASE_CURRENCY
+	}
+	if !models.IsSupportedCurrency(currency) {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": fmt.Sprintf("Unsupported currency, must be one of %s", strings.Join(config.CURRENCIES, ", "))})
+		return
+	}
+
 	newPlayerHashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPlayerData.Password), bcrypt.DefaultCost)
 	if err != nil {
 		response["message"] = "Error hashing password"
@@ -48,7 +80,7 @@ func HandleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newPlayerId, err := models.RegisterPlayer(newPlayerData.Name, string(newPlayerHashedPassword))
+	newPlayerId, err := models.RegisterPlayer(newPlayerData.Name, string(newPlayerHashedPassword), currency)
 	if err != nil {
 		response["message"] = fmt.Sprintln("Error registering player: ", err.Error())
 		stringifiedResponse, _ := helpers.JsonStringifier(response)
@@ -60,7 +92,7 @@ func HandleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Generate JWT
-	jwtToken, err := generateJWT(newPlayerId)
+	jwtToken, err := generateJWT(newPlayerId, models.RolePlayer)
 	if err != nil {
 		response["message"] = "Error generating JWT token"
 		stringifiedResponse, _ := helpers.JsonStringifier(response)
@@ -69,6 +101,24 @@ func HandleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 
 	response["token"] = jwtToken
+	response["currency"] = currency
+
+	deviceID := r.Header.Get(deviceIDHeader)
+	if deviceID != "" {
+		models.UpdatePlayerDeviceID(newPlayerId, deviceID)
+	}
+
+	referralCode, err := models.AssignReferralCode(newPlayerId)
+	if err != nil {
+		log.Println("Error assigning referral code:", err)
+	}
+	response["referralCode"] = referralCode
+
+	if referrer != nil {
+		recordReferral(r, referrer, newPlayerId, deviceID)
+	}
+
+	audit.Record(r, newPlayerId, audit.ActionRegister, newPlayerId, map[string]interface{}{"name": newPlayerData.Name})
 
 	// Send response
 	stringifiedResponse, _ := helpers.JsonStringifier(response)
@@ -104,6 +154,8 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	// Retrieve the hashed password from the simulated database
 	player, err := models.GetPlayerByName(loginData.Name)
 	if err != nil {
+		audit.Record(r, 0, audit.ActionLoginFailure, 0, map[string]interface{}{"name": loginData.Name, "reason": "player not found"})
+
 		response["message"] = fmt.Sprintln("Player not found: ", err.Error())
 		stringifiedResponse, _ := helpers.JsonStringifier(response)
 		w.Header().Set("Content-Type", "application/json")
@@ -115,6 +167,7 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	// Compare the provided password with the stored hashed password
 	err = bcrypt.CompareHashAndPassword([]byte(player.Password), []byte(loginData.Password))
 	if err != nil {
+		audit.Record(r, player.ID, audit.ActionLoginFailure, player.ID, map[string]interface{}{"name": loginData.Name, "reason": "invalid password"})
 
 		response["message"] = "Invalid password"
 		stringifiedResponse, _ := helpers.JsonStringifier(response)
@@ -124,8 +177,20 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Suspended and closed accounts can't log in
+	if statusErr := player.CanAuthenticate(); statusErr != nil {
+		audit.Record(r, player.ID, audit.ActionLoginFailure, player.ID, map[string]interface{}{"name": loginData.Name, "reason": statusErr.Error()})
+
+		statusCode, response := middleware.AuthErrorResponse(statusErr)
+		stringifiedResponse, _ := helpers.JsonStringifier(response)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		w.Write([]byte(stringifiedResponse))
+		return
+	}
+
 	// Generate JWT token for the player
-	jwtToken, err := generateJWT(player.ID)
+	jwtToken, err := generateJWT(player.ID, player.Role)
 	if err != nil {
 		response["message"] = "Error generating JWT token"
 		stringifiedResponse, _ := helpers.JsonStringifier(response)
@@ -138,6 +203,12 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	// Add the JWT token to the response
 	response["token"] = jwtToken
 
+	if deviceID := r.Header.Get(deviceIDHeader); deviceID != "" && deviceID != player.DeviceID {
+		models.UpdatePlayerDeviceID(player.ID, deviceID)
+	}
+
+	audit.Record(r, player.ID, audit.ActionLoginSuccess, player.ID, map[string]interface{}{"name": loginData.Name})
+
 	// Send response
 	stringifiedResponse, _ := helpers.JsonStringifier(response)
 	w.Header().Set("Content-Type", "application/json")
@@ -145,12 +216,136 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(stringifiedResponse))
 }
 
+// Refresh the token of an authenticated player (the role is read again from the database)
+func HandleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	player, err := middleware.AuthenticateUser(r)
+	if err != nil {
+		statusCode, response := middleware.AuthErrorResponse(err)
+		helpers.WriteJSONResponse(w, statusCode, response)
+		return
+	}
+
+	jwtToken, err := generateJWT(player.ID, player.Role)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": "Error generating JWT token"})
+		return
+	}
+
+	audit.Record(r, player.ID, audit.ActionTokenRefresh, player.ID, nil)
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "Token refreshed successfully",
+		"token":   jwtToken,
+	})
+}
+
+// One-time ticket for a socket handshake, for browsers that can't set the Authorization header (see middleware/wsAuthMiddleware.go)
+// The socket is then opened with ?ticket=<ticket> within WS_TICKET_SECONDS
+func HandleWSTicket(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ticket, expiresAt, err := middleware.IssueWSTicket(player.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": "Error generating socket ticket"})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message":   "Socket ticket created successfully",
+		"ticket":    ticket,
+		"expiresAt": expiresAt,
+	})
+}
+
+type PasswordChangeReqBody struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// Change the password of an authenticated player
+func HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	player, err := middleware.AuthenticateUser(r)
+	if err != nil {
+		statusCode, response := middleware.AuthErrorResponse(err)
+		helpers.WriteJSONResponse(w, statusCode, response)
+		return
+	}
+
+	var passwordChangeData PasswordChangeReqBody
+	err = json.NewDecoder(r.Body).Decode(&passwordChangeData)
+	if err != nil || passwordChangeData.NewPassword == "" {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Request is missing data (currentPassword, newPassword)"})
+		return
+	}
+	defer r.Body.Close()
+
+	err = bcrypt.CompareHashAndPassword([]byte(player.Password), []byte(passwordChangeData.CurrentPassword))
+	if err != nil {
+		audit.Record(r, player.ID, audit.ActionPasswordChange, player.ID, map[string]interface{}{"success": false, "reason": "invalid current password"})
+
+		helpers.WriteJSONResponse(w, http.StatusUnauthorized, map[string]interface{}{"message": "Invalid password"})
+		return
+	}
+
+	newHashedPassword, err := bcrypt.GenerateFromPassword([]byte(passwordChangeData.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Error hashing password"})
+		return
+	}
+
+	err = models.UpdatePlayerPassword(player.ID, string(newHashedPassword))
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	audit.Record(r, player.ID, audit.ActionPasswordChange, player.ID, map[string]interface{}{"success": true})
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{"message": "Password changed successfully"})
+}
+
+// recordReferral links the new player to their referrer
+// Registrations from an IP the referrer used or from the referrer's device are recorded as rejected self-referrals
+func recordReferral(r *http.Request, referrer *models.Player, newPlayerId int, deviceID string) {
+	ip := helpers.ClientIP(r)
+
+	rejectReason := ""
+	if deviceID != "" && deviceID == referrer.DeviceID {
+		rejectReason = "same_device"
+	} else if entries, err := audit.Query(audit.Filter{ActorID: referrer.ID, IP: ip, Limit: 1}); err == nil && len(entries) > 0 {
+		rejectReason = "same_ip"
+	}
+
+	if err := models.CreateReferral(referrer.ID, newPlayerId, rejectReason, ip, deviceID); err != nil {
+		log.Println("Error recording referral:", err)
+		return
+	}
+
+	if rejectReason != "" {
+		audit.Record(r, newPlayerId, audit.ActionReferralRejected, referrer.ID, map[string]interface{}{"reason": rejectReason})
+	}
+}
+
 // Generate JWT token
-func generateJWT(playerId int) (string, error) {
+func generateJWT(playerId int, role string) (string, error) {
 	claims := jwt.MapClaims{
-		"id":  playerId,
-		"exp": time.Now().Add(time.Hour * 15).Unix(), // Token expires in config 
-		"iat": time.Now().Unix(),
+		"id":   playerId,
+		"role": role,
+		"exp":  time.Now().Add(time.Hour * 15).Unix(), // Token expires in config
+		"iat":  time.Now().Unix(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"main/audit"
+	"main/config"
+	"main/helpers"
+	"main/models"
+	"net/http"
+	"time"
+)
+
+/*
+Bonus money with wagering requirements
+
+GET  /player/me/bonuses          -> The player's bonuses with their wagering progress
+GET  /admin/players/{id}/bonuses -> A player's bonuses (support can read)
+POST /admin/players/{id}/bonuses -> Admin grants a bonus {"amount": float, "wageringMultiplier": float, "expiresInDays": float}
+
+! Bonus money is only staked after the wallet and the bet balance, and the winnings of bonus-funded stakes stay bonus money
+! Once amount * wageringMultiplier was staked the bonus balance is released to the bet balance
+! Withdrawing before that forfeits the bonus, and bonuses not completed by their expiry are removed
+? wageringMultiplier / expiresInDays default to BONUS_WAGERING_MULTIPLIER / BONUS_EXPIRY_DAYS
+*/
+
+type BonusGrantReqBody struct {
+	Amount             float32  `json:"amount"`
+	WageringMultiplier *float32 `json:"wageringMultiplier"`
+	ExpiresInDays      *float32 `json:"expiresInDays"`
+}
+
+func HandlePlayerBonuses(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	bonuses, err := models.GetPlayerBonuses(player.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"bonusBalance": player.BonusBalance,
+		"bonuses":      bonuses,
+	})
+}
+
+func HandleAdminPlayerBonuses(w http.ResponseWriter, r *http.Request, staff *models.Player) {
+	switch r.Method {
+	case http.MethodGet:
+		player, ok := findPlayerFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		bonuses, err := models.GetPlayerBonuses(player.ID)
+		if err != nil {
+			helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+			return
+		}
+
+		helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{"bonuses": bonuses})
+	case http.MethodPost:
+		// Support staff can only read
+		if staff.Role != models.RoleAdmin {
+			helpers.WriteJSONResponse(w, http.StatusForbidden, map[string]interface{}{"message": "Only admins can grant bonuses"})
+			return
+		}
+		grantBonus(w, r, staff)
+	default:
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+	}
+}
+
+func grantBonus(w http.ResponseWriter, r *http.Request, admin *models.Player) {
+	player, ok := findPlayerFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var grantReqBody BonusGrantReqBody
+	err := json.NewDecoder(r.Body).Decode(&grantReqBody)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Request is missing data (amount)"})
+		return
+	}
+	defer r.Body.Close()
+
+	wageringMultiplier := config.BONUS_WAGERING_MULTIPLIER
+	if grantReqBody.WageringMultiplier != nil {
+		wageringMultiplier = *grantReqBody.WageringMultiplier
+	}
+
+	expiresInDays := config.BONUS_EXPIRY_DAYS
+	if grantReqBody.ExpiresInDays != nil {
+		expiresInDays = *grantReqBody.ExpiresInDays
+	}
+
+	// Error List
+	errorList := []string{}
+
+	if grantReqBody.Amount <= 0 {
+		errorList = append(errorList, "amount must be greater than 0")
+	}
+
+	if wageringMultiplier < 0 {
+		errorList = append(errorList, "wageringMultiplier can't be negative")
+	}
+
+	if expiresInDays <= 0 {
+		errorList = append(errorList, "expiresInDays must be greater than 0")
+	}
+
+	if len(errorList) > 0 {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"message":    "Invalid bonus, check error list",
+			"errorsList": errorList,
+		})
+		return
+	}
+
+	expiresAt := time.Now().Add(time.Duration(expiresInDays * float32(24*time.Hour)))
+
+	bonusID, err := models.GrantBonus(player.ID, grantReqBody.Amount, wageringMultiplier, expiresAt, fmt.Sprintf("admin:%d", admin.ID))
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	audit.Record(r, admin.ID, audit.ActionBonusGrant, player.ID, map[string]interface{}{
+		"bonusId":            bonusID,
+		"amount":             grantReqBody.Amount,
+		"wageringMultiplier": wageringMultiplier,
+		"expiresAt":          expiresAt.UTC(),
+	})
+
+	models.NotifyBalanceUpdate(player.ID)
+
+	helpers.WriteJSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"message": "Bonus granted",
+		"bonusId": bonusID,
+	})
+}
@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"main/audit"
+	"main/config"
+	"main/helpers"
+	"main/models"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+Weekly cashback on net losses
+
+GET  /player/me/cashbacks -> The player's cashbacks
+POST /admin/cashback/run  -> Admin runs the cashback of the last completed week now (the scheduler does it on its own)
+
+! Once a week is over (weeks start on Monday, UTC) every player with a net loss on the week's settled bets gets
+! their VIP tier's cashback rate of it back, as a bonus or in the wallet (CASHBACK_DESTINATION)
+? Each player / week is recorded before it's paid, so a run interrupted by a restart is finished by the next one without paying twice
+? Cashbacks of earlier weeks that couldn't be paid (e.g. the player was betting) are retried by every run until they are
+? The scheduler checks every CASHBACK_CHECK_INTERVAL_MINUTES (and on startup)
+*/
+
+// Only one run at a time (scheduler and admin)
+var cashbackRunMu sync.Mutex
+
+// StartCashbackScheduler pays the cashback of the last completed week on startup and then checks again periodically
+func StartCashbackScheduler() {
+	go func() {
+		ticker := time.NewTicker(time.Duration(config.CASHBACK_CHECK_INTERVAL_MINUTES * float32(time.Minute)))
+		defer ticker.Stop()
+
+		for {
+			if _, err := runCashback(time.Now()); err != nil {
+				log.Println("Error running cashback:", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+func HandlePlayerCashbacks(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cashbacks, err := models.GetPlayerCashbacks(player.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{"cashbacks": cashbacks})
+}
+
+func HandleAdminRunCashback(w http.ResponseWriter, r *http.Request, admin *models.Player) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	paid, err := runCashback(time.Now())
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	periodStart, periodEnd := models.LastCompletedWeek(time.Now())
+	audit.Record(r, admin.ID, audit.ActionCashbackRun, 0, map[string]interface{}{
+		"periodStart": periodStart,
+		"paid":        len(paid),
+	})
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message":     fmt.Sprintf("Cashback paid to %d players", len(paid)),
+		"periodStart": periodStart,
+		"periodEnd":   periodEnd,
+		"cashbacks":   paid,
+	})
+}
+
+// runCashback pays the cashback of the week before now to every player who wasn't paid yet
+// and the cashbacks of earlier weeks still pending, returns the cashbacks paid
+func runCashback(now time.Time) ([]models.Cashback, error) {
+	cashbackRunMu.Lock()
+	defer cashbackRunMu.Unlock()
+
+	periodStart, periodEnd := models.LastCompletedWeek(now)
+
+	paid := []models.Cashback{}
+
+	// Earlier weeks, their amount was computed back then
+	pending, err := models.GetPendingCashbacks(periodStart)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cashback := range pending {
+		if err := payCashback(&cashback); err != nil {
+			log.Printf("Cashback %d not paid (retried on the next run): %v\n", cashback.ID, err)
+			continue
+		}
+		paid = append(paid, cashback)
+	}
+
+	netLosses, err := models.GetNetLosses(periodStart, periodEnd)
+	if err != nil {
+		return paid, err
+	}
+
+	for _, netLoss := range netLosses {
+		tier, _, _, err := models.RefreshVIPTier(netLoss.PlayerID, config.LOYALTY_TIER_WINDOW_DAYS)
+		if err != nil {
+			log.Println("Error fetching VIP tier for cashback:", err)
+			continue
+		}
+
+		cashback, err := models.CreateCashback(models.Cashback{
+			PlayerID:    netLoss.PlayerID,
+			PeriodStart: periodStart,
+			PeriodEnd:   periodEnd,
+			NetLoss:     netLoss.NetLoss,
+			Rate:        tier.CashbackRate,
+			Amount:      netLoss.NetLoss * tier.CashbackRate / 100,
+			Destination: config.CASHBACK_DESTINATION,
+		})
+		if err != nil {
+			log.Println("Error recording cashback:", err)
+			continue
+		}
+
+		if err := payCashback(cashback); err != nil {
+			log.Printf("Cashback %d not paid (retried on the next run): %v\n", cashback.ID, err)
+			continue
+		}
+		paid = append(paid, *cashback)
+	}
+
+	return paid, nil
+}
+
+// payCashback credits a recorded cashback to the player's bonus balance or wallet
+// Uses the betting status as a processing lock like the other balance updates
+func payCashback(cashback *models.Cashback) error {
+	if cashback.Amount <= 0 {
+		_, err := models.ClaimCashback(cashback.ID)
+		return err
+	}
+
+	locked, err := models.TrySetPlayerBetting(cashback.PlayerID)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return fmt.Errorf("player %d is in Betting Process", cashback.PlayerID)
+	}
+	defer models.UpdatePlayerBettingStatus(cashback.PlayerID, false)
+
+	claimed, err := models.ClaimCashback(cashback.ID)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return fmt.Errorf("cashback was already paid")
+	}
+
+	reference := fmt.Sprintf("cashback:%d", cashback.ID)
+
+	if cashback.Destination == models.CashbackToCash {
+		player, err := models.GetPlayerByID(cashback.PlayerID)
+		if err != nil {
+			models.ReleaseCashbackClaim(cashback.ID)
+			return err
+		}
+
+		// Cashbacks are computed in the base currency
+		amount := models.FromBaseCurrency(cashback.Amount, player.Currency)
+		newWalletBalance := player.Wallet + amount
+		if err := models.UpdatePlayerBalance(player.ID, newWalletBalance, player.BetBalance); err != nil {
+			models.ReleaseCashbackClaim(cashback.ID)
+			return err
+		}
+
+		models.RecordTransaction(player.ID, models.TransactionCashback, amount, newWalletBalance, player.BetBalance, reference)
+	} else {
+		expiresAt := time.Now().Add(time.Duration(config.BONUS_EXPIRY_DAYS * float32(24*time.Hour)))
+		if _, err := models.GrantBonus(cashback.PlayerID, cashback.Amount, config.BONUS_WAGERING_MULTIPLIER, expiresAt, reference); err != nil {
+			models.ReleaseCashbackClaim(cashback.ID)
+			return err
+		}
+
+		models.NotifyBalanceUpdate(cashback.PlayerID)
+	}
+
+	cashback.Status = models.CashbackPaid
+
+	message := map[string]interface{}{
+		"type":     "cashback",
+		"code":     200,
+		"message":  fmt.Sprintf("You've received %.2f cashback (%.2f%% of last week's net loss)", cashback.Amount, cashback.Rate),
+		"cashback": cashback,
+	}
+	for _, wsSession := range helpers.Sessions.PlayerSessions(cashback.PlayerID, helpers.SessionWallet) {
+		wsSession.WriteJSON(message)
+	}
+
+	return nil
+}
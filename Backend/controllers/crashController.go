@@ -0,0 +1,742 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"main/config"
+	"main/helpers"
+	"main/middleware"
+	"main/models"
+	"math"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+/*
+Crash game, one shared round at a time: a multiplier rises from 1.00x until the round's crash point
+
+GET /crash/rounds -> Latest rounds, with the server seed and crash point of the finished ones (public, ?limit=&offset=)
+WS  /ws/crash     -> Player follows the rounds, bets on the round taking bets with {"betAmount": int, "autoCashout": 2.5}
+                     (autoCashout optional, or "freeBetId" instead of betAmount like on the play socket)
+                     and cashes out the running round with {"action": "cashout"}
+
+! Rounds take bets for CRASH_BETTING_SECONDS, then the multiplier rises and is pushed every CRASH_TICK_MILLISECONDS
+! Messages pushed to the socket: crashRoundOpen (with the SHA-256 of the server seed), crashBet (every bet placed, public),
+! crashRoundStart, crashTick, crashCashout (every cashout, public), crashed (crash point and server seed) and crashResult
+! to the player who bet
+! A cashout is settled at the multiplier of the moment it is received, an auto cashout at its target once reached
+! Bets still riding when the round crashes are lost
+? Provably fair: the crash point is derived from the server seed (see models.CrashPoint) committed to by its hash
+? before the round takes bets, players check it once the seed is revealed
+? The stake is taken when the bet is placed, crash bets can't be funded by bonus money (the bonus is only taken at settlement)
+? Rounds only run while someone is on the socket
+? Cashouts settled before a restart are paid when the game starts again, bets still riding in an unfinished round are refunded
+*/
+
+// Pause between the crash of a round and the next round
+const crashResultPause = 5 * time.Second
+
+// How fast the multiplier rises: multiplier = e^(crashGrowthRate * seconds since the start)
+const crashGrowthRate = 0.07
+
+// Lowest auto cashout target
+const crashMinAutoCashout = 1.01
+
+var (
+	errCrashNotRunning = errors.New("The round isn't running, wait for the next round")
+	errNoCrashBet      = errors.New("Player has no bet riding on this round")
+)
+
+// crashGame is the state of the game: the players on the socket and the current round
+type crashGame struct {
+	sessions map[int]*helpers.WSSession // Session ID -> Session
+	round    *crashRound                // nil between rounds
+	mu       sync.Mutex
+}
+
+type crashRound struct {
+	id         int
+	seedHash   string
+	serverSeed string // Kept secret until the round crashed
+	crashPoint float32
+	startsAt   time.Time
+	running    bool // Bets closed, multiplier rising
+	startedAt  time.Time
+	crashed    bool
+	bets       map[int]*models.CrashBet // Player ID -> Bet
+}
+
+var crash = &crashGame{sessions: make(map[int]*helpers.WSSession)}
+
+// StartCrashGame refunds the bets still riding in rounds left unfinished by a previous run and starts the rounds
+func StartCrashGame() {
+	bets, err := models.CancelUnfinishedCrashRounds()
+	if err != nil {
+		log.Println("Error cancelling unfinished crash rounds:", err)
+	}
+	for _, bet := range bets {
+		if err := refundCrashBet(bet); err != nil {
+			log.Printf("Crash bet %d not refunded: %v\n", bet.ID, err)
+		}
+	}
+
+	go crash.run()
+}
+
+func HandleCrashRounds(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, offset := helpers.ParsePagination(r)
+
+	rounds, err := models.GetCrashRounds(limit, offset)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"rounds":    rounds,
+		"houseEdge": config.CRASH_HOUSE_EDGE,
+		"limit":     limit,
+		"offset":    offset,
+	})
+}
+
+func HandleCrashWS(w http.ResponseWriter, r *http.Request) {
+	player, authenticated := middleware.AuthenticateWS(w, r)
+	if !authenticated {
+		return
+	}
+
+	conn, upgradeConErr := helpers.WSUpgrader.Upgrade(w, r, nil)
+	if upgradeConErr != nil {
+		return
+	}
+
+	// Keep track of the connection so it can be closed from elsewhere (e.g. account status changes)
+	session := helpers.Sessions.Register(player.ID, helpers.SessionCrash, conn)
+	defer helpers.Sessions.Unregister(session)
+
+	crash.join(session)
+	defer crash.leave(session)
+
+	// Gaming session for the reality checks
+	startRealityChecks(player.ID)
+	defer stopRealityChecks(player.ID)
+
+	welcome := crash.snapshot()
+	welcome["type"] = "crash"
+	welcome["code"] = 200
+	welcome["message"] = "Joined the crash game"
+	welcome["bettingSeconds"] = config.CRASH_BETTING_SECONDS
+	welcome["houseEdge"] = config.CRASH_HOUSE_EDGE
+	session.WriteJSON(welcome)
+
+	conn.SetReadDeadline(time.Now().Add(time.Duration(config.SOCKET_TIMEOUT_DURATION) * time.Second))
+	for {
+		_, receivedMsg, readMessageErr := conn.ReadMessage()
+		if readMessageErr != nil {
+			break
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Duration(config.SOCKET_TIMEOUT_DURATION) * time.Second))
+
+		if !middleware.AllowWSMessage(session, receivedMsg, "bet") {
+			continue
+		}
+
+		session.WriteJSON(handleCrashMessage(player.ID, receivedMsg))
+	}
+
+	conn.Close()
+}
+
+// handleCrashMessage places the bet / cashes out as sent on the crash socket and returns the response
+func handleCrashMessage(playerID int, receivedMsg []byte) map[string]interface{} {
+	response := map[string]interface{}{
+		"type":    "betPlaced",
+		"message": "Bet placed, waiting for the round to start",
+		"code":    200,
+	}
+
+	// Error List
+	errorList := []string{}
+
+	parsedData, jsonParserErr := helpers.JsonParser(receivedMsg)
+	if jsonParserErr != nil {
+		response["errorsList"] = append(errorList, "Invalid JSON received")
+		response["code"] = 400
+		response["message"] = "Invalid JSON"
+		return response
+	}
+
+	switch action, _ := parsedData["action"].(string); action {
+	// Reality check acknowledgements aren't bets
+	case "acknowledgeRealityCheck":
+		response["type"] = "realityCheckAcknowledged"
+		if acknowledgeRealityCheck(playerID) {
+			response["message"] = "Reality check acknowledged"
+		} else {
+			response["code"] = 400
+			response["message"] = "There is no reality check to acknowledge"
+		}
+		return response
+
+	case "cashout":
+		return handleCrashCashout(playerID)
+	}
+
+	// A free bet can be used instead of a stake
+	freeBetID64, usesFreeBet := parsedData["freeBetId"].(float64)
+	freeBetID := int(freeBetID64)
+	if usesFreeBet && freeBetID <= 0 {
+		errorList = append(errorList, "Invalid freeBetId")
+	}
+
+	betAmount64, betAmountIsFloat64 := parsedData["betAmount"].(float64)
+	if (!betAmountIsFloat64 || betAmount64 <= 0) && !usesFreeBet {
+		errorList = append(errorList, "betAmount must be greater than 0")
+	}
+
+	// Optional target the bet is cashed out at automatically
+	var autoCashout *float32
+	if value, found := parsedData["autoCashout"]; found && value != nil {
+		target, isFloat64 := value.(float64)
+		target = math.Round(target*100) / 100
+		if !isFloat64 || target < crashMinAutoCashout || target > models.CrashMaxMultiplier {
+			errorList = append(errorList, fmt.Sprintf("autoCashout must be between %.2f and %d", crashMinAutoCashout, models.CrashMaxMultiplier))
+		} else {
+			target32 := float32(target)
+			autoCashout = &target32
+		}
+	}
+
+	// The last reality check must be acknowledged before betting again
+	if realityCheckPending(playerID) {
+		errorList = append(errorList, "Acknowledge the reality check before placing another bet")
+		response["errorCode"] = "REALITY_CHECK_ACK_REQUIRED"
+	}
+
+	if len(errorList) == 0 {
+		bet, err := crash.placeBet(playerID, float32(betAmount64), autoCashout, freeBetID)
+		if err != nil {
+			errorList = append(errorList, err.Error())
+
+			var accountStatusError *models.AccountStatusError
+			switch {
+			case errors.Is(err, errRoundClosed):
+				response["errorCode"] = "ROUND_CLOSED"
+			case errors.Is(err, errRoundAlreadyBet):
+				response["errorCode"] = "ROUND_ALREADY_BET"
+			case errors.As(err, &accountStatusError):
+				response["errorCode"] = accountStatusError.Code
+			}
+			addLimitErrorFields(response, err)
+			addRiskErrorFields(response, err)
+		} else {
+			response["bet"] = bet
+		}
+	}
+
+	if len(errorList) > 0 {
+		response["errorsList"] = errorList
+		response["code"] = 400
+		response["message"] = "Error creating bet, check error list"
+	}
+
+	return response
+}
+
+// handleCrashCashout cashes out the player's bet at the current multiplier and returns the response with its result
+func handleCrashCashout(playerID int) map[string]interface{} {
+	response := map[string]interface{}{
+		"type": "cashout",
+		"code": 200,
+	}
+
+	bet, err := crash.cashOut(playerID)
+	if err != nil {
+		response["code"] = 400
+		response["message"] = "Error cashing out"
+		response["errorsList"] = []string{err.Error()}
+		if errors.Is(err, errCrashNotRunning) {
+			response["errorCode"] = "ROUND_NOT_RUNNING"
+		} else if errors.Is(err, errNoCrashBet) {
+			response["errorCode"] = "NO_BET_TO_CASH_OUT"
+		}
+		return response
+	}
+
+	response["message"] = fmt.Sprintf("Cashed out at %.2fx", *bet.CashoutMultiplier)
+	response["roundId"] = bet.RoundID
+	response["cashoutMultiplier"] = *bet.CashoutMultiplier
+
+	settlement, err := settleCrashBet(*bet)
+	if err != nil {
+		log.Printf("Crash bet %d not settled (retried before the next round): %v\n", bet.ID, err)
+		response["message"] = fmt.Sprintf("Cashed out at %.2fx, the payout is pending", *bet.CashoutMultiplier)
+		return response
+	}
+	addCrashSettlementFields(response, settlement)
+
+	return response
+}
+
+// run opens the rounds one after the other while there are players on the socket
+func (game *crashGame) run() {
+	for {
+		game.settlePendingBets()
+
+		if game.playerCount() == 0 {
+			time.Sleep(time.Second)
+			continue
+		}
+
+		serverSeed, seedHash, err := models.NewCrashSeed()
+		if err != nil {
+			log.Println("Error opening crash round:", err)
+			time.Sleep(crashResultPause)
+			continue
+		}
+
+		crashPoint := models.CrashPoint(serverSeed, config.CRASH_HOUSE_EDGE)
+		startsAt := time.Now().Add(time.Duration(config.CRASH_BETTING_SECONDS * float32(time.Second)))
+		roundID, err := models.CreateCrashRound(serverSeed, seedHash, crashPoint, startsAt)
+		if err != nil {
+			log.Println("Error opening crash round:", err)
+			time.Sleep(crashResultPause)
+			continue
+		}
+
+		round := &crashRound{id: roundID, seedHash: seedHash, serverSeed: serverSeed, crashPoint: crashPoint, startsAt: startsAt, bets: map[int]*models.CrashBet{}}
+		game.mu.Lock()
+		game.round = round
+		game.mu.Unlock()
+
+		game.broadcast(map[string]interface{}{
+			"type":     "crashRoundOpen",
+			"code":     200,
+			"roundId":  roundID,
+			"seedHash": seedHash,
+			"startsAt": startsAt,
+		})
+
+		time.Sleep(time.Until(startsAt))
+
+		// Late bets are rejected from here on
+		game.mu.Lock()
+		round.running, round.startedAt = true, time.Now()
+		game.mu.Unlock()
+
+		if err := models.StartCrashRound(roundID); err != nil {
+			log.Println("Error starting crash round:", err) // Its bets are refunded on the next startup
+		} else {
+			game.broadcast(map[string]interface{}{
+				"type":    "crashRoundStart",
+				"code":    200,
+				"roundId": roundID,
+			})
+
+			game.ride(round)
+			game.endRound(round)
+		}
+
+		game.mu.Lock()
+		game.round = nil
+		game.mu.Unlock()
+
+		time.Sleep(crashResultPause)
+	}
+}
+
+// ride pushes the multiplier of the running round and triggers the auto cashouts until the crash point is reached
+func (game *crashGame) ride(round *crashRound) {
+	ticker := time.NewTicker(time.Duration(config.CRASH_TICK_MILLISECONDS) * time.Millisecond)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		game.mu.Lock()
+		multiplier := crashMultiplier(time.Since(round.startedAt))
+		if multiplier >= round.crashPoint {
+			// Cashouts are rejected from here on, targets below the crash point are still paid
+			round.crashed = true
+			cashouts := game.autoCashouts(round, round.crashPoint, false)
+			game.mu.Unlock()
+
+			game.settleCashouts(cashouts)
+			return
+		}
+		cashouts := game.autoCashouts(round, multiplier, true)
+		game.mu.Unlock()
+
+		game.broadcast(map[string]interface{}{
+			"type":       "crashTick",
+			"code":       200,
+			"roundId":    round.id,
+			"multiplier": multiplier,
+		})
+		game.settleCashouts(cashouts)
+	}
+}
+
+// endRound ends the round at its crash point, reveals its server seed and settles the bets that were lost
+func (game *crashGame) endRound(round *crashRound) {
+	if err := models.CrashRoundOver(round.id); err != nil {
+		log.Println("Error ending crash round:", err) // Its bets still riding are refunded on the next startup
+		return
+	}
+
+	game.mu.Lock()
+	lostBets := []models.CrashBet{}
+	for _, bet := range round.bets {
+		if bet.Status == models.CrashBetPlaced {
+			bet.Status = models.CrashBetLost
+			lostBets = append(lostBets, *bet)
+		}
+	}
+	bets := sortedCrashBets(round.bets)
+	game.mu.Unlock()
+
+	game.broadcast(map[string]interface{}{
+		"type":       "crashed",
+		"code":       200,
+		"roundId":    round.id,
+		"crashPoint": round.crashPoint,
+		"serverSeed": round.serverSeed,
+		"seedHash":   round.seedHash,
+		"bets":       bets,
+	})
+
+	for _, bet := range lostBets {
+		settlement, err := settleCrashBet(bet)
+		if err != nil {
+			log.Printf("Crash bet %d not settled (retried before the next round): %v\n", bet.ID, err)
+			continue
+		}
+		game.sendResult(bet, settlement)
+	}
+}
+
+// autoCashouts cashes out the riding bets whose target is reached (below the multiplier if not inclusive) at their target
+// The game must be locked, the bets returned are settled by the caller once it's unlocked
+func (game *crashGame) autoCashouts(round *crashRound, multiplier float32, inclusive bool) []models.CrashBet {
+	cashouts := []models.CrashBet{}
+	for _, bet := range round.bets {
+		if bet.Status != models.CrashBetPlaced || bet.AutoCashout == nil {
+			continue
+		}
+		if target := *bet.AutoCashout; target > multiplier || (target == multiplier && !inclusive) {
+			continue
+		}
+
+		cashedOut, err := models.CashOutCrashBet(bet.ID, *bet.AutoCashout)
+		if err != nil {
+			log.Printf("Crash bet %d not cashed out: %v\n", bet.ID, err)
+			continue
+		}
+		if cashedOut {
+			bet.Status, bet.CashoutMultiplier = models.CrashBetCashedOut, bet.AutoCashout
+			cashouts = append(cashouts, *bet)
+		}
+	}
+
+	return cashouts
+}
+
+// settleCashouts announces the auto cashouts and pays them out in the background
+func (game *crashGame) settleCashouts(cashouts []models.CrashBet) {
+	for _, bet := range cashouts {
+		game.broadcastCashout(bet)
+
+		go func(bet models.CrashBet) {
+			settlement, err := settleCrashBet(bet)
+			if err != nil {
+				log.Printf("Crash bet %d not settled (retried before the next round): %v\n", bet.ID, err)
+				return
+			}
+			game.sendResult(bet, settlement)
+		}(bet)
+	}
+}
+
+// placeBet takes the stake of a bet on the round taking bets and records it
+func (game *crashGame) placeBet(playerID int, betAmount float32, autoCashout *float32, freeBetID int) (*models.CrashBet, error) {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	round := game.round
+	if round == nil || round.running || !time.Now().Before(round.startsAt) {
+		return nil, errRoundClosed
+	}
+	if _, found := round.bets[playerID]; found {
+		return nil, errRoundAlreadyBet
+	}
+
+	crashBetID, err := placeUpfrontBet(playerID, models.GameCrash, betAmount, freeBetID, fmt.Sprintf("crash:%d", round.id),
+		func(stake betStake, walletStake float32) (int, error) {
+			crashBet := models.CrashBet{
+				RoundID:     round.id,
+				PlayerID:    playerID,
+				BetAmount:   stake.BetAmount,
+				WalletStake: walletStake,
+				AutoCashout: autoCashout,
+			}
+			if stake.FreeBet != nil {
+				crashBet.FreeBetID = &stake.FreeBet.ID
+			}
+			return models.PlaceCrashBet(crashBet)
+		}, models.DeleteCrashBet)
+	if err != nil {
+		return nil, err
+	}
+
+	placedBet, err := models.GetCrashBetByID(crashBetID)
+	if err != nil {
+		return nil, err
+	}
+	round.bets[playerID] = placedBet
+
+	go game.broadcast(map[string]interface{}{
+		"type":    "crashBet",
+		"code":    200,
+		"roundId": round.id,
+		"bet":     placedBet,
+	})
+
+	return placedBet, nil
+}
+
+// cashOut stops the player's bet at the current multiplier, if the round hasn't reached its crash point yet
+func (game *crashGame) cashOut(playerID int) (*models.CrashBet, error) {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	round := game.round
+	if round == nil || !round.running || round.crashed {
+		return nil, errCrashNotRunning
+	}
+
+	bet, found := round.bets[playerID]
+	if !found || bet.Status != models.CrashBetPlaced {
+		return nil, errNoCrashBet
+	}
+
+	// The crash point may be reached before the next tick
+	multiplier := crashMultiplier(time.Since(round.startedAt))
+	if multiplier >= round.crashPoint {
+		return nil, errCrashNotRunning
+	}
+
+	cashedOut, err := models.CashOutCrashBet(bet.ID, multiplier)
+	if err != nil {
+		return nil, err
+	}
+	if !cashedOut {
+		return nil, errNoCrashBet
+	}
+	bet.Status, bet.CashoutMultiplier = models.CrashBetCashedOut, &multiplier
+
+	cashedOutBet := *bet
+	go game.broadcastCashout(cashedOutBet)
+
+	return &cashedOutBet, nil
+}
+
+// settlePendingBets settles the cashed out / lost bets whose settlement failed or was interrupted by a restart
+func (game *crashGame) settlePendingBets() {
+	bets, err := models.GetUnsettledCrashBets()
+	if err != nil {
+		log.Println("Error fetching unsettled crash bets:", err)
+		return
+	}
+
+	for _, bet := range bets {
+		settlement, err := settleCrashBet(bet)
+		if err != nil {
+			log.Printf("Crash bet %d not settled (retried before the next round): %v\n", bet.ID, err)
+			continue
+		}
+		game.sendResult(bet, settlement)
+	}
+}
+
+// settleCrashBet pays out a cashed out bet at its multiplier (or records a lost one) like the other bets
+func settleCrashBet(bet models.CrashBet) (betSettlement, error) {
+	if err := waitForPlayerBetting(bet.PlayerID, upfrontBetTimeout); err != nil {
+		return betSettlement{}, err
+	}
+	defer models.UpdatePlayerBettingStatus(bet.PlayerID, false)
+
+	claimed, err := models.ClaimCrashBetSettlement(bet.ID)
+	if err != nil {
+		return betSettlement{}, err
+	}
+	if !claimed {
+		return betSettlement{}, errors.New("crash bet was already settled")
+	}
+
+	player, err := models.GetPlayerByID(bet.PlayerID)
+	if err != nil {
+		models.ReleaseCrashBetSettlement(bet.ID)
+		return betSettlement{}, err
+	}
+
+	// The stake was taken and recorded when the bet was placed
+	stake := betStake{BetAmount: bet.BetAmount, Recorded: true}
+	if bet.FreeBetID != nil {
+		stake.FreeBet = &models.FreeBet{ID: *bet.FreeBetID, Amount: bet.BetAmount}
+	}
+
+	var payoutMultiplier float32
+	if bet.Status == models.CrashBetCashedOut {
+		payoutMultiplier = *bet.CashoutMultiplier
+	}
+
+	settlement, err := settleBet(player, stake, models.Bet{Game: models.GameCrash, BetType: "crash"}, payoutMultiplier)
+	if err != nil {
+		models.ReleaseCrashBetSettlement(bet.ID)
+		return betSettlement{}, err
+	}
+
+	models.SetCrashBetBet(bet.ID, settlement.BetID)
+
+	return settlement, nil
+}
+
+// refundCrashBet gives the stake of a bet still riding in a round that never finished back
+func refundCrashBet(bet models.CrashBet) error {
+	return refundUpfrontStake(bet.PlayerID, bet.BetAmount, bet.WalletStake, bet.FreeBetID, fmt.Sprintf("crash:%d", bet.RoundID),
+		func() (bool, error) { return models.ClaimCrashBetRefund(bet.ID) },
+		func() error { return models.ReleaseCrashBetRefund(bet.ID) })
+}
+
+// crashMultiplier returns the multiplier of a round running for the duration, rounded down to the cent
+func crashMultiplier(elapsed time.Duration) float32 {
+	return float32(math.Floor(100*math.Exp(crashGrowthRate*elapsed.Seconds())) / 100)
+}
+
+// addCrashSettlementFields adds the result of a settled crash bet to a message
+func addCrashSettlementFields(message map[string]interface{}, settlement betSettlement) {
+	message["playerWin"] = settlement.PlayerWin
+	message["winnings"] = settlement.Winnings
+	message["bonusReleased"] = settlement.BonusReleased
+	message["loyaltyPoints"] = settlement.LoyaltyPoints
+	message["betId"] = settlement.BetID
+	if settlement.JackpotWin > 0 {
+		message["jackpotWin"] = settlement.JackpotWin
+	}
+}
+
+// sortedCrashBets returns copies of the bets of a round in the order they were placed
+func sortedCrashBets(bets map[int]*models.CrashBet) []models.CrashBet {
+	sorted := make([]models.CrashBet, 0, len(bets))
+	for _, bet := range bets {
+		sorted = append(sorted, *bet)
+	}
+	slices.SortFunc(sorted, func(a, b models.CrashBet) int { return a.ID - b.ID })
+
+	return sorted
+}
+
+func (game *crashGame) join(session *helpers.WSSession) {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	game.sessions[session.ID] = session
+}
+
+func (game *crashGame) leave(session *helpers.WSSession) {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	delete(game.sessions, session.ID)
+}
+
+// playerCount returns how many players are on the socket
+func (game *crashGame) playerCount() int {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	players := map[int]bool{}
+	for _, session := range game.sessions {
+		players[session.PlayerID] = true
+	}
+
+	return len(players)
+}
+
+// snapshot returns the current round with the bets placed on it (its crash point stays hidden)
+func (game *crashGame) snapshot() map[string]interface{} {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	snapshot := map[string]interface{}{"round": nil}
+	if round := game.round; round != nil {
+		roundSnapshot := map[string]interface{}{
+			"roundId":  round.id,
+			"seedHash": round.seedHash,
+			"startsAt": round.startsAt,
+			"running":  round.running,
+			"bets":     sortedCrashBets(round.bets),
+		}
+		if round.running && !round.crashed {
+			roundSnapshot["multiplier"] = crashMultiplier(time.Since(round.startedAt))
+		}
+		snapshot["round"] = roundSnapshot
+	}
+
+	return snapshot
+}
+
+func (game *crashGame) broadcast(message map[string]interface{}) {
+	game.mu.Lock()
+	sessions := make([]*helpers.WSSession, 0, len(game.sessions))
+	for _, session := range game.sessions {
+		sessions = append(sessions, session)
+	}
+	game.mu.Unlock()
+
+	for _, session := range sessions {
+		session.WriteJSON(message)
+	}
+}
+
+// broadcastCashout announces a cashout to everyone on the socket
+func (game *crashGame) broadcastCashout(bet models.CrashBet) {
+	game.broadcast(map[string]interface{}{
+		"type":              "crashCashout",
+		"code":              200,
+		"roundId":           bet.RoundID,
+		"name":              bet.Name,
+		"cashoutMultiplier": bet.CashoutMultiplier,
+	})
+}
+
+// sendResult sends the result of a settled bet to the player's crash sockets
+func (game *crashGame) sendResult(bet models.CrashBet, settlement betSettlement) {
+	message := map[string]interface{}{
+		"type":              "crashResult",
+		"code":              200,
+		"roundId":           bet.RoundID,
+		"cashoutMultiplier": bet.CashoutMultiplier,
+	}
+	addCrashSettlementFields(message, settlement)
+
+	game.mu.Lock()
+	sessions := []*helpers.WSSession{}
+	for _, session := range game.sessions {
+		if session.PlayerID == bet.PlayerID {
+			sessions = append(sessions, session)
+		}
+	}
+	game.mu.Unlock()
+
+	for _, session := range sessions {
+		session.WriteJSON(message)
+	}
+}
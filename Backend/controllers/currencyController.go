@@ -0,0 +1,239 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"main/audit"
+	"main/config"
+	"main/helpers"
+	"main/models"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+Currencies and per-currency wallets
+
+GET /currencies                       -> Currencies in use with their exchange rate to the base currency and bet limits (public)
+GET /player/me/wallets                -> The player's wallets in every currency, the active one (played with) first
+PUT /player/me/wallets/active         -> Switches the currency the player plays in {"currency": "USD"}
+PUT /admin/currencies/{code}          -> Admin sets a currency's rate and bet limits {"rate": 0.92, "minBet": 0.1, "maxBet": 10000}
+GET /admin/reports/currencies?since=  -> Wagered, paid out, deposits and withdrawals per currency with their base currency
+                                         equivalents (support can read, since: YYYY-MM-DD, default: the last 30 days)
+
+! The active wallet is the one every balance update works on, the others are parked until the player switches to them
+! Switching is refused (CURRENCY_SWITCH_BLOCKED) while room / crash bets are open, they're settled in their currency
+! Bets must be within the currency's min / max bet (CURRENCY_MIN_BET / CURRENCY_MAX_BET)
+! Limits, VIP tiers, bonuses, jackpot, tournaments, cashbacks and leaderboards are in the base currency (BASE_CURRENCY),
+! amounts are converted at the current rate and records keep the rate they were made at
+? The play socket also switches the currency with {"currency": "BRL", ...} along a bet
+*/
+
+// Period of the currency report when no since is given
+const currencyReportDefaultDays = 30
+
+// How long a currency switch waits for a bet in progress to end
+const currencySwitchLockTimeout = 5 * time.Second
+
+type ActiveCurrencyReqBody struct {
+	Currency string `json:"currency"`
+}
+
+type CurrencyReqBody struct {
+	Rate   float32 `json:"rate"`
+	MinBet float32 `json:"minBet"`
+	MaxBet float32 `json:"maxBet"`
+}
+
+// SeedCurrencies loads the configured currencies, the server can't run without them
+func SeedCurrencies() {
+	if err := models.SeedCurrencies(config.BASE_CURRENCY, config.CURRENCIES); err != nil {
+		log.Fatal("Error seeding currencies: ", err)
+	}
+}
+
+func HandleCurrencies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"baseCurrency": models.BaseCurrency(),
+		"currencies":   models.GetCurrencies(),
+	})
+}
+
+func HandlePlayerWallets(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	wallets, err := models.GetPlayerWallets(player.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"currency": player.Currency,
+		"wallets":  wallets,
+	})
+}
+
+func HandleActiveWallet(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var currencyReqBody ActiveCurrencyReqBody
+	err := json.NewDecoder(r.Body).Decode(&currencyReqBody)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Request is missing data (currency)"})
+		return
+	}
+	defer r.Body.Close()
+
+	currency := strings.ToUpper(currencyReqBody.Currency)
+	if !models.IsSupportedCurrency(currency) {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"message": fmt.Sprintf("currency must be one of %s", strings.Join(config.CURRENCIES, ", ")),
+		})
+		return
+	}
+
+	// The balances are moved like the other balance updates, with the betting status as a processing lock
+	if err := waitForPlayerBetting(player.ID, currencySwitchLockTimeout); err != nil {
+		helpers.WriteJSONResponse(w, http.StatusConflict, map[string]interface{}{"message": "Cannot switch currency while player is in Betting Process"})
+		return
+	}
+	err = models.SwitchPlayerCurrency(player.ID, currency)
+	models.UpdatePlayerBettingStatus(player.ID, false)
+
+	if err != nil {
+		var currencySwitchError *models.CurrencySwitchError
+		if errors.As(err, &currencySwitchError) {
+			helpers.WriteJSONResponse(w, http.StatusConflict, map[string]interface{}{
+				"message":   currencySwitchError.Message,
+				"errorCode": models.CurrencySwitchBlocked,
+			})
+			return
+		}
+
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	wallets, err := models.GetPlayerWallets(player.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message":  "Now playing in " + currency,
+		"currency": currency,
+		"wallets":  wallets,
+	})
+}
+
+func HandleAdminUpdateCurrency(w http.ResponseWriter, r *http.Request, admin *models.Player) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	current, found := models.GetCurrency(strings.ToUpper(r.PathValue("code")))
+	if !found {
+		helpers.WriteJSONResponse(w, http.StatusNotFound, map[string]interface{}{"message": "Currency not found"})
+		return
+	}
+
+	var currencyReqBody CurrencyReqBody
+	err := json.NewDecoder(r.Body).Decode(&currencyReqBody)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Request is missing data (rate, minBet, maxBet)"})
+		return
+	}
+	defer r.Body.Close()
+
+	// Error List
+	errorList := []string{}
+
+	if current.IsBase && currencyReqBody.Rate != 1 {
+		errorList = append(errorList, "rate of the base currency is always 1")
+	} else if currencyReqBody.Rate <= 0 {
+		errorList = append(errorList, "rate must be greater than 0")
+	}
+
+	if currencyReqBody.MinBet < 0 || currencyReqBody.MaxBet < 0 {
+		errorList = append(errorList, "minBet and maxBet can't be negative (0 = no limit)")
+	}
+
+	if currencyReqBody.MaxBet > 0 && currencyReqBody.MinBet > currencyReqBody.MaxBet {
+		errorList = append(errorList, "minBet can't be above maxBet")
+	}
+
+	if len(errorList) > 0 {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"message":    "Invalid currency, check error list",
+			"errorsList": errorList,
+		})
+		return
+	}
+
+	currency, err := models.UpdateCurrency(current.Code, currencyReqBody.Rate, currencyReqBody.MinBet, currencyReqBody.MaxBet, admin.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	audit.Record(r, admin.ID, audit.ActionCurrencyUpdate, 0, map[string]interface{}{
+		"currency":  currency.Code,
+		"oldRate":   current.Rate,
+		"newRate":   currency.Rate,
+		"oldMinBet": current.MinBet,
+		"newMinBet": currency.MinBet,
+		"oldMaxBet": current.MaxBet,
+		"newMaxBet": currency.MaxBet,
+	})
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message":  "Currency updated",
+		"currency": currency,
+	})
+}
+
+func HandleAdminCurrencyReport(w http.ResponseWriter, r *http.Request, admin *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	since := time.Now().UTC().AddDate(0, 0, -currencyReportDefaultDays)
+	if sinceParam := r.URL.Query().Get("since"); sinceParam != "" {
+		parsed, err := time.Parse("2006-01-02", sinceParam)
+		if err != nil {
+			helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "since must be a date (YYYY-MM-DD)"})
+			return
+		}
+		since = parsed
+	}
+
+	report, err := models.GetCurrencyReport(since)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"baseCurrency": models.BaseCurrency(),
+		"since":        since,
+		"currencies":   report,
+	})
+}
@@ -23,23 +23,20 @@ A Player Requests for EndPlay Endpoint must have the following body : {"cashInAm
 ? that is subscribed via a go routine
 */
 func HandleEndPlayWS(w http.ResponseWriter, r *http.Request) {
+	player, authenticated := middleware.AuthenticateWS(w, r)
+	if !authenticated {
+		return
+	}
+
 	conn, upgradeConErr := helpers.WSUpgrader.Upgrade(w, r, nil)
 	// On error
 	if upgradeConErr != nil {
 		return
 	}
 
-	player, authError := middleware.AuthenticateUser(r)
-	if authError != nil {
-		response := map[string]interface{}{"code": 401, "message": authError.Error()}
-		stringifiedResponse, _ := helpers.JsonStringifier(response)
-
-		conn.WriteMessage(websocket.TextMessage, []byte(stringifiedResponse))
-		// Conn.WriteControl Should send a close code and a message but it's not working properly.
-		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "No auth token provided"), time.Now())
-		conn.Close()
-		return
-	}
+	// Keep track of the connection so it can be closed from elsewhere (e.g. account status changes)
+	session := helpers.Sessions.Register(player.ID, helpers.SessionEndPlay, conn)
+	defer helpers.Sessions.Unregister(session)
 	// Timeout float to seconds
 	timeoutDuration := time.Duration(config.SOCKET_TIMEOUT_DURATION * float32(time.Second))
 
@@ -54,15 +51,19 @@ func HandleEndPlayWS(w http.ResponseWriter, r *http.Request) {
 		// Receive ws message
 		messageType, receivedMsg, readMessageErr := conn.ReadMessage() // Blocking
 		if readMessageErr != nil {
-			if websocket.IsUnexpectedCloseError(readMessageErr, websocket.CloseGoingAway) {
-				break
-			}
-			continue
+			// Read errors are permanent (closed by the client, timed out or closed by Sessions.CloseAll)
+			break
 		}
 
 		//
 		timeout.Stop()
 
+		// Rate limited messages only get an error frame
+		if !middleware.AllowWSMessage(session, receivedMsg, "cashIn") {
+			timeout.Reset(timeoutDuration)
+			continue
+		}
+
 		// Default Response
 		response := map[string]interface{}{
 			"message": "Cash In Successful",
@@ -81,7 +82,7 @@ func HandleEndPlayWS(w http.ResponseWriter, r *http.Request) {
 			response["message"] = "Invalid JSON"
 
 			stringifiedResponse, _ := helpers.JsonStringifier(response)
-			conn.WriteMessage(messageType, []byte(stringifiedResponse))
+			session.WriteMessage(messageType, []byte(stringifiedResponse))
 			continue // Skip the rest of the loop
 		}
 
@@ -102,30 +103,22 @@ func HandleEndPlayWS(w http.ResponseWriter, r *http.Request) {
 
 		}
 
-		// Check if Player Is Already Betting
-		isAlreadyBetting, errCheckingStatus := models.CheckPlayerBettingStatus(player.ID)
-		if errCheckingStatus != nil {
-			errorList = append(errorList, errCheckingStatus.Error())
-
-		}
-
-		// Check if Player Is Already Betting
-		if isAlreadyBetting {
-			errorList = append(errorList, "Player already betting, please await the bet processing...")
-		}
-
-		// Process Betting if player is not already in Betting Process and if the status was properly provided
-		if !isAlreadyBetting && errCheckingStatus == nil {
-
-			models.UpdatePlayerBettingStatus(player.ID, true)
-
-			err := processCashIn(player.ID, cashInAmount32)
-			if err != nil {
-				errorList = append(errorList, err.Error())
-
+		// Process the cash in if it's valid and the player isn't already in Betting Process (checked and set in one query)
+		if len(errorList) == 0 {
+			locked, errLocking := models.TrySetPlayerBetting(player.ID)
+			if errLocking != nil {
+				errorList = append(errorList, errLocking.Error())
+			} else if !locked {
+				errorList = append(errorList, "Player already betting, please await the bet processing...")
+			} else {
+				err := processCashIn(player.ID, cashInAmount32)
+				if err != nil {
+					errorList = append(errorList, err.Error())
+				}
+
+				// Only released by the cash in that set it
+				models.UpdatePlayerBettingStatus(player.ID, false)
 			}
-
-			models.UpdatePlayerBettingStatus(player.ID, false)
 		}
 
 		// Check if any errors occurred
@@ -137,7 +130,7 @@ func HandleEndPlayWS(w http.ResponseWriter, r *http.Request) {
 
 		stringifiedResponse, _ := helpers.JsonStringifier(response)
 
-		if writingMessageErr := conn.WriteMessage(messageType, []byte(stringifiedResponse)); writingMessageErr != nil {
+		if writingMessageErr := session.WriteMessage(messageType, []byte(stringifiedResponse)); writingMessageErr != nil {
 			break
 		}
 
@@ -164,6 +157,11 @@ func processCashIn(id int, cashInAmount float32) error {
 		return errFindingPlayer
 	}
 
+	// Status may have changed since the socket was opened
+	if statusErr := player.CanPerform(models.ActionCashIn); statusErr != nil {
+		return statusErr
+	}
+
 	// Check if Player Has Enough Bet Balance
 	if player.BetBalance < cashInAmount {
 		return errors.New("Player does not have enough bet Balance to cash In")
@@ -175,6 +173,8 @@ func processCashIn(id int, cashInAmount float32) error {
 		return updatingBalanceError
 	}
 
+	models.RecordTransaction(id, models.TransactionCashIn, cashInAmount, player.Wallet+cashInAmount, player.BetBalance-cashInAmount, "")
+
 	isProcessed = true
 
 	for {
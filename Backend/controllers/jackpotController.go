@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"main/config"
+	"main/helpers"
+	"main/middleware"
+	"main/models"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+/*
+Progressive jackpot fed by JACKPOT_CONTRIBUTION_PERCENT of every stake (free bets excluded), on every game
+
+GET /jackpot              -> Current pool and the latest wins (public)
+WS  /ws/jackpot           -> Public socket, sends the pool on connect and pushes jackpotPool / jackpotWon
+GET /admin/jackpot/ledger -> Ledger of the pool with its reconciliation (support / admin, ?limit=&offset=)
+
+! Each contributing bet is a draw, won with a chance of 1 in JACKPOT_ODDS: the whole pool is added to the winner's bet balance
+! and the pool starts again from JACKPOT_SEED, in a single database transaction
+! Every movement of the pool (seed, contribution, win) is in the ledger, the pool must always be the sum of the ledger
+? The contribution comes out of the house's share of the stake, the player's balances aren't charged for it
+? The pool is kept in the base currency, contributions and wins are converted from / to the player's currency
+*/
+
+// How many of the latest wins GET /jackpot returns
+const jackpotWinsSize = 10
+
+// SeedJackpot puts the first pool in place when the jackpot is used for the first time
+func SeedJackpot() {
+	if err := models.SeedJackpot(config.JACKPOT_SEED); err != nil {
+		log.Println("Error seeding jackpot:", err)
+	}
+}
+
+func HandleJackpot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pool, err := models.GetJackpotPool()
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	wins, err := models.GetJackpotWins(jackpotWinsSize)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	// Wins are public, only with the winner's (maybe masked) name
+	latestWins := []map[string]interface{}{}
+	for _, win := range wins {
+		latestWins = append(latestWins, map[string]interface{}{"name": win.Name, "amount": -win.Amount, "wonAt": win.CreatedAt})
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"pool":                pool,
+		"contributionPercent": config.JACKPOT_CONTRIBUTION_PERCENT,
+		"odds":                config.JACKPOT_ODDS,
+		"latestWins":          latestWins,
+	})
+}
+
+func HandleJackpotWS(w http.ResponseWriter, r *http.Request) {
+	conn, upgradeConErr := helpers.WSUpgrader.Upgrade(w, r, nil)
+	if upgradeConErr != nil {
+		return
+	}
+
+	// Public socket, no player behind it
+	session := helpers.Sessions.Register(0, helpers.SessionJackpot, conn)
+	defer helpers.Sessions.Unregister(session)
+
+	pool, err := models.GetJackpotPool()
+	if err != nil {
+		session.WriteJSON(map[string]interface{}{"code": 500, "message": err.Error()})
+		conn.Close()
+		return
+	}
+
+	session.WriteJSON(map[string]interface{}{
+		"type":    "jackpotPool",
+		"code":    200,
+		"message": "Jackpot retrieved with success!",
+		"pool":    pool,
+	})
+
+	// Keep the connection alive until the timeout or the client leaves (incoming messages are ignored)
+	conn.SetReadDeadline(time.Now().Add(time.Duration(config.SOCKET_TIMEOUT_DURATION) * time.Second))
+	for {
+		_, receivedMsg, readMessageErr := conn.ReadMessage()
+		if readMessageErr != nil {
+			break
+		}
+
+		// Ignored, but flooding the socket closes it
+		middleware.AllowWSMessage(session, receivedMsg, "message")
+	}
+
+	conn.Close()
+}
+
+func HandleAdminJackpotLedger(w http.ResponseWriter, r *http.Request, admin *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, offset := helpers.ParsePagination(r)
+
+	entries, err := models.GetJackpotLedger(limit, offset)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	pool, err := models.GetJackpotPool()
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	ledgerTotal, err := models.GetJackpotLedgerTotal()
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"pool":        pool,
+		"ledgerTotal": ledgerTotal,
+		"reconciled":  math.Abs(float64(pool-ledgerTotal)) < 0.005,
+		"entries":     entries,
+		"limit":       limit,
+		"offset":      offset,
+	})
+}
+
+// playJackpot feeds the jackpot with part of a settled bet's stake and draws it
+// Returns the amount credited, in the player's currency (0 if not won)
+func playJackpot(player *models.Player, betID int, betAmount float32) float32 {
+	if config.JACKPOT_CONTRIBUTION_PERCENT <= 0 || betAmount <= 0 {
+		return 0
+	}
+
+	// The pool is kept in the base currency
+	contribution := models.ToBaseCurrency(betAmount*config.JACKPOT_CONTRIBUTION_PERCENT/100, player.Currency)
+	pool, err := models.ContributeToJackpot(contribution, player.ID, betID)
+	if err != nil {
+		log.Println("Error contributing to jackpot:", err)
+		return 0
+	}
+
+	if rand.Intn(config.JACKPOT_ODDS) != 0 {
+		go pushJackpot(map[string]interface{}{"type": "jackpotPool", "code": 200, "pool": pool})
+		return 0
+	}
+
+	won, credited, err := models.WinJackpot(player.ID, betID, config.JACKPOT_SEED)
+	if err != nil {
+		log.Println("Error paying jackpot:", err)
+		return 0
+	}
+	if won == 0 {
+		return 0
+	}
+	player.BetBalance += credited
+
+	name := player.Name
+	if player.MaskName {
+		name = models.MaskPlayerName(player.Name)
+	}
+	go pushJackpot(map[string]interface{}{
+		"type":    "jackpotWon",
+		"code":    200,
+		"message": fmt.Sprintf("%s won the jackpot of %.2f!", name, won),
+		"name":    name,
+		"amount":  won,
+		"pool":    config.JACKPOT_SEED,
+	})
+
+	return credited
+}
+
+// pushJackpot sends a message to the public jackpot sockets
+func pushJackpot(message map[string]interface{}) {
+	for _, wsSession := range helpers.Sessions.PlayerSessions(0, helpers.SessionJackpot) {
+		wsSession.WriteJSON(message)
+	}
+}
@@ -0,0 +1,546 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"main/audit"
+	"main/config"
+	"main/helpers"
+	"main/models"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Identity verification (KYC)
+
+GET  /player/me/kyc                   -> The player's KYC status, profile, documents and how much they can withdraw unverified
+PUT  /player/me/kyc/profile           -> Sets the profile {"fullName": string, "dateOfBirth": "YYYY-MM-DD", "address": string, "country": "XX"}
+POST /player/me/kyc/documents         -> Uploads a document (multipart form: documentType, document)
+POST /player/me/kyc/submit            -> Sends the profile and documents for review (status -> pending)
+GET  /admin/kyc?status=               -> Players waiting for a review, longest waiting first (default status: pending, support can read)
+GET  /admin/players/{id}/kyc          -> A player's profile, documents and review history (support can read)
+GET  /admin/kyc/documents/{id}        -> Downloads a document (support can read)
+POST /admin/players/{id}/kyc/review   -> Admin sets the player's KYC status {"status": string, "note": string}
+
+! Withdrawals taking the player's total withdrawals (cancelled / rejected excluded) over KYC_WITHDRAWAL_THRESHOLD
+! need a verified identity, otherwise they're refused with the KYC_REQUIRED error code
+! Documents are JPEG, PNG or PDF files of up to KYC_MAX_DOCUMENT_MB, the type is checked on the content and not the file name
+! They're stored in KYC_UPLOAD_DIR/<playerId>/ under a random name, only staff can download them
+! The profile and documents can't change while the review is pending or once verified
+? Reviews push {"type": "kycUpdate"} to the player's wallet sockets
+*/
+
+// Players must be adults
+const kycMinimumAge = 18
+
+// Document content types accepted (sniffed from the file), with the extension they're stored under
+var kycDocumentContentTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"application/pdf": ".pdf",
+}
+
+var kycCountryRegex = regexp.MustCompile(`^[A-Z]{2}$`)
+
+type KYCProfileReqBody struct {
+	FullName    string `json:"fullName"`
+	DateOfBirth string `json:"dateOfBirth"`
+	Address     string `json:"address"`
+	Country     string `json:"country"`
+}
+
+type KYCReviewReqBody struct {
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
+
+func HandlePlayerKYC(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	profile, err := models.GetKYCProfile(player.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	documents, err := models.GetKYCDocuments(player.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	withdrawn, err := models.GetWithdrawnTotal(player.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"kycStatus":           player.KYCStatus,
+		"profile":             profile,
+		"documents":           documents,
+		"withdrawnTotal":      withdrawn,
+		"withdrawalThreshold": config.KYC_WITHDRAWAL_THRESHOLD,
+	})
+}
+
+func HandleUpdateKYCProfile(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !kycEditable(w, player) {
+		return
+	}
+
+	var profileReqBody KYCProfileReqBody
+	err := json.NewDecoder(r.Body).Decode(&profileReqBody)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Request is missing data (fullName, dateOfBirth, address, country)"})
+		return
+	}
+	defer r.Body.Close()
+
+	profile := models.KYCProfile{
+		PlayerID:    player.ID,
+		FullName:    strings.TrimSpace(profileReqBody.FullName),
+		DateOfBirth: strings.TrimSpace(profileReqBody.DateOfBirth),
+		Address:     strings.TrimSpace(profileReqBody.Address),
+		Country:     strings.ToUpper(strings.TrimSpace(profileReqBody.Country)),
+	}
+
+	// Error List
+	errorList := []string{}
+
+	if profile.FullName == "" || len(profile.FullName) > 100 {
+		errorList = append(errorList, "fullName is required (up to 100 characters)")
+	}
+
+	if dateOfBirth, err := time.Parse("2006-01-02", profile.DateOfBirth); err != nil {
+		errorList = append(errorList, "dateOfBirth must be a date (YYYY-MM-DD)")
+	} else if time.Now().AddDate(-kycMinimumAge, 0, 0).Before(dateOfBirth) {
+		errorList = append(errorList, fmt.Sprintf("players must be at least %d years old", kycMinimumAge))
+	}
+
+	if profile.Address == "" || len(profile.Address) > 200 {
+		errorList = append(errorList, "address is required (up to 200 characters)")
+	}
+
+	if !kycCountryRegex.MatchString(profile.Country) {
+		errorList = append(errorList, "country must be a 2 letter country code (ISO 3166-1)")
+	}
+
+	if len(errorList) > 0 {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"message":    "Invalid KYC profile, check error list",
+			"errorsList": errorList,
+		})
+		return
+	}
+
+	if err := models.SaveKYCProfile(profile); err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	saved, _ := models.GetKYCProfile(player.ID)
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "KYC profile saved",
+		"profile": saved,
+	})
+}
+
+func HandleUploadKYCDocument(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !kycEditable(w, player) {
+		return
+	}
+
+	// Room for the multipart overhead on top of the file itself
+	maxSize := int64(config.KYC_MAX_DOCUMENT_MB * 1024 * 1024)
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+64*1024)
+	if err := r.ParseMultipartForm(maxSize); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			helpers.WriteJSONResponse(w, http.StatusRequestEntityTooLarge, map[string]interface{}{
+				"message": fmt.Sprintf("Documents can't be larger than %v MB", config.KYC_MAX_DOCUMENT_MB),
+			})
+			return
+		}
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Request must be a multipart form (documentType, document)"})
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	documentType := r.FormValue("documentType")
+	if !models.IsValidKYCDocumentType(documentType) {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"message": fmt.Sprintf("documentType must be one of %v", models.KYCDocumentTypes),
+		})
+		return
+	}
+
+	file, header, err := r.FormFile("document")
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Request is missing the document file"})
+		return
+	}
+	defer file.Close()
+
+	if header.Size == 0 {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Document is empty"})
+		return
+	}
+	if header.Size > maxSize {
+		helpers.WriteJSONResponse(w, http.StatusRequestEntityTooLarge, map[string]interface{}{
+			"message": fmt.Sprintf("Documents can't be larger than %v MB", config.KYC_MAX_DOCUMENT_MB),
+		})
+		return
+	}
+
+	// The type comes from the content, the client's file name and Content-Type aren't trusted
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Could not read the document"})
+		return
+	}
+	contentType := http.DetectContentType(head[:n])
+	extension, ok := kycDocumentContentTypes[contentType]
+	if !ok {
+		helpers.WriteJSONResponse(w, http.StatusUnsupportedMediaType, map[string]interface{}{
+			"message": "Documents must be JPEG, PNG or PDF files",
+		})
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	fileName, err := storeKYCDocument(player.ID, extension, file)
+	if err != nil {
+		log.Println("Error storing KYC document:", err)
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": "Could not store the document"})
+		return
+	}
+
+	document, err := models.AddKYCDocument(models.KYCDocument{
+		PlayerID:     player.ID,
+		DocumentType: documentType,
+		FileName:     fileName,
+		OriginalName: filepath.Base(header.Filename),
+		ContentType:  contentType,
+		Size:         header.Size,
+	})
+	if err != nil {
+		os.Remove(filepath.Join(config.KYC_UPLOAD_DIR, fileName))
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"message":  "Document uploaded",
+		"document": document,
+	})
+}
+
+func HandleSubmitKYC(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !kycEditable(w, player) {
+		return
+	}
+
+	profile, err := models.GetKYCProfile(player.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	documents, err := models.GetKYCDocuments(player.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	// Error List
+	errorList := []string{}
+
+	if profile == nil {
+		errorList = append(errorList, "the profile must be filled")
+	}
+
+	if len(documents) == 0 {
+		errorList = append(errorList, "at least one document must be uploaded")
+	}
+
+	if len(errorList) > 0 {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"message":    "KYC can't be submitted yet, check error list",
+			"errorsList": errorList,
+		})
+		return
+	}
+
+	updated, err := models.UpdatePlayerKYCStatus(player.ID, []string{models.KYCNone, models.KYCRejected}, models.KYCPending, "Submitted by the player", nil)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+	if !updated {
+		helpers.WriteJSONResponse(w, http.StatusConflict, map[string]interface{}{"message": "KYC was already submitted"})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message":   "KYC submitted, waiting for review",
+		"kycStatus": models.KYCPending,
+	})
+}
+
+func HandleAdminKYCQueue(w http.ResponseWriter, r *http.Request, staff *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = models.KYCPending
+	}
+	if !models.IsValidKYCStatus(status) {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"message": fmt.Sprintf("status must be one of %v", models.KYCStatuses),
+		})
+		return
+	}
+
+	limit, offset := helpers.ParsePagination(r)
+
+	queue, err := models.GetKYCQueue(status, limit, offset)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"players": queue,
+		"status":  status,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+func HandleAdminPlayerKYC(w http.ResponseWriter, r *http.Request, staff *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	player, ok := findPlayerFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	profile, err := models.GetKYCProfile(player.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	documents, err := models.GetKYCDocuments(player.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	reviews, err := models.GetKYCReviews(player.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	withdrawn, _ := models.GetWithdrawnTotal(player.ID)
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"playerId":       player.ID,
+		"kycStatus":      player.KYCStatus,
+		"profile":        profile,
+		"documents":      documents,
+		"reviews":        reviews,
+		"withdrawnTotal": withdrawn,
+	})
+}
+
+func HandleAdminKYCDocument(w http.ResponseWriter, r *http.Request, staff *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	documentID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Invalid document id"})
+		return
+	}
+
+	document, err := models.GetKYCDocumentByID(documentID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusNotFound, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	file, err := os.Open(filepath.Join(config.KYC_UPLOAD_DIR, document.FileName))
+	if err != nil {
+		log.Printf("KYC document %d missing on disk: %v\n", document.ID, err)
+		helpers.WriteJSONResponse(w, http.StatusNotFound, map[string]interface{}{"message": "Document file not found"})
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", document.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", document.OriginalName))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Length", strconv.FormatInt(document.Size, 10))
+	io.Copy(w, file)
+}
+
+func HandleAdminReviewKYC(w http.ResponseWriter, r *http.Request, admin *models.Player) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	player, ok := findPlayerFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var reviewReqBody KYCReviewReqBody
+	err := json.NewDecoder(r.Body).Decode(&reviewReqBody)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Request is missing data (status, note)"})
+		return
+	}
+	defer r.Body.Close()
+
+	// Error List
+	errorList := []string{}
+
+	if !models.IsValidKYCStatus(reviewReqBody.Status) {
+		errorList = append(errorList, fmt.Sprintf("status must be one of %v", models.KYCStatuses))
+	}
+
+	if reviewReqBody.Status == models.KYCRejected && strings.TrimSpace(reviewReqBody.Note) == "" {
+		errorList = append(errorList, "note is required to reject, the player sees it")
+	}
+
+	if player.ID == admin.ID {
+		errorList = append(errorList, "admins can't review their own KYC")
+	}
+
+	if len(errorList) > 0 {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"message":    "Invalid KYC review, check error list",
+			"errorsList": errorList,
+		})
+		return
+	}
+
+	updated, err := models.UpdatePlayerKYCStatus(player.ID, nil, reviewReqBody.Status, reviewReqBody.Note, &admin.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+	if !updated {
+		helpers.WriteJSONResponse(w, http.StatusConflict, map[string]interface{}{"message": "KYC status is already " + reviewReqBody.Status})
+		return
+	}
+
+	audit.Record(r, admin.ID, audit.ActionKYCReview, player.ID, map[string]interface{}{
+		"oldStatus": player.KYCStatus,
+		"newStatus": reviewReqBody.Status,
+		"note":      reviewReqBody.Note,
+	})
+
+	message := map[string]interface{}{
+		"type":      "kycUpdate",
+		"code":      200,
+		"message":   "Your identity verification is " + reviewReqBody.Status,
+		"kycStatus": reviewReqBody.Status,
+		"note":      reviewReqBody.Note,
+	}
+	for _, wsSession := range helpers.Sessions.PlayerSessions(player.ID, helpers.SessionWallet) {
+		wsSession.WriteJSON(message)
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message":   "KYC status updated",
+		"playerId":  player.ID,
+		"kycStatus": reviewReqBody.Status,
+	})
+}
+
+// kycEditable writes a 409 and returns false if the player's KYC is pending or verified
+func kycEditable(w http.ResponseWriter, player *models.Player) bool {
+	if player.KYCStatus == models.KYCPending || player.KYCStatus == models.KYCVerified {
+		helpers.WriteJSONResponse(w, http.StatusConflict, map[string]interface{}{
+			"message":   "KYC can't be changed while it's " + player.KYCStatus,
+			"kycStatus": player.KYCStatus,
+		})
+		return false
+	}
+
+	return true
+}
+
+// storeKYCDocument writes the file under a random name in the player's directory, returns its path relative to KYC_UPLOAD_DIR
+func storeKYCDocument(playerID int, extension string, file io.Reader) (string, error) {
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", fmt.Errorf("error generating file name: %v", err)
+	}
+
+	directory := strconv.Itoa(playerID)
+	if err := os.MkdirAll(filepath.Join(config.KYC_UPLOAD_DIR, directory), 0o700); err != nil {
+		return "", err
+	}
+
+	fileName := filepath.Join(directory, hex.EncodeToString(randomBytes)+extension)
+	stored, err := os.OpenFile(filepath.Join(config.KYC_UPLOAD_DIR, fileName), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := io.Copy(stored, file); err != nil {
+		stored.Close()
+		os.Remove(stored.Name())
+		return "", err
+	}
+
+	return fileName, stored.Close()
+}
@@ -0,0 +1,315 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"main/config"
+	"main/helpers"
+	"main/middleware"
+	"main/models"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Leaderboards over the settled bets
+
+GET /leaderboards/{board}?period= -> Top players of a board (public)
+WS  /ws/leaderboards              -> Public socket, sends every board on connect and pushes a board when its top changes
+GET /player/me/privacy            -> The player's privacy preferences
+PUT /player/me/privacy            -> Update them {"maskName": bool}
+
+! Boards: biggest-win, highest-multiplier, most-wagered
+! Periods: daily, weekly (the default), all-time (calendar periods in UTC, weeks start on Monday)
+? The boards are cached and updated with every settled bet instead of being recomputed
+? Players with maskName set show as "A****" on the boards
+*/
+
+// Players shown on a board
+const leaderboardSize = 10
+
+// leaderboardCache keeps every player's score of a board / period, reset when a new period starts
+type leaderboardCache struct {
+	periodStart time.Time
+	lastBetID   int             // Last bet counted in the scores
+	scores      map[int]float32 // Player ID -> Score
+	top         []models.LeaderboardEntry
+}
+
+var leaderboards = struct {
+	caches map[string]*leaderboardCache // "board/period" -> Cache
+	mu     sync.Mutex
+}{caches: make(map[string]*leaderboardCache)}
+
+type PrivacyReqBody struct {
+	MaskName *bool `json:"maskName"`
+}
+
+func HandleLeaderboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	board := r.PathValue("board")
+	if !models.IsValidLeaderboard(board) {
+		helpers.WriteJSONResponse(w, http.StatusNotFound, map[string]interface{}{"message": fmt.Sprintf("board must be one of %v", models.LeaderboardBoards)})
+		return
+	}
+
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = models.PeriodWeekly
+	}
+	if !models.IsValidLeaderboardPeriod(period) {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": fmt.Sprintf("period must be one of %v", models.LeaderboardPeriods)})
+		return
+	}
+
+	entries, err := getLeaderboard(board, period)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"board":   board,
+		"period":  period,
+		"entries": entries,
+	})
+}
+
+func HandleLeaderboardsWS(w http.ResponseWriter, r *http.Request) {
+	conn, upgradeConErr := helpers.WSUpgrader.Upgrade(w, r, nil)
+	if upgradeConErr != nil {
+		return
+	}
+
+	// Public socket, no player behind it
+	session := helpers.Sessions.Register(0, helpers.SessionLeaderboards, conn)
+	defer helpers.Sessions.Unregister(session)
+
+	snapshot := map[string]map[string][]models.LeaderboardEntry{}
+	for _, board := range models.LeaderboardBoards {
+		snapshot[board] = map[string][]models.LeaderboardEntry{}
+		for _, period := range models.LeaderboardPeriods {
+			entries, err := getLeaderboard(board, period)
+			if err != nil {
+				session.WriteJSON(map[string]interface{}{"code": 500, "message": err.Error()})
+				conn.Close()
+				return
+			}
+			snapshot[board][period] = entries
+		}
+	}
+
+	session.WriteJSON(map[string]interface{}{
+		"type":         "leaderboards",
+		"code":         200,
+		"message":      "Leaderboards retrieved with success!",
+		"leaderboards": snapshot,
+	})
+
+	// Keep the connection alive until the timeout or the client leaves (incoming messages are ignored)
+	conn.SetReadDeadline(time.Now().Add(time.Duration(config.SOCKET_TIMEOUT_DURATION) * time.Second))
+	for {
+		_, receivedMsg, readMessageErr := conn.ReadMessage()
+		if readMessageErr != nil {
+			break
+		}
+
+		// Ignored, but flooding the socket closes it
+		middleware.AllowWSMessage(session, receivedMsg, "message")
+	}
+
+	conn.Close()
+}
+
+func HandlePlayerPrivacy(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	switch r.Method {
+	case http.MethodGet:
+		helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{"maskName": player.MaskName})
+	case http.MethodPut:
+		var privacyReqBody PrivacyReqBody
+		err := json.NewDecoder(r.Body).Decode(&privacyReqBody)
+		if err != nil || privacyReqBody.MaskName == nil {
+			helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Request is missing data (maskName)"})
+			return
+		}
+		defer r.Body.Close()
+
+		if err := models.UpdatePlayerMaskName(player.ID, *privacyReqBody.MaskName); err != nil {
+			helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+			return
+		}
+
+		// The player's name may be on the cached boards
+		go rerankLeaderboards()
+
+		helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"message":  "Privacy preferences updated",
+			"maskName": *privacyReqBody.MaskName,
+		})
+	default:
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+	}
+}
+
+// getLeaderboard returns the top of a board, loading it from the bet history if it isn't cached for the current period
+func getLeaderboard(board string, period string) ([]models.LeaderboardEntry, error) {
+	leaderboards.mu.Lock()
+	defer leaderboards.mu.Unlock()
+
+	cache, err := loadLeaderboard(board, period)
+	if err != nil {
+		return nil, err
+	}
+
+	return cache.top, nil
+}
+
+// updateLeaderboards adds a settled bet to the cached boards and pushes the boards whose top changed
+func updateLeaderboards(bet models.Bet) {
+	leaderboards.mu.Lock()
+	defer leaderboards.mu.Unlock()
+
+	for _, board := range models.LeaderboardBoards {
+		value, counts := models.BetLeaderboardValue(board, bet)
+		if !counts {
+			continue
+		}
+
+		for _, period := range models.LeaderboardPeriods {
+			cache, err := loadLeaderboard(board, period)
+			if err != nil {
+				log.Println("Error loading leaderboard:", err)
+				continue
+			}
+
+			// A cache (re)loaded from the history may already have the bet, and a new period doesn't have the bets of the previous one
+			if bet.ID > cache.lastBetID && !bet.CreatedAt.Before(cache.periodStart) {
+				models.AddLeaderboardScore(board, cache.scores, bet.PlayerID, value)
+			}
+
+			top, err := rankLeaderboard(cache.scores)
+			if err != nil {
+				log.Println("Error ranking leaderboard:", err)
+				continue
+			}
+
+			if !slices.Equal(top, cache.top) {
+				cache.top = top
+				pushLeaderboard(board, period, top)
+			}
+		}
+	}
+}
+
+// rerankLeaderboards renders the cached boards again (e.g. after a privacy change) and pushes the ones that changed
+func rerankLeaderboards() {
+	leaderboards.mu.Lock()
+	defer leaderboards.mu.Unlock()
+
+	for key, cache := range leaderboards.caches {
+		top, err := rankLeaderboard(cache.scores)
+		if err != nil {
+			log.Println("Error ranking leaderboard:", err)
+			return
+		}
+
+		if !slices.Equal(top, cache.top) {
+			cache.top = top
+			board, period, _ := strings.Cut(key, "/")
+			pushLeaderboard(board, period, top)
+		}
+	}
+}
+
+// loadLeaderboard returns the cache of a board / period, (re)loading it when missing or when a new period started
+// Must be called with leaderboards.mu held
+func loadLeaderboard(board string, period string) (*leaderboardCache, error) {
+	key := board + "/" + period
+	periodStart := models.LeaderboardPeriodStart(period, time.Now())
+
+	if cache, found := leaderboards.caches[key]; found && cache.periodStart.Equal(periodStart) {
+		return cache, nil
+	}
+
+	lastBetID, err := models.GetLastBetID()
+	if err != nil {
+		return nil, err
+	}
+
+	scores, err := models.GetLeaderboardScores(board, periodStart, lastBetID)
+	if err != nil {
+		return nil, err
+	}
+
+	top, err := rankLeaderboard(scores)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &leaderboardCache{periodStart: periodStart, lastBetID: lastBetID, scores: scores, top: top}
+	leaderboards.caches[key] = cache
+
+	return cache, nil
+}
+
+// rankLeaderboard sorts the scores and returns the top entries with the players' public names
+func rankLeaderboard(scores map[int]float32) ([]models.LeaderboardEntry, error) {
+	entries := make([]models.LeaderboardEntry, 0, len(scores))
+	for playerID, score := range scores {
+		entries = append(entries, models.LeaderboardEntry{PlayerID: playerID, Value: score})
+	}
+
+	// Highest score first, ties go to the oldest account
+	slices.SortFunc(entries, func(a, b models.LeaderboardEntry) int {
+		if a.Value != b.Value {
+			if a.Value > b.Value {
+				return -1
+			}
+			return 1
+		}
+		return a.PlayerID - b.PlayerID
+	})
+
+	if len(entries) > leaderboardSize {
+		entries = entries[:leaderboardSize]
+	}
+
+	playerIDs := make([]int, len(entries))
+	for i, entry := range entries {
+		playerIDs[i] = entry.PlayerID
+	}
+
+	names, err := models.GetPublicNames(playerIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range entries {
+		entries[i].Rank = i + 1
+		entries[i].Name = names[entries[i].PlayerID]
+	}
+
+	return entries, nil
+}
+
+func pushLeaderboard(board string, period string, entries []models.LeaderboardEntry) {
+	message := map[string]interface{}{
+		"type":    "leaderboardUpdate",
+		"code":    200,
+		"board":   board,
+		"period":  period,
+		"entries": entries,
+	}
+
+	for _, wsSession := range helpers.Sessions.PlayerSessions(0, helpers.SessionLeaderboards) {
+		wsSession.WriteJSON(message)
+	}
+}
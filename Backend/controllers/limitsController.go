@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"main/config"
+	"main/helpers"
+	"main/models"
+	"net/http"
+	"time"
+)
+
+/*
+Responsible gaming limits, set by the players themselves
+
+GET /player/me/limits -> Player's limits with the usage and remaining allowance of the current period
+PUT /player/me/limits -> Sets a limit {"limitType": "deposit" | "loss" | "wager", "period": "daily" | "weekly" | "monthly", "amount": float | null}
+
+! Decreases apply immediately, increases (and removals with "amount": null) only after LIMIT_INCREASE_COOLING_OFF_HOURS
+? Deposit limits are enforced in HandleDeposit, loss and wager limits in processBet
+*/
+
+type LimitReqBody struct {
+	LimitType string   `json:"limitType"`
+	Period    string   `json:"period"`
+	Amount    *float32 `json:"amount"`
+}
+
+func HandlePlayerLimits(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	switch r.Method {
+	case http.MethodGet:
+		limits, err := models.GetPlayerLimits(player.ID)
+		if err != nil {
+			helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+			return
+		}
+
+		helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{"limits": limits})
+	case http.MethodPut:
+		setPlayerLimit(w, r, player)
+	default:
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+	}
+}
+
+func setPlayerLimit(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	var limitReqBody LimitReqBody
+	err := json.NewDecoder(r.Body).Decode(&limitReqBody)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Request is missing data (limitType, period, amount)"})
+		return
+	}
+	defer r.Body.Close()
+
+	// Error List
+	errorList := []string{}
+
+	if !models.IsValidLimitType(limitReqBody.LimitType) {
+		errorList = append(errorList, fmt.Sprintf("limitType must be one of %v", models.LimitTypes))
+	}
+
+	if !models.IsValidLimitPeriod(limitReqBody.Period) {
+		errorList = append(errorList, fmt.Sprintf("period must be one of %v", models.LimitPeriods))
+	}
+
+	if limitReqBody.Amount != nil && *limitReqBody.Amount < 0 {
+		errorList = append(errorList, "amount must be 0 or more (null removes the limit)")
+	}
+
+	if len(errorList) > 0 {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"message":    "Invalid limit, check error list",
+			"errorsList": errorList,
+		})
+		return
+	}
+
+	coolingOff := time.Duration(config.LIMIT_INCREASE_COOLING_OFF_HOURS * float32(time.Hour))
+
+	appliedImmediately, err := models.SetPlayerLimit(player.ID, limitReqBody.LimitType, limitReqBody.Period, limitReqBody.Amount, coolingOff)
+	if errors.Is(err, models.ErrLimitNotFound) {
+		helpers.WriteJSONResponse(w, http.StatusNotFound, map[string]interface{}{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	limits, err := models.GetPlayerLimits(player.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	message := "Limit updated"
+	if !appliedImmediately {
+		message = fmt.Sprintf("Limit increase will take effect after the %v hours cooling-off period", config.LIMIT_INCREASE_COOLING_OFF_HOURS)
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": message,
+		"limits":  limits,
+	})
+}
+
+// addLimitErrorFields adds the error code and remaining allowance to the response if err is a LimitError
+// (or the error code and limit if it's a CurrencyBetLimitError)
+func addLimitErrorFields(response map[string]interface{}, err error) bool {
+	var currencyLimitError *models.CurrencyBetLimitError
+	if errors.As(err, &currencyLimitError) {
+		response["errorCode"] = currencyLimitError.Code
+		response["currency"] = currencyLimitError.Currency
+		response["limit"] = currencyLimitError.Limit
+		return true
+	}
+
+	var limitError *models.LimitError
+	if !errors.As(err, &limitError) {
+		return false
+	}
+
+	response["errorCode"] = limitError.Code
+	response["limitType"] = limitError.LimitType
+	response["period"] = limitError.Period
+	response["limit"] = limitError.Limit
+	response["remaining"] = limitError.Remaining
+
+	return true
+}
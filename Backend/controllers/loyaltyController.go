@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"fmt"
+	"main/config"
+	"main/helpers"
+	"main/models"
+	"net/http"
+)
+
+/*
+Loyalty points and VIP tiers
+
+GET /player/me/loyalty -> The player's points, tier, wagered volume and progress towards the next tier
+
+! Every settled wager earns points: stake * game rate * tier multiplier (free bets don't earn points)
+! The tier comes from the volume wagered over the last LOYALTY_TIER_WINDOW_DAYS, it sets the max bet,
+! the daily withdrawal limit and the cashback rate (see models.VIPTiers)
+? Reaching a higher tier pushes a {"type": "tierUp"} message on the wallet socket
+*/
+
+func HandlePlayerLoyalty(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status, err := models.GetLoyaltyStatus(player.ID, config.LOYALTY_TIER_WINDOW_DAYS)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"loyalty": status,
+		"tiers":   models.VIPTiers,
+	})
+}
+
+// notifyTierUp pushes the player's new tier on their wallet sockets
+func notifyTierUp(playerID int, accrual models.LoyaltyAccrual) {
+	message := map[string]interface{}{
+		"type":          "tierUp",
+		"code":          200,
+		"message":       fmt.Sprintf("Congratulations, you've reached the %s tier!", accrual.Tier.Name),
+		"previousTier":  accrual.PreviousTier,
+		"tier":          accrual.Tier,
+		"loyaltyPoints": accrual.TotalPoints,
+	}
+
+	for _, wsSession := range helpers.Sessions.PlayerSessions(playerID, helpers.SessionWallet) {
+		wsSession.WriteJSON(message)
+	}
+}
@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"main/config"
+	"main/helpers"
+	"main/models"
+	"main/payments"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/*
+Payments going through the payment provider (PAYMENT_PROVIDER)
+
+POST /player/me/wallet/deposit     -> Creates a pending deposit at the provider, returns its checkoutUrl (nothing is credited yet)
+GET  /player/me/payments           -> The player's deposits / payouts (?limit=&offset=)
+GET  /player/me/payments/{id}      -> One payment, with its current status at the provider
+POST /payments/webhook/{provider}  -> Provider's notifications (signed, no player token)
+
+! Funds are only credited once the provider confirms the payment with a webhook whose signature checks out,
+! the deposit credit, its transaction and the payment's status change are a single database transaction
+! Webhooks are idempotent: a payment that isn't pending anymore is acknowledged without changing anything
+! A webhook that can't take the player's processing lock gets a 503, so the provider sends it again later
+? Confirmed deposits push {"type": "paymentUpdate"} to the player's wallet sockets, along the new balances
+? Payouts are sent for approved withdrawals, their webhooks close the withdrawal (see withdrawalController.go)
+*/
+
+// How long a webhook waits for the player's balance to be free before asking the provider to retry
+const paymentWebhookLockTimeout = 5 * time.Second
+
+// StartPayments registers the configured payment provider, starting the mock gateway when it's the one used
+func StartPayments() {
+	switch config.PAYMENT_PROVIDER {
+	case payments.MockProviderName:
+		autoConfirm := time.Duration(config.MOCK_GATEWAY_AUTO_CONFIRM_SECONDS * float32(time.Second))
+		gatewayURL := payments.StartMockGateway(config.MOCK_GATEWAY_PORT, config.PAYMENT_WEBHOOK_URL, config.PAYMENT_WEBHOOK_SECRET, autoConfirm)
+		payments.Register(payments.NewMockProvider(gatewayURL, config.PAYMENT_WEBHOOK_SECRET))
+	default:
+		log.Printf("Unknown payment provider '%s', deposits and payouts are unavailable\n", config.PAYMENT_PROVIDER)
+	}
+}
+
+func HandlePlayerPayments(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, offset := helpers.ParsePagination(r)
+
+	playerPayments, err := models.GetPaymentsByPlayerID(player.ID, limit, offset)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"payments": playerPayments,
+		"limit":    limit,
+		"offset":   offset,
+	})
+}
+
+func HandlePlayerPayment(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Invalid payment ID"})
+		return
+	}
+
+	payment, err := models.GetPaymentByID(id)
+	if err != nil || payment.PlayerID != player.ID {
+		helpers.WriteJSONResponse(w, http.StatusNotFound, map[string]interface{}{"message": "Payment not found"})
+		return
+	}
+
+	// The provider's side, for payments it didn't confirm yet (nil if it can't be reached)
+	var providerStatus interface{}
+	if provider, err := payments.Get(payment.Provider); err == nil && payment.ProviderReference != nil {
+		if status, err := provider.GetStatus(*payment.ProviderReference); err == nil {
+			providerStatus = status
+		}
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"payment":        payment,
+		"providerStatus": providerStatus,
+	})
+}
+
+func HandlePaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	provider, err := payments.Get(r.PathValue("provider"))
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusNotFound, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	event, err := provider.ParseWebhook(r)
+	if err != nil {
+		log.Printf("Rejected %s webhook: %v\n", provider.Name(), err)
+		helpers.WriteJSONResponse(w, http.StatusUnauthorized, map[string]interface{}{
+			"message":   err.Error(),
+			"errorCode": "INVALID_SIGNATURE",
+		})
+		return
+	}
+
+	payment, err := models.GetPaymentByReference(provider.Name(), event.Reference)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusNotFound, map[string]interface{}{"message": "Payment not found"})
+		return
+	}
+
+	// Already handled (retried / duplicated webhook) or nothing final yet
+	if payment.Status != models.PaymentPending || event.Status == payments.StatusPending {
+		helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{"message": "Nothing to do", "status": payment.Status})
+		return
+	}
+
+	if event.Currency != payment.Currency {
+		log.Printf("Webhook currency %s doesn't match payment %d (%s)\n", event.Currency, payment.ID, payment.Currency)
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Currency doesn't match the payment"})
+		return
+	}
+	if math.Abs(float64(event.Amount-payment.Amount)) >= 0.005 {
+		log.Printf("Webhook amount %.2f doesn't match payment %d (%.2f)\n", event.Amount, payment.ID, payment.Amount)
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Amount doesn't match the payment"})
+		return
+	}
+
+	status := models.PaymentFailed
+	if event.Status == payments.StatusSucceeded {
+		status = models.PaymentSucceeded
+	}
+
+	// Payouts also close their withdrawal (see withdrawalController.go)
+	if payment.Kind == models.PaymentPayout {
+		completePayout(w, payment, status)
+		return
+	}
+
+	var bonusGranted float32
+	if payment.Kind == models.PaymentDeposit && status == models.PaymentSucceeded {
+		// Balance updates are serialized by the processing lock, the deposit waits for bets in progress
+		if err := waitForPlayerBetting(payment.PlayerID, paymentWebhookLockTimeout); err != nil {
+			helpers.WriteJSONResponse(w, http.StatusServiceUnavailable, map[string]interface{}{"message": "Player is busy, retry later"})
+			return
+		}
+
+		completed, err := models.CompleteDeposit(payment.ID)
+		if err == nil && completed {
+			bonusGranted = onDepositCompleted(payment)
+		}
+		models.UpdatePlayerBettingStatus(payment.PlayerID, false)
+
+		if err != nil {
+			helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+			return
+		}
+		if !completed {
+			helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{"message": "Nothing to do"})
+			return
+		}
+	} else if updated, err := models.SetPaymentStatus(payment.ID, status); err != nil || !updated {
+		statusCode, message := http.StatusOK, "Nothing to do"
+		if err != nil {
+			statusCode, message = http.StatusInternalServerError, err.Error()
+		}
+		helpers.WriteJSONResponse(w, statusCode, map[string]interface{}{"message": message})
+		return
+	}
+
+	payment.Status = status
+	message := map[string]interface{}{
+		"type":         "paymentUpdate",
+		"code":         200,
+		"message":      fmt.Sprintf("Your %s of %.2f %s %s", payment.Kind, payment.Amount, payment.Currency, status),
+		"payment":      payment,
+		"bonusGranted": bonusGranted,
+	}
+	for _, wsSession := range helpers.Sessions.PlayerSessions(payment.PlayerID, helpers.SessionWallet) {
+		wsSession.WriteJSON(message)
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{"message": "Payment " + status, "status": status})
+}
+
+// onDepositCompleted runs what follows a credited deposit (deposit match bonuses, referral), returns the bonus granted
+// Called with the player's processing lock held
+func onDepositCompleted(payment *models.Payment) float32 {
+	// Redeemed deposit match promo codes turn into bonuses now (bonuses are in the base currency)
+	bonusGranted, err := models.ApplyDepositMatches(payment.PlayerID, models.ToBaseCurrency(payment.Amount, payment.Currency))
+	if err != nil {
+		log.Println("Error applying deposit matches:", err)
+	}
+	if bonusGranted > 0 {
+		models.NotifyBalanceUpdate(payment.PlayerID)
+	}
+
+	// A first deposit can qualify the player's referral
+	checkReferralQualification(payment.PlayerID)
+
+	return bonusGranted
+}
@@ -2,26 +2,36 @@ package controllers
 
 import (
 	"errors"
+	"fmt"
+	"log"
 	"main/config"
 	"main/helpers"
 	"main/middleware"
 	"main/models"
+	"main/risk"
 
 	"math/rand"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 // HandlePlay handles the play endpoint for betting
+// It checks if the player is authenticated, Returns a 401 / 403 if not
 // It upgrades the HTTP to WebSocket Connection
-// It checks if the player is authenticated Returns an error Message if So
 // It checks if the player is playing (aka: if already connected to the server through a socket), If so it Returns an error
 // If he wasn't playing change his playing status to true
 // On connection Close Change his playing status to false
 func HandlePlayWS(w http.ResponseWriter, r *http.Request) {
 
+	// Authenticated before upgrading so failures get a real 401 / 403 (see middleware/wsAuthMiddleware.go)
+	player, authenticated := middleware.AuthenticateWS(w, r)
+	if !authenticated {
+		return
+	}
+
 	// Try to upgrade to Websockets
 	conn, upgradeConErr := helpers.WSUpgrader.Upgrade(w, r, nil)
 	// On error
@@ -29,18 +39,13 @@ func HandlePlayWS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get Player initial information
-	player, authError := middleware.AuthenticateUser(r)
-	if authError != nil {
-		response := map[string]interface{}{"code": 401, "message": authError.Error()}
-		stringifiedResponse, _ := helpers.JsonStringifier(response)
+	// Keep track of the connection so it can be closed from elsewhere (e.g. account status changes)
+	session := helpers.Sessions.Register(player.ID, helpers.SessionPlay, conn)
+	defer helpers.Sessions.Unregister(session)
 
-		conn.WriteMessage(websocket.TextMessage, []byte(stringifiedResponse))
-		// Conn.WriteControl Should send a close code and a message but it's not working properly.
-		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "No auth token provided"), time.Now())
-		conn.Close()
-		return
-	}
+	// Gaming session for the reality checks
+	startRealityChecks(player.ID)
+	defer stopRealityChecks(player.ID)
 
 	// Timeout Duration based on ENV CONFIG
 	timeoutDuration := time.Duration(config.SOCKET_TIMEOUT_DURATION * float32(time.Second))
@@ -59,9 +64,13 @@ func HandlePlayWS(w http.ResponseWriter, r *http.Request) {
 		messageType, receivedMsg, readMessageErr := conn.ReadMessage() // BLOCKING
 		timeout.Stop()
 		if readMessageErr != nil {
-			if websocket.IsUnexpectedCloseError(readMessageErr, websocket.CloseGoingAway) {
-				break
-			}
+			// Read errors are permanent (closed by the client, timed out or closed by Sessions.CloseAll)
+			break
+		}
+
+		// Rate limited messages only get an error frame
+		if !middleware.AllowWSMessage(session, receivedMsg, "bet") {
+			timeout.Reset(timeoutDuration)
 			continue
 		}
 
@@ -83,10 +92,25 @@ func HandlePlayWS(w http.ResponseWriter, r *http.Request) {
 			response["message"] = "Invalid JSON"
 
 			stringifiedResponse, _ := helpers.JsonStringifier(response)
-			conn.WriteMessage(messageType, []byte(stringifiedResponse))
+			session.WriteMessage(messageType, []byte(stringifiedResponse))
 			continue // Skip the rest of the loop
 		}
 
+		// Reality check acknowledgements aren't bets
+		if action, _ := parsedData["action"].(string); action == "acknowledgeRealityCheck" {
+			if acknowledgeRealityCheck(player.ID) {
+				response["message"] = "Reality check acknowledged"
+			} else {
+				response["code"] = 400
+				response["message"] = "There is no reality check to acknowledge"
+			}
+
+			stringifiedResponse, _ := helpers.JsonStringifier(response)
+			session.WriteMessage(messageType, []byte(stringifiedResponse))
+			timeout.Reset(timeoutDuration)
+			continue
+		}
+
 		// If no bet amount or type of bet is provided return an error
 
 		// betAmount := float32(betAmount) // Transform from 64 to float32 If performance is critical
@@ -102,9 +126,23 @@ func HandlePlayWS(w http.ResponseWriter, r *http.Request) {
 			errorList = append(errorList, "betType must be 'pair' or 'not pair'")
 		}
 
+		// A free bet can be used instead of a stake
+		freeBetID64, usesFreeBet := parsedData["freeBetId"].(float64)
+		freeBetID := int(freeBetID64)
+		if usesFreeBet && freeBetID <= 0 {
+			errorList = append(errorList, "Invalid freeBetId")
+		}
+
+		// Optional currency to play in, the player's wallet switches to it before the bet (see models/wallet.go)
+		currency, currencyIsString := parsedData["currency"].(string)
+		currency = strings.ToUpper(currency)
+		if _, provided := parsedData["currency"]; provided && (!currencyIsString || !models.IsSupportedCurrency(currency)) {
+			errorList = append(errorList, fmt.Sprintf("currency must be one of %s", strings.Join(config.CURRENCIES, ", ")))
+		}
+
 		// Verify is a float32 or exists and extract betAmount
 		betAmount64, betAmountIsFloat64 := parsedData["betAmount"].(float64) // Assuming betAmount is of type float64
-		if !betAmountIsFloat64 {
+		if !betAmountIsFloat64 && !usesFreeBet {
 			errorList = append(errorList, "Invalid or missing betAmount")
 		}
 
@@ -112,39 +150,44 @@ func HandlePlayWS(w http.ResponseWriter, r *http.Request) {
 		betAmount32 := float32(betAmount64)
 
 		// Check if it's greater than 0
-		if betAmount32 <= 0 {
+		if betAmount32 <= 0 && !usesFreeBet {
 			errorList = append(errorList, "betAmount must be greater than 0")
 		}
 
-		// Check if Player Is Already Betting
-		isAlreadyBetting, errCheckingStatus := models.CheckPlayerBettingStatus(player.ID)
-		if errCheckingStatus != nil {
-			errorList = append(errorList, errCheckingStatus.Error())
+		// The last reality check must be acknowledged before betting again
+		if realityCheckPending(player.ID) {
+			errorList = append(errorList, "Acknowledge the reality check before placing another bet")
+			response["errorCode"] = "REALITY_CHECK_ACK_REQUIRED"
 		}
 
-		// Check if Player Is Already Betting
-		if isAlreadyBetting {
-
-			errorList = append(errorList, "Player already betting, please await the bet processing...")
-		}
-
-		// Process Betting if player is not already in Betting Process and if the status was properly provided
-		if !isAlreadyBetting && errCheckingStatus == nil && len(errorList) == 0 {
-
-			models.UpdatePlayerBettingStatus(player.ID, true)
-
-			diceRollResult, err := processBet(player.ID, betAmount32, betType)
-			if err != nil {
-				errorList = append(errorList, err.Error())
+		// Process Betting if the bet is valid and the player isn't already in Betting Process (checked and set in one query)
+		if len(errorList) == 0 {
+			locked, errLocking := models.TrySetPlayerBetting(player.ID)
+			if errLocking != nil {
+				errorList = append(errorList, errLocking.Error())
+			} else if !locked {
+				errorList = append(errorList, "Player already betting, please await the bet processing...")
 			} else {
-				response["DiceNumber"] = diceRollResult.DiceNumber
-				response["PlayerWin"] = diceRollResult.PlayerWin
-				response["PlayerOriginalBet"] = diceRollResult.PlayerOriginalBet
-				response["PlayerMessage"] = diceRollResult.PlayerMessage
-				response["Winnings"] = diceRollResult.Winnings
+				diceRollResult, err := processBet(player.ID, betAmount32, betType, freeBetID, currency)
+				if err != nil {
+					errorList = append(errorList, err.Error())
+
+					var accountStatusError *models.AccountStatusError
+					var currencySwitchError *models.CurrencySwitchError
+					if errors.As(err, &accountStatusError) {
+						response["errorCode"] = accountStatusError.Code
+					} else if errors.As(err, &currencySwitchError) {
+						response["errorCode"] = models.CurrencySwitchBlocked
+					}
+					addLimitErrorFields(response, err)
+					addRiskErrorFields(response, err)
+				} else {
+					addDiceRollResultFields(response, diceRollResult)
+				}
+
+				// Only released by the bet that set it
+				models.UpdatePlayerBettingStatus(player.ID, false)
 			}
-
-			models.UpdatePlayerBettingStatus(player.ID, false)
 		}
 
 		// Check if any errors occurred
@@ -157,7 +200,7 @@ func HandlePlayWS(w http.ResponseWriter, r *http.Request) {
 		// Send the stringified response JSON
 		stringifiedResponse, _ := helpers.JsonStringifier(response)
 
-		if writingMessageErr := conn.WriteMessage(messageType, []byte(stringifiedResponse)); writingMessageErr != nil {
+		if writingMessageErr := session.WriteMessage(messageType, []byte(stringifiedResponse)); writingMessageErr != nil {
 
 			break
 		}
@@ -178,22 +221,99 @@ type DiceRollResult struct {
 	PlayerOriginalBet string
 	PlayerMessage     string
 	Winnings          float32 // Winnings of the player on the bet (either be it negative or positive)
+	BonusStake        float32 // Part of the stake funded by bonus money
+	BonusReleased     float32 // Bonus money released to the bet balance by bonuses whose wagering was completed
+	FreeBetID         int     // Free bet used for the bet (0 if none)
+	LoyaltyPoints     float32 // Loyalty points earned on the bet
+	BetID             int     // ID of the recorded bet
+	JackpotWin        float32 // Progressive jackpot won with the bet (0 if not won)
+	Currency          string  // Currency of the amounts above
+}
+
+// addDiceRollResultFields adds the result of a bet to a socket response
+func addDiceRollResultFields(response map[string]interface{}, diceRollResult DiceRollResult) {
+	response["DiceNumber"] = diceRollResult.DiceNumber
+	response["PlayerWin"] = diceRollResult.PlayerWin
+	response["PlayerOriginalBet"] = diceRollResult.PlayerOriginalBet
+	response["PlayerMessage"] = diceRollResult.PlayerMessage
+	response["Winnings"] = diceRollResult.Winnings
+	response["BonusStake"] = diceRollResult.BonusStake
+	response["BonusReleased"] = diceRollResult.BonusReleased
+	response["LoyaltyPoints"] = diceRollResult.LoyaltyPoints
+	response["Currency"] = diceRollResult.Currency
+	if diceRollResult.FreeBetID != 0 {
+		response["FreeBetID"] = diceRollResult.FreeBetID
+	}
+	if diceRollResult.JackpotWin > 0 {
+		response["JackpotWin"] = diceRollResult.JackpotWin
+	}
+}
+
+// How long the settlement / refund of a bet placed upfront waits for the player's balance to be free
+const upfrontBetTimeout = 10 * time.Second
+
+// betStake is what the player put on a bet (see takeStake)
+type betStake struct {
+	BetAmount            float32
+	BonusStake           float32         // Part of the stake funded by bonus money
+	FreeBet              *models.FreeBet // Free bet used instead of a stake (nil if none)
+	WalletAfterStake     float32         // Balances right after the stake was taken (for the transaction history)
+	BetBalanceAfterStake float32
+	Recorded             bool // The stake's transaction is already in the history (room / crash bets)
+}
+
+// betSettlement is the outcome of a settled bet (see settleBet)
+type betSettlement struct {
+	PlayerWin     bool
+	Winnings      float32 // Payout on a win, minus the stake on a loss (free bets: only the profit)
+	BonusReleased float32
+	LoyaltyPoints float32
+	JackpotWin    float32
+	BetID         int
 }
 
 // Return betResult, Number of dice, and the type (pair / not pair)
-func processBet(playerId int, betAmount float32, betType string) (DiceRollResult, error) {
+// With a free bet (freeBetID != 0) the stake is the free bet's amount and only the winnings are paid out
+// With a currency other than the player's, the player switches to it first (empty = the current one)
+func processBet(playerId int, betAmount float32, betType string, freeBetID int, currency string) (DiceRollResult, error) {
 	// Get Current Info on Player
 	player, err := models.GetPlayerByID(playerId)
 	if err != nil {
 		return DiceRollResult{}, errors.New("Error getting player info for bet processing")
 	}
 
-	// Check if Bet Amount is Above BetBalance + Wallet
-	if betAmount > (player.BetBalance + player.Wallet) {
-		return DiceRollResult{}, errors.New("betAmount exceeds player's balance and bet balance")
-	} else {
-		player.DeductBetAmount(betAmount) // Subtract from Wallet
-		// ( I've Only remembered now that structs can have functions  RIP )
+	// Status may have changed since the socket was opened
+	if statusErr := player.CanPerform(models.ActionPlay); statusErr != nil {
+		return DiceRollResult{}, statusErr
+	}
+
+	// The processing lock is held, the balances can be switched safely
+	// A bet that can't be placed switches the player back, so it never changes the active wallet
+	previousCurrency := ""
+	if currency != "" && currency != player.Currency {
+		if freeBetID == 0 {
+			if limitErr := models.CheckCurrencyBetLimits(currency, betAmount); limitErr != nil {
+				return DiceRollResult{}, limitErr
+			}
+		}
+
+		if err := models.SwitchPlayerCurrency(player.ID, currency); err != nil {
+			return DiceRollResult{}, err
+		}
+		previousCurrency = player.Currency
+
+		if player, err = models.GetPlayerByID(playerId); err != nil {
+			switchBack(playerId, previousCurrency)
+			return DiceRollResult{}, errors.New("Error getting player info for bet processing")
+		}
+	}
+
+	stake, err := takeStake(player, models.GameDice, betAmount, freeBetID)
+	if err != nil {
+		if previousCurrency != "" {
+			switchBack(playerId, previousCurrency)
+		}
+		return DiceRollResult{}, err
 	}
 
 	// PS: This is here only to demonstrate that during a dice roll the player cannot bet again
@@ -201,47 +321,355 @@ func processBet(playerId int, betAmount float32, betType string) (DiceRollResult
 
 	diceRollEnd := false
 
+	diceRollResult, err := settleDiceBet(player, stake, betType, rollDice())
+	if err != nil {
+		return DiceRollResult{}, err
+	}
+
+	diceRollEnd = true
+	for {
+		// Loop until 2 seconds have passed
+		if time.Since(start).Seconds() >= float64(config.PROCESSING_DURATION) && diceRollEnd { // Bet processing minimum Time
+			break
+		}
+
+	}
+
+	return diceRollResult, nil
+}
+
+// switchBack switches the player back to the currency they had before a bet that couldn't be placed
+func switchBack(playerID int, currency string) {
+	if err := models.SwitchPlayerCurrency(playerID, currency); err != nil {
+		log.Printf("Player %d not switched back to %s: %v\n", playerID, currency, err)
+	}
+}
+
+// rollDice returns a number from 1 to 6 (RIGGED_DICE_NUMBER if set)
+func rollDice() int {
 	RolledDiceNumber := rand.Intn(6) + 1
 
 	if config.RIGGED_DICE_NUMBER != 0 { // Default value aka not rigged
 		RolledDiceNumber = config.RIGGED_DICE_NUMBER
 	}
 
+	return RolledDiceNumber
+}
+
+// takeStake takes the stake of a bet on the game from the player's balances (in memory, saved when the bet is settled)
+// and checks the player's limits, or uses the free bet
+// Bets blocked by the risk checks return a risk.BlockedError
+func takeStake(player *models.Player, game string, betAmount float32, freeBetID int) (betStake, error) {
+	stake := betStake{BetAmount: betAmount}
+
+	riskContext := risk.Context{Event: risk.EventPlay, PlayerID: player.ID, Amount: models.ToBaseCurrency(betAmount, player.Currency), Game: game}
+	if err := risk.Check(riskContext); err != nil {
+		return betStake{}, err
+	}
+
+	if freeBetID != 0 {
+		// The player stakes nothing, so no balance to take and no limits to check
+		freeBet, err := models.UseFreeBet(freeBetID, player.ID, game)
+		if err != nil {
+			return betStake{}, err
+		}
+		stake.FreeBet = freeBet
+		stake.BetAmount = models.FromBaseCurrency(freeBet.Amount, player.Currency) // Free bets are in the base currency
+	} else {
+		// Min / max bet of the player's currency
+		if limitErr := models.CheckCurrencyBetLimits(player.Currency, betAmount); limitErr != nil {
+			return betStake{}, limitErr
+		}
+
+		// Takes the stake from the wallet, then the bet balance and lastly the bonus balance
+		bonusStake, err := player.DeductBetAmount(betAmount)
+		if err != nil {
+			return betStake{}, err
+		}
+		stake.BonusStake = bonusStake
+
+		// Max bet of the player's VIP tier (tiers and limits are in the base currency)
+		betAmountInBase := models.ToBaseCurrency(betAmount, player.Currency)
+		tier, _, _, tierErr := models.RefreshVIPTier(player.ID, config.LOYALTY_TIER_WINDOW_DAYS)
+		if tierErr != nil {
+			return betStake{}, tierErr
+		}
+		if limitErr := models.CheckVIPMaxBet(tier, betAmountInBase); limitErr != nil {
+			return betStake{}, limitErr
+		}
+
+		// Responsible gaming limits (the whole stake could be lost)
+		if limitErr := models.CheckLimits(player.ID, models.LimitWager, betAmountInBase); limitErr != nil {
+			return betStake{}, limitErr
+		}
+		if limitErr := models.CheckLimits(player.ID, models.LimitLoss, betAmountInBase); limitErr != nil {
+			return betStake{}, limitErr
+		}
+	}
+
+	// Balances right after the stake was taken (for the transaction history)
+	stake.WalletAfterStake, stake.BetBalanceAfterStake = player.Wallet, player.BetBalance
+
+	return stake, nil
+}
+
+// placeUpfrontBet takes the stake of a bet settled later (room / crash rounds) and saves it right away
+// place stores the bet and returns its ID, unplace removes it if the stake can't be saved
+// Uses the betting status as a processing lock like the other balance updates
+func placeUpfrontBet(playerID int, game string, betAmount float32, freeBetID int, reference string,
+	place func(stake betStake, walletStake float32) (int, error), unplace func(id int) error) (int, error) {
+	locked, err := models.TrySetPlayerBetting(playerID)
+	if err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, errors.New("Player already betting, please await the bet processing...")
+	}
+	defer models.UpdatePlayerBettingStatus(playerID, false)
+
+	player, err := models.GetPlayerByID(playerID)
+	if err != nil {
+		return 0, errors.New("Error getting player info for bet processing")
+	}
+
+	// Status may have changed since the socket was opened
+	if statusErr := player.CanPerform(models.ActionPlay); statusErr != nil {
+		return 0, statusErr
+	}
+
+	walletBeforeStake := player.Wallet
+	stake, err := takeStake(player, game, betAmount, freeBetID)
+	if err != nil {
+		return 0, err
+	}
+
+	// The bonus part of a stake is only taken from the bonuses when the bet is settled, it could be staked twice meanwhile
+	if stake.BonusStake > 0 {
+		return 0, errors.New("These bets can't be funded by bonus money, not enough funds in wallet and bet balance")
+	}
+
+	id, err := place(stake, walletBeforeStake-player.Wallet)
+	if err != nil {
+		if stake.FreeBet != nil {
+			models.ReopenFreeBet(stake.FreeBet.ID)
+		}
+		return 0, err
+	}
+
+	if stake.FreeBet == nil {
+		if err := models.UpdatePlayerBalance(player.ID, player.Wallet, player.BetBalance); err != nil {
+			unplace(id)
+			return 0, err
+		}
+
+		models.RecordTransaction(player.ID, models.TransactionBet, -stake.BetAmount, player.Wallet, player.BetBalance, reference)
+	}
+
+	return id, nil
+}
+
+// refundUpfrontStake gives the stake of a bet placed with placeUpfrontBet back to the balances it was taken from
+// (or reopens its free bet), claim marks the bet as refunded (false if it can't be) and release reverts it if the refund fails
+func refundUpfrontStake(playerID int, betAmount float32, walletStake float32, freeBetID *int, reference string,
+	claim func() (bool, error), release func() error) error {
+	if err := waitForPlayerBetting(playerID, upfrontBetTimeout); err != nil {
+		return err
+	}
+	defer models.UpdatePlayerBettingStatus(playerID, false)
+
+	claimed, err := claim()
+	if err != nil || !claimed {
+		return err
+	}
+
+	if freeBetID != nil {
+		return models.ReopenFreeBet(*freeBetID)
+	}
+
+	player, err := models.GetPlayerByID(playerID)
+	if err != nil {
+		release()
+		return err
+	}
+
+	newWalletBalance := player.Wallet + walletStake
+	newBetBalance := player.BetBalance + betAmount - walletStake
+	if err := models.UpdatePlayerBalance(player.ID, newWalletBalance, newBetBalance); err != nil {
+		release()
+		return err
+	}
+
+	models.RecordTransaction(player.ID, models.TransactionRoundRefund, betAmount, newWalletBalance, newBetBalance, reference)
+
+	return nil
+}
+
+// waitForPlayerBetting sets the player's betting status, waiting up to timeout for a bet in progress to end
+func waitForPlayerBetting(playerID int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := models.TrySetPlayerBetting(playerID)
+		if err != nil {
+			return err
+		}
+		if locked {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("player %d is in Betting Process", playerID)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// settleDiceBet pays out a dice bet on the rolled die
+func settleDiceBet(player *models.Player, stake betStake, betType string, RolledDiceNumber int) (DiceRollResult, error) {
 	diceRollResult := DiceRollResult{
 		DiceNumber:        RolledDiceNumber, // Resulting Dice Number
 		PlayerOriginalBet: betType,
+		BonusStake:        stake.BonusStake,
+		Currency:          player.Currency,
 	}
 
 	// Check if the player won
 	playerWon := (RolledDiceNumber%2 == 0 && betType == "pair") || (RolledDiceNumber%2 != 0 && betType == "not pair")
 
-	// If player wins the bet
+	var payoutMultiplier float32
 	if playerWon {
-		player.BetBalance += betAmount * config.WINNING_MULTIPLIER // MULTIPLIER FROM ENV
-		diceRollResult.PlayerMessage = "You've Won :)"
-		diceRollResult.Winnings = betAmount * config.WINNING_MULTIPLIER
-		diceRollResult.PlayerWin = true
+		payoutMultiplier = config.WINNING_MULTIPLIER // MULTIPLIER FROM ENV
+	}
+
+	settlement, err := settleBet(player, stake, models.Bet{Game: models.GameDice, BetType: betType, DiceNumber: RolledDiceNumber}, payoutMultiplier)
+	if err != nil {
+		return DiceRollResult{}, err
+	}
 
+	diceRollResult.PlayerWin = settlement.PlayerWin
+	diceRollResult.Winnings = settlement.Winnings
+	diceRollResult.BonusReleased = settlement.BonusReleased
+	diceRollResult.LoyaltyPoints = settlement.LoyaltyPoints
+	diceRollResult.BetID = settlement.BetID
+	diceRollResult.JackpotWin = settlement.JackpotWin
+	if stake.FreeBet != nil {
+		diceRollResult.FreeBetID = stake.FreeBet.ID
+		diceRollResult.BonusStake = 0
+	}
+
+	if playerWon {
+		diceRollResult.PlayerMessage = "You've Won :)"
 	} else {
 		diceRollResult.PlayerMessage = "You've Lost :("
-		diceRollResult.Winnings = -betAmount
-		diceRollResult.PlayerWin = false
+	}
+
+	return diceRollResult, nil
+}
+
+// settleBet pays out the stake at payoutMultiplier (0 on a loss), saves the player's balances and records the bet
+// (game, betType and diceNumber set by the caller) with its side effects: transactions, jackpot, loyalty points,
+// leaderboards, tournaments and referrals
+func settleBet(player *models.Player, stake betStake, bet models.Bet, payoutMultiplier float32) (betSettlement, error) {
+	betAmount, bonusStake, freeBet := stake.BetAmount, stake.BonusStake, stake.FreeBet
+	settlement := betSettlement{PlayerWin: payoutMultiplier > 0}
+
+	// If player wins the bet
+	// Winnings of the bonus-funded part of the stake go back to the bonuses until their wagering is done
+	var bonusPayout float32
+
+	if settlement.PlayerWin {
+		bonusPayout = bonusStake * payoutMultiplier
+		player.BetBalance += betAmount*payoutMultiplier - bonusPayout
+		settlement.Winnings = betAmount * payoutMultiplier
+	} else {
+		settlement.Winnings = -betAmount
+	}
+
+	// Free bets only pay out the winnings (the stake wasn't the player's) and don't count towards wagering
+	if freeBet != nil {
+		if settlement.PlayerWin {
+			player.BetBalance -= betAmount
+			settlement.Winnings -= betAmount
+		} else {
+			settlement.Winnings = 0
+		}
+	} else {
+		// Wagering progress, completed bonuses are released to the bet balance (bonuses are in the base currency)
+		toBase := func(amount float32) float32 { return models.ToBaseCurrency(amount, player.Currency) }
+		bonusReleased, bonusError := models.SettleBonusBet(player.ID, toBase(betAmount), toBase(bonusStake), toBase(bonusPayout))
+		if bonusError != nil {
+			return betSettlement{}, bonusError
+		}
+		bonusReleased = models.FromBaseCurrency(bonusReleased, player.Currency)
+		player.BetBalance += bonusReleased
+		settlement.BonusReleased = bonusReleased
 	}
 
 	// Update Player's Balance and Wallet
 	updateBalanceError := models.UpdatePlayerBalance(player.ID, player.Wallet, player.BetBalance)
 	if updateBalanceError != nil {
-		return DiceRollResult{}, updateBalanceError
+		if freeBet != nil && !stake.Recorded {
+			models.ReopenFreeBet(freeBet.ID)
+		}
+		return betSettlement{}, updateBalanceError
 	}
 
-	diceRollEnd = true
-	for {
-		// Loop until 2 seconds have passed
-		if time.Since(start).Seconds() >= float64(config.PROCESSING_DURATION) && diceRollEnd { // Bet processing minimum Time
-			break
+	// Keep the bet and the balance movements in the history
+	bet.PlayerID = player.ID
+	bet.BetAmount = betAmount
+	bet.PlayerWin = settlement.PlayerWin
+	bet.Winnings = settlement.Winnings
+	bet.Currency = player.Currency
+	if freeBet != nil {
+		bet.BetAmount = 0 // Nothing was staked by the player
+		bet.FreeBetID = &freeBet.ID
+	}
+
+	betID, recordBetError := models.RecordBet(bet)
+	if recordBetError != nil {
+		return betSettlement{}, recordBetError
+	}
+
+	bet.ID, bet.CreatedAt = betID, time.Now()
+	settlement.BetID = betID
+	go updateLeaderboards(bet)
+	if freeBet == nil {
+		go updateTournamentScores(bet, bet.Game)
+	}
+
+	betReference := fmt.Sprintf("bet:%d", betID)
+	if freeBet != nil {
+		models.SetFreeBetBet(freeBet.ID, betID)
+	} else if !stake.Recorded {
+		models.RecordTransaction(player.ID, models.TransactionBet, -betAmount, stake.WalletAfterStake, stake.BetBalanceAfterStake, betReference)
+	}
+	if settlement.PlayerWin {
+		models.RecordTransaction(player.ID, models.TransactionWin, settlement.Winnings, player.Wallet, player.BetBalance-settlement.BonusReleased, betReference)
+	}
+	if settlement.BonusReleased > 0 {
+		models.RecordTransaction(player.ID, models.TransactionBonusRelease, settlement.BonusReleased, player.Wallet, player.BetBalance, betReference)
+	}
+
+	// Part of the stake feeds the progressive jackpot, which the bet may win (free bets aren't staked by the player)
+	if freeBet == nil {
+		settlement.JackpotWin = playJackpot(player, betID, betAmount)
+	}
+
+	// Loyalty points on the wager (free bets aren't wagered by the player)
+	if freeBet == nil {
+		accrual, loyaltyErr := models.AccrueLoyaltyPoints(player.ID, bet.Game, models.ToBaseCurrency(betAmount, player.Currency), config.LOYALTY_TIER_WINDOW_DAYS)
+		if loyaltyErr != nil {
+			log.Println("Error accruing loyalty points:", loyaltyErr)
+		} else {
+			settlement.LoyaltyPoints = accrual.Points
+			if accrual.TierUp() {
+				notifyTierUp(player.ID, accrual)
+			}
 		}
+	}
 
+	// Wagering can qualify the player's referral (free bets aren't wagered by the player)
+	if freeBet == nil && config.REFERRAL_QUALIFYING_WAGER > 0 {
+		checkReferralQualification(player.ID)
 	}
 
-	return diceRollResult, nil
+	return settlement, nil
 }
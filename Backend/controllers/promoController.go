@@ -0,0 +1,311 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"main/audit"
+	"main/config"
+	"main/helpers"
+	"main/middleware"
+	"main/models"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Promo codes and free bets
+
+POST /admin/promos                   -> Admin creates a promo code (see PromoCodeReqBody)
+GET  /admin/promos                   -> Lists promo codes (support can read)
+GET  /admin/promos/{id}/redemptions  -> Redemptions of a promo code (support can read)
+POST /player/me/promos/redeem        -> Player redeems a code {"code": string}
+GET  /player/me/free-bets            -> The player's free bets that can still be used
+
+! Types: deposit_match (bonus of value% of the next deposit, up to maxAmount), fixed_credit (bonus of value),
+! free_bets (freeBetCount free bets of value on game)
+! Bonuses follow the wagering rules of the bonus balance, free bets are used on the play socket with
+! {"betType": "pair", "freeBetId": int} instead of betAmount and only pay out the winnings
+? Each player can redeem a code once, redeeming it again returns the same redemption
+? maxRedemptions caps the redemptions of all players together (0 = unlimited)
+*/
+
+type PromoCodeReqBody struct {
+	Code               string     `json:"code"`
+	Type               string     `json:"type"`
+	Value              float32    `json:"value"`
+	MaxAmount          float32    `json:"maxAmount"`
+	FreeBetCount       int        `json:"freeBetCount"`
+	Game               string     `json:"game"`
+	WageringMultiplier *float32   `json:"wageringMultiplier"` // Defaults to BONUS_WAGERING_MULTIPLIER
+	RewardExpiryDays   *float32   `json:"rewardExpiryDays"`   // Defaults to BONUS_EXPIRY_DAYS
+	ValidFrom          *time.Time `json:"validFrom"`          // Defaults to now
+	ValidUntil         time.Time  `json:"validUntil"`
+	MaxRedemptions     int        `json:"maxRedemptions"`
+}
+
+type PromoRedeemReqBody struct {
+	Code string `json:"code"`
+}
+
+var promoCodeFormat = regexp.MustCompile(`^[A-Za-z0-9_-]{3,32}$`)
+
+// HTTP status codes of the promo error codes
+var promoErrorStatusCodes = map[string]int{
+	"PROMO_NOT_FOUND":   http.StatusNotFound,
+	"PROMO_NOT_STARTED": http.StatusBadRequest,
+	"PROMO_EXPIRED":     http.StatusBadRequest,
+	"PROMO_EXHAUSTED":   http.StatusConflict,
+}
+
+func HandleAdminPromos(w http.ResponseWriter, r *http.Request, staff *models.Player) {
+	switch r.Method {
+	case http.MethodGet:
+		limit, offset := helpers.ParsePagination(r)
+
+		promos, err := models.GetPromoCodes(limit, offset)
+		if err != nil {
+			helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+			return
+		}
+
+		helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"promos": promos,
+			"limit":  limit,
+			"offset": offset,
+		})
+	case http.MethodPost:
+		// Support staff can only read
+		if staff.Role != models.RoleAdmin {
+			helpers.WriteJSONResponse(w, http.StatusForbidden, map[string]interface{}{"message": "Only admins can create promo codes"})
+			return
+		}
+		createPromoCode(w, r, staff)
+	default:
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+	}
+}
+
+func HandleAdminPromoRedemptions(w http.ResponseWriter, r *http.Request, staff *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	promoID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Invalid promo id"})
+		return
+	}
+
+	promo, err := models.GetPromoCodeByID(promoID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusNotFound, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	limit, offset := helpers.ParsePagination(r)
+
+	redemptions, err := models.GetPromoRedemptions(promo.ID, limit, offset)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"promo":       promo,
+		"redemptions": redemptions,
+		"limit":       limit,
+		"offset":      offset,
+	})
+}
+
+func HandleRedeemPromo(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Promotions are an incentive to play, not for restricted accounts
+	if statusErr := player.CanPerform(models.ActionPlay); statusErr != nil {
+		statusCode, response := middleware.AuthErrorResponse(statusErr)
+		helpers.WriteJSONResponse(w, statusCode, response)
+		return
+	}
+
+	var redeemReqBody PromoRedeemReqBody
+	err := json.NewDecoder(r.Body).Decode(&redeemReqBody)
+	if err != nil || redeemReqBody.Code == "" {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Request is missing data (code)"})
+		return
+	}
+	defer r.Body.Close()
+
+	redemption, alreadyRedeemed, err := models.RedeemPromoCode(redeemReqBody.Code, player.ID)
+	if err != nil {
+		var promoError *models.PromoError
+		if errors.As(err, &promoError) {
+			helpers.WriteJSONResponse(w, promoErrorStatusCodes[promoError.Code], map[string]interface{}{
+				"message":   promoError.Message,
+				"errorCode": promoError.Code,
+			})
+			return
+		}
+
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	if alreadyRedeemed {
+		helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"message":    "Promo code was already redeemed",
+			"redemption": redemption,
+		})
+		return
+	}
+
+	if redemption.BonusID != nil {
+		models.NotifyBalanceUpdate(player.ID)
+	}
+
+	message := "Promo code redeemed"
+	if redemption.Status == models.RedemptionAwaitingDeposit {
+		message = "Promo code redeemed, the bonus is granted on your next deposit"
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"message":    message,
+		"redemption": redemption,
+	})
+}
+
+func HandlePlayerFreeBets(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	freeBets, err := models.GetAvailableFreeBets(player.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{"freeBets": freeBets})
+}
+
+func createPromoCode(w http.ResponseWriter, r *http.Request, admin *models.Player) {
+	var promoReqBody PromoCodeReqBody
+	err := json.NewDecoder(r.Body).Decode(&promoReqBody)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Request is missing data (code, type, value, validUntil)"})
+		return
+	}
+	defer r.Body.Close()
+
+	promo := models.PromoCode{
+		Code:               strings.ToUpper(promoReqBody.Code),
+		Type:               promoReqBody.Type,
+		Value:              promoReqBody.Value,
+		MaxAmount:          promoReqBody.MaxAmount,
+		FreeBetCount:       promoReqBody.FreeBetCount,
+		Game:               promoReqBody.Game,
+		WageringMultiplier: config.BONUS_WAGERING_MULTIPLIER,
+		RewardExpiryDays:   config.BONUS_EXPIRY_DAYS,
+		ValidFrom:          time.Now(),
+		ValidUntil:         promoReqBody.ValidUntil,
+		MaxRedemptions:     promoReqBody.MaxRedemptions,
+		CreatedBy:          admin.ID,
+	}
+
+	if promoReqBody.WageringMultiplier != nil {
+		promo.WageringMultiplier = *promoReqBody.WageringMultiplier
+	}
+	if promoReqBody.RewardExpiryDays != nil {
+		promo.RewardExpiryDays = *promoReqBody.RewardExpiryDays
+	}
+	if promoReqBody.ValidFrom != nil {
+		promo.ValidFrom = *promoReqBody.ValidFrom
+	}
+
+	// Error List
+	errorList := []string{}
+
+	if !promoCodeFormat.MatchString(promo.Code) {
+		errorList = append(errorList, "code must be 3 to 32 letters, digits, '-' or '_'")
+	}
+
+	if !models.IsValidPromoType(promo.Type) {
+		errorList = append(errorList, fmt.Sprintf("type must be one of %v", models.PromoTypes))
+	}
+
+	if promo.Value <= 0 {
+		errorList = append(errorList, "value must be greater than 0")
+	}
+
+	if promo.MaxAmount < 0 {
+		errorList = append(errorList, "maxAmount can't be negative")
+	}
+
+	if promo.Type == models.PromoFreeBets {
+		if promo.FreeBetCount < 1 || promo.FreeBetCount > 100 {
+			errorList = append(errorList, "freeBetCount must be between 1 and 100")
+		}
+
+		if !models.IsValidGame(promo.Game) {
+			errorList = append(errorList, fmt.Sprintf("game must be one of %v", models.Games))
+		}
+	}
+
+	if promo.WageringMultiplier < 0 {
+		errorList = append(errorList, "wageringMultiplier can't be negative")
+	}
+
+	if promo.RewardExpiryDays <= 0 {
+		errorList = append(errorList, "rewardExpiryDays must be greater than 0")
+	}
+
+	if !promo.ValidUntil.After(promo.ValidFrom) {
+		errorList = append(errorList, "validUntil must be after validFrom")
+	}
+
+	if promo.MaxRedemptions < 0 {
+		errorList = append(errorList, "maxRedemptions can't be negative")
+	}
+
+	if len(errorList) > 0 {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"message":    "Invalid promo code, check error list",
+			"errorsList": errorList,
+		})
+		return
+	}
+
+	promoID, err := models.CreatePromoCode(promo)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "already exists") {
+			statusCode = http.StatusConflict
+		}
+
+		helpers.WriteJSONResponse(w, statusCode, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	audit.Record(r, admin.ID, audit.ActionPromoCreate, 0, map[string]interface{}{
+		"promoId":        promoID,
+		"code":           promo.Code,
+		"type":           promo.Type,
+		"value":          promo.Value,
+		"maxRedemptions": promo.MaxRedemptions,
+	})
+
+	createdPromo, _ := models.GetPromoCodeByID(promoID)
+	helpers.WriteJSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"message": "Promo code created",
+		"promo":   createdPromo,
+	})
+}
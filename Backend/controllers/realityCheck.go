@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"fmt"
+	"main/config"
+	"main/helpers"
+	"main/models"
+	"sync"
+	"time"
+)
+
+/*
+Reality checks, reminders pushed over the play / wallet / room / crash sockets every REALITY_CHECK_INTERVAL_MINUTES
+with the session duration and the net result of the bets placed during the session
+
+! A gaming session starts when the player opens the first play / wallet / room / crash socket and ends when the last one closes
+! After a reality check the play, room and crash sockets reject bets until the player sends {"action": "acknowledgeRealityCheck"}
+! An unacknowledged reality check outlives the gaming session, reconnecting doesn't clear it
+*/
+
+type realityCheckSession struct {
+	startedAt   time.Time
+	connections int // Open play / wallet sockets
+	stop        chan struct{}
+}
+
+var realityChecks = struct {
+	sessions    map[int]*realityCheckSession // Player ID -> Gaming session
+	awaitingAck map[int]bool                 // Player ID -> Bets are blocked until the last reality check is acknowledged
+	mu          sync.Mutex
+}{sessions: make(map[int]*realityCheckSession), awaitingAck: make(map[int]bool)}
+
+// startRealityChecks is called when a play / wallet socket opens, the first one starts the gaming session
+func startRealityChecks(playerID int) {
+	if config.REALITY_CHECK_INTERVAL_MINUTES <= 0 {
+		return // Disabled
+	}
+
+	realityChecks.mu.Lock()
+	defer realityChecks.mu.Unlock()
+
+	if session, found := realityChecks.sessions[playerID]; found {
+		session.connections++
+		return
+	}
+
+	session := &realityCheckSession{startedAt: time.Now(), connections: 1, stop: make(chan struct{})}
+	realityChecks.sessions[playerID] = session
+
+	go runRealityChecks(playerID, session)
+}
+
+// stopRealityChecks is called when a play / wallet socket closes, the last one ends the gaming session
+func stopRealityChecks(playerID int) {
+	realityChecks.mu.Lock()
+	defer realityChecks.mu.Unlock()
+
+	session, found := realityChecks.sessions[playerID]
+	if !found {
+		return
+	}
+
+	session.connections--
+	if session.connections == 0 {
+		close(session.stop)
+		delete(realityChecks.sessions, playerID)
+	}
+}
+
+// realityCheckPending is true while the last reality check wasn't acknowledged
+func realityCheckPending(playerID int) bool {
+	realityChecks.mu.Lock()
+	defer realityChecks.mu.Unlock()
+
+	return realityChecks.awaitingAck[playerID]
+}
+
+// acknowledgeRealityCheck unblocks the bets, returns false if there was nothing to acknowledge
+func acknowledgeRealityCheck(playerID int) bool {
+	realityChecks.mu.Lock()
+	defer realityChecks.mu.Unlock()
+
+	if !realityChecks.awaitingAck[playerID] {
+		return false
+	}
+
+	delete(realityChecks.awaitingAck, playerID)
+	return true
+}
+
+func runRealityChecks(playerID int, session *realityCheckSession) {
+	interval := time.Duration(config.REALITY_CHECK_INTERVAL_MINUTES * float32(time.Minute))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-session.stop:
+			return
+		case <-ticker.C:
+			sendRealityCheck(playerID, session)
+		}
+	}
+}
+
+func sendRealityCheck(playerID int, session *realityCheckSession) {
+	betCount, netResult, err := models.GetBetSummarySince(playerID, session.startedAt)
+	if err != nil {
+		return
+	}
+
+	realityChecks.mu.Lock()
+	realityChecks.awaitingAck[playerID] = true
+	realityChecks.mu.Unlock()
+
+	sessionDuration := time.Since(session.startedAt).Round(time.Second)
+
+	message := map[string]interface{}{
+		"type":                   "realityCheck",
+		"code":                   200,
+		"message":                fmt.Sprintf("You've been playing for %v with a net result of %.2f, send {\"action\": \"acknowledgeRealityCheck\"} to keep playing", sessionDuration, netResult),
+		"sessionStartedAt":       session.startedAt.UTC(),
+		"sessionDurationSeconds": int(sessionDuration.Seconds()),
+		"betsPlaced":             betCount,
+		"netResult":              netResult,
+	}
+
+	for _, wsSession := range helpers.Sessions.PlayerSessions(playerID, helpers.SessionPlay, helpers.SessionWallet, helpers.SessionRoom, helpers.SessionCrash) {
+		wsSession.WriteJSON(message)
+	}
+}
@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"main/config"
+	"main/helpers"
+	"main/models"
+	"net/http"
+	"time"
+)
+
+/*
+Referral program
+
+GET /player/me/referrals          -> The player's referral code, the players they referred and the stats
+GET /admin/players/{id}/referrals -> Same for any player, with the referred players' names unmasked (support can read)
+
+! Every player gets a referral code at registration, a new player can register with {"referralCode": string}
+! Once the referred player qualifies (first deposit, or REFERRAL_QUALIFYING_WAGER wagered) both players get
+! their reward (REFERRAL_REFERRER_REWARD / REFERRAL_REFERRED_REWARD) credited to the wallet
+? Registrations from an IP the referrer used or with the referrer's device (X-Device-ID header) are rejected as self-referrals
+? Rewards are paid once the player isn't betting, unpaid rewards are retried when the referrals are viewed
+*/
+
+// How long a reward payout waits for the player's balance to be free
+const referralRewardTimeout = 30 * time.Second
+
+func HandlePlayerReferrals(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Players registered before the referral program don't have a code yet
+	referralCode, err := models.AssignReferralCode(player.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	go payUnpaidReferralRewards(player.ID)
+
+	referrals, stats, err := models.GetReferralsByReferrer(player.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	// Referred players only see each other's masked names
+	for i := range referrals {
+		referrals[i].ReferredName = models.MaskPlayerName(referrals[i].ReferredName)
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"referralCode": referralCode,
+		"referrals":    referrals,
+		"stats":        stats,
+	})
+}
+
+func HandleAdminPlayerReferrals(w http.ResponseWriter, r *http.Request, staff *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	player, ok := findPlayerFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	referrals, stats, err := models.GetReferralsByReferrer(player.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"referralCode": player.ReferralCode,
+		"referrals":    referrals,
+		"stats":        stats,
+	})
+}
+
+// checkReferralQualification qualifies the player's pending referral if they met the condition and pays the rewards
+// Called after deposits and bets, while the player's balance is still locked (the payout waits for it in the background)
+func checkReferralQualification(playerID int) {
+	referral, err := models.QualifyReferral(playerID, config.REFERRAL_QUALIFYING_WAGER, config.REFERRAL_REFERRER_REWARD, config.REFERRAL_REFERRED_REWARD)
+	if err != nil {
+		log.Println("Error checking referral qualification:", err)
+		return
+	}
+
+	if referral != nil {
+		go payReferralRewards(referral)
+	}
+}
+
+func payUnpaidReferralRewards(playerID int) {
+	referrals, err := models.GetUnpaidReferrals(playerID)
+	if err != nil {
+		log.Println("Error fetching unpaid referrals:", err)
+		return
+	}
+
+	for i := range referrals {
+		payReferralRewards(&referrals[i])
+	}
+}
+
+// payReferralRewards credits the rewards of a qualified referral that weren't paid yet
+func payReferralRewards(referral *models.Referral) {
+	if referral.ReferrerRewardedAt == nil {
+		payReferralReward(referral, models.ReferralSideReferrer, referral.ReferrerID, referral.ReferrerReward)
+	}
+	if referral.ReferredRewardedAt == nil {
+		payReferralReward(referral, models.ReferralSideReferred, referral.ReferredID, referral.ReferredReward)
+	}
+}
+
+// payReferralReward credits one side's reward to the player's wallet
+// Uses the betting status as a processing lock like the other balance updates, waiting for it if the player is busy
+func payReferralReward(referral *models.Referral, side string, playerID int, reward float32) {
+	if reward <= 0 {
+		models.MarkReferralRewarded(referral.ID, side)
+		return
+	}
+
+	deadline := time.Now().Add(referralRewardTimeout)
+	for {
+		locked, err := models.TrySetPlayerBetting(playerID)
+		if err != nil {
+			log.Println("Error locking player for referral reward:", err)
+			return
+		}
+		if locked {
+			break
+		}
+		if time.Now().After(deadline) {
+			return // Retried the next time the referrals are viewed
+		}
+		time.Sleep(time.Second)
+	}
+	defer models.UpdatePlayerBettingStatus(playerID, false)
+
+	// Only one payout can claim it
+	claimed, err := models.MarkReferralRewarded(referral.ID, side)
+	if err != nil || !claimed {
+		return
+	}
+
+	player, err := models.GetPlayerByID(playerID)
+	if err != nil {
+		models.UnmarkReferralRewarded(referral.ID, side)
+		return
+	}
+
+	// Rewards are in the base currency
+	credited := models.FromBaseCurrency(reward, player.Currency)
+	newWalletBalance := player.Wallet + credited
+	if err := models.UpdatePlayerBalance(player.ID, newWalletBalance, player.BetBalance); err != nil {
+		log.Println("Error crediting referral reward:", err)
+		models.UnmarkReferralRewarded(referral.ID, side)
+		return
+	}
+
+	models.RecordTransaction(player.ID, models.TransactionReferralReward, credited, newWalletBalance, player.BetBalance, fmt.Sprintf("referral:%d", referral.ID))
+}
@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"main/audit"
+	"main/config"
+	"main/helpers"
+	"main/models"
+	"main/risk"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+/*
+Risk checks (see risk/risk.go), evaluated on deposits, withdrawals and every bet
+
+GET  /admin/risk/flags?status=&playerId=&event=&limit=&offset= -> Flagged / blocked actions, newest first (support can read)
+GET  /admin/risk/flags/{id}                                     -> One flag with the rules that triggered and what they measured
+POST /admin/risk/flags/{id}/review                              -> Admin closes an open flag {"status": "confirmed" | "dismissed", "note": "..."}
+GET  /admin/risk/rules                                          -> Rule set in use, its file and why the last change of the file was ignored
+
+! Blocked actions are refused with RISK_BLOCKED (403 on deposits / withdrawals, in the socket answer for bets)
+! Flagged withdrawals aren't approved automatically, they wait for an admin
+? The rules are edited in RISK_RULES_FILE and picked up without a restart
+*/
+
+type RiskFlagReviewReqBody struct {
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
+
+// LoadRiskRules reads the rule set, the server can't run without one
+func LoadRiskRules() {
+	if err := risk.LoadRules(config.RISK_RULES_FILE); err != nil {
+		log.Fatal("Error loading risk rules: ", err)
+	}
+}
+
+func HandleAdminRiskFlags(w http.ResponseWriter, r *http.Request, staff *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, offset := helpers.ParsePagination(r)
+	query := r.URL.Query()
+
+	// Optional filters
+	playerID, _ := strconv.Atoi(query.Get("playerId"))
+
+	flags, err := risk.GetFlags(risk.FlagFilter{
+		Status:   query.Get("status"),
+		PlayerID: playerID,
+		Event:    query.Get("event"),
+		Limit:    limit,
+		Offset:   offset,
+	})
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"flags":  flags,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+func HandleAdminRiskFlag(w http.ResponseWriter, r *http.Request, staff *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flag, ok := findRiskFlagFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{"flag": flag})
+}
+
+func HandleAdminReviewRiskFlag(w http.ResponseWriter, r *http.Request, admin *models.Player) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flag, ok := findRiskFlagFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var reviewReqBody RiskFlagReviewReqBody
+	err := json.NewDecoder(r.Body).Decode(&reviewReqBody)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Request is missing data (status, note)"})
+		return
+	}
+	defer r.Body.Close()
+
+	// Error List
+	errorList := []string{}
+
+	if reviewReqBody.Status == risk.FlagOpen || !slices.Contains(risk.FlagStatuses, reviewReqBody.Status) {
+		errorList = append(errorList, fmt.Sprintf("status must be '%s' or '%s'", risk.FlagConfirmed, risk.FlagDismissed))
+	}
+
+	if flag.PlayerID == admin.ID {
+		errorList = append(errorList, "admins can't review their own flags")
+	}
+
+	if len(errorList) > 0 {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"message":    "Invalid review, check error list",
+			"errorsList": errorList,
+		})
+		return
+	}
+
+	updated, err := risk.ReviewFlag(flag.ID, reviewReqBody.Status, admin.ID, reviewReqBody.Note)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+	if !updated {
+		helpers.WriteJSONResponse(w, http.StatusConflict, map[string]interface{}{"message": "Flag was already reviewed"})
+		return
+	}
+
+	audit.Record(r, admin.ID, audit.ActionRiskFlagReview, flag.PlayerID, map[string]interface{}{
+		"flagId": flag.ID,
+		"status": reviewReqBody.Status,
+		"note":   reviewReqBody.Note,
+	})
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "Flag " + reviewReqBody.Status,
+		"flagId":  flag.ID,
+		"status":  reviewReqBody.Status,
+	})
+}
+
+func HandleAdminRiskRules(w http.ResponseWriter, r *http.Request, staff *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ruleSet, file, loadedAt, lastErr := risk.RulesStatus()
+
+	response := map[string]interface{}{
+		"rules":    ruleSet,
+		"file":     file,
+		"loadedAt": loadedAt,
+	}
+	if lastErr != "" {
+		response["ignoredChange"] = lastErr
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, response)
+}
+
+func findRiskFlagFromPath(w http.ResponseWriter, r *http.Request) (*risk.Flag, bool) {
+	flagID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Invalid flag id"})
+		return nil, false
+	}
+
+	flag, err := risk.GetFlagByID(flagID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusNotFound, map[string]interface{}{"message": err.Error()})
+		return nil, false
+	}
+
+	return flag, true
+}
+
+// addRiskErrorFields adds the error code of a blocked action to a response, false if the error isn't a block
+func addRiskErrorFields(response map[string]interface{}, err error) bool {
+	var blockedError *risk.BlockedError
+	if !errors.As(err, &blockedError) {
+		return false
+	}
+
+	response["errorCode"] = risk.BlockedCode
+	return true
+}
@@ -0,0 +1,515 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"main/config"
+	"main/helpers"
+	"main/middleware"
+	"main/models"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+Shared dice rooms, one die rolled for every player of a round
+
+GET /rooms          -> The rooms with their current round (public)
+WS  /ws/rooms/{room} -> Player joins a room, bets on the open round with {"betType": "pair", "betAmount": int}
+                       (or "freeBetId" instead of betAmount like on the play socket)
+
+! Rounds take bets for ROOM_BETTING_SECONDS, then the die is rolled once and every bet of the round is settled with it
+! Messages pushed to the room: roundOpen, roundBet (every bet placed, public), roundResult (the die and every bet's result)
+! and betResult to the player who placed a bet
+! Bets placed once the round closed are rejected (ROUND_CLOSED), a player can bet once per round (ROUND_ALREADY_BET)
+? The stake is taken when the bet is placed, room bets can't be funded by bonus money (the bonus is only taken at settlement)
+? Rounds only run while someone is in the room, rooms are set with DICE_ROOMS
+? Rounds rolled before a restart are settled when the room starts again, bets of rounds that were never rolled are refunded
+*/
+
+// Pause between the result of a round and the next round
+const roomResultPause = 5 * time.Second
+
+var (
+	errRoundClosed     = errors.New("Betting is closed, wait for the next round")
+	errRoundAlreadyBet = errors.New("Player already placed a bet on this round")
+)
+
+// diceRoom is the state of a room: the players in it and the round taking bets
+type diceRoom struct {
+	name     string
+	sessions map[int]*helpers.WSSession // Session ID -> Session
+	round    *roomRound                 // nil between rounds
+	mu       sync.Mutex
+}
+
+type roomRound struct {
+	id       int
+	closesAt time.Time
+	open     bool // Taking bets
+	bets     []models.RoundBet
+}
+
+// Room name -> Room, set up by StartDiceRooms
+var diceRooms = map[string]*diceRoom{}
+
+// roomBetResult is a bet of a round with its result, as broadcast to the room
+type roomBetResult struct {
+	Name      string  `json:"name"`
+	BetType   string  `json:"betType"`
+	BetAmount float32 `json:"betAmount"`
+	PlayerWin bool    `json:"playerWin"`
+	Winnings  float32 `json:"winnings"`
+	Settled   bool    `json:"settled"` // false while the payout is pending (retried before the next round)
+}
+
+// StartDiceRooms refunds the bets of rounds left open by a previous run and starts the rounds of every room
+func StartDiceRooms() {
+	bets, err := models.CancelOpenDiceRounds()
+	if err != nil {
+		log.Println("Error cancelling open dice rounds:", err)
+	}
+	for _, bet := range bets {
+		if err := refundRoundBet(bet); err != nil {
+			log.Printf("Round bet %d not refunded: %v\n", bet.ID, err)
+		}
+	}
+
+	for _, name := range config.DICE_ROOMS {
+		room := &diceRoom{name: name, sessions: make(map[int]*helpers.WSSession)}
+		diceRooms[name] = room
+		go room.run()
+	}
+}
+
+func HandleRooms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rooms := []map[string]interface{}{}
+	for _, name := range config.DICE_ROOMS {
+		rooms = append(rooms, diceRooms[name].snapshot())
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"rooms":          rooms,
+		"bettingSeconds": config.ROOM_BETTING_SECONDS,
+	})
+}
+
+func HandleRoomWS(w http.ResponseWriter, r *http.Request) {
+	player, authenticated := middleware.AuthenticateWS(w, r)
+	if !authenticated {
+		return
+	}
+
+	conn, upgradeConErr := helpers.WSUpgrader.Upgrade(w, r, nil)
+	if upgradeConErr != nil {
+		return
+	}
+
+	room, found := diceRooms[r.PathValue("room")]
+	if !found {
+		stringifiedResponse, _ := helpers.JsonStringifier(map[string]interface{}{"code": 404, "message": fmt.Sprintf("room must be one of %v", config.DICE_ROOMS)})
+		conn.WriteMessage(websocket.TextMessage, []byte(stringifiedResponse))
+		conn.Close()
+		return
+	}
+
+	// Keep track of the connection so it can be closed from elsewhere (e.g. account status changes)
+	session := helpers.Sessions.Register(player.ID, helpers.SessionRoom, conn)
+	defer helpers.Sessions.Unregister(session)
+
+	room.join(session)
+	defer room.leave(session)
+
+	// Gaming session for the reality checks
+	startRealityChecks(player.ID)
+	defer stopRealityChecks(player.ID)
+
+	welcome := room.snapshot()
+	welcome["type"] = "room"
+	welcome["code"] = 200
+	welcome["message"] = "Joined the room"
+	welcome["bettingSeconds"] = config.ROOM_BETTING_SECONDS
+	session.WriteJSON(welcome)
+
+	conn.SetReadDeadline(time.Now().Add(time.Duration(config.SOCKET_TIMEOUT_DURATION) * time.Second))
+	for {
+		_, receivedMsg, readMessageErr := conn.ReadMessage()
+		if readMessageErr != nil {
+			break
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Duration(config.SOCKET_TIMEOUT_DURATION) * time.Second))
+
+		if !middleware.AllowWSMessage(session, receivedMsg, "bet") {
+			continue
+		}
+
+		session.WriteJSON(handleRoomMessage(room, player.ID, receivedMsg))
+	}
+
+	conn.Close()
+}
+
+// handleRoomMessage places the bet sent on a room socket and returns the response
+func handleRoomMessage(room *diceRoom, playerID int, receivedMsg []byte) map[string]interface{} {
+	response := map[string]interface{}{
+		"type":    "betPlaced",
+		"message": "Bet placed, waiting for the roll",
+		"code":    200,
+	}
+
+	// Error List
+	errorList := []string{}
+
+	parsedData, jsonParserErr := helpers.JsonParser(receivedMsg)
+	if jsonParserErr != nil {
+		response["errorsList"] = append(errorList, "Invalid JSON received")
+		response["code"] = 400
+		response["message"] = "Invalid JSON"
+		return response
+	}
+
+	// Reality check acknowledgements aren't bets
+	if action, _ := parsedData["action"].(string); action == "acknowledgeRealityCheck" {
+		response["type"] = "realityCheckAcknowledged"
+		if acknowledgeRealityCheck(playerID) {
+			response["message"] = "Reality check acknowledged"
+		} else {
+			response["code"] = 400
+			response["message"] = "There is no reality check to acknowledge"
+		}
+		return response
+	}
+
+	betType, _ := parsedData["betType"].(string)
+	if betType != "pair" && betType != "not pair" {
+		errorList = append(errorList, "betType must be 'pair' or 'not pair'")
+	}
+
+	// A free bet can be used instead of a stake
+	freeBetID64, usesFreeBet := parsedData["freeBetId"].(float64)
+	freeBetID := int(freeBetID64)
+	if usesFreeBet && freeBetID <= 0 {
+		errorList = append(errorList, "Invalid freeBetId")
+	}
+
+	betAmount64, betAmountIsFloat64 := parsedData["betAmount"].(float64)
+	if (!betAmountIsFloat64 || betAmount64 <= 0) && !usesFreeBet {
+		errorList = append(errorList, "betAmount must be greater than 0")
+	}
+
+	// The last reality check must be acknowledged before betting again
+	if realityCheckPending(playerID) {
+		errorList = append(errorList, "Acknowledge the reality check before placing another bet")
+		response["errorCode"] = "REALITY_CHECK_ACK_REQUIRED"
+	}
+
+	if len(errorList) == 0 {
+		bet, err := room.placeBet(playerID, float32(betAmount64), betType, freeBetID)
+		if err != nil {
+			errorList = append(errorList, err.Error())
+
+			var accountStatusError *models.AccountStatusError
+			switch {
+			case errors.Is(err, errRoundClosed):
+				response["errorCode"] = "ROUND_CLOSED"
+			case errors.Is(err, errRoundAlreadyBet):
+				response["errorCode"] = "ROUND_ALREADY_BET"
+			case errors.As(err, &accountStatusError):
+				response["errorCode"] = accountStatusError.Code
+			}
+			addLimitErrorFields(response, err)
+			addRiskErrorFields(response, err)
+		} else {
+			response["bet"] = bet
+		}
+	}
+
+	if len(errorList) > 0 {
+		response["errorsList"] = errorList
+		response["code"] = 400
+		response["message"] = "Error creating bet, check error list"
+	}
+
+	return response
+}
+
+// run opens the rounds of the room one after the other while there are players in it
+func (room *diceRoom) run() {
+	for {
+		room.settlePendingBets()
+
+		if room.playerCount() == 0 {
+			time.Sleep(time.Second)
+			continue
+		}
+
+		closesAt := time.Now().Add(time.Duration(config.ROOM_BETTING_SECONDS * float32(time.Second)))
+		roundID, err := models.CreateDiceRound(room.name, closesAt)
+		if err != nil {
+			log.Println("Error opening dice round:", err)
+			time.Sleep(roomResultPause)
+			continue
+		}
+
+		room.mu.Lock()
+		room.round = &roomRound{id: roundID, closesAt: closesAt, open: true, bets: []models.RoundBet{}}
+		room.mu.Unlock()
+
+		room.broadcast(map[string]interface{}{
+			"type":     "roundOpen",
+			"code":     200,
+			"roundId":  roundID,
+			"closesAt": closesAt,
+		})
+
+		time.Sleep(time.Until(closesAt))
+
+		// Late bets are rejected from here on
+		room.mu.Lock()
+		round := room.round
+		round.open = false
+		room.mu.Unlock()
+
+		room.rollRound(round)
+
+		room.mu.Lock()
+		room.round = nil
+		room.mu.Unlock()
+
+		time.Sleep(roomResultPause)
+	}
+}
+
+// rollRound rolls the die of a closed round, settles its bets and broadcasts the results
+func (room *diceRoom) rollRound(round *roomRound) {
+	diceNumber := rollDice()
+	if err := models.SetDiceRoundRolled(round.id, diceNumber); err != nil {
+		log.Println("Error rolling dice round:", err) // Its bets are refunded on the next startup
+		return
+	}
+
+	results := []roomBetResult{}
+	for _, bet := range round.bets {
+		result := roomBetResult{Name: bet.Name, BetType: bet.BetType, BetAmount: bet.BetAmount}
+
+		diceRollResult, err := settleRoundBet(bet, diceNumber)
+		if err != nil {
+			log.Printf("Round bet %d not settled (retried before the next round): %v\n", bet.ID, err)
+			result.PlayerWin = (diceNumber%2 == 0) == (bet.BetType == "pair")
+		} else {
+			result.PlayerWin, result.Winnings, result.Settled = diceRollResult.PlayerWin, diceRollResult.Winnings, true
+			room.sendBetResult(bet, diceRollResult)
+		}
+
+		results = append(results, result)
+	}
+
+	room.broadcast(map[string]interface{}{
+		"type":       "roundResult",
+		"code":       200,
+		"roundId":    round.id,
+		"diceNumber": diceNumber,
+		"bets":       results,
+	})
+}
+
+// placeBet takes the stake of a bet on the open round and records it
+func (room *diceRoom) placeBet(playerID int, betAmount float32, betType string, freeBetID int) (*models.RoundBet, error) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	round := room.round
+	if round == nil || !round.open || !time.Now().Before(round.closesAt) {
+		return nil, errRoundClosed
+	}
+	if slices.ContainsFunc(round.bets, func(bet models.RoundBet) bool { return bet.PlayerID == playerID }) {
+		return nil, errRoundAlreadyBet
+	}
+
+	roundBetID, err := placeUpfrontBet(playerID, models.GameDice, betAmount, freeBetID, fmt.Sprintf("round:%d", round.id),
+		func(stake betStake, walletStake float32) (int, error) {
+			roundBet := models.RoundBet{
+				RoundID:     round.id,
+				PlayerID:    playerID,
+				BetType:     betType,
+				BetAmount:   stake.BetAmount,
+				WalletStake: walletStake,
+			}
+			if stake.FreeBet != nil {
+				roundBet.FreeBetID = &stake.FreeBet.ID
+			}
+			return models.PlaceRoundBet(roundBet)
+		}, models.DeleteRoundBet)
+	if err != nil {
+		return nil, err
+	}
+
+	placedBet, err := models.GetRoundBetByID(roundBetID)
+	if err != nil {
+		return nil, err
+	}
+	round.bets = append(round.bets, *placedBet)
+
+	go room.broadcast(map[string]interface{}{
+		"type":    "roundBet",
+		"code":    200,
+		"roundId": round.id,
+		"bet":     placedBet,
+	})
+
+	return placedBet, nil
+}
+
+// settlePendingBets settles the bets of rolled rounds whose settlement failed or was interrupted by a restart
+func (room *diceRoom) settlePendingBets() {
+	bets, err := models.GetUnsettledRoundBets(room.name)
+	if err != nil {
+		log.Println("Error fetching unsettled round bets:", err)
+		return
+	}
+
+	for _, bet := range bets {
+		diceRollResult, err := settleRoundBet(bet, *bet.DiceNumber)
+		if err != nil {
+			log.Printf("Round bet %d not settled (retried before the next round): %v\n", bet.ID, err)
+			continue
+		}
+		room.sendBetResult(bet, diceRollResult)
+	}
+}
+
+// settleRoundBet pays out a round bet on the rolled die and records it like the other bets
+func settleRoundBet(bet models.RoundBet, diceNumber int) (DiceRollResult, error) {
+	if err := waitForPlayerBetting(bet.PlayerID, upfrontBetTimeout); err != nil {
+		return DiceRollResult{}, err
+	}
+	defer models.UpdatePlayerBettingStatus(bet.PlayerID, false)
+
+	claimed, err := models.ClaimRoundBet(bet.ID, models.RoundBetSettled)
+	if err != nil {
+		return DiceRollResult{}, err
+	}
+	if !claimed {
+		return DiceRollResult{}, errors.New("round bet was already settled")
+	}
+
+	player, err := models.GetPlayerByID(bet.PlayerID)
+	if err != nil {
+		models.ReleaseRoundBetClaim(bet.ID)
+		return DiceRollResult{}, err
+	}
+
+	// The stake was taken and recorded when the bet was placed
+	stake := betStake{BetAmount: bet.BetAmount, Recorded: true}
+	if bet.FreeBetID != nil {
+		stake.FreeBet = &models.FreeBet{ID: *bet.FreeBetID, Amount: bet.BetAmount}
+	}
+
+	diceRollResult, err := settleDiceBet(player, stake, bet.BetType, diceNumber)
+	if err != nil {
+		models.ReleaseRoundBetClaim(bet.ID)
+		return DiceRollResult{}, err
+	}
+
+	models.SetRoundBetBet(bet.ID, diceRollResult.BetID)
+
+	return diceRollResult, nil
+}
+
+// refundRoundBet gives the stake of a bet of a round that was never rolled back
+func refundRoundBet(bet models.RoundBet) error {
+	return refundUpfrontStake(bet.PlayerID, bet.BetAmount, bet.WalletStake, bet.FreeBetID, fmt.Sprintf("round:%d", bet.RoundID),
+		func() (bool, error) { return models.ClaimRoundBet(bet.ID, models.RoundBetRefunded) },
+		func() error { return models.ReleaseRoundBetClaim(bet.ID) })
+}
+
+func (room *diceRoom) join(session *helpers.WSSession) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	room.sessions[session.ID] = session
+}
+
+func (room *diceRoom) leave(session *helpers.WSSession) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	delete(room.sessions, session.ID)
+}
+
+// playerCount returns how many players are in the room
+func (room *diceRoom) playerCount() int {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	players := map[int]bool{}
+	for _, session := range room.sessions {
+		players[session.PlayerID] = true
+	}
+
+	return len(players)
+}
+
+// snapshot returns the room with its current round and the bets placed on it
+func (room *diceRoom) snapshot() map[string]interface{} {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	snapshot := map[string]interface{}{"room": room.name, "round": nil}
+	if room.round != nil {
+		snapshot["round"] = map[string]interface{}{
+			"roundId":  room.round.id,
+			"closesAt": room.round.closesAt,
+			"open":     room.round.open,
+			"bets":     slices.Clone(room.round.bets),
+		}
+	}
+
+	return snapshot
+}
+
+func (room *diceRoom) broadcast(message map[string]interface{}) {
+	room.mu.Lock()
+	sessions := make([]*helpers.WSSession, 0, len(room.sessions))
+	for _, session := range room.sessions {
+		sessions = append(sessions, session)
+	}
+	room.mu.Unlock()
+
+	for _, session := range sessions {
+		session.WriteJSON(message)
+	}
+}
+
+// sendBetResult sends the full result of a settled bet to the player's sockets in the room
+func (room *diceRoom) sendBetResult(bet models.RoundBet, diceRollResult DiceRollResult) {
+	message := map[string]interface{}{
+		"type":    "betResult",
+		"code":    200,
+		"roundId": bet.RoundID,
+	}
+	addDiceRollResultFields(message, diceRollResult)
+
+	room.mu.Lock()
+	sessions := []*helpers.WSSession{}
+	for _, session := range room.sessions {
+		if session.PlayerID == bet.PlayerID {
+			sessions = append(sessions, session)
+		}
+	}
+	room.mu.Unlock()
+
+	for _, session := range sessions {
+		session.WriteJSON(message)
+	}
+}
@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"main/audit"
+	"main/helpers"
+	"main/models"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+Self-exclusion and cool-offs, requested by the players themselves
+
+POST /player/me/self-exclusion -> Self-excludes the player {"period": "6m" | "1y" | "5y" | "permanent"}
+POST /player/me/cool-off       -> Short break from gambling {"period": "24h" | "7d"}
+
+! Both block bets and deposits but still allow withdrawals and cash ins
+! They can only be extended, never shortened (a timed self-exclusion is lifted when it ends)
+! Only active accounts can self-exclude, ending the exclusion would otherwise lift a freeze set by an admin
+? Open sockets are closed so the restriction applies right away
+*/
+
+type ExclusionReqBody struct {
+	Period string `json:"period"`
+}
+
+// Self-exclusion periods (nil = permanent)
+var selfExclusionPeriods = map[string]func(time.Time) time.Time{
+	"6m":        func(now time.Time) time.Time { return now.AddDate(0, 6, 0) },
+	"1y":        func(now time.Time) time.Time { return now.AddDate(1, 0, 0) },
+	"5y":        func(now time.Time) time.Time { return now.AddDate(5, 0, 0) },
+	"permanent": nil,
+}
+
+var coolOffPeriods = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+}
+
+func HandleSelfExclusion(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var exclusionReqBody ExclusionReqBody
+	err := json.NewDecoder(r.Body).Decode(&exclusionReqBody)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Request is missing data (period)"})
+		return
+	}
+	defer r.Body.Close()
+
+	exclusionEnd, validPeriod := selfExclusionPeriods[exclusionReqBody.Period]
+	if !validPeriod {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"message": fmt.Sprintf("period must be one of %v", slices.Sorted(maps.Keys(selfExclusionPeriods))),
+		})
+		return
+	}
+
+	var until *time.Time
+	if exclusionEnd != nil {
+		end := exclusionEnd(time.Now().UTC())
+		until = &end
+	}
+
+	// Frozen accounts keep their status (support can self-exclude them on request)
+	if player.Status != models.StatusActive && player.Status != models.StatusSelfExcluded {
+		helpers.WriteJSONResponse(w, http.StatusConflict, map[string]interface{}{
+			"message": "Account is " + player.Status + ", please contact support to self-exclude",
+			"status":  player.Status,
+		})
+		return
+	}
+
+	// Can't shorten an ongoing self-exclusion (or turn a permanent one into a timed one)
+	if player.Status == models.StatusSelfExcluded {
+		if player.ExclusionUntil == nil || (until != nil && until.Before(*player.ExclusionUntil)) {
+			helpers.WriteJSONResponse(w, http.StatusConflict, map[string]interface{}{
+				"message":        "An ongoing self-exclusion can only be extended",
+				"exclusionUntil": player.ExclusionUntil,
+			})
+			return
+		}
+	}
+
+	reason := "Self-excluded permanently"
+	if until != nil {
+		reason = "Self-excluded until " + until.Format(time.RFC3339)
+	}
+
+	err = models.SetPlayerSelfExclusion(player.ID, until, reason)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	audit.Record(r, player.ID, audit.ActionSelfExclusion, player.ID, map[string]interface{}{
+		"period":         exclusionReqBody.Period,
+		"exclusionUntil": until,
+	})
+
+	// Reconnecting makes the sockets pick up the new status
+	helpers.Sessions.CloseAll(player.ID, websocket.ClosePolicyViolation, reason)
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message":        reason + ", withdrawals are still allowed",
+		"exclusionUntil": until,
+	})
+}
+
+func HandleCoolOff(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var coolOffReqBody ExclusionReqBody
+	err := json.NewDecoder(r.Body).Decode(&coolOffReqBody)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Request is missing data (period)"})
+		return
+	}
+	defer r.Body.Close()
+
+	coolOffDuration, validPeriod := coolOffPeriods[coolOffReqBody.Period]
+	if !validPeriod {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"message": fmt.Sprintf("period must be one of %v", slices.Sorted(maps.Keys(coolOffPeriods))),
+		})
+		return
+	}
+
+	until := time.Now().UTC().Add(coolOffDuration)
+
+	// Can't shorten an ongoing cool-off
+	if player.IsCoolingOff() && until.Before(*player.CoolOffUntil) {
+		helpers.WriteJSONResponse(w, http.StatusConflict, map[string]interface{}{
+			"message":      "An ongoing cool-off can only be extended",
+			"coolOffUntil": player.CoolOffUntil,
+		})
+		return
+	}
+
+	err = models.SetPlayerCoolOff(player.ID, until)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	audit.Record(r, player.ID, audit.ActionCoolOff, player.ID, map[string]interface{}{
+		"period":       coolOffReqBody.Period,
+		"coolOffUntil": until,
+	})
+
+	helpers.Sessions.CloseAll(player.ID, websocket.ClosePolicyViolation, "Cooling off until "+until.Format(time.RFC3339))
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message":      "Cooling off until " + until.Format(time.RFC3339) + ", withdrawals are still allowed",
+		"coolOffUntil": until,
+	})
+}
@@ -0,0 +1,534 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"main/audit"
+	"main/config"
+	"main/helpers"
+	"main/middleware"
+	"main/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Time-boxed tournaments
+
+GET  /tournaments?status=       -> Lists tournaments (public), status can be a comma separated list
+GET  /tournaments/{id}          -> A tournament with its standings (public)
+POST /tournaments/{id}/join     -> Player joins, paying the entry fee from the wallet
+POST /admin/tournaments         -> Admin creates a tournament (see TournamentReqBody)
+WS   /ws/tournaments            -> Public socket, sends the standings of the running tournaments on connect and
+                                   pushes the standings / status changes of every tournament
+
+! Statuses: scheduled -> running (at startsAt) -> settling (at endsAt) -> finished (every prize paid)
+! Scoring: wagered (total staked) or net_result (total won minus total staked), only the bets on the tournament's
+! game settled while it's running count, free bets don't
+! Prize pool = guaranteedPrize + entry fees, paid by rank with the payout table (% of the pool, TOURNAMENT_PAYOUT_TABLE by default)
+? The status lives in the database and the scheduler (every TOURNAMENT_CHECK_INTERVAL_SECONDS and on startup) picks up
+? where it stopped, prizes are marked as paid and credited in one transaction so a restart never pays twice or skips one
+*/
+
+// Players shown in the standings
+const tournamentStandingsSize = 10
+
+// Only one scheduler run at a time
+var tournamentRunMu sync.Mutex
+
+type TournamentReqBody struct {
+	Name            string     `json:"name"`
+	Game            string     `json:"game"`
+	Scoring         string     `json:"scoring"`
+	EntryFee        float32    `json:"entryFee"`
+	GuaranteedPrize float32    `json:"guaranteedPrize"`
+	PayoutTable     []float32  `json:"payoutTable"` // Defaults to TOURNAMENT_PAYOUT_TABLE
+	StartsAt        *time.Time `json:"startsAt"`    // Defaults to now
+	EndsAt          time.Time  `json:"endsAt"`
+}
+
+// HTTP status codes of the tournament error codes
+var tournamentErrorStatusCodes = map[string]int{
+	"TOURNAMENT_CLOSED":         http.StatusConflict,
+	"TOURNAMENT_ALREADY_JOINED": http.StatusConflict,
+}
+
+// StartTournamentScheduler moves the tournaments through their statuses on startup and then checks again periodically
+func StartTournamentScheduler() {
+	go func() {
+		ticker := time.NewTicker(time.Duration(config.TOURNAMENT_CHECK_INTERVAL_SECONDS * float32(time.Second)))
+		defer ticker.Stop()
+
+		for {
+			if err := runTournaments(time.Now()); err != nil {
+				log.Println("Error running tournaments:", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+func HandleTournaments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	statuses := []string{}
+	if status := r.URL.Query().Get("status"); status != "" {
+		statuses = strings.Split(status, ",")
+	}
+	for _, status := range statuses {
+		if !models.IsValidTournamentStatus(status) {
+			helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": fmt.Sprintf("status must be one of %v", models.TournamentStatuses)})
+			return
+		}
+	}
+
+	limit, offset := helpers.ParsePagination(r)
+
+	tournaments, err := models.GetTournaments(statuses, limit, offset)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"tournaments": tournaments,
+		"limit":       limit,
+		"offset":      offset,
+	})
+}
+
+func HandleTournament(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tournament, ok := findTournamentFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	standings, err := models.GetTournamentStandings(tournament.ID, tournamentStandingsSize)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"tournament": tournament,
+		"standings":  standings,
+	})
+}
+
+func HandleJoinTournament(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if statusErr := player.CanPerform(models.ActionPlay); statusErr != nil {
+		statusCode, response := middleware.AuthErrorResponse(statusErr)
+		helpers.WriteJSONResponse(w, statusCode, response)
+		return
+	}
+
+	tournament, ok := findTournamentFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	// The entry fee is taken like the other balance updates, with the betting status as a processing lock
+	// Fees and prizes are in the base currency, the player pays / gets them in the currency they play in
+	if tournament.EntryFee > 0 {
+		locked, err := models.TrySetPlayerBetting(player.ID)
+		if err != nil {
+			helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+			return
+		}
+		if !locked {
+			helpers.WriteJSONResponse(w, http.StatusConflict, map[string]interface{}{"message": "Cannot join a tournament while player is in Betting Process"})
+			return
+		}
+		defer models.UpdatePlayerBettingStatus(player.ID, false)
+	}
+
+	entryFee, currency, err := models.JoinTournament(tournament, player.ID)
+	if err != nil {
+		var tournamentError *models.TournamentError
+		if errors.As(err, &tournamentError) {
+			helpers.WriteJSONResponse(w, tournamentErrorStatusCodes[tournamentError.Code], map[string]interface{}{
+				"message":   tournamentError.Message,
+				"errorCode": tournamentError.Code,
+			})
+			return
+		}
+		if errors.Is(err, models.ErrTournamentInsufficientFunds) {
+			helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": err.Error()})
+			return
+		}
+
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	// The entry fee grew the prize pool
+	go pushTournamentStandings(tournament.ID)
+
+	helpers.WriteJSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"message":  "Joined the tournament",
+		"entryFee": entryFee,
+		"currency": currency,
+	})
+}
+
+func HandleAdminTournaments(w http.ResponseWriter, r *http.Request, admin *models.Player) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var tournamentReqBody TournamentReqBody
+	err := json.NewDecoder(r.Body).Decode(&tournamentReqBody)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Request is missing data (name, game, scoring, endsAt)"})
+		return
+	}
+	defer r.Body.Close()
+
+	tournament := models.Tournament{
+		Name:            strings.TrimSpace(tournamentReqBody.Name),
+		Game:            tournamentReqBody.Game,
+		Scoring:         tournamentReqBody.Scoring,
+		EntryFee:        tournamentReqBody.EntryFee,
+		GuaranteedPrize: tournamentReqBody.GuaranteedPrize,
+		PayoutTable:     config.TOURNAMENT_PAYOUT_TABLE,
+		StartsAt:        time.Now(),
+		EndsAt:          tournamentReqBody.EndsAt,
+		CreatedBy:       admin.ID,
+	}
+
+	if tournamentReqBody.PayoutTable != nil {
+		tournament.PayoutTable = tournamentReqBody.PayoutTable
+	}
+	if tournamentReqBody.StartsAt != nil {
+		tournament.StartsAt = *tournamentReqBody.StartsAt
+	}
+
+	// Error List
+	errorList := []string{}
+
+	if tournament.Name == "" || len(tournament.Name) > 64 {
+		errorList = append(errorList, "name must be 1 to 64 characters")
+	}
+
+	if !models.IsValidGame(tournament.Game) {
+		errorList = append(errorList, fmt.Sprintf("game must be one of %v", models.Games))
+	}
+
+	if !models.IsValidTournamentScoring(tournament.Scoring) {
+		errorList = append(errorList, fmt.Sprintf("scoring must be one of %v", models.TournamentScorings))
+	}
+
+	if tournament.EntryFee < 0 {
+		errorList = append(errorList, "entryFee can't be negative")
+	}
+
+	if tournament.GuaranteedPrize < 0 {
+		errorList = append(errorList, "guaranteedPrize can't be negative")
+	}
+
+	var payoutTotal float32
+	for _, share := range tournament.PayoutTable {
+		if share <= 0 {
+			errorList = append(errorList, "payoutTable shares must be greater than 0")
+			break
+		}
+		payoutTotal += share
+	}
+	if len(tournament.PayoutTable) == 0 || payoutTotal > 100 {
+		errorList = append(errorList, "payoutTable must have at least one share and add up to 100 at most")
+	}
+
+	if !tournament.EndsAt.After(tournament.StartsAt) {
+		errorList = append(errorList, "endsAt must be after startsAt")
+	}
+
+	if len(errorList) > 0 {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"message":    "Invalid tournament, check error list",
+			"errorsList": errorList,
+		})
+		return
+	}
+
+	tournamentID, err := models.CreateTournament(tournament)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	audit.Record(r, admin.ID, audit.ActionTournamentCreate, 0, map[string]interface{}{
+		"tournamentId":    tournamentID,
+		"name":            tournament.Name,
+		"game":            tournament.Game,
+		"entryFee":        tournament.EntryFee,
+		"guaranteedPrize": tournament.GuaranteedPrize,
+	})
+
+	// Starts right away if startsAt already passed
+	go func() {
+		if err := runTournaments(time.Now()); err != nil {
+			log.Println("Error running tournaments:", err)
+		}
+	}()
+
+	createdTournament, _ := models.GetTournamentByID(tournamentID)
+	helpers.WriteJSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"message":    "Tournament created",
+		"tournament": createdTournament,
+	})
+}
+
+func HandleTournamentsWS(w http.ResponseWriter, r *http.Request) {
+	conn, upgradeConErr := helpers.WSUpgrader.Upgrade(w, r, nil)
+	if upgradeConErr != nil {
+		return
+	}
+
+	// Public socket, no player behind it
+	session := helpers.Sessions.Register(0, helpers.SessionTournaments, conn)
+	defer helpers.Sessions.Unregister(session)
+
+	tournaments, err := models.GetTournaments([]string{models.TournamentRunning}, 100, 0)
+	if err != nil {
+		session.WriteJSON(map[string]interface{}{"code": 500, "message": err.Error()})
+		conn.Close()
+		return
+	}
+
+	snapshot := []map[string]interface{}{}
+	for _, tournament := range tournaments {
+		standings, err := models.GetTournamentStandings(tournament.ID, tournamentStandingsSize)
+		if err != nil {
+			session.WriteJSON(map[string]interface{}{"code": 500, "message": err.Error()})
+			conn.Close()
+			return
+		}
+		snapshot = append(snapshot, map[string]interface{}{"tournament": tournament, "standings": standings})
+	}
+
+	session.WriteJSON(map[string]interface{}{
+		"type":        "tournaments",
+		"code":        200,
+		"message":     "Tournaments retrieved with success!",
+		"tournaments": snapshot,
+	})
+
+	// Keep the connection alive until the timeout or the client leaves (incoming messages are ignored)
+	conn.SetReadDeadline(time.Now().Add(time.Duration(config.SOCKET_TIMEOUT_DURATION) * time.Second))
+	for {
+		_, receivedMsg, readMessageErr := conn.ReadMessage()
+		if readMessageErr != nil {
+			break
+		}
+
+		// Ignored, but flooding the socket closes it
+		middleware.AllowWSMessage(session, receivedMsg, "message")
+	}
+
+	conn.Close()
+}
+
+func findTournamentFromPath(w http.ResponseWriter, r *http.Request) (*models.Tournament, bool) {
+	tournamentID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Invalid tournament id"})
+		return nil, false
+	}
+
+	tournament, err := models.GetTournamentByID(tournamentID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusNotFound, map[string]interface{}{"message": err.Error()})
+		return nil, false
+	}
+
+	return tournament, true
+}
+
+// updateTournamentScores adds a settled bet to the player's running tournaments and pushes their standings
+func updateTournamentScores(bet models.Bet, game string) {
+	netResult := bet.Winnings
+	if bet.PlayerWin {
+		netResult -= bet.BetAmount
+	}
+
+	// Scores are compared in the base currency
+	stake := models.ToBaseCurrency(bet.BetAmount, bet.Currency)
+	tournamentIDs, err := models.AddTournamentScore(bet.PlayerID, game, stake, models.ToBaseCurrency(netResult, bet.Currency), bet.CreatedAt)
+	if err != nil {
+		log.Println("Error updating tournament scores:", err)
+		return
+	}
+
+	for _, tournamentID := range tournamentIDs {
+		pushTournamentStandings(tournamentID)
+	}
+}
+
+// runTournaments starts, ends and settles the tournaments that are due
+func runTournaments(now time.Time) error {
+	tournamentRunMu.Lock()
+	defer tournamentRunMu.Unlock()
+
+	tournaments, err := models.GetTournamentsDue(now)
+	if err != nil {
+		return err
+	}
+
+	for i := range tournaments {
+		tournament := &tournaments[i]
+
+		if tournament.Status == models.TournamentScheduled {
+			if !advanceTournament(tournament, models.TournamentRunning) {
+				continue
+			}
+		}
+
+		if tournament.Status == models.TournamentRunning && !now.Before(tournament.EndsAt) {
+			if !advanceTournament(tournament, models.TournamentSettling) {
+				continue
+			}
+		}
+
+		if tournament.Status == models.TournamentSettling {
+			if err := settleTournament(tournament); err != nil {
+				log.Printf("Tournament %d not settled (retried on the next run): %v\n", tournament.ID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// advanceTournament moves the tournament to the next status and pushes the change
+func advanceTournament(tournament *models.Tournament, status string) bool {
+	advanced, err := models.SetTournamentStatus(tournament.ID, tournament.Status, status)
+	if err != nil {
+		log.Println("Error updating tournament status:", err)
+		return false
+	}
+	if !advanced {
+		return false
+	}
+
+	tournament.Status = status
+	pushTournamentStatus(tournament)
+
+	return true
+}
+
+// settleTournament ranks the entries, pays every prize not paid yet and finishes the tournament once they all are
+func settleTournament(tournament *models.Tournament) error {
+	if err := models.RankTournament(tournament); err != nil {
+		return err
+	}
+
+	entries, err := models.GetUnpaidTournamentPrizes(tournament.ID)
+	if err != nil {
+		return err
+	}
+
+	for i := range entries {
+		if err := payTournamentPrize(tournament, &entries[i]); err != nil {
+			return err
+		}
+	}
+
+	if advanceTournament(tournament, models.TournamentFinished) {
+		pushTournamentStandings(tournament.ID)
+	}
+
+	return nil
+}
+
+// payTournamentPrize credits an entry's prize to the player's wallet
+// Uses the betting status as a processing lock like the other balance updates
+func payTournamentPrize(tournament *models.Tournament, entry *models.TournamentEntry) error {
+	locked, err := models.TrySetPlayerBetting(entry.PlayerID)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return fmt.Errorf("player %d is in Betting Process", entry.PlayerID)
+	}
+	defer models.UpdatePlayerBettingStatus(entry.PlayerID, false)
+
+	paid, prize, currency, err := models.PayTournamentPrize(entry)
+	if err != nil {
+		return err
+	}
+	if !paid {
+		return nil // Already paid
+	}
+
+	message := map[string]interface{}{
+		"type":         "tournamentPrize",
+		"code":         200,
+		"message":      fmt.Sprintf("You've finished #%d in %s and won %.2f %s", *entry.Rank, tournament.Name, prize, currency),
+		"tournamentId": tournament.ID,
+		"rank":         *entry.Rank,
+		"prize":        prize,
+		"currency":     currency,
+	}
+	for _, wsSession := range helpers.Sessions.PlayerSessions(entry.PlayerID, helpers.SessionWallet) {
+		wsSession.WriteJSON(message)
+	}
+
+	return nil
+}
+
+func pushTournamentStandings(tournamentID int) {
+	tournament, err := models.GetTournamentByID(tournamentID)
+	if err != nil {
+		log.Println("Error fetching tournament:", err)
+		return
+	}
+
+	standings, err := models.GetTournamentStandings(tournamentID, tournamentStandingsSize)
+	if err != nil {
+		log.Println("Error fetching standings:", err)
+		return
+	}
+
+	message := map[string]interface{}{
+		"type":       "tournamentStandings",
+		"code":       200,
+		"tournament": tournament,
+		"standings":  standings,
+	}
+
+	for _, wsSession := range helpers.Sessions.PlayerSessions(0, helpers.SessionTournaments) {
+		wsSession.WriteJSON(message)
+	}
+}
+
+func pushTournamentStatus(tournament *models.Tournament) {
+	message := map[string]interface{}{
+		"type":         "tournamentStatus",
+		"code":         200,
+		"tournamentId": tournament.ID,
+		"status":       tournament.Status,
+	}
+
+	for _, wsSession := range helpers.Sessions.PlayerSessions(0, helpers.SessionTournaments) {
+		wsSession.WriteJSON(message)
+	}
+}
@@ -0,0 +1,235 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"main/config"
+	"main/helpers"
+	"main/middleware"
+	"main/models"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+Player-to-player transfers and tips
+
+GET  /player/me/transfers          -> The transfers the player sent or received (?limit=&offset=) with today's allowance
+POST /player/me/transfers          -> Sends wallet funds to another player {"recipient": "name", "amount": 10, "message": "gg"}
+GET  /player/me/transfers/settings -> Whether the player accepts incoming transfers
+PUT  /player/me/transfers/settings -> {"acceptTransfers": false} turns incoming transfers off
+
+! Both wallets and both transaction histories (transfer_out / transfer_in) are updated in a single database transaction,
+! with both players' processing locks held (taken by ascending player ID, so two opposite transfers can't deadlock)
+! Both players' wallet sockets get the new balances, the recipient's also get a transferReceived message
+! A player can send up to TRANSFER_DAILY_LIMIT a day (TRANSFER_LIMIT_EXCEEDED), frozen / self-excluded accounts can't
+! send or receive transfers
+? Transfers are sent in the sender's currency and land in the recipient's wallet of that currency, the daily limit is
+? in the base currency
+*/
+
+// Longest message sent along a transfer
+const transferMessageMaxLength = 140
+
+// How long a transfer waits for each player's balance to be free
+const transferLockTimeout = 5 * time.Second
+
+type TransferReqBody struct {
+	Recipient string  `json:"recipient"`
+	Amount    float32 `json:"amount"`
+	Message   string  `json:"message"`
+}
+
+type TransferSettingsReqBody struct {
+	AcceptTransfers *bool `json:"acceptTransfers"`
+}
+
+func HandlePlayerTransfers(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	switch r.Method {
+	case http.MethodGet:
+		limit, offset := helpers.ParsePagination(r)
+
+		transfers, err := models.GetTransfersByPlayerID(player.ID, limit, offset)
+		if err != nil {
+			helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+			return
+		}
+
+		sentToday, err := models.GetTransferredToday(player.ID)
+		if err != nil {
+			helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+			return
+		}
+
+		response := map[string]interface{}{
+			"transfers":  transfers,
+			"sentToday":  sentToday,
+			"dailyLimit": nil,
+			"limit":      limit,
+			"offset":     offset,
+		}
+		if config.TRANSFER_DAILY_LIMIT > 0 {
+			response["dailyLimit"] = config.TRANSFER_DAILY_LIMIT
+		}
+
+		helpers.WriteJSONResponse(w, http.StatusOK, response)
+	case http.MethodPost:
+		handleSendTransfer(w, r, player)
+	default:
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleSendTransfer(w http.ResponseWriter, r *http.Request, sender *models.Player) {
+	var transferReqBody TransferReqBody
+	if err := json.NewDecoder(r.Body).Decode(&transferReqBody); err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Request is missing data (recipient, amount)"})
+		return
+	}
+	defer r.Body.Close()
+
+	// Error List
+	errorList := []string{}
+
+	transferReqBody.Recipient = strings.TrimSpace(transferReqBody.Recipient)
+	if transferReqBody.Recipient == "" {
+		errorList = append(errorList, "recipient is required")
+	}
+	if transferReqBody.Amount <= 0 {
+		errorList = append(errorList, "amount must be greater than 0")
+	}
+	if len(transferReqBody.Message) > transferMessageMaxLength {
+		errorList = append(errorList, fmt.Sprintf("message must be at most %d characters", transferMessageMaxLength))
+	}
+
+	if len(errorList) > 0 {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"message":    "Invalid transfer, check error list",
+			"errorsList": errorList,
+		})
+		return
+	}
+
+	// Frozen / self-excluded accounts can't move money to other players
+	if statusErr := sender.CanPerform(models.ActionTransfer); statusErr != nil {
+		statusCode, response := middleware.AuthErrorResponse(statusErr)
+		helpers.WriteJSONResponse(w, statusCode, response)
+		return
+	}
+
+	recipient, err := models.GetPlayerByName(transferReqBody.Recipient)
+	if err != nil || recipient.Role != models.RolePlayer {
+		helpers.WriteJSONResponse(w, http.StatusNotFound, map[string]interface{}{"message": fmt.Sprintf("Player '%s' not found", transferReqBody.Recipient)})
+		return
+	}
+
+	if recipient.ID == sender.ID {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "You can't send a transfer to yourself"})
+		return
+	}
+
+	if !recipient.AcceptTransfers {
+		helpers.WriteJSONResponse(w, http.StatusForbidden, map[string]interface{}{
+			"message":   fmt.Sprintf("%s doesn't accept transfers", recipient.Name),
+			"errorCode": "TRANSFERS_DISABLED",
+		})
+		return
+	}
+
+	if recipient.CanPerform(models.ActionTransfer) != nil {
+		helpers.WriteJSONResponse(w, http.StatusForbidden, map[string]interface{}{
+			"message":   fmt.Sprintf("%s can't receive transfers", recipient.Name),
+			"errorCode": "RECIPIENT_UNAVAILABLE",
+		})
+		return
+	}
+
+	unlock, err := lockPlayers(sender.ID, recipient.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusConflict, map[string]interface{}{"message": "Cannot transfer while a player is in Betting Process, try again"})
+		return
+	}
+	defer unlock()
+
+	transferResult, err := models.CreateTransfer(sender.ID, recipient.ID, transferReqBody.Amount, transferReqBody.Message, config.TRANSFER_DAILY_LIMIT)
+	if err != nil {
+		response := map[string]interface{}{"message": err.Error()}
+
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, models.ErrTransferInsufficientFunds) {
+			statusCode = http.StatusBadRequest
+		} else if addLimitErrorFields(response, err) {
+			statusCode = http.StatusForbidden
+		}
+
+		helpers.WriteJSONResponse(w, statusCode, response)
+		return
+	}
+
+	transfer := transferResult.Transfer
+	transfer.SenderName, transfer.RecipientName = sender.Name, recipient.Name
+
+	message := map[string]interface{}{
+		"type":     "transferReceived",
+		"code":     200,
+		"message":  fmt.Sprintf("%s sent you %.2f %s", sender.Name, transfer.Amount, transfer.Currency),
+		"transfer": transfer,
+	}
+	for _, wsSession := range helpers.Sessions.PlayerSessions(recipient.ID, helpers.SessionWallet) {
+		wsSession.WriteJSON(message)
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"message":    fmt.Sprintf("Sent %.2f %s to %s", transfer.Amount, transfer.Currency, recipient.Name),
+		"transfer":   transfer,
+		"newBalance": transferResult.SenderWallet,
+	})
+}
+
+func HandlePlayerTransferSettings(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	switch r.Method {
+	case http.MethodGet:
+		helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{"acceptTransfers": player.AcceptTransfers})
+	case http.MethodPut:
+		var settingsReqBody TransferSettingsReqBody
+		err := json.NewDecoder(r.Body).Decode(&settingsReqBody)
+		if err != nil || settingsReqBody.AcceptTransfers == nil {
+			helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Request is missing data (acceptTransfers)"})
+			return
+		}
+		defer r.Body.Close()
+
+		if err := models.UpdatePlayerAcceptTransfers(player.ID, *settingsReqBody.AcceptTransfers); err != nil {
+			helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+			return
+		}
+
+		helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"message":         "Transfer settings updated",
+			"acceptTransfers": *settingsReqBody.AcceptTransfers,
+		})
+	default:
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+	}
+}
+
+// lockPlayers takes the processing locks of the players by ascending ID (so concurrent callers can't deadlock)
+// and returns the function releasing them
+func lockPlayers(firstID int, secondID int) (func(), error) {
+	lowID, highID := min(firstID, secondID), max(firstID, secondID)
+
+	if err := waitForPlayerBetting(lowID, transferLockTimeout); err != nil {
+		return nil, err
+	}
+	if err := waitForPlayerBetting(highID, transferLockTimeout); err != nil {
+		models.UpdatePlayerBettingStatus(lowID, false)
+		return nil, err
+	}
+
+	return func() {
+		models.UpdatePlayerBettingStatus(highID, false)
+		models.UpdatePlayerBettingStatus(lowID, false)
+	}, nil
+}
@@ -2,44 +2,59 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"main/config"
 	"main/events"
 	"main/helpers"
 	"main/middleware"
 	"main/models"
+	"main/payments"
+	"main/risk"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 func HandleWalletWS(w http.ResponseWriter, r *http.Request) {
+	// Authenticate User, before upgrading so failures get a real 401 / 403
+	player, authenticated := middleware.AuthenticateWS(w, r)
+	if !authenticated {
+		return
+	}
+
 	// Upgrade to WebSockets
 	conn, upgradeConErr := helpers.WSUpgrader.Upgrade(w, r, nil)
 	if upgradeConErr != nil {
 		return
 	}
 
-	// Authenticate User
-	player, authError := middleware.AuthenticateUser(r)
-	if authError != nil {
-		response := map[string]interface{}{"code": 401, "message": authError.Error()}
-		stringifiedResponse, _ := helpers.JsonStringifier(response)
+	// Keep track of the connection so it can be closed from elsewhere (e.g. account status changes)
+	session := helpers.Sessions.Register(player.ID, helpers.SessionWallet, conn)
+	defer helpers.Sessions.Unregister(session)
 
-		conn.WriteMessage(websocket.TextMessage, []byte(stringifiedResponse))
-		// Conn.WriteControl Should send a close code and a message but it's not working properly.
-		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "No auth token provided"), time.Now())
-		conn.Close()
-		return
-	}
+	// Gaming session for the reality checks
+	startRealityChecks(player.ID)
+	defer stopRealityChecks(player.ID)
+
+	// Withdrawals not paid yet are no longer in the wallet, they're shown apart
+	pendingWithdrawals, _ := models.GetPendingWithdrawals(player.ID)
+	// Balances in every currency, the ones above are in the currency the player plays in
+	wallets, _ := models.GetPlayerWallets(player.ID)
 
 	// Prepare and send a welcome message
 	response := map[string]interface{}{
-		"message":    "Wallet and bet balance retrieved with success!",
-		"status":     "success",
-		"wallet":     player.Wallet,
-		"betBalance": player.BetBalance,
+		"message":            "Wallet and bet balance retrieved with success!",
+		"status":             "success",
+		"wallet":             player.Wallet,
+		"betBalance":         player.BetBalance,
+		"bonusBalance":       player.BonusBalance,
+		"pendingWithdrawals": pendingWithdrawals,
+		"currency":           player.Currency,
+		"wallets":            wallets,
 	}
 
 	// Error List
@@ -55,7 +70,7 @@ func HandleWalletWS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if writeMessageErr := conn.WriteMessage(websocket.TextMessage, []byte(stringifiedResponse)); writeMessageErr != nil {
+	if writeMessageErr := session.WriteMessage(websocket.TextMessage, []byte(stringifiedResponse)); writeMessageErr != nil {
 
 		return
 	}
@@ -68,17 +83,31 @@ func HandleWalletWS(w http.ResponseWriter, r *http.Request) {
 		response["message"] = "Wallet / BetBalance Updated"
 		response["wallet"] = balanceData.Wallet
 		response["betBalance"] = balanceData.BetBalance
+		response["bonusBalance"] = balanceData.BonusBalance
+		response["pendingWithdrawals"] = balanceData.PendingWithdrawals
+		response["currency"] = balanceData.Currency
+		response["wallets"] = balanceData.Wallets
 
 		// Convert Data to JSON and Send to Client
 		stringifiedResponse, _ := helpers.JsonStringifier(response)
 
-		if writeMessageErr := conn.WriteMessage(websocket.TextMessage, []byte(stringifiedResponse)); writeMessageErr != nil {
+		if writeMessageErr := session.WriteMessage(websocket.TextMessage, []byte(stringifiedResponse)); writeMessageErr != nil {
 
 		}
 	})
 
-	// To keep connection alive (you can set a timeout here if necessary)
-	time.Sleep(time.Duration(config.SOCKET_TIMEOUT_DURATION) * time.Second)
+	// Keep the connection alive until the timeout, the client leaves or the session is closed
+	// (Reading is also what processes the client's close frames, incoming messages are ignored)
+	conn.SetReadDeadline(time.Now().Add(time.Duration(config.SOCKET_TIMEOUT_DURATION) * time.Second))
+	for {
+		_, receivedMsg, readMessageErr := conn.ReadMessage()
+		if readMessageErr != nil {
+			break
+		}
+
+		// Ignored, but flooding the socket closes it
+		middleware.AllowWSMessage(session, receivedMsg, "message")
+	}
 
 	// Unsubscribe to prevent memory issues
 	events.GlobalEmitter.Off(balanceUpdateEvent, handlerId)
@@ -99,12 +128,20 @@ func HandleDeposit(w http.ResponseWriter, r *http.Request) {
 
 	player, err := middleware.AuthenticateUser(r)
 	if err != nil {
-		response := map[string]interface{}{
-			"message": err.Error(),
-		}
+		statusCode, response := middleware.AuthErrorResponse(err)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// Frozen / self-excluded accounts can't deposit
+	if statusErr := player.CanPerform(models.ActionDeposit); statusErr != nil {
+		statusCode, response := middleware.AuthErrorResponse(statusErr)
 
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
+		w.WriteHeader(statusCode)
 		json.NewEncoder(w).Encode(response)
 		return
 	}
@@ -146,54 +183,50 @@ func HandleDeposit(w http.ResponseWriter, r *http.Request) {
 
 	}
 
-	isBetting, checkPlayerBettingStatusErr := models.CheckPlayerBettingStatus(player.ID)
-	if checkPlayerBettingStatusErr != nil {
+	// Responsible gaming deposit limits
+	if limitErr := models.CheckLimits(player.ID, models.LimitDeposit, models.ToBaseCurrency(depositReqBody.AmountToDeposit, player.Currency)); limitErr != nil {
 		response := map[string]interface{}{
-			"message": err.Error(),
+			"message": limitErr.Error(),
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
-		return
-	}
 
-	if isBetting {
-		response := map[string]interface{}{
-			"message": "Cannot deposit while player is in Betting Process",
+		statusCode := http.StatusInternalServerError
+		if addLimitErrorFields(response, limitErr) {
+			statusCode = http.StatusForbidden
 		}
+
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusConflict)
+		w.WriteHeader(statusCode)
 		json.NewEncoder(w).Encode(response)
 		return
 	}
 
-	updateBettingStatusError := models.UpdatePlayerBettingStatus(player.ID, true)
-	if updateBettingStatusError != nil {
+	// Risk checks (see riskController.go)
+	riskContext := risk.Context{Event: risk.EventDeposit, PlayerID: player.ID, IP: helpers.ClientIP(r), Amount: models.ToBaseCurrency(depositReqBody.AmountToDeposit, player.Currency)}
+	if riskErr := risk.Check(riskContext); riskErr != nil {
 		response := map[string]interface{}{
-			"message": err.Error(),
+			"message": riskErr.Error(),
 		}
+		addRiskErrorFields(response, riskErr)
+
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusForbidden)
 		json.NewEncoder(w).Encode(response)
 		return
 	}
 
-	// Start timer to test race conditions easier
-	start := time.Now()
-	isProcessed := false
-
-	player, err = models.GetPlayerByID(player.ID)
+	provider, err := payments.Get(config.PAYMENT_PROVIDER)
 	if err != nil {
 		response := map[string]interface{}{
-			"message": err.Error(),
+			"message": "Deposits are unavailable right now",
 		}
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusServiceUnavailable)
 		json.NewEncoder(w).Encode(response)
 		return
 	}
 
-	err = models.UpdatePlayerBalance(player.ID, player.Wallet+depositReqBody.AmountToDeposit, player.BetBalance)
+	// Nothing is credited here, the wallet is only credited once the provider confirms the payment (see paymentController.go)
+	payment, err := models.CreatePayment(player.ID, models.PaymentDeposit, depositReqBody.AmountToDeposit, player.Currency, provider.Name())
 	if err != nil {
 		response := map[string]interface{}{
 			"message": err.Error(),
@@ -204,35 +237,34 @@ func HandleDeposit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updateBettingStatusError = models.UpdatePlayerBettingStatus(player.ID, false)
-	if updateBettingStatusError != nil {
+	intent, err := provider.CreateDepositIntent(strconv.Itoa(payment.ID), payment.Amount, payment.Currency)
+	if err == nil {
+		err = models.SetPaymentReference(payment.ID, intent.Reference)
+	}
+	if err != nil {
+		log.Println("Error creating deposit intent:", err)
+		models.SetPaymentStatus(payment.ID, models.PaymentFailed)
+
 		response := map[string]interface{}{
-			"message": err.Error(),
+			"message": "Payment provider is unavailable, try again later",
 		}
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusBadGateway)
 		json.NewEncoder(w).Encode(response)
 		return
 	}
 
-	isProcessed = true
-	for {
-		// Loop until 2 seconds have passed
-		if time.Since(start).Seconds() >= float64(config.PROCESSING_DURATION) && isProcessed { // Bet processing minimum Time
-			break
-		}
-
-	}
-
-	// Handle deposit logic (e.g., update balance, save to DB, etc.)
-	// For now, just send a success response
 	response := map[string]interface{}{
-		"message":    "Deposit successful",
-		"amount":     depositReqBody.AmountToDeposit,
-		"newBalance": player.Wallet + depositReqBody.AmountToDeposit,
+		"message":     "Deposit pending, complete it at the checkout",
+		"paymentId":   payment.ID,
+		"amount":      payment.Amount,
+		"currency":    payment.Currency,
+		"status":      payment.Status,
+		"checkoutUrl": intent.CheckoutURL,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(response)
 }
 
@@ -249,12 +281,20 @@ func HandleWithdraw(w http.ResponseWriter, r *http.Request) {
 	// Authenticate user
 	player, err := middleware.AuthenticateUser(r)
 	if err != nil {
-		response := map[string]interface{}{
-			"message": err.Error(),
-		}
+		statusCode, response := middleware.AuthErrorResponse(err)
 
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// Frozen / self-excluded accounts can still withdraw
+	if statusErr := player.CanPerform(models.ActionWithdraw); statusErr != nil {
+		statusCode, response := middleware.AuthErrorResponse(statusErr)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
 		json.NewEncoder(w).Encode(response)
 		return
 	}
@@ -284,46 +324,73 @@ func HandleWithdraw(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if player is already in the betting process
-	isBetting, checkPlayerBettingStatusErr := models.CheckPlayerBettingStatus(player.ID)
-	if checkPlayerBettingStatusErr != nil {
+	// Above KYC_WITHDRAWAL_THRESHOLD withdrawn in total (base currency) the player's identity must be verified (see kycController.go)
+	amountInBase := models.ToBaseCurrency(withdrawReqBody.AmountToWithdraw, player.Currency)
+	if kycErr := models.CheckKYCForWithdrawal(player.ID, player.KYCStatus, amountInBase, config.KYC_WITHDRAWAL_THRESHOLD); kycErr != nil {
 		response := map[string]interface{}{
-			"message": checkPlayerBettingStatusErr.Error(),
+			"message": kycErr.Error(),
+		}
+
+		statusCode := http.StatusInternalServerError
+		var kycRequiredErr *models.KYCRequiredError
+		if errors.As(kycErr, &kycRequiredErr) {
+			statusCode = http.StatusForbidden
+			response["errorCode"] = models.KYCRequired
+			response["kycStatus"] = kycRequiredErr.KYCStatus
+			response["threshold"] = kycRequiredErr.Threshold
+			response["withdrawnTotal"] = kycRequiredErr.Withdrawn
 		}
+
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(statusCode)
 		json.NewEncoder(w).Encode(response)
 		return
 	}
 
-	if isBetting {
+	// Risk checks (see riskController.go), flagged withdrawals wait for an admin
+	riskDecision := risk.Evaluate(risk.Context{Event: risk.EventWithdraw, PlayerID: player.ID, IP: helpers.ClientIP(r), Amount: amountInBase})
+	if riskErr := riskDecision.Err(); riskErr != nil {
 		response := map[string]interface{}{
-			"message": "Cannot withdraw while player is in Betting Process",
+			"message": riskErr.Error(),
 		}
+		addRiskErrorFields(response, riskErr)
+
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusConflict)
+		w.WriteHeader(http.StatusForbidden)
 		json.NewEncoder(w).Encode(response)
 		return
 	}
 
-	// Update player's betting status to true to prevent racing updates during the withdraw process
-	updateBettingStatusError := models.UpdatePlayerBettingStatus(player.ID, true) // Lock in processing
-	if updateBettingStatusError != nil {
+	// Lock in processing to prevent racing updates during the withdraw process (checked and set in one query)
+	locked, lockErr := models.TrySetPlayerBetting(player.ID)
+	if lockErr != nil {
 		response := map[string]interface{}{
-			"message": updateBettingStatusError.Error(),
+			"message": lockErr.Error(),
 		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(response)
 		return
 	}
+
+	if !locked {
+		response := map[string]interface{}{
+			"message": "Cannot withdraw while player is in Betting Process",
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
 	// Start Timer to test race conditions easier
 	start := time.Now()
 	isProcessed := false
 
 	// Fetch latest player details (to get their current wallet balance)
-	player, err = models.GetPlayerByID(player.ID)
+	latestPlayer, err := models.GetPlayerByID(player.ID)
 	if err != nil {
+		models.UpdatePlayerBettingStatus(player.ID, false)
+
 		response := map[string]interface{}{
 			"message": err.Error(),
 		}
@@ -332,6 +399,7 @@ func HandleWithdraw(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(response)
 		return
 	}
+	player = latestPlayer
 
 	// Check if the player has sufficient balance for the withdrawal
 	if player.Wallet < withdrawReqBody.AmountToWithdraw {
@@ -340,7 +408,7 @@ func HandleWithdraw(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Unlock the processing
-		updateBettingStatusError = models.UpdatePlayerBettingStatus(player.ID, false)
+		models.UpdatePlayerBettingStatus(player.ID, false)
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
@@ -348,20 +416,52 @@ func HandleWithdraw(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update player's balance after withdrawal
-	err = models.UpdatePlayerBalance(player.ID, player.Wallet-withdrawReqBody.AmountToWithdraw, player.BetBalance)
+	// Daily withdrawal limit of the player's VIP tier
+	tier, _, _, err := models.RefreshVIPTier(player.ID, config.LOYALTY_TIER_WINDOW_DAYS)
+	if err == nil {
+		err = models.CheckVIPWithdrawalLimit(player.ID, tier, amountInBase)
+	}
 	if err != nil {
+		models.UpdatePlayerBettingStatus(player.ID, false)
+
 		response := map[string]interface{}{
 			"message": err.Error(),
 		}
+
+		statusCode := http.StatusInternalServerError
+		if addLimitErrorFields(response, err) {
+			statusCode = http.StatusForbidden
+		}
+
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// The amount is reserved (taken from the wallet) until the withdrawal is paid, cancelled or rejected
+	// Withdrawing before the wagering requirements are met forfeits the bonuses (and their winnings), only if the reservation went through
+	withdrawal, newBalance, forfeitedBonus, err := models.RequestWithdrawal(player.ID, withdrawReqBody.AmountToWithdraw)
+	if err != nil {
+		models.UpdatePlayerBettingStatus(player.ID, false)
+
+		response := map[string]interface{}{
+			"message": err.Error(),
+		}
+
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, models.ErrWithdrawalInsufficientFunds) {
+			statusCode = http.StatusBadRequest
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
 		json.NewEncoder(w).Encode(response)
 		return
 	}
 
 	// Update betting status back to false
-	updateBettingStatusError = models.UpdatePlayerBettingStatus(player.ID, false)
+	updateBettingStatusError := models.UpdatePlayerBettingStatus(player.ID, false)
 	if updateBettingStatusError != nil {
 		response := map[string]interface{}{
 			"message": updateBettingStatusError.Error(),
@@ -381,13 +481,39 @@ func HandleWithdraw(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Small withdrawals don't wait for an admin (see withdrawalController.go)
+	if models.ToBaseCurrency(withdrawal.Amount, withdrawal.Currency) <= config.WITHDRAWAL_AUTO_APPROVE_THRESHOLD && riskDecision.Action == risk.ActionAllow {
+		if _, err := approveWithdrawal(withdrawal, nil, "Approved automatically (below threshold)"); err != nil {
+			log.Println("Error approving withdrawal:", err)
+			// It may have stopped anywhere, the message follows where it is
+			if current, err := models.GetWithdrawalByID(withdrawal.ID); err == nil {
+				*withdrawal = *current
+			}
+		}
+	}
+
+	var message string
+	switch withdrawal.Status {
+	case models.WithdrawalRequested:
+		message = "Withdrawal requested, waiting for approval"
+	case models.WithdrawalApproved:
+		message = "Withdrawal approved, payout will be sent later"
+	case models.WithdrawalProcessing, models.WithdrawalPaid:
+		message = "Withdrawal approved, payout sent"
+	default:
+		message = "Withdrawal " + withdrawal.Status
+	}
+
 	// Send success response with updated balance
 	response := map[string]interface{}{
-		"message":    "Withdrawal successful",
-		"amount":     withdrawReqBody.AmountToWithdraw,
-		"newBalance": player.Wallet - withdrawReqBody.AmountToWithdraw,
+		"message":        message,
+		"amount":         withdrawal.Amount,
+		"withdrawal":     withdrawal,
+		"newBalance":     newBalance,
+		"forfeitedBonus": models.FromBaseCurrency(forfeitedBonus, withdrawal.Currency),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(response)
 }
@@ -0,0 +1,344 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"main/audit"
+	"main/config"
+	"main/helpers"
+	"main/models"
+	"main/payments"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/*
+Withdrawal requests: requested -> approved -> processing -> paid, or rejected / cancelled while still pending
+
+POST /player/me/wallet/withdraw           -> Requests a withdrawal {"amountToWithdraw": 50}, the amount is reserved (taken from the wallet)
+GET  /player/me/withdrawals               -> The player's withdrawals (?limit=&offset=)
+POST /player/me/withdrawals/{id}/cancel   -> Cancels a requested / approved withdrawal, the amount goes back to the wallet
+GET  /admin/withdrawals?status=&playerId= -> Approval queue, oldest first (support can read)
+POST /admin/withdrawals/{id}/approve      -> Approves a requested withdrawal and sends its payout {"note": string}
+POST /admin/withdrawals/{id}/reject       -> Rejects a requested / approved withdrawal, the amount goes back to the wallet {"note": string}
+
+! Withdrawals up to WITHDRAWAL_AUTO_APPROVE_THRESHOLD (base currency) are approved right away, above it an admin must approve them
+! Once approved the payout goes to the payment provider (processing), the withdrawal is paid when the provider confirms it
+! and rejected (amount given back) if the payout fails. A payout that couldn't be sent leaves the withdrawal approved,
+! approving it again retries
+! The wallet socket shows the available balance (wallet) and the reserved one (pendingWithdrawals),
+! every status change also pushes {"type": "withdrawalUpdate"}
+*/
+
+// How long giving a withdrawal back waits for the player's balance to be free
+const withdrawalLockTimeout = 5 * time.Second
+
+type WithdrawalReviewReqBody struct {
+	Note string `json:"note"`
+}
+
+func HandlePlayerWithdrawals(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, offset := helpers.ParsePagination(r)
+
+	withdrawals, err := models.GetWithdrawalsByPlayerID(player.ID, limit, offset)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	pendingWithdrawals, err := models.GetPendingWithdrawals(player.ID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"withdrawals":        withdrawals,
+		"pendingWithdrawals": pendingWithdrawals,
+		"limit":              limit,
+		"offset":             offset,
+	})
+}
+
+func HandleCancelWithdrawal(w http.ResponseWriter, r *http.Request, player *models.Player) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	withdrawal, ok := findWithdrawalFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if withdrawal.PlayerID != player.ID {
+		helpers.WriteJSONResponse(w, http.StatusNotFound, map[string]interface{}{"message": "Withdrawal not found"})
+		return
+	}
+
+	statusCode, err := returnWithdrawal(withdrawal, models.WithdrawalCancelled, nil, "Cancelled by the player")
+	if err != nil {
+		response := map[string]interface{}{"message": err.Error()}
+		if statusCode == http.StatusConflict {
+			response["errorCode"] = "WITHDRAWAL_NOT_CANCELLABLE"
+		}
+		helpers.WriteJSONResponse(w, statusCode, response)
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message":    "Withdrawal cancelled, the amount is back in your wallet",
+		"withdrawal": withdrawal,
+	})
+}
+
+func HandleAdminWithdrawals(w http.ResponseWriter, r *http.Request, staff *models.Player) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, offset := helpers.ParsePagination(r)
+
+	// Optional filters
+	status := r.URL.Query().Get("status")
+	playerID, _ := strconv.Atoi(r.URL.Query().Get("playerId"))
+
+	withdrawals, err := models.GetWithdrawals(status, playerID, limit, offset)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"withdrawals": withdrawals,
+		"limit":       limit,
+		"offset":      offset,
+	})
+}
+
+func HandleAdminApproveWithdrawal(w http.ResponseWriter, r *http.Request, admin *models.Player) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	withdrawal, ok := findWithdrawalFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var reviewReqBody WithdrawalReviewReqBody
+	json.NewDecoder(r.Body).Decode(&reviewReqBody) // The note is optional
+
+	wasRequested := withdrawal.Status == models.WithdrawalRequested
+	statusCode, err := approveWithdrawal(withdrawal, &admin.ID, reviewReqBody.Note)
+
+	// Approved even if its payout couldn't be sent
+	if wasRequested && withdrawal.Status != models.WithdrawalRequested {
+		audit.Record(r, admin.ID, audit.ActionWithdrawalApprove, withdrawal.PlayerID, map[string]interface{}{
+			"withdrawalId": withdrawal.ID,
+			"amount":       withdrawal.Amount,
+		})
+	}
+
+	if err != nil {
+		helpers.WriteJSONResponse(w, statusCode, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message":    "Withdrawal approved, payout sent",
+		"withdrawal": withdrawal,
+	})
+}
+
+func HandleAdminRejectWithdrawal(w http.ResponseWriter, r *http.Request, admin *models.Player) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	withdrawal, ok := findWithdrawalFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var reviewReqBody WithdrawalReviewReqBody
+	json.NewDecoder(r.Body).Decode(&reviewReqBody) // The note is optional
+
+	statusCode, err := returnWithdrawal(withdrawal, models.WithdrawalRejected, &admin.ID, reviewReqBody.Note)
+	if err != nil {
+		helpers.WriteJSONResponse(w, statusCode, map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	audit.Record(r, admin.ID, audit.ActionWithdrawalReject, withdrawal.PlayerID, map[string]interface{}{
+		"withdrawalId": withdrawal.ID,
+		"amount":       withdrawal.Amount,
+	})
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message":    "Withdrawal rejected, the amount is back in the player's wallet",
+		"withdrawal": withdrawal,
+	})
+}
+
+// approveWithdrawal approves a requested withdrawal (reviewerID nil = automatically) and sends its payout
+// An approved withdrawal whose payout couldn't be sent is only sent again
+// Returns the HTTP status code to use on error
+func approveWithdrawal(withdrawal *models.Withdrawal, reviewerID *int, reviewNote string) (int, error) {
+	switch withdrawal.Status {
+	case models.WithdrawalRequested:
+		approved, err := models.ApproveWithdrawal(withdrawal.ID, reviewerID, reviewNote)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if !approved {
+			return http.StatusConflict, errors.New("withdrawal was already reviewed")
+		}
+		pushWithdrawalUpdate(withdrawal)
+	case models.WithdrawalApproved:
+	default:
+		return http.StatusConflict, errors.New("Withdrawal is already " + withdrawal.Status)
+	}
+
+	return sendPayout(withdrawal)
+}
+
+// sendPayout sends the payout of an approved withdrawal to the payment provider and moves the withdrawal to processing
+func sendPayout(withdrawal *models.Withdrawal) (int, error) {
+	provider, err := payments.Get(config.PAYMENT_PROVIDER)
+	if err != nil {
+		return http.StatusServiceUnavailable, fmt.Errorf("withdrawal approved but payouts are unavailable (%v), approve it again to retry", err)
+	}
+
+	payment, err := models.CreatePayment(withdrawal.PlayerID, models.PaymentPayout, withdrawal.Amount, withdrawal.Currency, provider.Name())
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	// Claimed before the payout is sent, so the player can't cancel it meanwhile
+	started, err := models.StartWithdrawalPayout(withdrawal.ID, payment.ID)
+	if err != nil || !started {
+		models.SetPaymentStatus(payment.ID, models.PaymentFailed)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return http.StatusConflict, errors.New("withdrawal isn't approved anymore")
+	}
+
+	intent, err := provider.InitiatePayout(strconv.Itoa(payment.ID), payment.Amount, payment.Currency)
+	if err == nil {
+		err = models.SetPaymentReference(payment.ID, intent.Reference)
+	}
+	if err != nil {
+		log.Println("Error sending payout:", err)
+		models.SetPaymentStatus(payment.ID, models.PaymentFailed)
+		models.ReleaseWithdrawalPayout(withdrawal.ID)
+		return http.StatusBadGateway, errors.New("withdrawal approved but the payment provider is unavailable, approve it again to retry")
+	}
+
+	pushWithdrawalUpdate(withdrawal)
+
+	return http.StatusOK, nil
+}
+
+// returnWithdrawal cancels / rejects a withdrawal that wasn't sent to the provider yet and gives the amount back
+// Uses the betting status as a processing lock like the other balance updates
+// Returns the HTTP status code to use on error
+func returnWithdrawal(withdrawal *models.Withdrawal, status string, reviewerID *int, reviewNote string) (int, error) {
+	if withdrawal.Status != models.WithdrawalRequested && withdrawal.Status != models.WithdrawalApproved {
+		return http.StatusConflict, errors.New("Withdrawal is already " + withdrawal.Status)
+	}
+
+	if err := waitForPlayerBetting(withdrawal.PlayerID, withdrawalLockTimeout); err != nil {
+		return http.StatusConflict, errors.New("player is in Betting Process, try again later")
+	}
+	defer models.UpdatePlayerBettingStatus(withdrawal.PlayerID, false)
+
+	returned, err := models.ReturnWithdrawal(withdrawal.ID, []string{models.WithdrawalRequested, models.WithdrawalApproved}, status, reviewerID, reviewNote)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !returned {
+		return http.StatusConflict, errors.New("withdrawal was already sent to the payment provider or closed")
+	}
+
+	pushWithdrawalUpdate(withdrawal)
+
+	return http.StatusOK, nil
+}
+
+// completePayout handles the provider's final status of a withdrawal's payout
+func completePayout(w http.ResponseWriter, payment *models.Payment, status string) {
+	succeeded := status == models.PaymentSucceeded
+
+	// A failed payout gives the amount back to the wallet
+	if !succeeded {
+		if err := waitForPlayerBetting(payment.PlayerID, paymentWebhookLockTimeout); err != nil {
+			helpers.WriteJSONResponse(w, http.StatusServiceUnavailable, map[string]interface{}{"message": "Player is busy, retry later"})
+			return
+		}
+		defer models.UpdatePlayerBettingStatus(payment.PlayerID, false)
+	}
+
+	completed, err := models.CompletePayout(payment.ID, succeeded)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+		return
+	}
+	if !completed {
+		helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{"message": "Nothing to do"})
+		return
+	}
+
+	if withdrawal, err := models.GetWithdrawalByPaymentID(payment.ID); err == nil {
+		pushWithdrawalUpdate(withdrawal)
+	}
+
+	helpers.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{"message": "Payment " + status, "status": status})
+}
+
+// pushWithdrawalUpdate reloads a withdrawal whose status changed and sends it to the player's wallet sockets
+func pushWithdrawalUpdate(withdrawal *models.Withdrawal) {
+	if current, err := models.GetWithdrawalByID(withdrawal.ID); err == nil {
+		*withdrawal = *current
+	}
+
+	message := map[string]interface{}{
+		"type":       "withdrawalUpdate",
+		"code":       200,
+		"message":    fmt.Sprintf("Your withdrawal of %.2f %s is %s", withdrawal.Amount, withdrawal.Currency, withdrawal.Status),
+		"withdrawal": withdrawal,
+	}
+	for _, wsSession := range helpers.Sessions.PlayerSessions(withdrawal.PlayerID, helpers.SessionWallet) {
+		wsSession.WriteJSON(message)
+	}
+}
+
+// findWithdrawalFromPath loads the withdrawal referenced by the {id} path value
+// Writes the error response itself and returns false if it can't
+func findWithdrawalFromPath(w http.ResponseWriter, r *http.Request) (*models.Withdrawal, bool) {
+	withdrawalID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusBadRequest, map[string]interface{}{"message": "Invalid withdrawal id"})
+		return nil, false
+	}
+
+	withdrawal, err := models.GetWithdrawalByID(withdrawalID)
+	if err != nil {
+		helpers.WriteJSONResponse(w, http.StatusNotFound, map[string]interface{}{"message": err.Error()})
+		return nil, false
+	}
+
+	return withdrawal, true
+}
@@ -8,8 +8,19 @@ import (
 // Define data struct for events
 
 type EventWalletData struct {
-	BetBalance float32
-	Wallet     float32
+	BetBalance         float32
+	Wallet             float32
+	BonusBalance       float32
+	PendingWithdrawals float32
+	Currency           string          // Currency of the balances above
+	Wallets            []WalletBalance // Balances in every currency, the active one first
+}
+
+type WalletBalance struct {
+	Currency           string  `json:"currency"`
+	Wallet             float32 `json:"wallet"`
+	BetBalance         float32 `json:"betBalance"`
+	PendingWithdrawals float32 `json:"pendingWithdrawals"`
 }
 
 // Define a type for event handlers
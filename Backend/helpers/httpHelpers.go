@@ -0,0 +1,68 @@
+package helpers
+
+import (
+	"encoding/json"
+	"main/config"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// WriteJSONResponse sends a JSON response with the given status code
+func WriteJSONResponse(w http.ResponseWriter, statusCode int, response map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// ParsePagination reads the limit and offset query params (defaults to 50 and 0, limit is capped at 500)
+func ParsePagination(r *http.Request) (int, int) {
+	limit := 50
+	offset := 0
+
+	if value, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && value > 0 {
+		limit = value
+	}
+	if limit > 500 {
+		limit = 500
+	}
+
+	if value, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && value >= 0 {
+		offset = value
+	}
+
+	return limit, offset
+}
+
+// ClientIP returns the IP address of the client that made the request
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// IsAllowedOrigin checks the Origin header of the request against ALLOWED_ORIGINS
+// Requests without one (not sent by a browser) and from the server's own origin are allowed
+func IsAllowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if slices.Contains(config.ALLOWED_ORIGINS, "*") || slices.Contains(config.ALLOWED_ORIGINS, strings.TrimRight(origin, "/")) {
+		return true
+	}
+
+	parsedOrigin, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(parsedOrigin.Host, r.Host)
+}
@@ -0,0 +1,78 @@
+package helpers
+
+import (
+	"main/config"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsAllowedOrigin(t *testing.T) {
+	tests := []struct {
+		name           string
+		allowedOrigins []string
+		origin         string
+		host           string
+		want           bool
+	}{
+		{"no origin", []string{}, "", "api.example.com", true},
+		{"listed origin", []string{"https://app.example.com"}, "https://app.example.com", "api.example.com", true},
+		{"listed origin with trailing slash", []string{"https://app.example.com"}, "https://app.example.com/", "api.example.com", true},
+		{"wildcard", []string{"*"}, "https://evil.example.net", "api.example.com", true},
+		{"same origin", []string{}, "http://api.example.com", "api.example.com", true},
+		{"same origin with port", []string{}, "http://localhost:8080", "localhost:8080", true},
+		{"same origin ignores case", []string{}, "http://API.example.com", "api.example.com", true},
+		{"unlisted origin", []string{"https://app.example.com"}, "https://evil.example.net", "api.example.com", false},
+		{"unlisted origin without list", []string{}, "https://evil.example.net", "api.example.com", false},
+		{"other scheme of listed origin", []string{"https://app.example.com"}, "http://app.example.com", "api.example.com", false},
+		{"other port of the server", []string{}, "http://localhost:3000", "localhost:8080", false},
+		{"listed origin as a prefix", []string{"https://app.example.com"}, "https://app.example.com.evil.net", "api.example.com", false},
+		{"invalid origin", []string{}, "://bad origin", "api.example.com", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config.ALLOWED_ORIGINS = test.allowedOrigins
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Host = test.host
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+
+			if got := IsAllowedOrigin(r); got != test.want {
+				t.Errorf("IsAllowedOrigin() with origin %q = %v, want %v", test.origin, got, test.want)
+			}
+		})
+	}
+}
+
+func TestWSUpgraderCheckOrigin(t *testing.T) {
+	config.ALLOWED_ORIGINS = []string{"https://app.example.com"}
+
+	tests := []struct {
+		name   string
+		origin string
+		want   bool
+	}{
+		{"no origin", "", true},
+		{"listed origin", "https://app.example.com", true},
+		{"same origin", "http://api.example.com", true},
+		{"unlisted origin", "https://evil.example.net", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ws/play", nil)
+			r.Host = "api.example.com"
+			r.Header.Set("Connection", "Upgrade")
+			r.Header.Set("Upgrade", "websocket")
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+
+			if got := WSUpgrader.CheckOrigin(r); got != test.want {
+				t.Errorf("WSUpgrader.CheckOrigin() with origin %q = %v, want %v", test.origin, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package helpers
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// tokenBucket holds up to burst tokens, refilled continuously at burst per period
+type tokenBucket struct {
+	tokens    float64
+	burst     float64
+	period    time.Duration
+	updatedAt time.Time
+}
+
+// refill adds the tokens earned since the last update
+func (b *tokenBucket) refill(now time.Time) {
+	rate := b.burst / b.period.Seconds()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.updatedAt).Seconds()*rate)
+	b.updatedAt = now
+}
+
+// retryAfter is how long until the bucket has a token again
+func (b *tokenBucket) retryAfter() time.Duration {
+	rate := b.burst / b.period.Seconds()
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// RateLimiter keeps a token bucket per key (e.g. route + IP), idle buckets are dropped once full again
+type RateLimiter struct {
+	buckets map[string]*tokenBucket
+	mu      sync.Mutex
+}
+
+// How often full buckets are dropped
+const rateLimiterCleanupInterval = time.Minute
+
+func NewRateLimiter() *RateLimiter {
+	limiter := &RateLimiter{buckets: make(map[string]*tokenBucket)}
+
+	go func() {
+		for range time.Tick(rateLimiterCleanupInterval) {
+			limiter.cleanup()
+		}
+	}()
+
+	return limiter
+}
+
+// Allow takes a token from the bucket of every key, or none if one of them is empty
+// burst tokens are refilled over period; returns how long to wait before retrying when refused
+func (l *RateLimiter) Allow(burst int, period time.Duration, keys ...string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	buckets := make([]*tokenBucket, 0, len(keys))
+	var retryAfter time.Duration
+
+	for _, key := range keys {
+		bucket, found := l.buckets[key]
+		if !found || bucket.burst != float64(burst) || bucket.period != period {
+			// New key or its limit changed, starts full
+			bucket = &tokenBucket{tokens: float64(burst), burst: float64(burst), period: period, updatedAt: now}
+			l.buckets[key] = bucket
+		}
+
+		bucket.refill(now)
+		if bucket.tokens < 1 {
+			retryAfter = max(retryAfter, bucket.retryAfter())
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+
+	return true, 0
+}
+
+func (l *RateLimiter) cleanup() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for key, bucket := range l.buckets {
+		bucket.refill(now)
+		if bucket.tokens >= bucket.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Global rate limiter instance
+var Limiter = NewRateLimiter()
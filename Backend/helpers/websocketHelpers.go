@@ -1,14 +1,16 @@
 package helpers
 
 import (
-	"net/http"
-
 	"github.com/gorilla/websocket"
 )
 
+// Subprotocol browsers offer along their token, new WebSocket(url, ["bearer", token]) (see middleware/wsAuthMiddleware.go)
+const WSBearerProtocol = "bearer"
+
 // WS Route upgrader ( Transforms http request into ws)
+// Browsers send cookies / credentials along cross-site socket handshakes, so only allowed origins can connect (403 otherwise)
+// Browsers drop sockets that don't agree on one of the subprotocols they offered, so "bearer" is accepted back
 var WSUpgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all connections
-	},
+	CheckOrigin:  IsAllowedOrigin,
+	Subprotocols: []string{WSBearerProtocol},
 }
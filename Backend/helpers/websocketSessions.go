@@ -0,0 +1,126 @@
+package helpers
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Kinds of WebSocket sessions
+const (
+	SessionWallet  = "wallet"
+	SessionPlay    = "play"
+	SessionEndPlay = "end-play"
+	SessionRoom    = "room"  // Shared dice rooms, the rooms keep track of their own sessions
+	SessionCrash   = "crash" // Crash game, the game keeps track of its own sessions
+
+	SessionLeaderboards = "leaderboards" // Public, registered with player ID 0
+	SessionTournaments  = "tournaments"  // Public, registered with player ID 0
+	SessionJackpot      = "jackpot"      // Public, registered with player ID 0
+)
+
+// WSSession is an open WebSocket connection of a player (or an anonymous client of a public socket)
+// Gorilla connections only support one concurrent writer, so every write goes through the session lock
+type WSSession struct {
+	ID       int
+	PlayerID int
+	Kind     string
+	Conn     *websocket.Conn
+	mu       sync.Mutex
+}
+
+// WriteMessage sends a message through the connection (safe to call from multiple goroutines)
+func (s *WSSession) WriteMessage(messageType int, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.Conn.WriteMessage(messageType, data)
+}
+
+// WriteJSON stringifies and sends a JSON message
+func (s *WSSession) WriteJSON(message map[string]interface{}) error {
+	stringifiedMessage, err := JsonStringifier(message)
+	if err != nil {
+		return err
+	}
+
+	return s.WriteMessage(websocket.TextMessage, []byte(stringifiedMessage))
+}
+
+// Close sends a close frame with the reason and closes the connection
+// The handler's blocking ReadMessage then returns an error and the handler cleans up
+func (s *WSSession) Close(closeCode int, reason string) {
+	s.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, reason), time.Now().Add(time.Second))
+	s.Conn.Close()
+}
+
+type WSSessionRegistry struct {
+	sessions map[int]map[int]*WSSession // Player ID -> Session ID -> Session
+	nextID   int
+	mu       sync.Mutex
+}
+
+func NewWSSessionRegistry() *WSSessionRegistry {
+	return &WSSessionRegistry{
+		sessions: make(map[int]map[int]*WSSession),
+	}
+}
+
+// Register keeps track of a player's connection and returns its session
+func (r *WSSessionRegistry) Register(playerID int, kind string, conn *websocket.Conn) *WSSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.sessions[playerID] == nil {
+		r.sessions[playerID] = make(map[int]*WSSession)
+	}
+
+	session := &WSSession{ID: r.nextID, PlayerID: playerID, Kind: kind, Conn: conn}
+	r.sessions[playerID][session.ID] = session
+	r.nextID++
+
+	return session
+}
+
+// Unregister forgets a session (call it when the handler returns)
+func (r *WSSessionRegistry) Unregister(session *WSSession) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if playerSessions, found := r.sessions[session.PlayerID]; found {
+		delete(playerSessions, session.ID)
+		if len(playerSessions) == 0 {
+			delete(r.sessions, session.PlayerID) // Clean up empty player key
+		}
+	}
+}
+
+// PlayerSessions returns the player's open sessions of the given kinds (all kinds if none given)
+func (r *WSSessionRegistry) PlayerSessions(playerID int, kinds ...string) []*WSSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sessions := []*WSSession{}
+	for _, session := range r.sessions[playerID] {
+		if len(kinds) == 0 || slices.Contains(kinds, session.Kind) {
+			sessions = append(sessions, session)
+		}
+	}
+
+	return sessions
+}
+
+// CloseAll closes every open session of the player and returns how many were closed
+func (r *WSSessionRegistry) CloseAll(playerID int, closeCode int, reason string) int {
+	sessions := r.PlayerSessions(playerID)
+	for _, session := range sessions {
+		session.Close(closeCode, reason)
+	}
+
+	return len(sessions)
+}
+
+// Global session registry instance
+var Sessions = NewWSSessionRegistry()
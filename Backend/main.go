@@ -2,9 +2,12 @@ package main
 
 import (
 	"fmt"
+	"main/audit"
 	"main/config"
 	"main/controllers"
+	"main/middleware"
 	"main/models"
+	"main/risk"
 	"net/http"
 )
 
@@ -12,21 +15,131 @@ func main() {
 	config.LoadConfig()
 
 	models.ConnectDB()
+	controllers.SeedCurrencies()
+	audit.InitializeTable()
+	risk.InitializeTable()
+	controllers.LoadRiskRules()
+	controllers.SeedJackpot()
+
+	// Background jobs
+	controllers.StartCashbackScheduler()
+	controllers.StartTournamentScheduler()
+	controllers.StartDiceRooms()
+	controllers.StartCrashGame()
+	controllers.StartPayments()
 
 	http.HandleFunc("/ws/wallet", controllers.HandleWalletWS)
 	http.HandleFunc("/ws/play", controllers.HandlePlayWS)
 	http.HandleFunc("/ws/end-play", controllers.HandleEndPlayWS)
+	http.HandleFunc("/ws/leaderboards", controllers.HandleLeaderboardsWS)
+	http.HandleFunc("/ws/tournaments", controllers.HandleTournamentsWS)
+	http.HandleFunc("/ws/rooms/{room}", controllers.HandleRoomWS)
+	http.HandleFunc("/ws/crash", controllers.HandleCrashWS)
+	http.HandleFunc("/ws/jackpot", controllers.HandleJackpotWS)
 
 	// Authentication routes
 	http.HandleFunc("/auth/register", controllers.HandleRegister)
 	http.HandleFunc("/auth/login", controllers.HandleLogin)
+	http.HandleFunc("/auth/refresh", controllers.HandleRefreshToken)
+	http.HandleFunc("/auth/ws-ticket", middleware.Authorize(controllers.HandleWSTicket, models.RolePlayer, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/player/me/password", controllers.HandleChangePassword)
+
+	// Public leaderboards
+	http.HandleFunc("/leaderboards/{board}", controllers.HandleLeaderboard)
+
+	// Shared dice rooms
+	http.HandleFunc("/rooms", controllers.HandleRooms)
+
+	// Crash game
+	http.HandleFunc("/crash/rounds", controllers.HandleCrashRounds)
+
+	// Progressive jackpot
+	http.HandleFunc("/jackpot", controllers.HandleJackpot)
+
+	// Currencies (public rates and bet limits)
+	http.HandleFunc("/currencies", controllers.HandleCurrencies)
+
+	// Tournaments (listing and standings are public)
+	http.HandleFunc("/tournaments", controllers.HandleTournaments)
+	http.HandleFunc("/tournaments/{id}", controllers.HandleTournament)
+	http.HandleFunc("/tournaments/{id}/join", middleware.Authorize(controllers.HandleJoinTournament, models.RolePlayer))
 
 	// Wallet HTTP routes
 	http.HandleFunc("/player/me/wallet/withdraw", controllers.HandleWithdraw)
 	http.HandleFunc("/player/me/wallet/deposit", controllers.HandleDeposit)
+	http.HandleFunc("/player/me/wallets", middleware.Authorize(controllers.HandlePlayerWallets, models.RolePlayer))
+	http.HandleFunc("/player/me/wallets/active", middleware.Authorize(controllers.HandleActiveWallet, models.RolePlayer))
+
+	// Payments (the webhook is authenticated by the provider's signature)
+	http.HandleFunc("/player/me/payments", middleware.Authorize(controllers.HandlePlayerPayments, models.RolePlayer))
+	http.HandleFunc("/player/me/payments/{id}", middleware.Authorize(controllers.HandlePlayerPayment, models.RolePlayer))
+	http.HandleFunc("/payments/webhook/{provider}", controllers.HandlePaymentWebhook)
+
+	// Withdrawal requests
+	http.HandleFunc("/player/me/withdrawals", middleware.Authorize(controllers.HandlePlayerWithdrawals, models.RolePlayer))
+	http.HandleFunc("/player/me/withdrawals/{id}/cancel", middleware.Authorize(controllers.HandleCancelWithdrawal, models.RolePlayer))
+
+	// Identity verification (KYC)
+	http.HandleFunc("/player/me/kyc", middleware.Authorize(controllers.HandlePlayerKYC, models.RolePlayer))
+	http.HandleFunc("/player/me/kyc/profile", middleware.Authorize(controllers.HandleUpdateKYCProfile, models.RolePlayer))
+	http.HandleFunc("/player/me/kyc/documents", middleware.Authorize(controllers.HandleUploadKYCDocument, models.RolePlayer))
+	http.HandleFunc("/player/me/kyc/submit", middleware.Authorize(controllers.HandleSubmitKYC, models.RolePlayer))
+
+	// Responsible gaming routes
+	http.HandleFunc("/player/me/limits", middleware.Authorize(controllers.HandlePlayerLimits, models.RolePlayer))
+	http.HandleFunc("/player/me/self-exclusion", middleware.Authorize(controllers.HandleSelfExclusion, models.RolePlayer))
+	http.HandleFunc("/player/me/cool-off", middleware.Authorize(controllers.HandleCoolOff, models.RolePlayer))
+
+	// Bonus routes
+	http.HandleFunc("/player/me/bonuses", middleware.Authorize(controllers.HandlePlayerBonuses, models.RolePlayer))
+	http.HandleFunc("/player/me/promos/redeem", middleware.Authorize(controllers.HandleRedeemPromo, models.RolePlayer))
+	http.HandleFunc("/player/me/free-bets", middleware.Authorize(controllers.HandlePlayerFreeBets, models.RolePlayer))
+	http.HandleFunc("/player/me/referrals", middleware.Authorize(controllers.HandlePlayerReferrals, models.RolePlayer))
+	http.HandleFunc("/player/me/loyalty", middleware.Authorize(controllers.HandlePlayerLoyalty, models.RolePlayer))
+	http.HandleFunc("/player/me/cashbacks", middleware.Authorize(controllers.HandlePlayerCashbacks, models.RolePlayer))
+	http.HandleFunc("/player/me/privacy", middleware.Authorize(controllers.HandlePlayerPrivacy, models.RolePlayer))
+
+	// Player-to-player transfers
+	http.HandleFunc("/player/me/transfers", middleware.Authorize(controllers.HandlePlayerTransfers, models.RolePlayer))
+	http.HandleFunc("/player/me/transfers/settings", middleware.Authorize(controllers.HandlePlayerTransferSettings, models.RolePlayer))
+
+	// Admin routes (support staff can only read)
+	http.HandleFunc("/admin/players", middleware.Authorize(controllers.HandleAdminListPlayers, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/admin/players/{id}", middleware.Authorize(controllers.HandleAdminGetPlayer, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/admin/players/{id}/bets", middleware.Authorize(controllers.HandleAdminPlayerBets, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/admin/players/{id}/transactions", middleware.Authorize(controllers.HandleAdminPlayerTransactions, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/admin/players/{id}/status-history", middleware.Authorize(controllers.HandleAdminPlayerStatusHistory, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/admin/players/{id}/status", middleware.Authorize(controllers.HandleAdminUpdatePlayerStatus, models.RoleAdmin))
+	http.HandleFunc("/admin/players/{id}/bonuses", middleware.Authorize(controllers.HandleAdminPlayerBonuses, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/admin/players/{id}/referrals", middleware.Authorize(controllers.HandleAdminPlayerReferrals, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/admin/audit", middleware.Authorize(controllers.HandleAdminAuditLog, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/admin/audit/verify", middleware.Authorize(controllers.HandleAdminAuditVerify, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/admin/adjustments", middleware.Authorize(controllers.HandleAdminAdjustments, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/admin/adjustments/{id}", middleware.Authorize(controllers.HandleAdminGetAdjustment, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/admin/adjustments/{id}/approve", middleware.Authorize(controllers.HandleAdminApproveAdjustment, models.RoleAdmin))
+	http.HandleFunc("/admin/adjustments/{id}/reject", middleware.Authorize(controllers.HandleAdminRejectAdjustment, models.RoleAdmin))
+	http.HandleFunc("/admin/promos", middleware.Authorize(controllers.HandleAdminPromos, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/admin/promos/{id}/redemptions", middleware.Authorize(controllers.HandleAdminPromoRedemptions, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/admin/cashback/run", middleware.Authorize(controllers.HandleAdminRunCashback, models.RoleAdmin))
+	http.HandleFunc("/admin/tournaments", middleware.Authorize(controllers.HandleAdminTournaments, models.RoleAdmin))
+	http.HandleFunc("/admin/withdrawals", middleware.Authorize(controllers.HandleAdminWithdrawals, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/admin/withdrawals/{id}/approve", middleware.Authorize(controllers.HandleAdminApproveWithdrawal, models.RoleAdmin))
+	http.HandleFunc("/admin/withdrawals/{id}/reject", middleware.Authorize(controllers.HandleAdminRejectWithdrawal, models.RoleAdmin))
+	http.HandleFunc("/admin/kyc", middleware.Authorize(controllers.HandleAdminKYCQueue, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/admin/kyc/documents/{id}", middleware.Authorize(controllers.HandleAdminKYCDocument, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/admin/players/{id}/kyc", middleware.Authorize(controllers.HandleAdminPlayerKYC, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/admin/players/{id}/kyc/review", middleware.Authorize(controllers.HandleAdminReviewKYC, models.RoleAdmin))
+	http.HandleFunc("/admin/jackpot/ledger", middleware.Authorize(controllers.HandleAdminJackpotLedger, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/admin/currencies/{code}", middleware.Authorize(controllers.HandleAdminUpdateCurrency, models.RoleAdmin))
+	http.HandleFunc("/admin/reports/currencies", middleware.Authorize(controllers.HandleAdminCurrencyReport, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/admin/risk/flags", middleware.Authorize(controllers.HandleAdminRiskFlags, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/admin/risk/flags/{id}", middleware.Authorize(controllers.HandleAdminRiskFlag, models.RoleSupport, models.RoleAdmin))
+	http.HandleFunc("/admin/risk/flags/{id}/review", middleware.Authorize(controllers.HandleAdminReviewRiskFlag, models.RoleAdmin))
+	http.HandleFunc("/admin/risk/rules", middleware.Authorize(controllers.HandleAdminRiskRules, models.RoleSupport, models.RoleAdmin))
 
 	fmt.Println("\n\nServer started on ", config.PORT)
-	if err := http.ListenAndServe(config.PORT, nil); err != nil {
+	// Every request goes through CORS (preflights stop there) and the rate limiter first (see middleware/)
+	if err := http.ListenAndServe(config.PORT, middleware.CORS(middleware.RateLimit(http.DefaultServeMux))); err != nil {
 		fmt.Println("\n\nServer failed to start:", err)
 	}
 
@@ -2,39 +2,156 @@ package middleware
 
 import (
 	"errors"
+	"main/audit"
 	"main/config"
+	"main/helpers"
 	"main/models"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
 )
 
-// PrintMessage is an exported function that prints a message.
+// AuthorizedHandler is a handler that receives the already authenticated player
+type AuthorizedHandler func(w http.ResponseWriter, r *http.Request, player *models.Player)
+
+// AuthenticateUser validates the request's JWT token and returns the player it belongs to
+// Fails if the token is missing / invalid, the player doesn't exist or their account can't be used (suspended, closed, ...)
 func AuthenticateUser(r *http.Request) (*models.Player, error) {
+	player, _, err := authenticate(r)
+	return player, err
+}
 
-	// Check for auth header
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
-		return nil, errors.New("authorization token missing")
+// Authorize wraps a handler so it's only reached by players whose token carries one of the allowed roles
+// Returns 401 if the token is missing / invalid and 403 if the role isn't allowed
+func Authorize(handler AuthorizedHandler, allowedRoles ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestDetails := map[string]interface{}{"method": r.Method, "path": r.URL.Path}
+
+		player, tokenRole, err := authenticate(r)
+		if err != nil {
+			requestDetails["reason"] = err.Error()
+			recordUnauthorized(r, requestDetails)
+
+			statusCode, response := AuthErrorResponse(err)
+			helpers.WriteJSONResponse(w, statusCode, response)
+			return
+		}
+
+		// The role in the token must still match the one in the database (e.g. a demoted admin)
+		if tokenRole != player.Role {
+			requestDetails["reason"] = "token role " + tokenRole + " does not match role " + player.Role
+			audit.Record(r, player.ID, audit.ActionForbidden, 0, requestDetails)
+
+			helpers.WriteJSONResponse(w, http.StatusForbidden, map[string]interface{}{"message": "Role has changed, please log in again"})
+			return
+		}
+
+		if !slices.Contains(allowedRoles, tokenRole) {
+			requestDetails["reason"] = "role " + tokenRole + " not allowed"
+			audit.Record(r, player.ID, audit.ActionForbidden, 0, requestDetails)
+
+			helpers.WriteJSONResponse(w, http.StatusForbidden, map[string]interface{}{"message": "Not allowed to access this resource"})
+			return
+		}
+
+		// Every request made by staff is kept in the audit trail
+		if tokenRole != models.RolePlayer {
+			requestDetails["query"] = r.URL.RawQuery
+			audit.Record(r, player.ID, audit.ActionAdminRequest, 0, requestDetails)
+		}
+
+		handler(w, r, player)
+	}
+}
+
+// At most this many refused authentications are audited per IP and minute, the others are only refused
+const unauthorizedAuditBurst = 10
+
+// recordUnauthorized audits a refused authentication
+// Requests without credentials aren't audited and the others are rate limited per IP, every entry is chained
+// under the same lock so anonymous clients could otherwise flood the trail and hold up every audited action
+func recordUnauthorized(r *http.Request, details map[string]interface{}) {
+	if bearerToken(r) == "" && r.URL.Query().Get("ticket") == "" {
+		return
+	}
+
+	if allowed, _ := helpers.Limiter.Allow(unauthorizedAuditBurst, time.Minute, "audit-unauthorized:"+helpers.ClientIP(r)); !allowed {
+		return
+	}
+
+	audit.Record(r, 0, audit.ActionUnauthorized, 0, details)
+}
+
+// authenticate validates the JWT token and returns the player together with the role in the token claims
+func authenticate(r *http.Request) (*models.Player, string, error) {
+	playerID, tokenRole, err := parseToken(r)
+	if err != nil {
+		return nil, "", err
+	}
+
+	player, err := loadPlayer(playerID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Authentication successful
+	return player, tokenRole, nil
+
+}
+
+// loadPlayer fetches the authenticated player, refusing accounts that can't use the API
+func loadPlayer(playerID int) (*models.Player, error) {
+
+	// Fetch player from database using ID
+	player, findPlayerErr := models.GetPlayerByID(playerID)
+	if findPlayerErr != nil {
+
+		return nil, errors.New("player not found")
+	}
+
+	// Timed self-exclusions are lifted on the first request after they end
+	if player.SelfExclusionEnded() {
+		if err := models.EndPlayerSelfExclusion(player.ID); err == nil {
+			player, findPlayerErr = models.GetPlayerByID(playerID)
+			if findPlayerErr != nil {
+				return nil, errors.New("player not found")
+			}
+		}
 	}
 
-	// Extract the JWT token to remove the "Bearer "
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	// Suspended and closed accounts can't use the API at all
+	if statusErr := player.CanAuthenticate(); statusErr != nil {
+		return nil, statusErr
+	}
+
+	return player, nil
+}
+
+// parseToken validates the JWT token of the request and returns the player ID and role in its claims (without looking the player up)
+func parseToken(r *http.Request) (int, string, error) {
+
+	tokenString := bearerToken(r)
+	if tokenString == "" {
+		return 0, "", errors.New("authorization token missing")
+	}
 
 	// Parse and validate the JWT token
 	token, err := jwt.ParseWithClaims(tokenString, jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
 		return []byte(config.JWT_SECRET), nil
 	})
 	if err != nil {
-		return nil, errors.New("invalid JWT token")
+		return 0, "", errors.New("invalid JWT token")
 	}
 
 	// Check if the claims can be extracted from the JWT Token
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
 
-		return nil, errors.New("invalid token claims")
+		return 0, "", errors.New("invalid token claims")
 
 	}
 
@@ -42,19 +159,44 @@ func AuthenticateUser(r *http.Request) (*models.Player, error) {
 	playerIDFloat, ok := claims["id"].(float64)
 	if !ok {
 
-		return nil, errors.New("invalid token payload")
+		return 0, "", errors.New("invalid token payload")
 	}
 
-	playerID := int(playerIDFloat)
+	// Tokens issued before roles existed don't carry one, they were all players
+	tokenRole, ok := claims["role"].(string)
+	if !ok {
+		tokenRole = models.RolePlayer
+	}
 
-	// Fetch player from database using ID
-	player, findPlayerErr := models.GetPlayerByID(playerID)
-	if findPlayerErr != nil {
+	return int(playerIDFloat), tokenRole, nil
+}
 
-		return nil, errors.New("player not found")
+// bearerToken returns the JWT token of the request, from the "Authorization: Bearer <token>" header
+// or, for socket handshakes from browsers (which can't set headers), the "Sec-WebSocket-Protocol: bearer, <token>" header
+func bearerToken(r *http.Request) string {
+
+	// Check for auth header
+	authHeader := r.Header.Get("Authorization")
+	if strings.HasPrefix(authHeader, "Bearer ") {
+		// Extract the JWT token to remove the "Bearer "
+		return strings.TrimPrefix(authHeader, "Bearer ")
 	}
 
-	// Authentication successful
-	return player, nil
+	protocols := websocket.Subprotocols(r)
+	if index := slices.Index(protocols, helpers.WSBearerProtocol); index != -1 && index+1 < len(protocols) {
+		return protocols[index+1]
+	}
+
+	return ""
+}
+
+// AuthErrorResponse returns the status code and response body for an authentication error
+// Account status errors are sent as 403 with their error code so clients can tell them apart
+func AuthErrorResponse(err error) (int, map[string]interface{}) {
+	var accountStatusError *models.AccountStatusError
+	if errors.As(err, &accountStatusError) {
+		return http.StatusForbidden, map[string]interface{}{"code": http.StatusForbidden, "errorCode": accountStatusError.Code, "message": accountStatusError.Message}
+	}
 
+	return http.StatusUnauthorized, map[string]interface{}{"code": http.StatusUnauthorized, "message": err.Error()}
 }
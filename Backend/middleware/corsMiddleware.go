@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"main/helpers"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+CORS for the HTTP routes, browsers on one of ALLOWED_ORIGINS can call the API (sockets check the origin in helpers.WSUpgrader)
+
+! Preflights (OPTIONS with Access-Control-Request-Method) are answered here and never reach the routes, 403 for other origins
+! Other origins' requests still reach the routes but without CORS headers, so their browser won't let them read the response
+? No cookies are used (tokens go in the Authorization header), so credentials aren't allowed
+*/
+
+// What cross-origin requests may send
+var (
+	corsAllowedMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}
+	corsAllowedHeaders = []string{"Authorization", "Content-Type"}
+	corsExposedHeaders = []string{"Retry-After"} // Rate limited requests (see rateLimitMiddleware.go)
+)
+
+// How long browsers can cache a preflight answer (seconds)
+const corsMaxAge = 600
+
+// CORS wraps a handler with the CORS headers and answers the preflights
+func CORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		isPreflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		// Same-origin and non-browser requests don't need anything
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if !helpers.IsAllowedOrigin(r) {
+			if isPreflight {
+				helpers.WriteJSONResponse(w, http.StatusForbidden, map[string]interface{}{"message": "Origin not allowed"})
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+
+		if !isPreflight {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Preflight: the method and every requested header must be allowed
+		requestedMethod := r.Header.Get("Access-Control-Request-Method")
+		if !slices.Contains(corsAllowedMethods, requestedMethod) {
+			helpers.WriteJSONResponse(w, http.StatusForbidden, map[string]interface{}{"message": "Method " + requestedMethod + " not allowed"})
+			return
+		}
+
+		for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+			header = strings.TrimSpace(header)
+			if header != "" && !slices.ContainsFunc(corsAllowedHeaders, func(allowed string) bool { return strings.EqualFold(allowed, header) }) {
+				helpers.WriteJSONResponse(w, http.StatusForbidden, map[string]interface{}{"message": "Header " + header + " not allowed"})
+				return
+			}
+		}
+
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(corsAllowedMethods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
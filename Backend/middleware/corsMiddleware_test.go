@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"main/config"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	config.ALLOWED_ORIGINS = []string{"https://app.example.com"}
+
+	tests := []struct {
+		name           string
+		method         string
+		origin         string
+		requestMethod  string // Access-Control-Request-Method
+		requestHeaders string // Access-Control-Request-Headers
+		wantStatus     int
+		wantNext       bool   // Whether the request reaches the wrapped handler
+		wantAllowed    string // Expected Access-Control-Allow-Origin
+		wantVary       []string
+		wantAllowLists bool // Whether Access-Control-Allow-Methods / Headers and Max-Age are set
+	}{
+		{
+			name: "no origin", method: http.MethodGet,
+			wantStatus: http.StatusOK, wantNext: true,
+		},
+		{
+			name: "allowed origin", method: http.MethodGet, origin: "https://app.example.com",
+			wantStatus: http.StatusOK, wantNext: true, wantAllowed: "https://app.example.com", wantVary: []string{"Origin"},
+		},
+		{
+			name: "disallowed origin", method: http.MethodPost, origin: "https://evil.example.net",
+			wantStatus: http.StatusOK, wantNext: true, wantVary: []string{"Origin"},
+		},
+		{
+			name: "OPTIONS without preflight headers", method: http.MethodOptions, origin: "https://app.example.com",
+			wantStatus: http.StatusOK, wantNext: true, wantAllowed: "https://app.example.com", wantVary: []string{"Origin"},
+		},
+		{
+			name: "allowed preflight", method: http.MethodOptions, origin: "https://app.example.com",
+			requestMethod: http.MethodPost, requestHeaders: "authorization, Content-Type",
+			wantStatus: http.StatusNoContent, wantAllowed: "https://app.example.com",
+			wantVary:       []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+			wantAllowLists: true,
+		},
+		{
+			name: "allowed preflight without headers", method: http.MethodOptions, origin: "https://app.example.com",
+			requestMethod: http.MethodDelete,
+			wantStatus:    http.StatusNoContent, wantAllowed: "https://app.example.com",
+			wantVary:       []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+			wantAllowLists: true,
+		},
+		{
+			name: "preflight from disallowed origin", method: http.MethodOptions, origin: "https://evil.example.net",
+			requestMethod: http.MethodGet,
+			wantStatus:    http.StatusForbidden, wantVary: []string{"Origin"},
+		},
+		{
+			name: "preflight with disallowed method", method: http.MethodOptions, origin: "https://app.example.com",
+			requestMethod: http.MethodPatch,
+			wantStatus:    http.StatusForbidden, wantAllowed: "https://app.example.com", wantVary: []string{"Origin"},
+		},
+		{
+			name: "preflight with disallowed header", method: http.MethodOptions, origin: "https://app.example.com",
+			requestMethod: http.MethodPost, requestHeaders: "Authorization, X-Custom",
+			wantStatus: http.StatusForbidden, wantAllowed: "https://app.example.com", wantVary: []string{"Origin"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reachedNext := false
+			handler := CORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reachedNext = true
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			r := httptest.NewRequest(test.method, "/player/me", nil)
+			r.Host = "api.example.com"
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+			if test.requestMethod != "" {
+				r.Header.Set("Access-Control-Request-Method", test.requestMethod)
+			}
+			if test.requestHeaders != "" {
+				r.Header.Set("Access-Control-Request-Headers", test.requestHeaders)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != test.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, test.wantStatus)
+			}
+			if reachedNext != test.wantNext {
+				t.Errorf("reached next handler = %v, want %v", reachedNext, test.wantNext)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != test.wantAllowed {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, test.wantAllowed)
+			}
+			if got := w.Header().Get("Access-Control-Expose-Headers"); (got != "") != (test.wantAllowed != "") {
+				t.Errorf("Access-Control-Expose-Headers = %q, want it set only for allowed origins", got)
+			}
+			if got := w.Header().Values("Vary"); !slices.Equal(got, test.wantVary) {
+				t.Errorf("Vary = %q, want %q", got, test.wantVary)
+			}
+
+			allowMethods := w.Header().Get("Access-Control-Allow-Methods")
+			allowHeaders := w.Header().Get("Access-Control-Allow-Headers")
+			maxAge := w.Header().Get("Access-Control-Max-Age")
+			if test.wantAllowLists {
+				if allowMethods != "GET, POST, PUT, DELETE" {
+					t.Errorf("Access-Control-Allow-Methods = %q", allowMethods)
+				}
+				if allowHeaders != "Authorization, Content-Type" {
+					t.Errorf("Access-Control-Allow-Headers = %q", allowHeaders)
+				}
+				if maxAge != "600" {
+					t.Errorf("Access-Control-Max-Age = %q", maxAge)
+				}
+			} else if allowMethods != "" || allowHeaders != "" || maxAge != "" {
+				t.Errorf("Access-Control-Allow-Methods / Headers / Max-Age set on a non-allowed preflight: %q / %q / %q", allowMethods, allowHeaders, maxAge)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"main/config"
+	"main/helpers"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+Rate limiting with token buckets (see helpers/rateLimiter.go), per IP and per player when the request / socket has one
+
+HTTP: limits per route pattern (HTTP_RATE_LIMITS), refused requests get 429 with Retry-After
+WS: limits per message type (WS_RATE_LIMITS, "<socket>.<action>" e.g. "crash.cashout"), refused messages get
+{"type": "error", "code": 429, "errorCode": "RATE_LIMITED", ...} and the socket is closed after WS_RATE_LIMIT_MAX_VIOLATIONS refused messages in a minute
+*/
+
+// Error code sent to rate limited clients
+const RateLimited = "RATE_LIMITED"
+
+// Window of the socket violations counted against WS_RATE_LIMIT_MAX_VIOLATIONS
+const wsViolationWindow = time.Minute
+
+// RateLimit wraps the mux so every request takes a token from the buckets of its route
+func RateLimit(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Routes without their own limit still get their own buckets (unknown paths share one)
+		_, pattern := mux.Handler(r)
+		_, limit := findRateLimit(config.HTTP_RATE_LIMITS, pattern)
+
+		if limit.Burst > 0 {
+			// Player ID from the token only, looking the player up for every request would defeat the purpose
+			keys := []string{"http:" + pattern + ":ip:" + helpers.ClientIP(r)}
+			if playerID, _, err := parseToken(r); err == nil {
+				keys = append(keys, fmt.Sprintf("http:%s:player:%d", pattern, playerID))
+			}
+
+			if allowed, retryAfter := helpers.Limiter.Allow(limit.Burst, limit.Period, keys...); !allowed {
+				seconds := retryAfterSeconds(retryAfter)
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				helpers.WriteJSONResponse(w, http.StatusTooManyRequests, map[string]interface{}{
+					"code":       http.StatusTooManyRequests,
+					"errorCode":  RateLimited,
+					"message":    fmt.Sprintf("Too many requests, retry in %d seconds", seconds),
+					"retryAfter": seconds,
+				})
+				return
+			}
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// AllowWSMessage takes a token from the buckets of the message's type, defaultAction is the type of messages without an "action"
+// Refused messages get an error frame and false is returned, the socket is closed when they keep coming
+func AllowWSMessage(session *helpers.WSSession, receivedMsg []byte, defaultAction string) bool {
+	var message struct {
+		Action string `json:"action"`
+	}
+	json.Unmarshal(receivedMsg, &message) // Invalid JSON is answered by the handler
+	if message.Action == "" {
+		message.Action = defaultAction
+	}
+
+	messageType := session.Kind + "." + message.Action
+	limitKey, limit := findRateLimit(config.WS_RATE_LIMITS, messageType, session.Kind)
+	if limit.Burst == 0 {
+		return true
+	}
+
+	// Buckets by the matched limit, not the raw action (clients could make up any number of them)
+	bucket := limitKey
+	if bucket == "default" {
+		bucket = session.Kind
+	}
+
+	keys := []string{"ws:" + bucket + ":ip:" + remoteIP(session.Conn)}
+	if session.PlayerID != 0 {
+		keys = append(keys, fmt.Sprintf("ws:%s:player:%d", bucket, session.PlayerID))
+	}
+
+	allowed, retryAfter := helpers.Limiter.Allow(limit.Burst, limit.Period, keys...)
+	if allowed {
+		return true
+	}
+
+	if violationAllowed, _ := helpers.Limiter.Allow(config.WS_RATE_LIMIT_MAX_VIOLATIONS, wsViolationWindow, fmt.Sprintf("ws-violations:%d", session.ID)); !violationAllowed {
+		session.Close(websocket.ClosePolicyViolation, "Too many messages")
+		return false
+	}
+
+	seconds := retryAfterSeconds(retryAfter)
+	session.WriteJSON(map[string]interface{}{
+		"type":        "error",
+		"code":        http.StatusTooManyRequests,
+		"errorCode":   RateLimited,
+		"message":     fmt.Sprintf("Too many messages, retry in %d seconds", seconds),
+		"messageType": messageType,
+		"retryAfter":  seconds,
+	})
+
+	return false
+}
+
+// findRateLimit returns the first of the keys with a limit (or the default one) and its limit
+func findRateLimit(limits map[string]config.RateLimit, keys ...string) (string, config.RateLimit) {
+	for _, key := range keys {
+		if limit, found := limits[key]; found {
+			return key, limit
+		}
+	}
+
+	return "default", limits["default"]
+}
+
+// retryAfterSeconds rounds up to whole seconds (Retry-After doesn't take fractions)
+func retryAfterSeconds(retryAfter time.Duration) int {
+	return max(1, int(math.Ceil(retryAfter.Seconds())))
+}
+
+// remoteIP is the IP of the socket's client, like helpers.ClientIP for requests
+func remoteIP(conn *websocket.Conn) string {
+	address := conn.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+
+	return host
+}
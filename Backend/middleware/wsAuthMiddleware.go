@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"main/config"
+	"main/helpers"
+	"main/models"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+Socket authentication, done on the handshake before upgrading so failures get a real HTTP status (401 / 403)
+
+Browsers can't set an Authorization header on a socket, so the player can be authenticated with either:
+- Authorization: Bearer <token>                   -> Non-browser clients
+- Sec-WebSocket-Protocol: bearer, <token>          -> new WebSocket(url, ["bearer", token]), the server agrees on "bearer"
+- ?ticket=<ticket>                                 -> One-time ticket from POST /auth/ws-ticket, valid WS_TICKET_SECONDS
+
+! Tickets are kept in memory, they're lost on restart (clients just ask for a new one)
+? Tickets keep the token itself out of URLs (and so out of proxy / access logs)
+*/
+
+// wsTicket is a one-time ticket for a socket handshake
+type wsTicket struct {
+	playerID  int
+	expiresAt time.Time
+}
+
+// Tickets not used yet, by ticket
+var wsTickets = struct {
+	tickets map[string]wsTicket
+	mu      sync.Mutex
+}{tickets: make(map[string]wsTicket)}
+
+// IssueWSTicket creates a one-time ticket for the player's next socket handshake
+func IssueWSTicket(playerID int) (string, time.Time, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", time.Time{}, err
+	}
+
+	ticket := hex.EncodeToString(randomBytes)
+	expiresAt := time.Now().Add(time.Duration(config.WS_TICKET_SECONDS * float32(time.Second)))
+
+	wsTickets.mu.Lock()
+	defer wsTickets.mu.Unlock()
+
+	// Drop the tickets that were never used
+	now := time.Now()
+	for key, issued := range wsTickets.tickets {
+		if now.After(issued.expiresAt) {
+			delete(wsTickets.tickets, key)
+		}
+	}
+
+	wsTickets.tickets[ticket] = wsTicket{playerID: playerID, expiresAt: expiresAt}
+
+	return ticket, expiresAt, nil
+}
+
+// redeemWSTicket returns the player ID of the ticket, which can't be used again
+func redeemWSTicket(ticket string) (int, error) {
+	wsTickets.mu.Lock()
+	defer wsTickets.mu.Unlock()
+
+	issued, found := wsTickets.tickets[ticket]
+	if !found {
+		return 0, errors.New("invalid socket ticket")
+	}
+	delete(wsTickets.tickets, ticket)
+
+	if time.Now().After(issued.expiresAt) {
+		return 0, errors.New("socket ticket expired")
+	}
+
+	return issued.playerID, nil
+}
+
+// AuthenticateWS authenticates a socket handshake before it's upgraded (with a ticket or a token)
+// On failure the error response is written (401, or 403 for account status errors) and false is returned
+func AuthenticateWS(w http.ResponseWriter, r *http.Request) (*models.Player, bool) {
+	var player *models.Player
+	var err error
+
+	if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+		var playerID int
+		playerID, err = redeemWSTicket(ticket)
+		if err == nil {
+			player, err = loadPlayer(playerID)
+		}
+	} else {
+		player, _, err = authenticate(r)
+	}
+
+	if err != nil {
+		recordUnauthorized(r, map[string]interface{}{"method": r.Method, "path": r.URL.Path, "reason": err.Error()})
+
+		statusCode, response := AuthErrorResponse(err)
+		helpers.WriteJSONResponse(w, statusCode, response)
+		return nil, false
+	}
+
+	return player, true
+}
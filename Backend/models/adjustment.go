@@ -0,0 +1,185 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Adjustment statuses
+const (
+	AdjustmentPending  = "pending"
+	AdjustmentApplied  = "applied"
+	AdjustmentRejected = "rejected"
+)
+
+var ErrAdjustmentInsufficientFunds = errors.New("debit exceeds the player's wallet balance")
+
+// Reason codes accepted for manual adjustments
+var AdjustmentReasonCodes = []string{"goodwill", "chargeback_correction", "bonus_correction", "technical_error", "other"}
+
+type BalanceAdjustment struct {
+	ID               int        `json:"id"`
+	PlayerID         int        `json:"playerId"`
+	Amount           float32    `json:"amount"`   // Positive credits the wallet, negative debits it
+	Currency         string     `json:"currency"` // Wallet it's applied to, whichever the player plays in when it's applied
+	ReasonCode       string     `json:"reasonCode"`
+	Note             string     `json:"note"`
+	Status           string     `json:"status"`
+	RequiresApproval bool       `json:"requiresApproval"` // Above the threshold a different admin must approve it
+	ProposedBy       int        `json:"proposedBy"`
+	ReviewedBy       *int       `json:"reviewedBy"`
+	ReviewNote       string     `json:"reviewNote"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	ReviewedAt       *time.Time `json:"reviewedAt"`
+}
+
+func IsValidAdjustmentReasonCode(reasonCode string) bool {
+	return slices.Contains(AdjustmentReasonCodes, reasonCode)
+}
+
+// CreateBalanceAdjustment stores a new pending adjustment and returns its ID
+func CreateBalanceAdjustment(adjustment BalanceAdjustment) (int, error) {
+	query := `INSERT INTO balance_adjustments (playerId, amount, currency, reasonCode, note, requiresApproval, proposedBy) 
+	          VALUES (?, ?, ?, ?, ?, ?, ?);`
+
+	result, err := DB.Exec(query, adjustment.PlayerID, roundToCents(adjustment.Amount), adjustment.Currency, adjustment.ReasonCode, adjustment.Note,
+		adjustment.RequiresApproval, adjustment.ProposedBy)
+	if err != nil {
+		return 0, fmt.Errorf("error creating adjustment: %v", err)
+	}
+
+	adjustmentID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("unable to retrieve last inserted ID: %v", err)
+	}
+
+	return int(adjustmentID), nil
+}
+
+func GetBalanceAdjustmentByID(id int) (*BalanceAdjustment, error) {
+	query := `SELECT id, playerId, amount, currency, reasonCode, note, status, requiresApproval, proposedBy, reviewedBy, reviewNote, createdAt, reviewedAt 
+	          FROM balance_adjustments WHERE id = ?;`
+
+	adjustment, err := scanBalanceAdjustment(DB.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("adjustment with ID %d not found", id)
+		}
+		return nil, fmt.Errorf("error fetching adjustment: %v", err)
+	}
+
+	return adjustment, nil
+}
+
+// GetBalanceAdjustments returns a page of adjustments, newest first
+// status and playerID are optional filters (empty / 0 to ignore them)
+func GetBalanceAdjustments(status string, playerID int, limit int, offset int) ([]BalanceAdjustment, error) {
+	query := `SELECT id, playerId, amount, currency, reasonCode, note, status, requiresApproval, proposedBy, reviewedBy, reviewNote, createdAt, reviewedAt 
+	          FROM balance_adjustments 
+	          WHERE (? = '' OR status = ?) AND (? = 0 OR playerId = ?) 
+	          ORDER BY id DESC LIMIT ? OFFSET ?;`
+
+	rows, err := DB.Query(query, status, status, playerID, playerID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching adjustments: %v", err)
+	}
+	defer rows.Close()
+
+	adjustments := []BalanceAdjustment{}
+	for rows.Next() {
+		adjustment, err := scanBalanceAdjustment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading adjustment: %v", err)
+		}
+		adjustments = append(adjustments, *adjustment)
+	}
+
+	return adjustments, rows.Err()
+}
+
+// ReviewBalanceAdjustment moves a pending adjustment to applied / rejected
+// Returns false if the adjustment was no longer pending (e.g. reviewed at the same time by someone else)
+func ReviewBalanceAdjustment(id int, status string, reviewerID *int, reviewNote string) (bool, error) {
+	query := `UPDATE balance_adjustments SET status = ?, reviewedBy = ?, reviewNote = ?, reviewedAt = CURRENT_TIMESTAMP 
+	          WHERE id = ? AND status = ?;`
+
+	result, err := DB.Exec(query, status, reviewerID, reviewNote, id, AdjustmentPending)
+	if err != nil {
+		return false, fmt.Errorf("error reviewing adjustment: %v", err)
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error reviewing adjustment: %v", err)
+	}
+
+	return affectedRows == 1, nil
+}
+
+// ApplyBalanceAdjustment marks a pending adjustment as applied and credits / debits the player's wallet in its currency,
+// the live one or a parked one, all or nothing. Returns false if it wasn't pending anymore, ErrAdjustmentInsufficientFunds
+// if a debit would leave the wallet negative
+func ApplyBalanceAdjustment(adjustment *BalanceAdjustment, reviewerID *int, reviewNote string) (bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Only one reviewer can move it out of pending
+	query := `UPDATE balance_adjustments SET status = ?, reviewedBy = ?, reviewNote = ?, reviewedAt = CURRENT_TIMESTAMP 
+	          WHERE id = ? AND status = ?;`
+	result, err := tx.Exec(query, AdjustmentApplied, reviewerID, reviewNote, adjustment.ID, AdjustmentPending)
+	if err != nil {
+		return false, fmt.Errorf("error reviewing adjustment: %v", err)
+	}
+	if updated, _ := result.RowsAffected(); updated != 1 {
+		return false, nil
+	}
+
+	wallet, betBalance, active, err := creditWallet(tx, adjustment.PlayerID, adjustment.Currency, adjustment.Amount)
+	if err != nil {
+		return false, err
+	}
+	if wallet < 0 {
+		return false, ErrAdjustmentInsufficientFunds
+	}
+
+	err = recordTransaction(tx, adjustment.PlayerID, TransactionAdjustment, adjustment.Amount, wallet, betBalance, fmt.Sprintf("adjustment:%d", adjustment.ID), adjustment.Currency)
+	if err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing adjustment: %v", err)
+	}
+
+	notifyWalletUpdate(adjustment.PlayerID, wallet, betBalance, active)
+
+	return true, nil
+}
+
+func scanBalanceAdjustment(row scanner) (*BalanceAdjustment, error) {
+	var adjustment BalanceAdjustment
+	var reviewedBy sql.NullInt64
+	var reviewedAt sql.NullTime
+
+	err := row.Scan(&adjustment.ID, &adjustment.PlayerID, &adjustment.Amount, &adjustment.Currency, &adjustment.ReasonCode, &adjustment.Note, &adjustment.Status,
+		&adjustment.RequiresApproval, &adjustment.ProposedBy, &reviewedBy, &adjustment.ReviewNote, &adjustment.CreatedAt, &reviewedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if reviewedBy.Valid {
+		reviewerID := int(reviewedBy.Int64)
+		adjustment.ReviewedBy = &reviewerID
+	}
+	if reviewedAt.Valid {
+		adjustment.ReviewedAt = &reviewedAt.Time
+	}
+
+	return &adjustment, nil
+}
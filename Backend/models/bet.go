@@ -0,0 +1,97 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type Bet struct {
+	ID         int       `json:"id"`
+	PlayerID   int       `json:"playerId"`
+	Game       string    `json:"game"`
+	BetType    string    `json:"betType"`
+	BetAmount  float32   `json:"betAmount"`
+	DiceNumber int       `json:"diceNumber"`
+	PlayerWin  bool      `json:"playerWin"`
+	Winnings   float32   `json:"winnings"`  // Positive on a win, minus the bet amount on a loss
+	FreeBetID  *int      `json:"freeBetId"` // Set when placed with a free bet (bet amount 0, winnings are only the profit)
+	Currency   string    `json:"currency"`  // Currency of the bet amount and winnings
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// RecordBet stores a settled bet and returns its ID
+func RecordBet(bet Bet) (int, error) {
+	query := `INSERT INTO bets (playerId, game, betType, betAmount, diceNumber, playerWin, winnings, freeBetId, currency, exchangeRate) 
+	          VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+
+	result, err := DB.Exec(query, bet.PlayerID, bet.Game, bet.BetType, bet.BetAmount, bet.DiceNumber, bet.PlayerWin, bet.Winnings, bet.FreeBetID,
+		bet.Currency, ExchangeRate(bet.Currency))
+	if err != nil {
+		return 0, fmt.Errorf("error recording bet: %v", err)
+	}
+
+	betID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("unable to retrieve last inserted ID: %v", err)
+	}
+
+	return int(betID), nil
+}
+
+// GetLastBetID returns the ID of the last settled bet (0 if there's none)
+func GetLastBetID() (int, error) {
+	var lastBetID int
+	err := DB.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM bets;`).Scan(&lastBetID)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching last bet: %v", err)
+	}
+
+	return lastBetID, nil
+}
+
+// GetBetsByPlayerID returns a page of the player's bets, newest first
+func GetBetsByPlayerID(playerID int, limit int, offset int) ([]Bet, error) {
+	query := `SELECT id, playerId, game, betType, betAmount, diceNumber, playerWin, winnings, freeBetId, currency, createdAt 
+	          FROM bets WHERE playerId = ? ORDER BY id DESC LIMIT ? OFFSET ?;`
+
+	rows, err := DB.Query(query, playerID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching bets: %v", err)
+	}
+	defer rows.Close()
+
+	bets := []Bet{}
+	for rows.Next() {
+		var bet Bet
+		var freeBetID sql.NullInt64
+		if err := rows.Scan(&bet.ID, &bet.PlayerID, &bet.Game, &bet.BetType, &bet.BetAmount, &bet.DiceNumber, &bet.PlayerWin, &bet.Winnings, &freeBetID, &bet.Currency, &bet.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error reading bet: %v", err)
+		}
+		if freeBetID.Valid {
+			id := int(freeBetID.Int64)
+			bet.FreeBetID = &id
+		}
+		bets = append(bets, bet)
+	}
+
+	return bets, rows.Err()
+}
+
+// GetBetSummarySince returns how many bets the player placed since the given time and their net result
+// (in the currency the player plays in, bets in other currencies converted)
+func GetBetSummarySince(playerID int, since time.Time) (int, float32, error) {
+	// Winnings on a win are the payout (stake included), on a loss minus the stake
+	query := `SELECT COUNT(*), COALESCE(SUM(CASE WHEN playerWin THEN winnings - betAmount ELSE winnings END * exchangeRate), 0)
+	                 / COALESCE((SELECT c.rate FROM players p JOIN currencies c ON c.code = p.currency WHERE p.id = ?1), 1)
+	          FROM bets WHERE playerId = ?1 AND createdAt >= ?2;`
+
+	var betCount int
+	var netResult float64
+	err := DB.QueryRow(query, playerID, since.UTC().Format(sqliteTimeFormat)).Scan(&betCount, &netResult)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error computing bet summary: %v", err)
+	}
+
+	return betCount, roundToCents(float32(netResult)), nil
+}
@@ -0,0 +1,208 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Bonus statuses
+const (
+	BonusActive    = "active"
+	BonusCompleted = "completed" // Wagering done, the balance was released to the bet balance
+	BonusExpired   = "expired"   // Wagering not done in time, the balance was removed
+	BonusForfeited = "forfeited" // Player withdrew before finishing the wagering, the balance was removed
+)
+
+type Bonus struct {
+	ID                  int        `json:"id"`
+	PlayerID            int        `json:"playerId"`
+	Amount              float32    `json:"amount"`              // Amount granted
+	Balance             float32    `json:"balance"`             // Bonus money left (stakes taken, winnings of bonus-funded stakes added), once closed what was released / removed
+	WageringRequirement float32    `json:"wageringRequirement"` // Total to stake before the balance is released
+	Wagered             float32    `json:"wagered"`             // Total staked towards the requirement
+	Status              string     `json:"status"`
+	Reference           string     `json:"reference"` // Where it came from, e.g. "admin:7"
+	ExpiresAt           time.Time  `json:"expiresAt"`
+	CreatedAt           time.Time  `json:"createdAt"`
+	ClosedAt            *time.Time `json:"closedAt"`
+}
+
+// Subquery of the player's bonus balance (active bonuses that didn't expire yet), used by playerColumns
+// Bonuses are kept in the base currency, the balance is converted to the currency the player plays in
+const bonusBalanceSubquery = `(SELECT ROUND(COALESCE(SUM(balance), 0) / COALESCE((SELECT rate FROM currencies WHERE code = players.currency), 1), 2)
+	FROM bonuses WHERE playerId = players.id AND status = 'active' AND expiresAt > CURRENT_TIMESTAMP)`
+
+// GrantBonus gives the player bonus money (in the base currency) that has to be staked wageringMultiplier times before it's released
+func GrantBonus(playerID int, amount float32, wageringMultiplier float32, expiresAt time.Time, reference string) (int, error) {
+	return grantBonus(DB, playerID, amount, wageringMultiplier, expiresAt, reference)
+}
+
+// grantBonus can run inside a transaction (e.g. promo redemptions)
+func grantBonus(db execer, playerID int, amount float32, wageringMultiplier float32, expiresAt time.Time, reference string) (int, error) {
+	amount = roundToCents(amount)
+
+	query := `INSERT INTO bonuses (playerId, amount, balance, wageringRequirement, reference, expiresAt)
+	          VALUES (?, ?, ?, ?, ?, ?);`
+
+	result, err := db.Exec(query, playerID, amount, amount, roundToCents(amount*wageringMultiplier), reference, expiresAt.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return 0, fmt.Errorf("error granting bonus: %v", err)
+	}
+
+	bonusID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("unable to retrieve last inserted ID: %v", err)
+	}
+
+	return int(bonusID), nil
+}
+
+// GetPlayerBonuses returns every bonus of the player, newest first
+func GetPlayerBonuses(playerID int) ([]Bonus, error) {
+	if err := expirePlayerBonuses(playerID); err != nil {
+		return nil, err
+	}
+
+	return queryBonuses(`SELECT `+bonusColumns+` FROM bonuses WHERE playerId = ? ORDER BY id DESC;`, playerID)
+}
+
+// GetBonusBalance returns the total balance of the player's active bonuses
+func GetBonusBalance(playerID int) (float32, error) {
+	var bonusBalance float32
+	err := DB.QueryRow(`SELECT `+bonusBalanceSubquery+` FROM players WHERE id = ?;`, playerID).Scan(&bonusBalance)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching bonus balance: %v", err)
+	}
+
+	return bonusBalance, nil
+}
+
+// SettleBonusBet updates the active bonuses (oldest first) after a bet:
+// the bonus-funded part of the stake is taken from their balances, the payout of that part is added back to them,
+// and the whole stake counts towards their wagering requirements (what's left over rolls over to the next bonus)
+// Amounts are in the base currency. Bonuses whose wagering is done are completed, returns the amount they released (to add to the bet balance)
+func SettleBonusBet(playerID int, stake float32, bonusStake float32, bonusPayout float32) (float32, error) {
+	bonuses, err := getActiveBonuses(playerID)
+	if err != nil {
+		return 0, err
+	}
+
+	remainingBonusStake := bonusStake
+	remainingWager := stake
+	var released float32
+
+	for _, bonus := range bonuses {
+		// Bonus-funded stake and its share of the payout
+		if remainingBonusStake > 0 && bonus.Balance > 0 {
+			taken := min(bonus.Balance, remainingBonusStake)
+			remainingBonusStake -= taken
+			bonus.Balance += bonusPayout*taken/bonusStake - taken
+		}
+
+		// Wagering progress
+		if remainingWager > 0 {
+			counted := min(bonus.WageringRequirement-bonus.Wagered, remainingWager)
+			remainingWager -= counted
+			bonus.Wagered += counted
+		}
+
+		status := BonusActive
+		if bonus.Wagered >= bonus.WageringRequirement {
+			status = BonusCompleted
+			released += roundToCents(bonus.Balance)
+		}
+
+		if err := updateBonus(bonus.ID, bonus.Balance, bonus.Wagered, status); err != nil {
+			return 0, err
+		}
+	}
+
+	return released, nil
+}
+
+// forfeitPlayerBonuses removes every active bonus of the player inside a transaction (withdrawal before the wagering was done)
+// Expired bonuses must be closed before (expirePlayerBonuses), returns the forfeited bonus money
+func forfeitPlayerBonuses(tx *sql.Tx, playerID int) (float32, error) {
+	var forfeited float32
+	err := tx.QueryRow(`SELECT COALESCE(SUM(balance), 0) FROM bonuses WHERE playerId = ? AND status = ?;`, playerID, BonusActive).Scan(&forfeited)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching bonuses: %v", err)
+	}
+
+	_, err = tx.Exec(`UPDATE bonuses SET status = ?, closedAt = CURRENT_TIMESTAMP WHERE playerId = ? AND status = ?;`, BonusForfeited, playerID, BonusActive)
+	if err != nil {
+		return 0, fmt.Errorf("error forfeiting bonuses: %v", err)
+	}
+
+	return roundToCents(forfeited), nil
+}
+
+// Columns read by scanBonus, in order
+const bonusColumns = `id, playerId, amount, balance, wageringRequirement, wagered, status, reference, expiresAt, createdAt, closedAt`
+
+func scanBonus(row scanner) (*Bonus, error) {
+	var bonus Bonus
+	var closedAt sql.NullTime
+	err := row.Scan(&bonus.ID, &bonus.PlayerID, &bonus.Amount, &bonus.Balance, &bonus.WageringRequirement, &bonus.Wagered, &bonus.Status, &bonus.Reference,
+		&bonus.ExpiresAt, &bonus.CreatedAt, &closedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if closedAt.Valid {
+		bonus.ClosedAt = &closedAt.Time
+	}
+
+	return &bonus, nil
+}
+
+func queryBonuses(query string, args ...any) ([]Bonus, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching bonuses: %v", err)
+	}
+	defer rows.Close()
+
+	bonuses := []Bonus{}
+	for rows.Next() {
+		bonus, err := scanBonus(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading bonus: %v", err)
+		}
+		bonuses = append(bonuses, *bonus)
+	}
+
+	return bonuses, rows.Err()
+}
+
+// getActiveBonuses returns the player's active bonuses, oldest first (the order they're used in)
+func getActiveBonuses(playerID int) ([]Bonus, error) {
+	if err := expirePlayerBonuses(playerID); err != nil {
+		return nil, err
+	}
+
+	return queryBonuses(`SELECT `+bonusColumns+` FROM bonuses WHERE playerId = ? AND status = ? ORDER BY id;`, playerID, BonusActive)
+}
+
+// expirePlayerBonuses closes the active bonuses whose expiry passed
+// (the bonus balance already ignores them, this only keeps the statuses right)
+func expirePlayerBonuses(playerID int) error {
+	query := `UPDATE bonuses SET status = ?, closedAt = CURRENT_TIMESTAMP WHERE playerId = ? AND status = ? AND expiresAt <= CURRENT_TIMESTAMP;`
+	_, err := DB.Exec(query, BonusExpired, playerID, BonusActive)
+	if err != nil {
+		return fmt.Errorf("error expiring bonuses: %v", err)
+	}
+
+	return nil
+}
+
+func updateBonus(id int, balance float32, wagered float32, status string) error {
+	query := `UPDATE bonuses SET balance = ?, wagered = ?, status = ?, closedAt = CASE WHEN ? = 'active' THEN NULL ELSE CURRENT_TIMESTAMP END WHERE id = ?;`
+	_, err := DB.Exec(query, roundToCents(max(balance, 0)), roundToCents(wagered), status, status, id)
+	if err != nil {
+		return fmt.Errorf("error updating bonus: %v", err)
+	}
+
+	return nil
+}
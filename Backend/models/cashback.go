@@ -0,0 +1,181 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Cashback destinations
+const (
+	CashbackToBonus = "bonus" // Granted as a bonus with the usual wagering requirements
+	CashbackToCash  = "cash"  // Added to the wallet
+)
+
+// Cashback statuses
+const (
+	CashbackPending = "pending" // Computed but not credited yet (retried by the next run)
+	CashbackPaid    = "paid"
+)
+
+var CashbackDestinations = []string{CashbackToBonus, CashbackToCash}
+
+type Cashback struct {
+	ID          int        `json:"id"`
+	PlayerID    int        `json:"playerId"`
+	PeriodStart time.Time  `json:"periodStart"`
+	PeriodEnd   time.Time  `json:"periodEnd"`
+	NetLoss     float32    `json:"netLoss"`
+	Rate        float32    `json:"rate"` // % of the net loss, from the player's VIP tier
+	Amount      float32    `json:"amount"`
+	Destination string     `json:"destination"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	PaidAt      *time.Time `json:"paidAt"`
+}
+
+// PlayerNetLoss is a player's net loss over a cashback period
+type PlayerNetLoss struct {
+	PlayerID int
+	NetLoss  float32
+}
+
+// LastCompletedWeek returns the last full calendar week (UTC, from Monday) before now
+func LastCompletedWeek(now time.Time) (time.Time, time.Time) {
+	end := periodStart(PeriodWeekly, now)
+	return end.AddDate(0, 0, -7), end
+}
+
+// GetNetLosses returns the players who lost money on their settled bets between start and end, with their net loss in the base currency
+// Players already paid for the period are left out
+func GetNetLosses(start time.Time, end time.Time) ([]PlayerNetLoss, error) {
+	// Winnings on a win are the payout (stake included), on a loss minus the stake
+	query := `SELECT playerId, SUM(CASE WHEN playerWin THEN betAmount - winnings ELSE betAmount END * exchangeRate) AS netLoss
+	          FROM bets
+	          WHERE createdAt >= ? AND createdAt < ?
+	            AND playerId NOT IN (SELECT playerId FROM cashbacks WHERE periodStart = ? AND status = ?)
+	          GROUP BY playerId
+	          HAVING netLoss > 0
+	          ORDER BY playerId;`
+
+	startText, endText := start.UTC().Format(sqliteTimeFormat), end.UTC().Format(sqliteTimeFormat)
+	rows, err := DB.Query(query, startText, endText, startText, CashbackPaid)
+	if err != nil {
+		return nil, fmt.Errorf("error computing net losses: %v", err)
+	}
+	defer rows.Close()
+
+	netLosses := []PlayerNetLoss{}
+	for rows.Next() {
+		var netLoss PlayerNetLoss
+		var amount float64
+		if err := rows.Scan(&netLoss.PlayerID, &amount); err != nil {
+			return nil, fmt.Errorf("error reading net loss: %v", err)
+		}
+		netLoss.NetLoss = roundToCents(float32(amount))
+		netLosses = append(netLosses, netLoss)
+	}
+
+	return netLosses, rows.Err()
+}
+
+// CreateCashback records the player's cashback for the period, or returns the one already recorded
+// (a run that stopped half way computed it already, its amount is kept)
+func CreateCashback(cashback Cashback) (*Cashback, error) {
+	query := `INSERT INTO cashbacks (playerId, periodStart, periodEnd, netLoss, rate, amount, destination)
+	          VALUES (?, ?, ?, ?, ?, ?, ?)
+	          ON CONFLICT (playerId, periodStart) DO NOTHING;`
+
+	periodStartText := cashback.PeriodStart.UTC().Format(sqliteTimeFormat)
+	_, err := DB.Exec(query, cashback.PlayerID, periodStartText, cashback.PeriodEnd.UTC().Format(sqliteTimeFormat),
+		roundToCents(cashback.NetLoss), cashback.Rate, roundToCents(cashback.Amount), cashback.Destination)
+	if err != nil {
+		return nil, fmt.Errorf("error recording cashback: %v", err)
+	}
+
+	query = `SELECT ` + cashbackColumns + ` FROM cashbacks WHERE playerId = ? AND periodStart = ?;`
+	return scanCashback(DB.QueryRow(query, cashback.PlayerID, periodStartText))
+}
+
+// GetPendingCashbacks returns the cashbacks of the periods before the given one that weren't paid yet, oldest first
+func GetPendingCashbacks(before time.Time) ([]Cashback, error) {
+	query := `SELECT ` + cashbackColumns + ` FROM cashbacks WHERE status = ? AND periodStart < ? ORDER BY periodStart, id;`
+	rows, err := DB.Query(query, CashbackPending, before.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching pending cashbacks: %v", err)
+	}
+	defer rows.Close()
+
+	cashbacks := []Cashback{}
+	for rows.Next() {
+		cashback, err := scanCashback(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading cashback: %v", err)
+		}
+		cashbacks = append(cashbacks, *cashback)
+	}
+
+	return cashbacks, rows.Err()
+}
+
+// ClaimCashback marks the cashback as paid before crediting it, returns false if it was already paid
+// Claiming first means a crash can't pay it twice
+func ClaimCashback(id int) (bool, error) {
+	query := `UPDATE cashbacks SET status = ?, paidAt = CURRENT_TIMESTAMP WHERE id = ? AND status = ?;`
+	result, err := DB.Exec(query, CashbackPaid, id, CashbackPending)
+	if err != nil {
+		return false, fmt.Errorf("error updating cashback: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected == 1, nil
+}
+
+// ReleaseCashbackClaim reverts ClaimCashback when the cashback couldn't be credited
+func ReleaseCashbackClaim(id int) error {
+	_, err := DB.Exec(`UPDATE cashbacks SET status = ?, paidAt = NULL WHERE id = ?;`, CashbackPending, id)
+	if err != nil {
+		return fmt.Errorf("error updating cashback: %v", err)
+	}
+
+	return nil
+}
+
+// GetPlayerCashbacks returns the player's cashbacks, newest first
+func GetPlayerCashbacks(playerID int) ([]Cashback, error) {
+	rows, err := DB.Query(`SELECT `+cashbackColumns+` FROM cashbacks WHERE playerId = ? ORDER BY periodStart DESC;`, playerID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching cashbacks: %v", err)
+	}
+	defer rows.Close()
+
+	cashbacks := []Cashback{}
+	for rows.Next() {
+		cashback, err := scanCashback(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading cashback: %v", err)
+		}
+		cashbacks = append(cashbacks, *cashback)
+	}
+
+	return cashbacks, rows.Err()
+}
+
+// Columns read by scanCashback, in order
+const cashbackColumns = `id, playerId, periodStart, periodEnd, netLoss, rate, amount, destination, status, createdAt, paidAt`
+
+func scanCashback(row scanner) (*Cashback, error) {
+	var cashback Cashback
+	var paidAt sql.NullTime
+	err := row.Scan(&cashback.ID, &cashback.PlayerID, &cashback.PeriodStart, &cashback.PeriodEnd, &cashback.NetLoss, &cashback.Rate,
+		&cashback.Amount, &cashback.Destination, &cashback.Status, &cashback.CreatedAt, &paidAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if paidAt.Valid {
+		cashback.PaidAt = &paidAt.Time
+	}
+
+	return &cashback, nil
+}
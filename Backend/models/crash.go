@@ -0,0 +1,341 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Crash round statuses
+const (
+	CrashBetting   = "betting"   // Taking bets until startsAt
+	CrashRunning   = "running"   // Multiplier rising, players can cash out
+	CrashCrashed   = "crashed"   // Reached the crash point, bets not cashed out are lost
+	CrashCancelled = "cancelled" // Never finished (server stopped), the stakes were given back
+)
+
+// Crash bet statuses
+const (
+	CrashBetPlaced    = "placed"     // Stake taken, riding the multiplier
+	CrashBetCashedOut = "cashed_out" // Cashed out at cashoutMultiplier
+	CrashBetLost      = "lost"       // Still riding when the round crashed
+	CrashBetRefunded  = "refunded"   // Stake given back
+)
+
+// Highest crash point of a round
+const CrashMaxMultiplier = 10000
+
+type CrashRound struct {
+	ID         int        `json:"id"`
+	SeedHash   string     `json:"seedHash"`   // SHA-256 of the server seed, published when the round opens
+	ServerSeed string     `json:"serverSeed"` // Revealed once the round is over
+	CrashPoint *float32   `json:"crashPoint"` // Revealed once the round is over
+	Status     string     `json:"status"`
+	StartsAt   time.Time  `json:"startsAt"`
+	CrashedAt  *time.Time `json:"crashedAt"`
+}
+
+type CrashBet struct {
+	ID                int       `json:"-"`
+	RoundID           int       `json:"roundId"`
+	PlayerID          int       `json:"-"` // Crash bets are public
+	Name              string    `json:"name"`
+	BetAmount         float32   `json:"betAmount"`
+	WalletStake       float32   `json:"-"` // Part of the stake taken from the wallet (the rest from the bet balance)
+	FreeBetID         *int      `json:"-"`
+	AutoCashout       *float32  `json:"autoCashout"`
+	CashoutMultiplier *float32  `json:"cashoutMultiplier"`
+	Status            string    `json:"status"`
+	CreatedAt         time.Time `json:"createdAt"`
+}
+
+// NewCrashSeed returns a random server seed and its SHA-256 hash (the commitment shown to players)
+func NewCrashSeed() (string, string, error) {
+	seed := make([]byte, 32)
+	if _, err := rand.Read(seed); err != nil {
+		return "", "", fmt.Errorf("error generating server seed: %v", err)
+	}
+
+	serverSeed := hex.EncodeToString(seed)
+	hash := sha256.Sum256([]byte(serverSeed))
+
+	return serverSeed, hex.EncodeToString(hash[:]), nil
+}
+
+// CrashPoint derives the crash point of a round from its server seed, so players can check it once the seed is revealed:
+// h = first 52 bits of HMAC-SHA256(key = server seed, message = "crash"),
+// crash point = floor(100 * (1 - houseEdge / 100) * 2^52 / (2^52 - h)) / 100, at least 1.00 and at most CrashMaxMultiplier
+func CrashPoint(serverSeed string, houseEdge float32) float32 {
+	mac := hmac.New(sha256.New, []byte(serverSeed))
+	mac.Write([]byte("crash"))
+	h, _ := strconv.ParseUint(hex.EncodeToString(mac.Sum(nil))[:13], 16, 64)
+
+	e := math.Pow(2, 52)
+	crashPoint := math.Floor(100*(1-float64(houseEdge)/100)*e/(e-float64(h))) / 100
+
+	return float32(min(max(crashPoint, 1), CrashMaxMultiplier))
+}
+
+// CreateCrashRound opens a round taking bets until startsAt and returns its ID
+func CreateCrashRound(serverSeed string, seedHash string, crashPoint float32, startsAt time.Time) (int, error) {
+	query := `INSERT INTO crash_rounds (serverSeed, seedHash, crashPoint, startsAt) VALUES (?, ?, ?, ?);`
+	result, err := DB.Exec(query, serverSeed, seedHash, crashPoint, startsAt.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return 0, fmt.Errorf("error creating crash round: %v", err)
+	}
+
+	roundID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("unable to retrieve last inserted ID: %v", err)
+	}
+
+	return int(roundID), nil
+}
+
+// StartCrashRound closes the bets of the round and starts its multiplier
+func StartCrashRound(id int) error {
+	_, err := DB.Exec(`UPDATE crash_rounds SET status = ? WHERE id = ? AND status = ?;`, CrashRunning, id, CrashBetting)
+	if err != nil {
+		return fmt.Errorf("error starting crash round: %v", err)
+	}
+
+	return nil
+}
+
+// CrashRoundOver marks the round as crashed and its bets that weren't cashed out as lost
+func CrashRoundOver(id int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE crash_rounds SET status = ?, crashedAt = CURRENT_TIMESTAMP WHERE id = ? AND status = ?;`, CrashCrashed, id, CrashRunning)
+	if err != nil {
+		return fmt.Errorf("error crashing round: %v", err)
+	}
+
+	_, err = tx.Exec(`UPDATE crash_bets SET status = ? WHERE roundId = ? AND status = ?;`, CrashBetLost, id, CrashBetPlaced)
+	if err != nil {
+		return fmt.Errorf("error updating crash bets: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// CancelUnfinishedCrashRounds cancels the rounds left betting / running by a stopped server and returns their bets
+// still riding to refund (bets cashed out before the stop are settled as usual)
+func CancelUnfinishedCrashRounds() ([]CrashBet, error) {
+	_, err := DB.Exec(`UPDATE crash_rounds SET status = ? WHERE status IN (?, ?);`, CrashCancelled, CrashBetting, CrashRunning)
+	if err != nil {
+		return nil, fmt.Errorf("error cancelling crash rounds: %v", err)
+	}
+
+	query := `SELECT ` + crashBetColumns + ` FROM crash_bets b JOIN crash_rounds r ON r.id = b.roundId JOIN players p ON p.id = b.playerId
+	          WHERE r.status = ? AND b.status = ? ORDER BY b.id;`
+	return queryCrashBets(query, CrashCancelled, CrashBetPlaced)
+}
+
+// GetCrashRounds returns a page of the rounds, the latest first (seed and crash point hidden until the round is over)
+func GetCrashRounds(limit int, offset int) ([]CrashRound, error) {
+	query := `SELECT id, seedHash, serverSeed, crashPoint, status, startsAt, crashedAt FROM crash_rounds ORDER BY id DESC LIMIT ? OFFSET ?;`
+	rows, err := DB.Query(query, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching crash rounds: %v", err)
+	}
+	defer rows.Close()
+
+	rounds := []CrashRound{}
+	for rows.Next() {
+		var round CrashRound
+		var crashPoint float32
+		var crashedAt sql.NullTime
+		if err := rows.Scan(&round.ID, &round.SeedHash, &round.ServerSeed, &crashPoint, &round.Status, &round.StartsAt, &crashedAt); err != nil {
+			return nil, fmt.Errorf("error reading crash round: %v", err)
+		}
+
+		if round.Status == CrashCrashed || round.Status == CrashCancelled {
+			round.CrashPoint = &crashPoint
+		} else {
+			round.ServerSeed = ""
+		}
+		if crashedAt.Valid {
+			round.CrashedAt = &crashedAt.Time
+		}
+		rounds = append(rounds, round)
+	}
+
+	return rounds, rows.Err()
+}
+
+// PlaceCrashBet records a bet whose stake was taken and returns its ID, a player can bet once per round
+func PlaceCrashBet(bet CrashBet) (int, error) {
+	query := `INSERT INTO crash_bets (roundId, playerId, betAmount, walletStake, freeBetId, autoCashout) VALUES (?, ?, ?, ?, ?, ?);`
+	result, err := DB.Exec(query, bet.RoundID, bet.PlayerID, roundToCents(bet.BetAmount), roundToCents(bet.WalletStake), bet.FreeBetID, bet.AutoCashout)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return 0, fmt.Errorf("player already placed a bet on this round")
+		}
+		return 0, fmt.Errorf("error placing crash bet: %v", err)
+	}
+
+	betID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("unable to retrieve last inserted ID: %v", err)
+	}
+
+	return int(betID), nil
+}
+
+// Columns read by scanCrashBet, in order (b = crash_bets, p = players)
+const crashBetColumns = `b.id, b.roundId, b.playerId, CASE WHEN p.maskName THEN '' ELSE p.name END, p.name, b.betAmount, b.walletStake, b.freeBetId, b.autoCashout, b.cashoutMultiplier, b.status, b.createdAt`
+
+func scanCrashBet(row scanner) (*CrashBet, error) {
+	var bet CrashBet
+	var publicName, name string
+	var freeBetID sql.NullInt64
+	var autoCashout, cashoutMultiplier sql.NullFloat64
+	err := row.Scan(&bet.ID, &bet.RoundID, &bet.PlayerID, &publicName, &name, &bet.BetAmount, &bet.WalletStake, &freeBetID, &autoCashout, &cashoutMultiplier, &bet.Status, &bet.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Players who asked for it are masked like on the leaderboards
+	bet.Name = publicName
+	if publicName == "" {
+		bet.Name = MaskPlayerName(name)
+	}
+	if freeBetID.Valid {
+		id := int(freeBetID.Int64)
+		bet.FreeBetID = &id
+	}
+	if autoCashout.Valid {
+		multiplier := float32(autoCashout.Float64)
+		bet.AutoCashout = &multiplier
+	}
+	if cashoutMultiplier.Valid {
+		multiplier := float32(cashoutMultiplier.Float64)
+		bet.CashoutMultiplier = &multiplier
+	}
+
+	return &bet, nil
+}
+
+func GetCrashBetByID(id int) (*CrashBet, error) {
+	query := `SELECT ` + crashBetColumns + ` FROM crash_bets b JOIN players p ON p.id = b.playerId WHERE b.id = ?;`
+	bet, err := scanCrashBet(DB.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("crash bet with ID %d not found", id)
+		}
+		return nil, fmt.Errorf("error fetching crash bet: %v", err)
+	}
+
+	return bet, nil
+}
+
+// GetUnsettledCrashBets returns the cashed out / lost bets whose settlement failed or was interrupted
+func GetUnsettledCrashBets() ([]CrashBet, error) {
+	query := `SELECT ` + crashBetColumns + ` FROM crash_bets b JOIN players p ON p.id = b.playerId
+	          WHERE b.status IN (?, ?) AND b.settledAt IS NULL ORDER BY b.id;`
+	return queryCrashBets(query, CrashBetCashedOut, CrashBetLost)
+}
+
+func queryCrashBets(query string, args ...any) ([]CrashBet, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching crash bets: %v", err)
+	}
+	defer rows.Close()
+
+	bets := []CrashBet{}
+	for rows.Next() {
+		bet, err := scanCrashBet(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading crash bet: %v", err)
+		}
+		bets = append(bets, *bet)
+	}
+
+	return bets, rows.Err()
+}
+
+// CashOutCrashBet stops a riding bet at the multiplier, returns false if it wasn't riding anymore
+func CashOutCrashBet(id int, multiplier float32) (bool, error) {
+	result, err := DB.Exec(`UPDATE crash_bets SET status = ?, cashoutMultiplier = ? WHERE id = ? AND status = ?;`, CrashBetCashedOut, multiplier, id, CrashBetPlaced)
+	if err != nil {
+		return false, fmt.Errorf("error cashing out crash bet: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected == 1, nil
+}
+
+// ClaimCrashBetSettlement marks a cashed out / lost bet as settled before its balance update, returns false if it already was
+func ClaimCrashBetSettlement(id int) (bool, error) {
+	result, err := DB.Exec(`UPDATE crash_bets SET settledAt = CURRENT_TIMESTAMP WHERE id = ? AND status IN (?, ?) AND settledAt IS NULL;`, id, CrashBetCashedOut, CrashBetLost)
+	if err != nil {
+		return false, fmt.Errorf("error updating crash bet: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected == 1, nil
+}
+
+// ReleaseCrashBetSettlement reverts ClaimCrashBetSettlement when the balance update failed
+func ReleaseCrashBetSettlement(id int) error {
+	_, err := DB.Exec(`UPDATE crash_bets SET settledAt = NULL WHERE id = ?;`, id)
+	if err != nil {
+		return fmt.Errorf("error updating crash bet: %v", err)
+	}
+
+	return nil
+}
+
+// SetCrashBetBet links a settled crash bet to the bet recorded for it
+func SetCrashBetBet(id int, betID int) error {
+	_, err := DB.Exec(`UPDATE crash_bets SET betId = ? WHERE id = ?;`, betID, id)
+	if err != nil {
+		return fmt.Errorf("error updating crash bet: %v", err)
+	}
+
+	return nil
+}
+
+// ClaimCrashBetRefund marks a riding bet as refunded before its stake is given back, returns false if it wasn't riding anymore
+func ClaimCrashBetRefund(id int) (bool, error) {
+	result, err := DB.Exec(`UPDATE crash_bets SET status = ? WHERE id = ? AND status = ?;`, CrashBetRefunded, id, CrashBetPlaced)
+	if err != nil {
+		return false, fmt.Errorf("error updating crash bet: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected == 1, nil
+}
+
+// ReleaseCrashBetRefund reverts ClaimCrashBetRefund when the refund failed
+func ReleaseCrashBetRefund(id int) error {
+	_, err := DB.Exec(`UPDATE crash_bets SET status = ? WHERE id = ? AND status = ?;`, CrashBetPlaced, id, CrashBetRefunded)
+	if err != nil {
+		return fmt.Errorf("error updating crash bet: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteCrashBet removes a bet whose stake couldn't be saved
+func DeleteCrashBet(id int) error {
+	_, err := DB.Exec(`DELETE FROM crash_bets WHERE id = ? AND status = ?;`, id, CrashBetPlaced)
+	if err != nil {
+		return fmt.Errorf("error deleting crash bet: %v", err)
+	}
+
+	return nil
+}
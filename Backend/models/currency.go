@@ -0,0 +1,290 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Error codes sent to clients when a bet is outside its currency's bet limits
+const (
+	CurrencyMinBet = "CURRENCY_MIN_BET"
+	CurrencyMaxBet = "CURRENCY_MAX_BET"
+)
+
+// Rates and bet limits used until an admin sets them (unknown currencies start at 1)
+var defaultCurrencies = map[string]Currency{
+	"EUR": {Rate: 1, MinBet: 0.1, MaxBet: 10000},
+	"USD": {Rate: 0.92, MinBet: 0.1, MaxBet: 10000},
+	"BRL": {Rate: 0.17, MinBet: 0.5, MaxBet: 50000},
+}
+
+type Currency struct {
+	Code      string    `json:"code"`
+	Rate      float32   `json:"rate"`   // Value of one unit in the base currency
+	MinBet    float32   `json:"minBet"` // 0 = no minimum
+	MaxBet    float32   `json:"maxBet"` // 0 = no maximum
+	IsBase    bool      `json:"isBase"`
+	UpdatedBy *int      `json:"updatedBy"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// The currencies are read on every bet and conversion, they're kept in memory and only changed through UpdateCurrency
+var currencies = struct {
+	mu     sync.RWMutex
+	base   string
+	byCode map[string]Currency
+}{byCode: make(map[string]Currency)}
+
+// SeedCurrencies adds the configured currencies that aren't in the table yet, loads them
+// and moves the players and money records from before multi-currency to the base currency
+func SeedCurrencies(base string, codes []string) error {
+	for _, code := range codes {
+		currency, found := defaultCurrencies[code]
+		if !found {
+			currency = Currency{Rate: 1}
+		}
+		if code == base {
+			currency.Rate = 1
+		}
+
+		_, err := DB.Exec(`INSERT OR IGNORE INTO currencies (code, rate, minBet, maxBet) VALUES (?, ?, ?, ?);`, code, currency.Rate, currency.MinBet, currency.MaxBet)
+		if err != nil {
+			return fmt.Errorf("error seeding currency %s: %v", code, err)
+		}
+	}
+
+	// The base currency is what everything is converted to
+	if _, err := DB.Exec(`UPDATE currencies SET rate = 1 WHERE code = ? AND rate != 1;`, base); err != nil {
+		return fmt.Errorf("error seeding base currency: %v", err)
+	}
+
+	for _, table := range []string{"players", "bets", "transactions", "payments", "withdrawals", "transfers", "balance_adjustments"} {
+		if _, err := DB.Exec(fmt.Sprintf(`UPDATE %s SET currency = ? WHERE currency = '';`, table), base); err != nil {
+			return fmt.Errorf("error setting the currency of %s: %v", table, err)
+		}
+	}
+
+	rows, err := DB.Query(`SELECT code, rate, minBet, maxBet, updatedBy, updatedAt FROM currencies;`)
+	if err != nil {
+		return fmt.Errorf("error fetching currencies: %v", err)
+	}
+	defer rows.Close()
+
+	loaded := make(map[string]Currency)
+	for rows.Next() {
+		currency, err := scanCurrency(rows)
+		if err != nil {
+			return fmt.Errorf("error reading currency: %v", err)
+		}
+		// Currencies removed from the configuration stay in the table for the records, but can't be used anymore
+		for _, code := range codes {
+			if code == currency.Code {
+				currency.IsBase = code == base
+				loaded[code] = *currency
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	currencies.mu.Lock()
+	currencies.base, currencies.byCode = base, loaded
+	currencies.mu.Unlock()
+
+	return nil
+}
+
+func scanCurrency(row scanner) (*Currency, error) {
+	var currency Currency
+	var updatedBy sql.NullInt64
+	if err := row.Scan(&currency.Code, &currency.Rate, &currency.MinBet, &currency.MaxBet, &updatedBy, &currency.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if updatedBy.Valid {
+		adminID := int(updatedBy.Int64)
+		currency.UpdatedBy = &adminID
+	}
+
+	return &currency, nil
+}
+
+// GetCurrencies returns the currencies in use, the base currency first
+func GetCurrencies() []Currency {
+	currencies.mu.RLock()
+	defer currencies.mu.RUnlock()
+
+	list := make([]Currency, 0, len(currencies.byCode))
+	for _, currency := range currencies.byCode {
+		list = append(list, currency)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].IsBase != list[j].IsBase {
+			return list[i].IsBase
+		}
+		return list[i].Code < list[j].Code
+	})
+
+	return list
+}
+
+// BaseCurrency returns the currency everything is converted to for reporting and limits
+func BaseCurrency() string {
+	currencies.mu.RLock()
+	defer currencies.mu.RUnlock()
+
+	return currencies.base
+}
+
+// GetCurrency returns a currency in use, false if it isn't one
+func GetCurrency(code string) (Currency, bool) {
+	currencies.mu.RLock()
+	defer currencies.mu.RUnlock()
+
+	currency, found := currencies.byCode[code]
+	return currency, found
+}
+
+func IsSupportedCurrency(code string) bool {
+	_, found := GetCurrency(code)
+	return found
+}
+
+// UpdateCurrency sets a currency's exchange rate (ignored for the base currency) and bet limits
+func UpdateCurrency(code string, rate float32, minBet float32, maxBet float32, updatedBy int) (*Currency, error) {
+	current, found := GetCurrency(code)
+	if !found {
+		return nil, fmt.Errorf("currency %s is not supported", code)
+	}
+	if current.IsBase {
+		rate = 1
+	}
+
+	query := `UPDATE currencies SET rate = ?, minBet = ?, maxBet = ?, updatedBy = ?, updatedAt = CURRENT_TIMESTAMP WHERE code = ?
+	          RETURNING code, rate, minBet, maxBet, updatedBy, updatedAt;`
+	currency, err := scanCurrency(DB.QueryRow(query, rate, roundToCents(minBet), roundToCents(maxBet), updatedBy, code))
+	if err != nil {
+		return nil, fmt.Errorf("error updating currency: %v", err)
+	}
+	currency.IsBase = current.IsBase
+
+	currencies.mu.Lock()
+	currencies.byCode[code] = *currency
+	currencies.mu.Unlock()
+
+	return currency, nil
+}
+
+// ExchangeRate returns the value of one unit of the currency in the base currency (1 for unknown currencies)
+func ExchangeRate(code string) float32 {
+	if currency, found := GetCurrency(code); found {
+		return currency.Rate
+	}
+
+	return 1
+}
+
+// ToBaseCurrency converts an amount in the currency to the base currency
+func ToBaseCurrency(amount float32, code string) float32 {
+	return roundToCents(amount * ExchangeRate(code))
+}
+
+// FromBaseCurrency converts an amount in the base currency to the currency
+func FromBaseCurrency(amount float32, code string) float32 {
+	return roundToCents(amount / ExchangeRate(code))
+}
+
+// CurrencyBetLimitError is returned when a bet is outside its currency's min / max bet
+type CurrencyBetLimitError struct {
+	Code     string // CurrencyMinBet or CurrencyMaxBet
+	Currency string
+	Limit    float32
+}
+
+func (e *CurrencyBetLimitError) Error() string {
+	if e.Code == CurrencyMinBet {
+		return fmt.Sprintf("%s minimum bet is %.2f", e.Currency, e.Limit)
+	}
+	return fmt.Sprintf("%s maximum bet is %.2f", e.Currency, e.Limit)
+}
+
+// CheckCurrencyBetLimits returns a CurrencyBetLimitError if the bet is outside its currency's min / max bet
+func CheckCurrencyBetLimits(code string, betAmount float32) error {
+	currency, found := GetCurrency(code)
+	if !found {
+		return fmt.Errorf("currency %s is not supported", code)
+	}
+
+	if currency.MinBet > 0 && betAmount < currency.MinBet {
+		return &CurrencyBetLimitError{Code: CurrencyMinBet, Currency: code, Limit: currency.MinBet}
+	}
+	if currency.MaxBet > 0 && betAmount > currency.MaxBet {
+		return &CurrencyBetLimitError{Code: CurrencyMaxBet, Currency: code, Limit: currency.MaxBet}
+	}
+
+	return nil
+}
+
+// CurrencyTotals are the money movements in one currency over a period, as is and in the base currency
+// (at the rate of each movement)
+type CurrencyTotals struct {
+	Currency         string  `json:"currency"`
+	Wagered          float32 `json:"wagered"`
+	WageredBase      float32 `json:"wageredBase"`
+	PaidOut          float32 `json:"paidOut"` // Payouts of won bets
+	PaidOutBase      float32 `json:"paidOutBase"`
+	Deposits         float32 `json:"deposits"`
+	DepositsBase     float32 `json:"depositsBase"`
+	Withdrawals      float32 `json:"withdrawals"` // Paid or still pending, cancelled / rejected ones excluded
+	WithdrawalsBase  float32 `json:"withdrawalsBase"`
+	GrossRevenue     float32 `json:"grossRevenue"` // Wagered minus paid out
+	GrossRevenueBase float32 `json:"grossRevenueBase"`
+}
+
+// GetCurrencyReport returns the totals of every currency with movements since the given time
+func GetCurrencyReport(since time.Time) ([]CurrencyTotals, error) {
+	query := `SELECT currency,
+	                 SUM(wagered), SUM(wageredBase), SUM(paidOut), SUM(paidOutBase),
+	                 SUM(deposits), SUM(depositsBase), SUM(withdrawals), SUM(withdrawalsBase)
+	          FROM (
+	              SELECT currency, betAmount AS wagered, betAmount * exchangeRate AS wageredBase,
+	                     IIF(playerWin, winnings, 0) AS paidOut, IIF(playerWin, winnings, 0) * exchangeRate AS paidOutBase,
+	                     0 AS deposits, 0 AS depositsBase, 0 AS withdrawals, 0 AS withdrawalsBase
+	              FROM bets WHERE createdAt >= ?1
+	              UNION ALL
+	              SELECT currency, 0, 0, 0, 0, amount, amount * exchangeRate, 0, 0
+	              FROM transactions WHERE type = ?2 AND createdAt >= ?1
+	              UNION ALL
+	              SELECT currency, 0, 0, 0, 0, 0, 0, amount, amount * exchangeRate
+	              FROM withdrawals WHERE status NOT IN (?3, ?4) AND createdAt >= ?1
+	          )
+	          GROUP BY currency ORDER BY currency;`
+
+	rows, err := DB.Query(query, since.UTC().Format(sqliteTimeFormat), TransactionDeposit, WithdrawalRejected, WithdrawalCancelled)
+	if err != nil {
+		return nil, fmt.Errorf("error computing currency report: %v", err)
+	}
+	defer rows.Close()
+
+	report := []CurrencyTotals{}
+	for rows.Next() {
+		var totals CurrencyTotals
+		var values [8]float64
+		if err := rows.Scan(&totals.Currency, &values[0], &values[1], &values[2], &values[3], &values[4], &values[5], &values[6], &values[7]); err != nil {
+			return nil, fmt.Errorf("error reading currency report: %v", err)
+		}
+
+		totals.Wagered, totals.WageredBase = roundToCents(float32(values[0])), roundToCents(float32(values[1]))
+		totals.PaidOut, totals.PaidOutBase = roundToCents(float32(values[2])), roundToCents(float32(values[3]))
+		totals.Deposits, totals.DepositsBase = roundToCents(float32(values[4])), roundToCents(float32(values[5]))
+		totals.Withdrawals, totals.WithdrawalsBase = roundToCents(float32(values[6])), roundToCents(float32(values[7]))
+		totals.GrossRevenue = roundToCents(totals.Wagered - totals.PaidOut)
+		totals.GrossRevenueBase = roundToCents(totals.WageredBase - totals.PaidOutBase)
+		report = append(report, totals)
+	}
+
+	return report, rows.Err()
+}
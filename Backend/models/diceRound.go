@@ -0,0 +1,206 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Dice round statuses
+const (
+	RoundOpen      = "open"      // Taking bets until closesAt
+	RoundRolled    = "rolled"    // Die rolled, the bets are settled
+	RoundCancelled = "cancelled" // Never rolled (server stopped), the stakes were given back
+)
+
+// Round bet statuses
+const (
+	RoundBetPlaced   = "placed"   // Stake taken, waiting for the roll
+	RoundBetSettled  = "settled"  // Recorded as a bet with its result
+	RoundBetRefunded = "refunded" // Stake given back
+)
+
+type RoundBet struct {
+	ID          int       `json:"-"`
+	RoundID     int       `json:"roundId"`
+	PlayerID    int       `json:"-"` // Round bets are public
+	Name        string    `json:"name"`
+	BetType     string    `json:"betType"`
+	BetAmount   float32   `json:"betAmount"`
+	WalletStake float32   `json:"-"` // Part of the stake taken from the wallet (the rest from the bet balance)
+	FreeBetID   *int      `json:"-"`
+	Status      string    `json:"status"`
+	BetID       *int      `json:"-"`
+	DiceNumber  *int      `json:"-"` // Of the round, once rolled
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// CreateDiceRound opens a round of the room and returns its ID
+func CreateDiceRound(room string, closesAt time.Time) (int, error) {
+	result, err := DB.Exec(`INSERT INTO dice_rounds (room, closesAt) VALUES (?, ?);`, room, closesAt.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return 0, fmt.Errorf("error creating dice round: %v", err)
+	}
+
+	roundID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("unable to retrieve last inserted ID: %v", err)
+	}
+
+	return int(roundID), nil
+}
+
+// SetDiceRoundRolled stores the round's die, its bets are settled with it from then on
+func SetDiceRoundRolled(id int, diceNumber int) error {
+	_, err := DB.Exec(`UPDATE dice_rounds SET status = ?, diceNumber = ?, rolledAt = CURRENT_TIMESTAMP WHERE id = ? AND status = ?;`, RoundRolled, diceNumber, id, RoundOpen)
+	if err != nil {
+		return fmt.Errorf("error updating dice round: %v", err)
+	}
+
+	return nil
+}
+
+// CancelOpenDiceRounds cancels the rounds that were still open (left by a stopped server) and returns their placed bets to refund
+func CancelOpenDiceRounds() ([]RoundBet, error) {
+	_, err := DB.Exec(`UPDATE dice_rounds SET status = ? WHERE status = ?;`, RoundCancelled, RoundOpen)
+	if err != nil {
+		return nil, fmt.Errorf("error cancelling dice rounds: %v", err)
+	}
+
+	query := `SELECT ` + roundBetColumns + ` FROM dice_round_bets b JOIN dice_rounds r ON r.id = b.roundId JOIN players p ON p.id = b.playerId
+	          WHERE r.status = ? AND b.status = ? ORDER BY b.id;`
+	return queryRoundBets(query, RoundCancelled, RoundBetPlaced)
+}
+
+// PlaceRoundBet records a bet whose stake was taken, a player can bet once per round
+func PlaceRoundBet(bet RoundBet) (int, error) {
+	query := `INSERT INTO dice_round_bets (roundId, playerId, betType, betAmount, walletStake, freeBetId) VALUES (?, ?, ?, ?, ?, ?);`
+	result, err := DB.Exec(query, bet.RoundID, bet.PlayerID, bet.BetType, roundToCents(bet.BetAmount), roundToCents(bet.WalletStake), bet.FreeBetID)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return 0, fmt.Errorf("player already placed a bet on this round")
+		}
+		return 0, fmt.Errorf("error placing round bet: %v", err)
+	}
+
+	betID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("unable to retrieve last inserted ID: %v", err)
+	}
+
+	return int(betID), nil
+}
+
+// DeleteRoundBet removes a placed bet whose stake couldn't be taken
+func DeleteRoundBet(id int) error {
+	_, err := DB.Exec(`DELETE FROM dice_round_bets WHERE id = ? AND status = ?;`, id, RoundBetPlaced)
+	if err != nil {
+		return fmt.Errorf("error deleting round bet: %v", err)
+	}
+
+	return nil
+}
+
+// Columns read by scanRoundBet, in order (b = dice_round_bets, r = dice_rounds, p = players)
+const roundBetColumns = `b.id, b.roundId, b.playerId, CASE WHEN p.maskName THEN '' ELSE p.name END, p.name, b.betType, b.betAmount, b.walletStake, b.freeBetId, b.status, b.betId, r.diceNumber, b.createdAt`
+
+func scanRoundBet(row scanner) (*RoundBet, error) {
+	var bet RoundBet
+	var publicName, name string
+	var freeBetID, betID, diceNumber sql.NullInt64
+	err := row.Scan(&bet.ID, &bet.RoundID, &bet.PlayerID, &publicName, &name, &bet.BetType, &bet.BetAmount, &bet.WalletStake, &freeBetID, &bet.Status, &betID, &diceNumber, &bet.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Players who asked for it are masked like on the leaderboards
+	bet.Name = publicName
+	if publicName == "" {
+		bet.Name = MaskPlayerName(name)
+	}
+	if freeBetID.Valid {
+		id := int(freeBetID.Int64)
+		bet.FreeBetID = &id
+	}
+	if betID.Valid {
+		id := int(betID.Int64)
+		bet.BetID = &id
+	}
+	if diceNumber.Valid {
+		number := int(diceNumber.Int64)
+		bet.DiceNumber = &number
+	}
+
+	return &bet, nil
+}
+
+func GetRoundBetByID(id int) (*RoundBet, error) {
+	query := `SELECT ` + roundBetColumns + ` FROM dice_round_bets b JOIN dice_rounds r ON r.id = b.roundId JOIN players p ON p.id = b.playerId WHERE b.id = ?;`
+	bet, err := scanRoundBet(DB.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("round bet with ID %d not found", id)
+		}
+		return nil, fmt.Errorf("error fetching round bet: %v", err)
+	}
+
+	return bet, nil
+}
+
+// GetUnsettledRoundBets returns the placed bets of the room's rolled rounds (settlements that failed or were interrupted)
+func GetUnsettledRoundBets(room string) ([]RoundBet, error) {
+	query := `SELECT ` + roundBetColumns + ` FROM dice_round_bets b JOIN dice_rounds r ON r.id = b.roundId JOIN players p ON p.id = b.playerId
+	          WHERE r.room = ? AND r.status = ? AND b.status = ? ORDER BY b.id;`
+	return queryRoundBets(query, room, RoundRolled, RoundBetPlaced)
+}
+
+func queryRoundBets(query string, args ...any) ([]RoundBet, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching round bets: %v", err)
+	}
+	defer rows.Close()
+
+	bets := []RoundBet{}
+	for rows.Next() {
+		bet, err := scanRoundBet(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading round bet: %v", err)
+		}
+		bets = append(bets, *bet)
+	}
+
+	return bets, rows.Err()
+}
+
+// ClaimRoundBet moves a placed bet to settled / refunded before its balance update, returns false if it wasn't placed anymore
+func ClaimRoundBet(id int, status string) (bool, error) {
+	result, err := DB.Exec(`UPDATE dice_round_bets SET status = ? WHERE id = ? AND status = ?;`, status, id, RoundBetPlaced)
+	if err != nil {
+		return false, fmt.Errorf("error updating round bet: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected == 1, nil
+}
+
+// ReleaseRoundBetClaim reverts ClaimRoundBet when the balance update failed
+func ReleaseRoundBetClaim(id int) error {
+	_, err := DB.Exec(`UPDATE dice_round_bets SET status = ? WHERE id = ?;`, RoundBetPlaced, id)
+	if err != nil {
+		return fmt.Errorf("error updating round bet: %v", err)
+	}
+
+	return nil
+}
+
+// SetRoundBetBet links a settled round bet to the bet recorded for it
+func SetRoundBetBet(id int, betID int) error {
+	_, err := DB.Exec(`UPDATE dice_round_bets SET betId = ? WHERE id = ?;`, betID, id)
+	if err != nil {
+		return fmt.Errorf("error updating round bet: %v", err)
+	}
+
+	return nil
+}
@@ -23,7 +23,7 @@ func ConnectDB() {
 	}
 
 	// SQLite doesn't support concurrency well -> Limit to 1 Open Conn
-	// No transactions are needed for this project
+	// Transactions are only needed where several writes must succeed together (e.g. promo redemptions)
 	// because there can't be simultaneous queries being executed
 	DB.SetMaxOpenConns(1)
 	DB.SetMaxIdleConns(1)
@@ -41,6 +41,16 @@ func CloseDB() {
 	DB.Close()
 }
 
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
 func initializeTables() {
 	// Use Exec instead of Query for table creation
 	query := `
@@ -60,6 +70,532 @@ func initializeTables() {
 
 	fmt.Println("TABLE Players Initialized Successfully")
 
+	// Columns added after the first release need to be added to existing databases too
+	ensureColumn("players", "role", "TEXT NOT NULL DEFAULT 'player'")
+	ensureColumn("players", "status", "TEXT NOT NULL DEFAULT 'active'")
+	ensureColumn("players", "statusReason", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn("players", "exclusionUntil", "DATETIME")
+	ensureColumn("players", "coolOffUntil", "DATETIME")
+	ensureColumn("players", "referralCode", "TEXT")
+	ensureColumn("players", "deviceId", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn("players", "loyaltyPoints", "REAL NOT NULL DEFAULT 0")
+	ensureColumn("players", "vipTier", "TEXT NOT NULL DEFAULT 'bronze'")
+	ensureColumn("players", "maskName", "BOOLEAN NOT NULL DEFAULT false")
+	ensureColumn("players", "acceptTransfers", "BOOLEAN NOT NULL DEFAULT true")
+	ensureColumn("players", "kycStatus", "TEXT NOT NULL DEFAULT 'none'")
+
+	_, err = DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS players_referral_code ON players (referralCode);`)
+	if err != nil {
+		log.Fatal("Error creating players referral code index:", err)
+	}
+
+	// History of account status changes made by admins
+	query = `
+	CREATE TABLE IF NOT EXISTS player_status_changes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		playerId INTEGER NOT NULL REFERENCES players(id),
+		oldStatus TEXT NOT NULL,
+		newStatus TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		changedBy INTEGER NOT NULL REFERENCES players(id),
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		log.Fatal("Error creating player_status_changes table:", err)
+	}
+
+	fmt.Println("TABLE Player Status Changes Initialized Successfully")
+
+	// Bet history, one row per settled dice roll
+	query = `
+	CREATE TABLE IF NOT EXISTS bets (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		playerId INTEGER NOT NULL REFERENCES players(id),
+		betType TEXT NOT NULL,
+		betAmount DECIMAL(10,2) NOT NULL,
+		diceNumber INTEGER NOT NULL,
+		playerWin BOOLEAN NOT NULL,
+		winnings DECIMAL(10,2) NOT NULL,
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		log.Fatal("Error creating bets table:", err)
+	}
+
+	fmt.Println("TABLE Bets Initialized Successfully")
+
+	// Bets placed with a free bet (stored with a 0 bet amount, the player staked nothing)
+	ensureColumn("bets", "freeBetId", "INTEGER REFERENCES free_bets(id)")
+	// Game the bet was placed on (dice bets have the die in diceNumber, other games 0)
+	ensureColumn("bets", "game", "TEXT NOT NULL DEFAULT 'dice'")
+
+	// Transaction history, one row per balance movement
+	query = `
+	CREATE TABLE IF NOT EXISTS transactions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		playerId INTEGER NOT NULL REFERENCES players(id),
+		type TEXT NOT NULL,
+		amount DECIMAL(10,2) NOT NULL,
+		wallet DECIMAL(10,2) NOT NULL,
+		betBalance DECIMAL(10,2) NOT NULL,
+		reference TEXT NOT NULL DEFAULT '',
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		log.Fatal("Error creating transactions table:", err)
+	}
+
+	fmt.Println("TABLE Transactions Initialized Successfully")
+
+	// Manual balance adjustments proposed (and approved) by admins
+	query = `
+	CREATE TABLE IF NOT EXISTS balance_adjustments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		playerId INTEGER NOT NULL REFERENCES players(id),
+		amount DECIMAL(10,2) NOT NULL,
+		reasonCode TEXT NOT NULL,
+		note TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'pending',
+		requiresApproval BOOLEAN NOT NULL,
+		proposedBy INTEGER NOT NULL REFERENCES players(id),
+		reviewedBy INTEGER REFERENCES players(id),
+		reviewNote TEXT NOT NULL DEFAULT '',
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		reviewedAt DATETIME
+	);`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		log.Fatal("Error creating balance_adjustments table:", err)
+	}
+
+	fmt.Println("TABLE Balance Adjustments Initialized Successfully")
+
+	// Responsible gaming limits set by the players themselves
+	query = `
+	CREATE TABLE IF NOT EXISTS player_limits (
+		playerId INTEGER NOT NULL REFERENCES players(id),
+		limitType TEXT NOT NULL,
+		period TEXT NOT NULL,
+		amount DECIMAL(10,2),
+		pendingAmount DECIMAL(10,2),
+		pendingEffectiveAt DATETIME,
+		PRIMARY KEY (playerId, limitType, period)
+	);`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		log.Fatal("Error creating player_limits table:", err)
+	}
+
+	fmt.Println("TABLE Player Limits Initialized Successfully")
+
+	// Promotional money with wagering requirements (balance includes the winnings of bonus-funded stakes)
+	query = `
+	CREATE TABLE IF NOT EXISTS bonuses (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		playerId INTEGER NOT NULL REFERENCES players(id),
+		amount DECIMAL(10,2) NOT NULL,
+		balance DECIMAL(10,2) NOT NULL,
+		wageringRequirement DECIMAL(10,2) NOT NULL,
+		wagered DECIMAL(10,2) NOT NULL DEFAULT 0,
+		status TEXT NOT NULL DEFAULT 'active',
+		reference TEXT NOT NULL DEFAULT '',
+		expiresAt DATETIME NOT NULL,
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		closedAt DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS bonuses_player ON bonuses (playerId, status);`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		log.Fatal("Error creating bonuses table:", err)
+	}
+
+	fmt.Println("TABLE Bonuses Initialized Successfully")
+
+	// Promo codes created by admins and their redemptions (a player can redeem each code once)
+	query = `
+	CREATE TABLE IF NOT EXISTS promo_codes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code TEXT NOT NULL UNIQUE,
+		type TEXT NOT NULL,
+		value DECIMAL(10,2) NOT NULL,
+		maxAmount DECIMAL(10,2) NOT NULL DEFAULT 0,
+		freeBetCount INTEGER NOT NULL DEFAULT 0,
+		game TEXT NOT NULL DEFAULT '',
+		wageringMultiplier DECIMAL(10,2) NOT NULL,
+		rewardExpiryDays DECIMAL(10,2) NOT NULL,
+		validFrom DATETIME NOT NULL,
+		validUntil DATETIME NOT NULL,
+		maxRedemptions INTEGER NOT NULL DEFAULT 0,
+		redemptions INTEGER NOT NULL DEFAULT 0,
+		createdBy INTEGER NOT NULL REFERENCES players(id),
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS promo_redemptions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		promoId INTEGER NOT NULL REFERENCES promo_codes(id),
+		playerId INTEGER NOT NULL REFERENCES players(id),
+		status TEXT NOT NULL,
+		amount DECIMAL(10,2) NOT NULL DEFAULT 0,
+		bonusId INTEGER REFERENCES bonuses(id),
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		completedAt DATETIME,
+		UNIQUE (promoId, playerId)
+	);
+	CREATE TABLE IF NOT EXISTS free_bets (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		playerId INTEGER NOT NULL REFERENCES players(id),
+		redemptionId INTEGER NOT NULL REFERENCES promo_redemptions(id),
+		game TEXT NOT NULL,
+		amount DECIMAL(10,2) NOT NULL,
+		status TEXT NOT NULL DEFAULT 'available',
+		betId INTEGER REFERENCES bets(id),
+		expiresAt DATETIME NOT NULL,
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		usedAt DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS free_bets_player ON free_bets (playerId, status);`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		log.Fatal("Error creating promo tables:", err)
+	}
+
+	fmt.Println("TABLE Promo Codes Initialized Successfully")
+
+	// Players who registered with someone's referral code (one referral per referred player)
+	query = `
+	CREATE TABLE IF NOT EXISTS referrals (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		referrerId INTEGER NOT NULL REFERENCES players(id),
+		referredId INTEGER NOT NULL UNIQUE REFERENCES players(id),
+		status TEXT NOT NULL DEFAULT 'pending',
+		rejectReason TEXT NOT NULL DEFAULT '',
+		ip TEXT NOT NULL DEFAULT '',
+		deviceId TEXT NOT NULL DEFAULT '',
+		referrerReward DECIMAL(10,2) NOT NULL DEFAULT 0,
+		referredReward DECIMAL(10,2) NOT NULL DEFAULT 0,
+		referrerRewardedAt DATETIME,
+		referredRewardedAt DATETIME,
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		qualifiedAt DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS referrals_referrer ON referrals (referrerId);`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		log.Fatal("Error creating referrals table:", err)
+	}
+
+	fmt.Println("TABLE Referrals Initialized Successfully")
+
+	// Weekly cashback on net losses, one row per player and period so a run can't pay a period twice
+	query = `
+	CREATE TABLE IF NOT EXISTS cashbacks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		playerId INTEGER NOT NULL REFERENCES players(id),
+		periodStart DATETIME NOT NULL,
+		periodEnd DATETIME NOT NULL,
+		netLoss DECIMAL(10,2) NOT NULL,
+		rate DECIMAL(5,2) NOT NULL,
+		amount DECIMAL(10,2) NOT NULL,
+		destination TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		paidAt DATETIME,
+		UNIQUE (playerId, periodStart)
+	);`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		log.Fatal("Error creating cashbacks table:", err)
+	}
+
+	fmt.Println("TABLE Cashbacks Initialized Successfully")
+
+	// Time-boxed tournaments, their state is kept here so the scheduler picks them up again after a restart
+	query = `
+	CREATE TABLE IF NOT EXISTS tournaments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		game TEXT NOT NULL,
+		scoring TEXT NOT NULL,
+		entryFee DECIMAL(10,2) NOT NULL DEFAULT 0,
+		guaranteedPrize DECIMAL(10,2) NOT NULL DEFAULT 0,
+		payoutTable TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'scheduled',
+		startsAt DATETIME NOT NULL,
+		endsAt DATETIME NOT NULL,
+		createdBy INTEGER NOT NULL REFERENCES players(id),
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		settledAt DATETIME
+	);
+	CREATE TABLE IF NOT EXISTS tournament_entries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		tournamentId INTEGER NOT NULL REFERENCES tournaments(id),
+		playerId INTEGER NOT NULL REFERENCES players(id),
+		entryFee DECIMAL(10,2) NOT NULL DEFAULT 0,
+		score DECIMAL(10,2) NOT NULL DEFAULT 0,
+		rank INTEGER,
+		prize DECIMAL(10,2) NOT NULL DEFAULT 0,
+		prizePaidAt DATETIME,
+		joinedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (tournamentId, playerId)
+	);
+	CREATE INDEX IF NOT EXISTS tournament_entries_player ON tournament_entries (playerId);`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		log.Fatal("Error creating tournament tables:", err)
+	}
+
+	fmt.Println("TABLE Tournaments Initialized Successfully")
+
+	// Shared dice rounds of the rooms and the bets placed on them
+	query = `
+	CREATE TABLE IF NOT EXISTS dice_rounds (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		room TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'open',
+		diceNumber INTEGER,
+		closesAt DATETIME NOT NULL,
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		rolledAt DATETIME
+	);
+	CREATE TABLE IF NOT EXISTS dice_round_bets (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		roundId INTEGER NOT NULL,
+		playerId INTEGER NOT NULL,
+		betType TEXT NOT NULL,
+		betAmount REAL NOT NULL,
+		walletStake REAL NOT NULL DEFAULT 0,
+		freeBetId INTEGER,
+		status TEXT NOT NULL DEFAULT 'placed',
+		betId INTEGER,
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (roundId, playerId)
+	);
+	CREATE INDEX IF NOT EXISTS dice_round_bets_status ON dice_round_bets (status);`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		log.Fatal("Error creating dice round tables:", err)
+	}
+
+	fmt.Println("TABLE Dice Rounds Initialized Successfully")
+
+	// Crash game rounds (the server seed is only shown once the round is over) and their bets
+	query = `
+	CREATE TABLE IF NOT EXISTS crash_rounds (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		serverSeed TEXT NOT NULL,
+		seedHash TEXT NOT NULL,
+		crashPoint REAL NOT NULL,
+		status TEXT NOT NULL DEFAULT 'betting',
+		startsAt DATETIME NOT NULL,
+		crashedAt DATETIME,
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS crash_bets (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		roundId INTEGER NOT NULL REFERENCES crash_rounds(id),
+		playerId INTEGER NOT NULL REFERENCES players(id),
+		betAmount REAL NOT NULL,
+		walletStake REAL NOT NULL DEFAULT 0,
+		freeBetId INTEGER,
+		autoCashout REAL,
+		cashoutMultiplier REAL,
+		status TEXT NOT NULL DEFAULT 'placed',
+		betId INTEGER,
+		settledAt DATETIME,
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (roundId, playerId)
+	);
+	CREATE INDEX IF NOT EXISTS crash_bets_status ON crash_bets (status);`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		log.Fatal("Error creating crash tables:", err)
+	}
+
+	fmt.Println("TABLE Crash Rounds Initialized Successfully")
+
+	// Progressive jackpot: a single pool and the ledger of every movement of it (the pool is the sum of the ledger)
+	query = `
+	CREATE TABLE IF NOT EXISTS jackpot (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		pool REAL NOT NULL DEFAULT 0,
+		updatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS jackpot_ledger (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		type TEXT NOT NULL,
+		amount REAL NOT NULL,
+		poolAfter REAL NOT NULL,
+		playerId INTEGER REFERENCES players(id),
+		betId INTEGER,
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS jackpot_ledger_type ON jackpot_ledger (type);`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		log.Fatal("Error creating jackpot tables:", err)
+	}
+
+	fmt.Println("TABLE Jackpot Initialized Successfully")
+
+	// Player-to-player transfers (the balance movements are in the transactions)
+	query = `
+	CREATE TABLE IF NOT EXISTS transfers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		senderId INTEGER NOT NULL REFERENCES players(id),
+		recipientId INTEGER NOT NULL REFERENCES players(id),
+		amount REAL NOT NULL,
+		message TEXT NOT NULL DEFAULT '',
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS transfers_sender ON transfers (senderId, createdAt);
+	CREATE INDEX IF NOT EXISTS transfers_recipient ON transfers (recipientId);`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		log.Fatal("Error creating transfers table:", err)
+	}
+
+	fmt.Println("TABLE Transfers Initialized Successfully")
+
+	// Deposits / payouts going through a payment provider (the balance movements are in the transactions)
+	query = `
+	CREATE TABLE IF NOT EXISTS payments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		playerId INTEGER NOT NULL REFERENCES players(id),
+		kind TEXT NOT NULL CHECK (kind IN ('deposit', 'payout')),
+		amount REAL NOT NULL,
+		provider TEXT NOT NULL,
+		providerReference TEXT,
+		status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		completedAt DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS payments_player ON payments (playerId, createdAt);
+	CREATE UNIQUE INDEX IF NOT EXISTS payments_reference ON payments (provider, providerReference);`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		log.Fatal("Error creating payments table:", err)
+	}
+
+	fmt.Println("TABLE Payments Initialized Successfully")
+
+	// Withdrawal requests, the amount is taken from the wallet when requested and given back if it's never paid
+	query = `
+	CREATE TABLE IF NOT EXISTS withdrawals (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		playerId INTEGER NOT NULL REFERENCES players(id),
+		amount REAL NOT NULL,
+		status TEXT NOT NULL DEFAULT 'requested' CHECK (status IN ('requested', 'approved', 'processing', 'paid', 'rejected', 'cancelled')),
+		autoApproved BOOLEAN NOT NULL DEFAULT false,
+		reviewedBy INTEGER REFERENCES players(id),
+		reviewNote TEXT NOT NULL DEFAULT '',
+		paymentId INTEGER REFERENCES payments(id),
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		reviewedAt DATETIME,
+		completedAt DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS withdrawals_player ON withdrawals (playerId, createdAt);
+	CREATE INDEX IF NOT EXISTS withdrawals_status ON withdrawals (status);`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		log.Fatal("Error creating withdrawals table:", err)
+	}
+
+	fmt.Println("TABLE Withdrawals Initialized Successfully")
+
+	// Identity verification: the player's profile, their uploaded documents (files are on disk) and the admins' reviews
+	query = `
+	CREATE TABLE IF NOT EXISTS kyc_profiles (
+		playerId INTEGER PRIMARY KEY REFERENCES players(id),
+		fullName TEXT NOT NULL,
+		dateOfBirth TEXT NOT NULL,
+		address TEXT NOT NULL,
+		country TEXT NOT NULL,
+		updatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS kyc_documents (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		playerId INTEGER NOT NULL REFERENCES players(id),
+		documentType TEXT NOT NULL,
+		fileName TEXT NOT NULL,
+		originalName TEXT NOT NULL,
+		contentType TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS kyc_documents_player ON kyc_documents (playerId);
+	CREATE TABLE IF NOT EXISTS kyc_reviews (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		playerId INTEGER NOT NULL REFERENCES players(id),
+		oldStatus TEXT NOT NULL,
+		newStatus TEXT NOT NULL,
+		note TEXT NOT NULL DEFAULT '',
+		reviewedBy INTEGER REFERENCES players(id),
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS kyc_reviews_player ON kyc_reviews (playerId);`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		log.Fatal("Error creating KYC tables:", err)
+	}
+
+	fmt.Println("TABLE KYC Initialized Successfully")
+
+	// Currencies with their exchange rate to the base currency and bet limits (maintained by admins),
+	// and the wallets of the currencies the players aren't playing in (see wallet.go)
+	query = `
+	CREATE TABLE IF NOT EXISTS currencies (
+		code TEXT PRIMARY KEY,
+		rate REAL NOT NULL CHECK (rate > 0),
+		minBet REAL NOT NULL DEFAULT 0,
+		maxBet REAL NOT NULL DEFAULT 0,
+		updatedBy INTEGER REFERENCES players(id),
+		updatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS wallets (
+		playerId INTEGER NOT NULL REFERENCES players(id),
+		currency TEXT NOT NULL REFERENCES currencies(code),
+		wallet REAL NOT NULL DEFAULT 0,
+		betBalance REAL NOT NULL DEFAULT 0,
+		PRIMARY KEY (playerId, currency)
+	);`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		log.Fatal("Error creating currency tables:", err)
+	}
+
+	fmt.Println("TABLE Currencies Initialized Successfully")
+
+	// Currency of the player's live balances (wallet / betBalance), set to the base currency by SeedCurrencies
+	ensureColumn("players", "currency", "TEXT NOT NULL DEFAULT ''")
+	// Currency of the money records and its exchange rate to the base currency at the time (older records are in the base currency)
+	for _, table := range []string{"bets", "transactions", "payments", "withdrawals", "transfers"} {
+		ensureColumn(table, "currency", "TEXT NOT NULL DEFAULT ''")
+		ensureColumn(table, "exchangeRate", "REAL NOT NULL DEFAULT 1")
+	}
+	// Wallet an adjustment is applied to, chosen when it's proposed (older ones are in the base currency)
+	ensureColumn("balance_adjustments", "currency", "TEXT NOT NULL DEFAULT ''")
+
 	// Check if the table has any players
 	var count int
 	err = DB.QueryRow("SELECT COUNT(*) FROM players;").Scan(&count)
@@ -71,6 +607,55 @@ func initializeTables() {
 	if count == 0 {
 		insertMockData()
 	}
+
+	// Insert staff accounts only if there is no admin yet
+	err = DB.QueryRow("SELECT COUNT(*) FROM players WHERE role = ?;", RoleAdmin).Scan(&count)
+	if err != nil {
+		log.Fatal("Error checking admin count:", err)
+	}
+
+	if count == 0 {
+		insertMockStaff()
+	}
+}
+
+// ensureColumn adds a column to an existing table if it isn't there yet
+// (CREATE TABLE IF NOT EXISTS doesn't touch tables created by older versions)
+func ensureColumn(table string, column string, definition string) {
+	rows, err := DB.Query(fmt.Sprintf("PRAGMA table_info(%s);", table))
+	if err != nil {
+		log.Fatal("Error reading table info:", err)
+	}
+
+	found := false
+	for rows.Next() {
+		var (
+			cid          int
+			name         string
+			columnType   string
+			notNull      bool
+			defaultValue sql.NullString
+			primaryKey   int
+		)
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &primaryKey); err != nil {
+			log.Fatal("Error reading table info:", err)
+		}
+		if name == column {
+			found = true
+		}
+	}
+	rows.Close()
+
+	if found {
+		return
+	}
+
+	_, err = DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, column, definition))
+	if err != nil {
+		log.Fatal("Error adding column ", column, " to ", table, ":", err)
+	}
+
+	fmt.Printf("COLUMN %s.%s Added Successfully\n", table, column)
 }
 
 // insertMockData adds test players to the database
@@ -101,3 +686,32 @@ func insertMockData() {
 
 	fmt.Println("Mock data inserted successfully")
 }
+
+// insertMockStaff adds test admin and support accounts to the database
+func insertMockStaff() {
+	// This is only for testing purposes
+	// Two admins so that four-eyes approvals can be tested
+	passwords := []string{"adminpass", "adminpass2", "supportpass"}
+	// Hashed Passwords
+	hashedPasswords := []any{}
+
+	for _, password := range passwords {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			log.Fatal("Error hashing password:", err)
+		}
+		hashedPasswords = append(hashedPasswords, string(hashedPassword))
+	}
+
+	query := `INSERT OR IGNORE INTO players (name, password, wallet, betBalance, isBetting, role) VALUES 
+		('Diana', ?, 0.00, 0.00, false, 'admin'), 
+		('Eve', ?, 0.00, 0.00, false, 'admin'),
+		('Frank', ?, 0.00, 0.00, false, 'support');`
+
+	_, err := DB.Exec(query, hashedPasswords...)
+	if err != nil {
+		log.Fatal("Error inserting mock staff:", err)
+	}
+
+	fmt.Println("Mock staff inserted successfully")
+}
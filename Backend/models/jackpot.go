@@ -0,0 +1,215 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Jackpot ledger entry types, the pool is always the sum of the ledger
+const (
+	JackpotSeed         = "seed"         // Money put in the pool by the house (first pool and after each win)
+	JackpotContribution = "contribution" // Part of a stake
+	JackpotWin          = "win"          // Pool paid out to a player
+)
+
+type JackpotLedgerEntry struct {
+	ID        int       `json:"id"`
+	Type      string    `json:"type"`
+	Amount    float32   `json:"amount"`    // Added to the pool (negative for wins)
+	PoolAfter float32   `json:"poolAfter"` // Pool right after the entry
+	PlayerID  *int      `json:"playerId"`
+	Name      string    `json:"name,omitempty"` // Of the winner, masked like on the leaderboards
+	BetID     *int      `json:"betId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SeedJackpot puts the first pool in place if there isn't one yet
+func SeedJackpot(seed float32) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT OR IGNORE INTO jackpot (id, pool) VALUES (1, ?);`, roundToCents(seed))
+	if err != nil {
+		return fmt.Errorf("error seeding jackpot: %v", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return nil // Already seeded
+	}
+
+	if err := insertJackpotLedgerEntry(tx, JackpotSeed, seed, seed, nil, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func GetJackpotPool() (float32, error) {
+	var pool float32
+	if err := DB.QueryRow(`SELECT pool FROM jackpot WHERE id = 1;`).Scan(&pool); err != nil {
+		return 0, fmt.Errorf("error fetching jackpot pool: %v", err)
+	}
+
+	return pool, nil
+}
+
+// ContributeToJackpot adds part of a bet's stake (in the base currency) to the pool and returns the new pool
+func ContributeToJackpot(amount float32, playerID int, betID int) (float32, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var pool float32
+	err = tx.QueryRow(`UPDATE jackpot SET pool = ROUND(pool + ?, 2), updatedAt = CURRENT_TIMESTAMP WHERE id = 1 RETURNING pool;`, roundToCents(amount)).Scan(&pool)
+	if err != nil {
+		return 0, fmt.Errorf("error updating jackpot pool: %v", err)
+	}
+
+	if err := insertJackpotLedgerEntry(tx, JackpotContribution, amount, pool, &playerID, &betID); err != nil {
+		return 0, err
+	}
+
+	return pool, tx.Commit()
+}
+
+// WinJackpot pays the whole pool to the player's bet balance (converted to the currency the player plays in)
+// and seeds the next pool, all or nothing
+// Returns the pool won in the base currency and the amount credited (0 if the pool was empty)
+func WinJackpot(playerID int, betID int, reseed float32) (float32, float32, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var pool float32
+	if err := tx.QueryRow(`SELECT pool FROM jackpot WHERE id = 1;`).Scan(&pool); err != nil {
+		return 0, 0, fmt.Errorf("error fetching jackpot pool: %v", err)
+	}
+	if pool <= 0 {
+		return 0, 0, nil
+	}
+
+	var currency string
+	if err := tx.QueryRow(`SELECT currency FROM players WHERE id = ?;`, playerID).Scan(&currency); err != nil {
+		return 0, 0, fmt.Errorf("error fetching player: %v", err)
+	}
+	credited := FromBaseCurrency(pool, currency)
+
+	var wallet, betBalance float32
+	err = tx.QueryRow(`UPDATE players SET betBalance = ROUND(betBalance + ?, 2) WHERE id = ? RETURNING wallet, betBalance;`, credited, playerID).Scan(&wallet, &betBalance)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error crediting jackpot: %v", err)
+	}
+
+	err = recordTransaction(tx, playerID, TransactionJackpotWin, credited, wallet, betBalance, fmt.Sprintf("bet:%d", betID), currency)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := insertJackpotLedgerEntry(tx, JackpotWin, -pool, 0, &playerID, &betID); err != nil {
+		return 0, 0, err
+	}
+
+	reseed = roundToCents(reseed)
+	if _, err := tx.Exec(`UPDATE jackpot SET pool = ?, updatedAt = CURRENT_TIMESTAMP WHERE id = 1;`, reseed); err != nil {
+		return 0, 0, fmt.Errorf("error updating jackpot pool: %v", err)
+	}
+	if reseed > 0 {
+		if err := insertJackpotLedgerEntry(tx, JackpotSeed, reseed, reseed, nil, nil); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("error committing jackpot win: %v", err)
+	}
+
+	emitBalanceUpdate(playerID, wallet, betBalance)
+
+	return pool, credited, nil
+}
+
+func insertJackpotLedgerEntry(tx *sql.Tx, entryType string, amount float32, poolAfter float32, playerID *int, betID *int) error {
+	query := `INSERT INTO jackpot_ledger (type, amount, poolAfter, playerId, betId) VALUES (?, ?, ?, ?, ?);`
+	if _, err := tx.Exec(query, entryType, roundToCents(amount), roundToCents(poolAfter), playerID, betID); err != nil {
+		return fmt.Errorf("error recording jackpot ledger entry: %v", err)
+	}
+
+	return nil
+}
+
+// GetJackpotLedger returns a page of the ledger, newest first
+func GetJackpotLedger(limit int, offset int) ([]JackpotLedgerEntry, error) {
+	query := `SELECT ` + jackpotLedgerColumns + ` FROM jackpot_ledger l LEFT JOIN players p ON p.id = l.playerId ORDER BY l.id DESC LIMIT ? OFFSET ?;`
+	return queryJackpotLedger(query, limit, offset)
+}
+
+// GetJackpotWins returns the latest wins
+func GetJackpotWins(limit int) ([]JackpotLedgerEntry, error) {
+	query := `SELECT ` + jackpotLedgerColumns + ` FROM jackpot_ledger l LEFT JOIN players p ON p.id = l.playerId WHERE l.type = ? ORDER BY l.id DESC LIMIT ?;`
+	return queryJackpotLedger(query, JackpotWin, limit)
+}
+
+// GetJackpotLedgerTotal returns the sum of the ledger, which must match the pool
+func GetJackpotLedgerTotal() (float32, error) {
+	var total float32
+	if err := DB.QueryRow(`SELECT ROUND(COALESCE(SUM(amount), 0), 2) FROM jackpot_ledger;`).Scan(&total); err != nil {
+		return 0, fmt.Errorf("error summing jackpot ledger: %v", err)
+	}
+
+	return total, nil
+}
+
+// Columns read by scanJackpotLedgerEntry, in order (l = jackpot_ledger, p = players)
+const jackpotLedgerColumns = `l.id, l.type, l.amount, l.poolAfter, l.playerId, CASE WHEN p.maskName THEN '' ELSE COALESCE(p.name, '') END, COALESCE(p.name, ''), l.betId, l.createdAt`
+
+func scanJackpotLedgerEntry(row scanner) (*JackpotLedgerEntry, error) {
+	var entry JackpotLedgerEntry
+	var publicName, name string
+	var playerID, betID sql.NullInt64
+	err := row.Scan(&entry.ID, &entry.Type, &entry.Amount, &entry.PoolAfter, &playerID, &publicName, &name, &betID, &entry.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Players who asked for it are masked like on the leaderboards
+	entry.Name = publicName
+	if publicName == "" && name != "" {
+		entry.Name = MaskPlayerName(name)
+	}
+	if playerID.Valid {
+		id := int(playerID.Int64)
+		entry.PlayerID = &id
+	}
+	if betID.Valid {
+		id := int(betID.Int64)
+		entry.BetID = &id
+	}
+
+	return &entry, nil
+}
+
+func queryJackpotLedger(query string, args ...any) ([]JackpotLedgerEntry, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching jackpot ledger: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []JackpotLedgerEntry{}
+	for rows.Next() {
+		entry, err := scanJackpotLedgerEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading jackpot ledger entry: %v", err)
+		}
+		entries = append(entries, *entry)
+	}
+
+	return entries, rows.Err()
+}
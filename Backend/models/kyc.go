@@ -0,0 +1,279 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// KYC (identity verification) statuses
+const (
+	KYCNone     = "none"     // Nothing submitted yet
+	KYCPending  = "pending"  // Submitted, waiting for an admin's review
+	KYCVerified = "verified" // Identity verified by an admin
+	KYCRejected = "rejected" // Rejected by an admin, the player can fix their profile / documents and submit again
+)
+
+var KYCStatuses = []string{KYCNone, KYCPending, KYCVerified, KYCRejected}
+
+// Error code sent to clients when a withdrawal needs a verified identity
+const KYCRequired = "KYC_REQUIRED"
+
+// Documents the players can upload
+var KYCDocumentTypes = []string{"id_card", "passport", "driving_licence", "proof_of_address"}
+
+// KYCRequiredError is returned when a withdrawal would take the player's withdrawals over the threshold without a verified identity
+type KYCRequiredError struct {
+	KYCStatus string
+	Threshold float32
+	Withdrawn float32 // Total withdrawn so far (cancelled / rejected withdrawals excluded)
+}
+
+func (e *KYCRequiredError) Error() string {
+	return fmt.Sprintf("Identity verification is required to withdraw more than %.2f in total (KYC status: %s)", e.Threshold, e.KYCStatus)
+}
+
+type KYCProfile struct {
+	PlayerID    int       `json:"-"`
+	FullName    string    `json:"fullName"`
+	DateOfBirth string    `json:"dateOfBirth"` // YYYY-MM-DD
+	Address     string    `json:"address"`
+	Country     string    `json:"country"` // ISO 3166-1 alpha-2
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+type KYCDocument struct {
+	ID           int       `json:"id"`
+	PlayerID     int       `json:"-"`
+	DocumentType string    `json:"documentType"`
+	FileName     string    `json:"-"` // Name of the stored file (in KYC_UPLOAD_DIR), never sent to clients
+	OriginalName string    `json:"originalName"`
+	ContentType  string    `json:"contentType"`
+	Size         int64     `json:"size"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// KYCReview is a change of a player's KYC status (ReviewedBy nil = submitted by the player)
+type KYCReview struct {
+	ID         int       `json:"id"`
+	PlayerID   int       `json:"-"`
+	OldStatus  string    `json:"oldStatus"`
+	NewStatus  string    `json:"newStatus"`
+	Note       string    `json:"note"`
+	ReviewedBy *int      `json:"reviewedBy"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// KYCQueueEntry is a player in the admins' KYC queue
+type KYCQueueEntry struct {
+	PlayerID    int        `json:"playerId"`
+	Name        string     `json:"name"`
+	KYCStatus   string     `json:"kycStatus"`
+	SubmittedAt *time.Time `json:"submittedAt"` // Last status change
+}
+
+func IsValidKYCStatus(status string) bool {
+	return slices.Contains(KYCStatuses, status)
+}
+
+func IsValidKYCDocumentType(documentType string) bool {
+	return slices.Contains(KYCDocumentTypes, documentType)
+}
+
+// GetKYCProfile returns the player's profile, nil if they didn't fill it yet
+func GetKYCProfile(playerID int) (*KYCProfile, error) {
+	var profile KYCProfile
+	query := `SELECT playerId, fullName, dateOfBirth, address, country, updatedAt FROM kyc_profiles WHERE playerId = ?;`
+	err := DB.QueryRow(query, playerID).Scan(&profile.PlayerID, &profile.FullName, &profile.DateOfBirth, &profile.Address, &profile.Country, &profile.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching KYC profile: %v", err)
+	}
+
+	return &profile, nil
+}
+
+// SaveKYCProfile creates or replaces the player's profile
+func SaveKYCProfile(profile KYCProfile) error {
+	query := `INSERT INTO kyc_profiles (playerId, fullName, dateOfBirth, address, country) VALUES (?, ?, ?, ?, ?)
+	          ON CONFLICT (playerId) DO UPDATE SET fullName = excluded.fullName, dateOfBirth = excluded.dateOfBirth,
+	          address = excluded.address, country = excluded.country, updatedAt = CURRENT_TIMESTAMP;`
+
+	_, err := DB.Exec(query, profile.PlayerID, profile.FullName, profile.DateOfBirth, profile.Address, profile.Country)
+	if err != nil {
+		return fmt.Errorf("error saving KYC profile: %v", err)
+	}
+
+	return nil
+}
+
+const kycDocumentColumns = `id, playerId, documentType, fileName, originalName, contentType, size, createdAt`
+
+func scanKYCDocument(row scanner) (*KYCDocument, error) {
+	var document KYCDocument
+	err := row.Scan(&document.ID, &document.PlayerID, &document.DocumentType, &document.FileName, &document.OriginalName, &document.ContentType, &document.Size, &document.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &document, nil
+}
+
+// AddKYCDocument records a document whose file was stored
+func AddKYCDocument(document KYCDocument) (*KYCDocument, error) {
+	query := `INSERT INTO kyc_documents (playerId, documentType, fileName, originalName, contentType, size) VALUES (?, ?, ?, ?, ?, ?)
+	          RETURNING ` + kycDocumentColumns + `;`
+
+	row := DB.QueryRow(query, document.PlayerID, document.DocumentType, document.FileName, document.OriginalName, document.ContentType, document.Size)
+	stored, err := scanKYCDocument(row)
+	if err != nil {
+		return nil, fmt.Errorf("error recording KYC document: %v", err)
+	}
+
+	return stored, nil
+}
+
+func GetKYCDocumentByID(id int) (*KYCDocument, error) {
+	document, err := scanKYCDocument(DB.QueryRow(`SELECT `+kycDocumentColumns+` FROM kyc_documents WHERE id = ?;`, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("document with ID %d not found", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching KYC document: %v", err)
+	}
+
+	return document, nil
+}
+
+// GetKYCDocuments returns the player's documents, oldest first
+func GetKYCDocuments(playerID int) ([]KYCDocument, error) {
+	rows, err := DB.Query(`SELECT `+kycDocumentColumns+` FROM kyc_documents WHERE playerId = ? ORDER BY id;`, playerID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching KYC documents: %v", err)
+	}
+	defer rows.Close()
+
+	documents := []KYCDocument{}
+	for rows.Next() {
+		document, err := scanKYCDocument(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading KYC document: %v", err)
+		}
+		documents = append(documents, *document)
+	}
+
+	return documents, rows.Err()
+}
+
+// UpdatePlayerKYCStatus changes the player's KYC status if it's one of fromStatuses (nil = any other status)
+// and keeps the change in the reviews. Returns false if the status didn't allow the change
+func UpdatePlayerKYCStatus(playerID int, fromStatuses []string, newStatus string, note string, reviewedBy *int) (bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var oldStatus string
+	if err := tx.QueryRow(`SELECT kycStatus FROM players WHERE id = ?;`, playerID).Scan(&oldStatus); err != nil {
+		return false, fmt.Errorf("error fetching KYC status: %v", err)
+	}
+
+	if oldStatus == newStatus || (fromStatuses != nil && !slices.Contains(fromStatuses, oldStatus)) {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(`UPDATE players SET kycStatus = ? WHERE id = ?;`, newStatus, playerID); err != nil {
+		return false, fmt.Errorf("KYC status was not updated: %v", err)
+	}
+
+	query := `INSERT INTO kyc_reviews (playerId, oldStatus, newStatus, note, reviewedBy) VALUES (?, ?, ?, ?, ?);`
+	if _, err := tx.Exec(query, playerID, oldStatus, newStatus, note, reviewedBy); err != nil {
+		return false, fmt.Errorf("error recording KYC review: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing KYC status: %v", err)
+	}
+
+	return true, nil
+}
+
+// GetKYCReviews returns the player's KYC status changes, newest first
+func GetKYCReviews(playerID int) ([]KYCReview, error) {
+	query := `SELECT id, playerId, oldStatus, newStatus, note, reviewedBy, createdAt FROM kyc_reviews WHERE playerId = ? ORDER BY id DESC;`
+
+	rows, err := DB.Query(query, playerID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching KYC reviews: %v", err)
+	}
+	defer rows.Close()
+
+	reviews := []KYCReview{}
+	for rows.Next() {
+		var review KYCReview
+		var reviewedBy sql.NullInt64
+		if err := rows.Scan(&review.ID, &review.PlayerID, &review.OldStatus, &review.NewStatus, &review.Note, &reviewedBy, &review.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error reading KYC review: %v", err)
+		}
+		if reviewedBy.Valid {
+			reviewerID := int(reviewedBy.Int64)
+			review.ReviewedBy = &reviewerID
+		}
+		reviews = append(reviews, review)
+	}
+
+	return reviews, rows.Err()
+}
+
+// GetKYCQueue returns a page of the players with that KYC status, longest waiting first
+func GetKYCQueue(status string, limit int, offset int) ([]KYCQueueEntry, error) {
+	query := `SELECT p.id, p.name, p.kycStatus, (SELECT MAX(createdAt) FROM kyc_reviews WHERE playerId = p.id) AS submittedAt
+	          FROM players p WHERE p.kycStatus = ? ORDER BY submittedAt, p.id LIMIT ? OFFSET ?;`
+
+	rows, err := DB.Query(query, status, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching KYC queue: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []KYCQueueEntry{}
+	for rows.Next() {
+		var entry KYCQueueEntry
+		var submittedAt sql.NullString
+		if err := rows.Scan(&entry.PlayerID, &entry.Name, &entry.KYCStatus, &submittedAt); err != nil {
+			return nil, fmt.Errorf("error reading KYC queue: %v", err)
+		}
+		// MAX() loses the column type, the timestamp comes back as text
+		if submittedAt.Valid {
+			if parsed, err := time.Parse(sqliteTimeFormat, submittedAt.String); err == nil {
+				entry.SubmittedAt = &parsed
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// CheckKYCForWithdrawal returns a KYCRequiredError if the withdrawal would take the player's total withdrawals
+// over the threshold while their identity isn't verified
+func CheckKYCForWithdrawal(playerID int, kycStatus string, amount float32, threshold float32) error {
+	if kycStatus == KYCVerified {
+		return nil
+	}
+
+	withdrawn, err := GetWithdrawnTotal(playerID)
+	if err != nil {
+		return err
+	}
+
+	if withdrawn+amount <= threshold {
+		return nil
+	}
+
+	return &KYCRequiredError{KYCStatus: kycStatus, Threshold: threshold, Withdrawn: withdrawn}
+}
@@ -0,0 +1,156 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Leaderboards
+const (
+	BoardBiggestWin        = "biggest-win"        // Largest payout of a single bet
+	BoardHighestMultiplier = "highest-multiplier" // Largest payout / stake of a single bet (free bets excluded)
+	BoardMostWagered       = "most-wagered"       // Total staked
+)
+
+// Leaderboard periods, besides the daily / weekly limit periods
+const PeriodAllTime = "all-time"
+
+var LeaderboardBoards = []string{BoardBiggestWin, BoardHighestMultiplier, BoardMostWagered}
+var LeaderboardPeriods = []string{PeriodDaily, PeriodWeekly, PeriodAllTime}
+
+type LeaderboardEntry struct {
+	Rank     int     `json:"rank"`
+	PlayerID int     `json:"-"` // Leaderboards are public
+	Name     string  `json:"name"`
+	Value    float32 `json:"value"`
+}
+
+func IsValidLeaderboard(board string) bool {
+	return slices.Contains(LeaderboardBoards, board)
+}
+
+func IsValidLeaderboardPeriod(period string) bool {
+	return slices.Contains(LeaderboardPeriods, period)
+}
+
+// LeaderboardPeriodStart returns the start of the current period (zero time for all-time)
+func LeaderboardPeriodStart(period string, now time.Time) time.Time {
+	if period == PeriodAllTime {
+		return time.Time{}
+	}
+
+	return periodStart(period, now)
+}
+
+// BetLeaderboardValue returns what a settled bet scores on the board (amounts in the base currency), false if it doesn't count
+func BetLeaderboardValue(board string, bet Bet) (float32, bool) {
+	switch board {
+	case BoardBiggestWin:
+		return ToBaseCurrency(bet.Winnings, bet.Currency), bet.PlayerWin
+	case BoardHighestMultiplier:
+		if !bet.PlayerWin || bet.BetAmount <= 0 {
+			return 0, false
+		}
+		return roundToCents(bet.Winnings / bet.BetAmount), true
+	case BoardMostWagered:
+		return ToBaseCurrency(bet.BetAmount, bet.Currency), bet.BetAmount > 0
+	}
+
+	return 0, false
+}
+
+// AddLeaderboardScore adds a bet's value to the player's score: most-wagered adds up the bets, the other boards keep the best bet
+func AddLeaderboardScore(board string, scores map[int]float32, playerID int, value float32) {
+	if board == BoardMostWagered {
+		scores[playerID] = roundToCents(scores[playerID] + value)
+	} else if current, found := scores[playerID]; !found || value > current {
+		scores[playerID] = value
+	}
+}
+
+// GetLeaderboardScores returns every player's score on the board over the bets settled since the given time, up to the bet lastBetID
+// Amounts are compared in the base currency, at the rate each bet was placed at
+func GetLeaderboardScores(board string, since time.Time, lastBetID int) (map[int]float32, error) {
+	var score string
+	where := "createdAt >= ? AND id <= ?"
+	switch board {
+	case BoardBiggestWin:
+		score = "MAX(winnings * exchangeRate)"
+		where += " AND playerWin"
+	case BoardHighestMultiplier:
+		score = "MAX(ROUND(winnings / betAmount, 2))"
+		where += " AND playerWin AND betAmount > 0"
+	case BoardMostWagered:
+		score = "SUM(betAmount * exchangeRate)"
+		where += " AND betAmount > 0"
+	default:
+		return nil, fmt.Errorf("unknown leaderboard %s", board)
+	}
+
+	query := fmt.Sprintf(`SELECT playerId, %s FROM bets WHERE %s GROUP BY playerId;`, score, where)
+	rows, err := DB.Query(query, since.UTC().Format(sqliteTimeFormat), lastBetID)
+	if err != nil {
+		return nil, fmt.Errorf("error computing leaderboard: %v", err)
+	}
+	defer rows.Close()
+
+	scores := make(map[int]float32)
+	for rows.Next() {
+		var playerID int
+		var value float64
+		if err := rows.Scan(&playerID, &value); err != nil {
+			return nil, fmt.Errorf("error reading leaderboard score: %v", err)
+		}
+		scores[playerID] = roundToCents(float32(value))
+	}
+
+	return scores, rows.Err()
+}
+
+// GetPublicNames returns the names to show publicly for the players, masked for the players who asked for it
+func GetPublicNames(playerIDs []int) (map[int]string, error) {
+	names := make(map[int]string)
+	if len(playerIDs) == 0 {
+		return names, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(playerIDs)), ", ")
+	args := make([]any, len(playerIDs))
+	for i, playerID := range playerIDs {
+		args[i] = playerID
+	}
+
+	rows, err := DB.Query(`SELECT id, name, maskName FROM players WHERE id IN (`+placeholders+`);`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching player names: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var playerID int
+		var name string
+		var maskName bool
+		if err := rows.Scan(&playerID, &name, &maskName); err != nil {
+			return nil, fmt.Errorf("error reading player name: %v", err)
+		}
+
+		if maskName {
+			name = MaskPlayerName(name)
+		}
+		names[playerID] = name
+	}
+
+	return names, rows.Err()
+}
+
+// MaskPlayerName keeps the first letter of a player's name, e.g. "Alice" -> "A****"
+func MaskPlayerName(name string) string {
+	runes := []rune(name)
+	if len(runes) == 0 {
+		return name
+	}
+
+	return string(runes[0]) + strings.Repeat("*", len(runes)-1)
+}
@@ -0,0 +1,284 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Responsible gaming limit types
+const (
+	LimitDeposit = "deposit" // Total deposited
+	LimitLoss    = "loss"    // Net loss on settled bets
+	LimitWager   = "wager"   // Total staked
+)
+
+// Limit periods (calendar periods in UTC, weeks start on Monday)
+const (
+	PeriodDaily   = "daily"
+	PeriodWeekly  = "weekly"
+	PeriodMonthly = "monthly"
+)
+
+var LimitTypes = []string{LimitDeposit, LimitLoss, LimitWager}
+var LimitPeriods = []string{PeriodDaily, PeriodWeekly, PeriodMonthly}
+
+// ErrLimitNotFound is returned when removing a limit the player doesn't have
+var ErrLimitNotFound = errors.New("No such limit to remove")
+
+// Error codes sent to clients when a limit would be exceeded
+var limitErrorCodes = map[string]string{
+	LimitDeposit: "DEPOSIT_LIMIT_EXCEEDED",
+	LimitLoss:    "LOSS_LIMIT_EXCEEDED",
+	LimitWager:   "WAGER_LIMIT_EXCEEDED",
+}
+
+// Format of DATETIME columns filled by CURRENT_TIMESTAMP
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+type PlayerLimit struct {
+	PlayerID           int        `json:"playerId"`
+	LimitType          string     `json:"limitType"`
+	Period             string     `json:"period"`
+	Amount             *float32   `json:"amount"`             // Limit in effect (nil = no limit)
+	PendingAmount      *float32   `json:"pendingAmount"`      // Increase waiting for the cooling-off period (nil = removal if PendingEffectiveAt is set)
+	PendingEffectiveAt *time.Time `json:"pendingEffectiveAt"` // When the pending increase takes effect
+	Used               float32    `json:"used"`               // Amount used in the current period
+	Remaining          *float32   `json:"remaining"`          // nil = no limit
+}
+
+// LimitError is returned when an action would exceed one of the player's limits
+type LimitError struct {
+	Code      string
+	LimitType string
+	Period    string
+	Limit     float32
+	Remaining float32
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s %s limit of %.2f would be exceeded, remaining allowance is %.2f", e.Period, e.LimitType, e.Limit, e.Remaining)
+}
+
+func IsValidLimitType(limitType string) bool {
+	return slices.Contains(LimitTypes, limitType)
+}
+
+func IsValidLimitPeriod(period string) bool {
+	return slices.Contains(LimitPeriods, period)
+}
+
+// periodStart returns the start of the current calendar period
+func periodStart(period string, now time.Time) time.Time {
+	now = now.UTC()
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch period {
+	case PeriodWeekly:
+		daysSinceMonday := (int(now.Weekday()) + 6) % 7
+		return startOfDay.AddDate(0, 0, -daysSinceMonday)
+	case PeriodMonthly:
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return startOfDay
+	}
+}
+
+// GetLimitUsage returns how much of a limit type the player used since the start of the period, in the base currency
+func GetLimitUsage(playerID int, limitType string, period string) (float32, error) {
+	var query string
+	switch limitType {
+	case LimitDeposit:
+		// Deposits still waiting for the provider count too, or several could be started past the limit
+		query = `SELECT COALESCE(SUM(amount * exchangeRate), 0) FROM (
+		             SELECT amount, exchangeRate FROM transactions WHERE playerId = ?1 AND type = 'deposit' AND createdAt >= ?2
+		             UNION ALL
+		             SELECT amount, exchangeRate FROM payments WHERE playerId = ?1 AND kind = 'deposit' AND status = 'pending' AND createdAt >= ?2
+		         );`
+	case LimitWager:
+		query = `SELECT COALESCE(SUM(betAmount * exchangeRate), 0) FROM bets WHERE playerId = ? AND createdAt >= ?;`
+	case LimitLoss:
+		// Winnings on a win are the payout (stake included), on a loss minus the stake
+		query = `SELECT COALESCE(SUM(CASE WHEN playerWin THEN betAmount - winnings ELSE betAmount END * exchangeRate), 0) FROM bets WHERE playerId = ? AND createdAt >= ?;`
+	default:
+		return 0, fmt.Errorf("unknown limit type %s", limitType)
+	}
+
+	var used float64
+	err := DB.QueryRow(query, playerID, periodStart(period, time.Now()).Format(sqliteTimeFormat)).Scan(&used)
+	if err != nil {
+		return 0, fmt.Errorf("error computing limit usage: %v", err)
+	}
+
+	// Net winnings don't make room beyond the limit
+	if used < 0 {
+		used = 0
+	}
+
+	return roundToCents(float32(used)), nil
+}
+
+// GetPlayerLimits returns the player's limits with their usage, applying pending increases whose cooling-off ended
+func GetPlayerLimits(playerID int) ([]PlayerLimit, error) {
+	query := `SELECT playerId, limitType, period, amount, pendingAmount, pendingEffectiveAt
+	          FROM player_limits WHERE playerId = ? ORDER BY limitType, period;`
+
+	rows, err := DB.Query(query, playerID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching limits: %v", err)
+	}
+
+	limits := []PlayerLimit{}
+	for rows.Next() {
+		var limit PlayerLimit
+		var amount, pendingAmount sql.NullFloat64
+		var pendingEffectiveAt sql.NullTime
+		if err := rows.Scan(&limit.PlayerID, &limit.LimitType, &limit.Period, &amount, &pendingAmount, &pendingEffectiveAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error reading limit: %v", err)
+		}
+
+		limit.Amount = nullableAmount(amount)
+		limit.PendingAmount = nullableAmount(pendingAmount)
+		if pendingEffectiveAt.Valid {
+			limit.PendingEffectiveAt = &pendingEffectiveAt.Time
+		}
+
+		limits = append(limits, limit)
+	}
+	rows.Close()
+
+	// Rows must be closed before running other queries (single connection)
+	activeLimits := []PlayerLimit{}
+	for _, limit := range limits {
+		if limit.PendingEffectiveAt != nil && !limit.PendingEffectiveAt.After(time.Now()) {
+			if err := applyPendingLimit(limit); err != nil {
+				return nil, err
+			}
+			limit.Amount = limit.PendingAmount
+			limit.PendingAmount = nil
+			limit.PendingEffectiveAt = nil
+		}
+
+		// Removed limit, nothing left to show
+		if limit.Amount == nil && limit.PendingEffectiveAt == nil {
+			continue
+		}
+
+		used, err := GetLimitUsage(playerID, limit.LimitType, limit.Period)
+		if err != nil {
+			return nil, err
+		}
+		limit.Used = used
+
+		if limit.Amount != nil {
+			remaining := max(*limit.Amount-used, 0)
+			limit.Remaining = &remaining
+		}
+
+		activeLimits = append(activeLimits, limit)
+	}
+
+	return activeLimits, nil
+}
+
+// SetPlayerLimit changes a limit (nil amount removes it)
+// Decreases (and new limits) apply immediately, increases and removals only after the cooling-off period
+// Returns true if the change applied immediately, ErrLimitNotFound when removing a limit that isn't set
+func SetPlayerLimit(playerID int, limitType string, period string, amount *float32, coolingOff time.Duration) (bool, error) {
+	limits, err := GetPlayerLimits(playerID)
+	if err != nil {
+		return false, err
+	}
+
+	var current *float32
+	for _, limit := range limits {
+		if limit.LimitType == limitType && limit.Period == period {
+			current = limit.Amount
+		}
+	}
+
+	isDecrease := amount != nil && (current == nil || *amount <= *current)
+
+	if isDecrease {
+		query := `INSERT INTO player_limits (playerId, limitType, period, amount, pendingAmount, pendingEffectiveAt)
+		          VALUES (?, ?, ?, ?, NULL, NULL)
+		          ON CONFLICT (playerId, limitType, period) DO UPDATE SET amount = excluded.amount, pendingAmount = NULL, pendingEffectiveAt = NULL;`
+		_, err = DB.Exec(query, playerID, limitType, period, roundToCents(*amount))
+		if err != nil {
+			return false, fmt.Errorf("error setting limit: %v", err)
+		}
+		return true, nil
+	}
+
+	var pendingAmount any // NULL for removals
+	if amount != nil {
+		pendingAmount = roundToCents(*amount)
+	}
+
+	query := `UPDATE player_limits SET pendingAmount = ?, pendingEffectiveAt = ? WHERE playerId = ? AND limitType = ? AND period = ?;`
+	result, err := DB.Exec(query, pendingAmount, time.Now().UTC().Add(coolingOff), playerID, limitType, period)
+	if err != nil {
+		return false, fmt.Errorf("error setting limit: %v", err)
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return false, ErrLimitNotFound
+	}
+
+	return false, nil
+}
+
+// CheckLimits returns a LimitError if adding the amount to the usage of the given limit type exceeds any of its periods
+// Limits are in the base currency, so is the amount
+func CheckLimits(playerID int, limitType string, amount float32) error {
+	limits, err := GetPlayerLimits(playerID)
+	if err != nil {
+		return err
+	}
+
+	for _, limit := range limits {
+		if limit.LimitType != limitType || limit.Remaining == nil {
+			continue
+		}
+
+		if amount > *limit.Remaining {
+			return &LimitError{
+				Code:      limitErrorCodes[limitType],
+				LimitType: limitType,
+				Period:    limit.Period,
+				Limit:     *limit.Amount,
+				Remaining: *limit.Remaining,
+			}
+		}
+	}
+
+	return nil
+}
+
+func applyPendingLimit(limit PlayerLimit) error {
+	var err error
+	if limit.PendingAmount == nil {
+		_, err = DB.Exec(`DELETE FROM player_limits WHERE playerId = ? AND limitType = ? AND period = ?;`, limit.PlayerID, limit.LimitType, limit.Period)
+	} else {
+		query := `UPDATE player_limits SET amount = pendingAmount, pendingAmount = NULL, pendingEffectiveAt = NULL
+		          WHERE playerId = ? AND limitType = ? AND period = ?;`
+		_, err = DB.Exec(query, limit.PlayerID, limit.LimitType, limit.Period)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error applying pending limit: %v", err)
+	}
+
+	return nil
+}
+
+func nullableAmount(value sql.NullFloat64) *float32 {
+	if !value.Valid {
+		return nil
+	}
+
+	amount := float32(value.Float64)
+	return &amount
+}
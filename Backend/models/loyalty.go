@@ -0,0 +1,222 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// VIP tiers, from the lowest
+const (
+	TierBronze   = "bronze"
+	TierSilver   = "silver"
+	TierGold     = "gold"
+	TierPlatinum = "platinum"
+)
+
+type VIPTier struct {
+	Name                 string  `json:"name"`
+	MinWagered           float32 `json:"minWagered"`           // Wagered volume over the rolling window needed to reach the tier
+	PointsMultiplier     float32 `json:"pointsMultiplier"`     // Applied to the game's points rate
+	MaxBet               float32 `json:"maxBet"`               // Largest stake of a single bet (0 = no maximum)
+	DailyWithdrawalLimit float32 `json:"dailyWithdrawalLimit"` // Total that can be withdrawn per UTC day (0 = no limit)
+	CashbackRate         float32 `json:"cashbackRate"`         // % of the net losses given back as cashback
+}
+
+// Tiers ordered from the lowest, a player is in the highest tier whose MinWagered they reached
+var VIPTiers = []VIPTier{
+	{Name: TierBronze, MinWagered: 0, PointsMultiplier: 1, MaxBet: 500, DailyWithdrawalLimit: 1000, CashbackRate: 5},
+	{Name: TierSilver, MinWagered: 1000, PointsMultiplier: 1.25, MaxBet: 1000, DailyWithdrawalLimit: 5000, CashbackRate: 7.5},
+	{Name: TierGold, MinWagered: 5000, PointsMultiplier: 1.5, MaxBet: 5000, DailyWithdrawalLimit: 20000, CashbackRate: 10},
+	{Name: TierPlatinum, MinWagered: 25000, PointsMultiplier: 2, MaxBet: 0, DailyWithdrawalLimit: 0, CashbackRate: 15},
+}
+
+// Loyalty points earned per 1 wagered, by game
+var gamePointRates = map[string]float32{
+	GameDice:  1,
+	GameCrash: 1,
+}
+
+// Error codes sent to clients when a tier limit would be exceeded
+const (
+	VIPMaxBetExceeded          = "VIP_MAX_BET_EXCEEDED"
+	VIPWithdrawalLimitExceeded = "VIP_WITHDRAWAL_LIMIT_EXCEEDED"
+)
+
+type LoyaltyStatus struct {
+	Points            float32  `json:"points"`
+	Tier              VIPTier  `json:"tier"`
+	WageredVolume     float32  `json:"wageredVolume"` // Over the rolling window
+	WindowDays        float32  `json:"windowDays"`
+	NextTier          *VIPTier `json:"nextTier"`          // nil at the highest tier
+	WageredToNextTier float32  `json:"wageredToNextTier"` // Volume still needed to reach the next tier
+}
+
+// LoyaltyAccrual is the result of a settled wager
+type LoyaltyAccrual struct {
+	Points       float32 // Earned on the wager
+	TotalPoints  float32
+	Tier         VIPTier
+	PreviousTier string
+}
+
+// TierUp is true if the wager moved the player to a higher tier
+func (a LoyaltyAccrual) TierUp() bool {
+	return tierRank(a.Tier.Name) > tierRank(a.PreviousTier)
+}
+
+// GetVIPTier returns the tier with that name (the lowest tier if unknown)
+func GetVIPTier(name string) VIPTier {
+	if rank := tierRank(name); rank >= 0 {
+		return VIPTiers[rank]
+	}
+
+	return VIPTiers[0]
+}
+
+func tierRank(name string) int {
+	for rank, tier := range VIPTiers {
+		if tier.Name == name {
+			return rank
+		}
+	}
+
+	return -1
+}
+
+// tierForVolume returns the highest tier reached with the wagered volume
+func tierForVolume(volume float32) VIPTier {
+	tier := VIPTiers[0]
+	for _, candidate := range VIPTiers {
+		if volume >= candidate.MinWagered {
+			tier = candidate
+		}
+	}
+
+	return tier
+}
+
+// GetWageredVolume returns the total the player staked over the last windowDays
+func GetWageredVolume(playerID int, windowDays float32) (float32, error) {
+	since := time.Now().Add(-time.Duration(windowDays * float32(24*time.Hour)))
+
+	var volume float64
+	query := `SELECT COALESCE(SUM(betAmount * exchangeRate), 0) FROM bets WHERE playerId = ? AND createdAt >= ?;`
+	err := DB.QueryRow(query, playerID, since.UTC().Format(sqliteTimeFormat)).Scan(&volume)
+	if err != nil {
+		return 0, fmt.Errorf("error computing wagered volume: %v", err)
+	}
+
+	return roundToCents(float32(volume)), nil
+}
+
+// RefreshVIPTier recomputes the player's tier from their rolling wagered volume (tiers also go down as old bets leave the window)
+// Returns the current tier, the previous one and the volume
+func RefreshVIPTier(playerID int, windowDays float32) (VIPTier, string, float32, error) {
+	var previousTier string
+	err := DB.QueryRow(`SELECT vipTier FROM players WHERE id = ?;`, playerID).Scan(&previousTier)
+	if err != nil {
+		return VIPTier{}, "", 0, fmt.Errorf("error fetching VIP tier: %v", err)
+	}
+
+	volume, err := GetWageredVolume(playerID, windowDays)
+	if err != nil {
+		return VIPTier{}, "", 0, err
+	}
+
+	tier := tierForVolume(volume)
+	if tier.Name != previousTier {
+		if _, err := DB.Exec(`UPDATE players SET vipTier = ? WHERE id = ?;`, tier.Name, playerID); err != nil {
+			return VIPTier{}, "", 0, fmt.Errorf("error updating VIP tier: %v", err)
+		}
+	}
+
+	return tier, previousTier, volume, nil
+}
+
+// AccrueLoyaltyPoints credits the points of a settled wager (already recorded in the bets) at the game's rate and the tier's multiplier
+func AccrueLoyaltyPoints(playerID int, game string, stake float32, windowDays float32) (LoyaltyAccrual, error) {
+	tier, previousTier, _, err := RefreshVIPTier(playerID, windowDays)
+	if err != nil {
+		return LoyaltyAccrual{}, err
+	}
+
+	accrual := LoyaltyAccrual{
+		Points:       roundToCents(stake * gamePointRates[game] * tier.PointsMultiplier),
+		Tier:         tier,
+		PreviousTier: previousTier,
+	}
+
+	query := `UPDATE players SET loyaltyPoints = ROUND(loyaltyPoints + ?, 2) WHERE id = ? RETURNING loyaltyPoints;`
+	err = DB.QueryRow(query, accrual.Points, playerID).Scan(&accrual.TotalPoints)
+	if err != nil {
+		return LoyaltyAccrual{}, fmt.Errorf("error crediting loyalty points: %v", err)
+	}
+
+	return accrual, nil
+}
+
+// GetLoyaltyStatus returns the player's points, tier and progress towards the next tier
+func GetLoyaltyStatus(playerID int, windowDays float32) (LoyaltyStatus, error) {
+	tier, _, volume, err := RefreshVIPTier(playerID, windowDays)
+	if err != nil {
+		return LoyaltyStatus{}, err
+	}
+
+	status := LoyaltyStatus{Tier: tier, WageredVolume: volume, WindowDays: windowDays}
+
+	err = DB.QueryRow(`SELECT loyaltyPoints FROM players WHERE id = ?;`, playerID).Scan(&status.Points)
+	if err != nil {
+		return LoyaltyStatus{}, fmt.Errorf("error fetching loyalty points: %v", err)
+	}
+
+	if rank := tierRank(tier.Name); rank+1 < len(VIPTiers) {
+		nextTier := VIPTiers[rank+1]
+		status.NextTier = &nextTier
+		status.WageredToNextTier = roundToCents(nextTier.MinWagered - volume)
+	}
+
+	return status, nil
+}
+
+// CheckVIPMaxBet returns a LimitError if the stake is above the max bet of the tier
+func CheckVIPMaxBet(tier VIPTier, betAmount float32) error {
+	if tier.MaxBet == 0 || betAmount <= tier.MaxBet {
+		return nil
+	}
+
+	return &LimitError{
+		Code:      VIPMaxBetExceeded,
+		LimitType: "max_bet",
+		Period:    tier.Name,
+		Limit:     tier.MaxBet,
+		Remaining: tier.MaxBet,
+	}
+}
+
+// CheckVIPWithdrawalLimit returns a LimitError if the withdrawal would go over the player's daily withdrawal limit of the tier
+func CheckVIPWithdrawalLimit(playerID int, tier VIPTier, amount float32) error {
+	if tier.DailyWithdrawalLimit == 0 {
+		return nil
+	}
+
+	// Withdrawals that were cancelled / rejected don't count
+	var withdrawn float64
+	query := `SELECT COALESCE(SUM(amount * exchangeRate), 0) FROM withdrawals WHERE playerId = ? AND status NOT IN (?, ?) AND createdAt >= ?;`
+	err := DB.QueryRow(query, playerID, WithdrawalRejected, WithdrawalCancelled, periodStart(PeriodDaily, time.Now()).Format(sqliteTimeFormat)).Scan(&withdrawn)
+	if err != nil {
+		return fmt.Errorf("error computing withdrawn amount: %v", err)
+	}
+
+	remaining := roundToCents(max(tier.DailyWithdrawalLimit-float32(withdrawn), 0))
+	if amount <= remaining {
+		return nil
+	}
+
+	return &LimitError{
+		Code:      VIPWithdrawalLimitExceeded,
+		LimitType: "withdrawal",
+		Period:    PeriodDaily,
+		Limit:     tier.DailyWithdrawalLimit,
+		Remaining: remaining,
+	}
+}
@@ -0,0 +1,179 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Payment statuses
+const (
+	PaymentPending   = "pending"   // Created at the provider, waiting for its confirmation
+	PaymentSucceeded = "succeeded" // Confirmed by a verified webhook of the provider
+	PaymentFailed    = "failed"    // Declined / cancelled at the provider
+)
+
+// Payment kinds
+const (
+	PaymentDeposit = "deposit"
+	PaymentPayout  = "payout"
+)
+
+type Payment struct {
+	ID                int        `json:"id"`
+	PlayerID          int        `json:"-"`
+	Kind              string     `json:"kind"`
+	Amount            float32    `json:"amount"`
+	Currency          string     `json:"currency"`
+	ExchangeRate      float32    `json:"exchangeRate"` // Rate to the base currency when it was created
+	Provider          string     `json:"provider"`
+	ProviderReference *string    `json:"providerReference"`
+	Status            string     `json:"status"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	CompletedAt       *time.Time `json:"completedAt"`
+}
+
+const paymentColumns = `id, playerId, kind, amount, currency, exchangeRate, provider, providerReference, status, createdAt, completedAt`
+
+func scanPayment(row scanner) (*Payment, error) {
+	var payment Payment
+	var providerReference sql.NullString
+	var completedAt sql.NullTime
+
+	err := row.Scan(&payment.ID, &payment.PlayerID, &payment.Kind, &payment.Amount, &payment.Currency, &payment.ExchangeRate, &payment.Provider, &providerReference, &payment.Status, &payment.CreatedAt, &completedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if providerReference.Valid {
+		payment.ProviderReference = &providerReference.String
+	}
+	if completedAt.Valid {
+		payment.CompletedAt = &completedAt.Time
+	}
+
+	return &payment, nil
+}
+
+// CreatePayment records a pending payment (amount in the currency) before it's created at the provider
+func CreatePayment(playerID int, kind string, amount float32, currency string, provider string) (*Payment, error) {
+	query := `INSERT INTO payments (playerId, kind, amount, currency, exchangeRate, provider) VALUES (?, ?, ?, ?, ?, ?) RETURNING ` + paymentColumns + `;`
+
+	payment, err := scanPayment(DB.QueryRow(query, playerID, kind, roundToCents(amount), currency, ExchangeRate(currency), provider))
+	if err != nil {
+		return nil, fmt.Errorf("error creating payment: %v", err)
+	}
+
+	return payment, nil
+}
+
+// SetPaymentReference stores the provider's ID of the payment
+func SetPaymentReference(id int, providerReference string) error {
+	_, err := DB.Exec(`UPDATE payments SET providerReference = ? WHERE id = ?;`, providerReference, id)
+	if err != nil {
+		return fmt.Errorf("error updating payment: %v", err)
+	}
+
+	return nil
+}
+
+func GetPaymentByID(id int) (*Payment, error) {
+	payment, err := scanPayment(DB.QueryRow(`SELECT `+paymentColumns+` FROM payments WHERE id = ?;`, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("payment not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching payment: %v", err)
+	}
+
+	return payment, nil
+}
+
+// GetPaymentByReference returns the payment the provider knows by that reference
+func GetPaymentByReference(provider string, providerReference string) (*Payment, error) {
+	query := `SELECT ` + paymentColumns + ` FROM payments WHERE provider = ? AND providerReference = ?;`
+
+	payment, err := scanPayment(DB.QueryRow(query, provider, providerReference))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("payment not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching payment: %v", err)
+	}
+
+	return payment, nil
+}
+
+// GetPaymentsByPlayerID returns a page of the player's payments, newest first
+func GetPaymentsByPlayerID(playerID int, limit int, offset int) ([]Payment, error) {
+	query := `SELECT ` + paymentColumns + ` FROM payments WHERE playerId = ? ORDER BY id DESC LIMIT ? OFFSET ?;`
+
+	rows, err := DB.Query(query, playerID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching payments: %v", err)
+	}
+	defer rows.Close()
+
+	payments := []Payment{}
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading payment: %v", err)
+		}
+		payments = append(payments, *payment)
+	}
+
+	return payments, rows.Err()
+}
+
+// CompleteDeposit marks a pending deposit as succeeded and credits the player's wallet in its currency, all or nothing
+// Returns false if the payment wasn't a pending deposit anymore (already handled)
+func CompleteDeposit(id int) (bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var playerID int
+	var amount float32
+	var currency string
+	query := `UPDATE payments SET status = ?, completedAt = CURRENT_TIMESTAMP WHERE id = ? AND kind = ? AND status = ? RETURNING playerId, amount, currency;`
+	err = tx.QueryRow(query, PaymentSucceeded, id, PaymentDeposit, PaymentPending).Scan(&playerID, &amount, &currency)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error completing payment: %v", err)
+	}
+
+	// The player may have switched currency since the deposit was started
+	wallet, betBalance, active, err := creditWallet(tx, playerID, currency, amount)
+	if err != nil {
+		return false, err
+	}
+
+	err = recordTransaction(tx, playerID, TransactionDeposit, amount, wallet, betBalance, fmt.Sprintf("payment:%d", id), currency)
+	if err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing deposit: %v", err)
+	}
+
+	notifyWalletUpdate(playerID, wallet, betBalance, active)
+
+	return true, nil
+}
+
+// SetPaymentStatus moves a pending payment to its final status, returns false if it wasn't pending anymore
+func SetPaymentStatus(id int, status string) (bool, error) {
+	result, err := DB.Exec(`UPDATE payments SET status = ?, completedAt = CURRENT_TIMESTAMP WHERE id = ? AND status = ?;`, status, id, PaymentPending)
+	if err != nil {
+		return false, fmt.Errorf("error updating payment: %v", err)
+	}
+
+	updated, _ := result.RowsAffected()
+	return updated == 1, nil
+}
@@ -2,46 +2,159 @@ package models
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"main/events"
 	"math"
+	"slices"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
 
-type Player struct {
-	ID         int     `json:"id"`
-	Password   string  `json:"password"`
-	Name       string  `json:"name"`
-	Wallet     float32 `json:"wallet"`     // FLOAT 32 to avoid crazy floating point issues
-	BetBalance float32 `json:"betBalance"` // + I don't think anybody has more than 2,147,483,647 in their account xD
-	IsBetting  bool    `json:"isBetting"`
+// Player roles carried in the JWT claims
+const (
+	RolePlayer  = "player"
+	RoleSupport = "support" // Can read the admin API but not mutate anything
+	RoleAdmin   = "admin"
+)
+
+// Player account statuses
+const (
+	StatusActive       = "active"
+	StatusFrozen       = "frozen"        // Can still withdraw / cash in, but can't bet or deposit
+	StatusSuspended    = "suspended"     // Can't use the account at all (pending investigation)
+	StatusSelfExcluded = "self_excluded" // Can still withdraw / cash in, but can't bet or deposit
+	StatusClosed       = "closed"
+)
+
+var PlayerStatuses = []string{StatusActive, StatusFrozen, StatusSuspended, StatusSelfExcluded, StatusClosed}
+
+// Account actions that depend on the player status
+const (
+	ActionPlay     = "play"
+	ActionDeposit  = "deposit"
+	ActionWithdraw = "withdraw"
+	ActionCashIn   = "cash_in"
+	ActionTransfer = "transfer" // Sending / receiving player-to-player transfers
+)
+
+// AccountStatusError is returned when the player's status doesn't allow an action
+// Code is sent to clients as "errorCode" so they can tell the statuses apart
+type AccountStatusError struct {
+	Code    string
+	Message string
+}
+
+func (e *AccountStatusError) Error() string {
+	return e.Message
 }
 
-// Deducts the bet amount, prioritizing bet balance over wallet
-func (p *Player) DeductBetAmount(betAmount float32) error {
+// Error codes for each blocking status
+var accountStatusErrors = map[string]*AccountStatusError{
+	StatusFrozen:       {Code: "ACCOUNT_FROZEN", Message: "Account is frozen, only withdrawals are allowed"},
+	StatusSuspended:    {Code: "ACCOUNT_SUSPENDED", Message: "Account is suspended"},
+	StatusSelfExcluded: {Code: "ACCOUNT_SELF_EXCLUDED", Message: "Account is self-excluded, only withdrawals are allowed"},
+	StatusClosed:       {Code: "ACCOUNT_CLOSED", Message: "Account is closed"},
+}
+
+// Actions still allowed for each status (active allows everything)
+var allowedActionsByStatus = map[string][]string{
+	StatusFrozen:       {ActionWithdraw, ActionCashIn},
+	StatusSuspended:    {},
+	StatusSelfExcluded: {ActionWithdraw, ActionCashIn},
+	StatusClosed:       {},
+}
+
+type Player struct {
+	ID              int        `json:"id"`
+	Password        string     `json:"-"` // Never send the password hash to clients
+	Name            string     `json:"name"`
+	Wallet          float32    `json:"wallet"`       // FLOAT 32 to avoid crazy floating point issues
+	BetBalance      float32    `json:"betBalance"`   // + I don't think anybody has more than 2,147,483,647 in their account xD
+	BonusBalance    float32    `json:"bonusBalance"` // Promotional money of the active bonuses (read only, see bonus.go)
+	IsBetting       bool       `json:"isBetting"`
+	Role            string     `json:"role"`
+	Status          string     `json:"status"`
+	StatusReason    string     `json:"statusReason"`
+	ExclusionUntil  *time.Time `json:"exclusionUntil"` // End of a self-exclusion (nil while self-excluded = permanent)
+	CoolOffUntil    *time.Time `json:"coolOffUntil"`   // End of a cool-off (play and deposits blocked until then)
+	ReferralCode    string     `json:"referralCode"`   // Code other players can register with (empty until assigned)
+	DeviceID        string     `json:"-"`              // Last X-Device-ID sent on register / login (self-referral checks)
+	LoyaltyPoints   float32    `json:"loyaltyPoints"`
+	VIPTier         string     `json:"vipTier"`         // Tier as of the player's last bet (see loyalty.go)
+	MaskName        bool       `json:"maskName"`        // Privacy preference, the name is masked on public boards
+	AcceptTransfers bool       `json:"acceptTransfers"` // Other players can send transfers to the player
+	KYCStatus       string     `json:"kycStatus"`       // Identity verification (see kyc.go)
+	Currency        string     `json:"currency"`        // Currency of the balances above, the one the player plays in (see wallet.go)
+}
 
-	// Deduct from bet balance first
-	if betAmount <= p.BetBalance {
-		p.BetBalance -= betAmount
-	} else {
-		// Deduct what's available from bet balance and then the rest
-		remaining := betAmount - p.BetBalance
-		p.BetBalance = 0
-		p.Wallet -= remaining
+// CanAuthenticate returns an AccountStatusError if the account can't be used at all
+func (p *Player) CanAuthenticate() error {
+	if p.Status == StatusSuspended || p.Status == StatusClosed {
+		return accountStatusErrors[p.Status]
 	}
 
 	return nil
 }
 
+// CanPerform returns an AccountStatusError if the player's status doesn't allow the action
+func (p *Player) CanPerform(action string) error {
+	// Cool-offs only block gambling, withdrawals and cash ins still work
+	if p.IsCoolingOff() && (action == ActionPlay || action == ActionDeposit) {
+		return &AccountStatusError{
+			Code:    "ACCOUNT_COOLING_OFF",
+			Message: fmt.Sprintf("Account is cooling off until %s, only withdrawals are allowed", p.CoolOffUntil.Format(time.RFC3339)),
+		}
+	}
+
+	allowedActions, restricted := allowedActionsByStatus[p.Status]
+	if !restricted || slices.Contains(allowedActions, action) {
+		return nil
+	}
+
+	return accountStatusErrors[p.Status]
+}
+
+func (p *Player) IsCoolingOff() bool {
+	return p.CoolOffUntil != nil && p.CoolOffUntil.After(time.Now())
+}
+
+// SelfExclusionEnded is true when a timed self-exclusion is over and the account can be reactivated
+func (p *Player) SelfExclusionEnded() bool {
+	return p.Status == StatusSelfExcluded && p.ExclusionUntil != nil && !p.ExclusionUntil.After(time.Now())
+}
+
+func IsValidPlayerStatus(status string) bool {
+	return slices.Contains(PlayerStatuses, status)
+}
+
+// DeductBetAmount takes the stake from the wallet first, then the bet balance and lastly the bonus balance
+// Returns the part of the stake that was funded by bonus money
+func (p *Player) DeductBetAmount(betAmount float32) (float32, error) {
+	if betAmount > p.Wallet+p.BetBalance+p.BonusBalance {
+		return 0, errors.New("betAmount exceeds player's balance, bet balance and bonus balance")
+	}
+
+	fromWallet := min(betAmount, p.Wallet)
+	fromBetBalance := min(betAmount-fromWallet, p.BetBalance)
+	fromBonus := betAmount - fromWallet - fromBetBalance
+
+	p.Wallet -= fromWallet
+	p.BetBalance -= fromBetBalance
+	p.BonusBalance -= fromBonus
+
+	return fromBonus, nil
+}
+
 // RegisterPlayer stores player data and returns the player ID
-func RegisterPlayer(playerName string, hashedPlayerPassword string) (int, error) {
+func RegisterPlayer(playerName string, hashedPlayerPassword string, currency string) (int, error) {
 	// Query to insert new player and return the auto-generated ID
-	query := `INSERT INTO players (name, password) 
-	          VALUES (?, ?);`
+	query := `INSERT INTO players (name, password, currency) 
+	          VALUES (?, ?, ?);`
 
 	// Execute the query and get the last inserted ID
-	result, err := DB.Exec(query, playerName, hashedPlayerPassword)
+	result, err := DB.Exec(query, playerName, hashedPlayerPassword, currency)
 	if err != nil {
 		return 0, err
 	}
@@ -54,10 +167,34 @@ func RegisterPlayer(playerName string, hashedPlayerPassword string) (int, error)
 
 	return int(playerID), nil
 }
-func GetPlayerByID(id int) (*Player, error) {
-	query := `SELECT id, name, password, wallet, betBalance, isBetting FROM players WHERE id = ?;`
+
+// Columns read by scanPlayer, in order
+const playerColumns = `id, name, password, wallet, betBalance, isBetting, role, status, statusReason, exclusionUntil, coolOffUntil, referralCode, deviceId, loyaltyPoints, vipTier, maskName, acceptTransfers, kycStatus, currency, ` + bonusBalanceSubquery
+
+func scanPlayer(row scanner) (*Player, error) {
 	var player Player
-	err := DB.QueryRow(query, id).Scan(&player.ID, &player.Name, &player.Password, &player.Wallet, &player.BetBalance, &player.IsBetting)
+	var exclusionUntil, coolOffUntil sql.NullTime
+	var referralCode sql.NullString
+	err := row.Scan(&player.ID, &player.Name, &player.Password, &player.Wallet, &player.BetBalance, &player.IsBetting, &player.Role, &player.Status, &player.StatusReason,
+		&exclusionUntil, &coolOffUntil, &referralCode, &player.DeviceID, &player.LoyaltyPoints, &player.VIPTier, &player.MaskName, &player.AcceptTransfers, &player.KYCStatus, &player.Currency, &player.BonusBalance)
+	if err != nil {
+		return nil, err
+	}
+
+	if exclusionUntil.Valid {
+		player.ExclusionUntil = &exclusionUntil.Time
+	}
+	if coolOffUntil.Valid {
+		player.CoolOffUntil = &coolOffUntil.Time
+	}
+	player.ReferralCode = referralCode.String
+
+	return &player, nil
+}
+
+func GetPlayerByID(id int) (*Player, error) {
+	query := `SELECT ` + playerColumns + ` FROM players WHERE id = ?;`
+	player, err := scanPlayer(DB.QueryRow(query, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// Player not found
@@ -65,14 +202,13 @@ func GetPlayerByID(id int) (*Player, error) {
 		}
 		return nil, fmt.Errorf("error fetching player: %v", err)
 	}
-	return &player, nil
+	return player, nil
 }
 
 func GetPlayerByName(name string) (*Player, error) {
 
-	query := "SELECT id, password FROM players WHERE name = ?"
-	var player Player
-	err := DB.QueryRow(query, name).Scan(&player.ID, &player.Password)
+	query := `SELECT ` + playerColumns + ` FROM players WHERE name = ?;`
+	player, err := scanPlayer(DB.QueryRow(query, name))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// Player not found with the given name
@@ -81,15 +217,13 @@ func GetPlayerByName(name string) (*Player, error) {
 		return nil, fmt.Errorf("error fetching player: %v", err)
 	}
 
-	return &player, nil
+	return player, nil
 }
 
 func GetPlayerByUsernameAndPassword(username, password string) (*Player, error) {
-	query := `SELECT id, name, password, wallet, betBalance, isBetting 
-	          FROM players WHERE name = ? AND password = ?;`
+	query := `SELECT ` + playerColumns + ` FROM players WHERE name = ? AND password = ?;`
 
-	var player Player
-	err := DB.QueryRow(query, username, password).Scan(&player.ID, &player.Name, &player.Password, &player.Wallet, &player.BetBalance, &player.IsBetting)
+	player, err := scanPlayer(DB.QueryRow(query, username, password))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// Player not found with the given username and password
@@ -98,7 +232,37 @@ func GetPlayerByUsernameAndPassword(username, password string) (*Player, error)
 		return nil, fmt.Errorf("error fetching player: %v", err)
 	}
 
-	return &player, nil
+	return player, nil
+}
+
+// SearchPlayers returns a page of players whose name contains the search term
+// and the total amount of players matching it
+func SearchPlayers(search string, limit int, offset int) ([]Player, int, error) {
+	pattern := "%" + search + "%"
+
+	var total int
+	err := DB.QueryRow(`SELECT COUNT(*) FROM players WHERE name LIKE ?;`, pattern).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error counting players: %v", err)
+	}
+
+	query := `SELECT ` + playerColumns + ` FROM players WHERE name LIKE ? ORDER BY id LIMIT ? OFFSET ?;`
+	rows, err := DB.Query(query, pattern, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error searching players: %v", err)
+	}
+	defer rows.Close()
+
+	players := []Player{}
+	for rows.Next() {
+		player, err := scanPlayer(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error reading player: %v", err)
+		}
+		players = append(players, *player)
+	}
+
+	return players, total, rows.Err()
 }
 
 func CheckPlayerBettingStatus(id int) (bool, error) {
@@ -117,9 +281,9 @@ func CheckPlayerBettingStatus(id int) (bool, error) {
 func UpdatePlayerBalance(playerId int, newWalletBalance float32, newBetBalance float32) error {
 
 	// Format values to have 2 decimal places
-	newWalletBalance = float32(math.Round(float64(newWalletBalance)*100) / 100)
-	newBetBalance = float32(math.Round(float64(newBetBalance)*100) / 100)
-	
+	newWalletBalance = roundToCents(newWalletBalance)
+	newBetBalance = roundToCents(newBetBalance)
+
 	// Update the player's wallet and bet balance
 	// This will also emit an event to notify listeners about the balance update
 
@@ -130,17 +294,173 @@ func UpdatePlayerBalance(playerId int, newWalletBalance float32, newBetBalance f
 		return err
 	}
 
+	emitBalanceUpdate(playerId, newWalletBalance, newBetBalance)
+
+	return nil
+}
+
+// NotifyBalanceUpdate sends the player's current balances to the wallet listeners
+// (for changes that don't go through UpdatePlayerBalance, e.g. bonuses granted)
+func NotifyBalanceUpdate(playerId int) error {
+	player, err := GetPlayerByID(playerId)
+	if err != nil {
+		return err
+	}
+
+	emitBalanceUpdate(playerId, player.Wallet, player.BetBalance)
+
+	return nil
+}
+
+func emitBalanceUpdate(playerId int, newWalletBalance float32, newBetBalance float32) {
 	// Emit Event for balance update
 	balanceUpdateEvent := fmt.Sprintf("BalanceUpdate_%d", playerId)
 
+	// Bonus money is kept in the bonuses table, send its current total along
+	newBonusBalance, _ := GetBonusBalance(playerId)
+	// Same for the withdrawals that are reserved but not paid yet
+	pendingWithdrawals, _ := GetPendingWithdrawals(playerId)
+
 	// Prepare the balance data to send with the event
 	var betData = events.EventWalletData{
-		Wallet:     newWalletBalance,
-		BetBalance: newBetBalance,
+		Wallet:             newWalletBalance,
+		BetBalance:         newBetBalance,
+		BonusBalance:       newBonusBalance,
+		PendingWithdrawals: pendingWithdrawals,
+	}
+
+	// The balances above are in the currency the player plays in, the other wallets are sent along
+	wallets, err := GetPlayerWallets(playerId)
+	if err == nil && len(wallets) > 0 {
+		betData.Currency = wallets[0].Currency
+		for _, wallet := range wallets {
+			betData.Wallets = append(betData.Wallets, events.WalletBalance{
+				Currency:           wallet.Currency,
+				Wallet:             wallet.Wallet,
+				BetBalance:         wallet.BetBalance,
+				PendingWithdrawals: wallet.PendingWithdrawals,
+			})
+		}
 	}
 
 	// Emit the event so listeners can react to it (e.g., update WebSocket clients)
 	events.GlobalEmitter.Emit(balanceUpdateEvent, betData)
+}
+
+// roundToCents rounds an amount to 2 decimal places
+func roundToCents(amount float32) float32 {
+	return float32(math.Round(float64(amount)*100) / 100)
+}
+
+// UpdatePlayerStatus changes the account status and keeps the change in the status history
+func UpdatePlayerStatus(id int, newStatus string, reason string, changedBy int) error {
+	player, err := GetPlayerByID(id)
+	if err != nil {
+		return err
+	}
+
+	// A self-exclusion end date only makes sense while self-excluded
+	query := `UPDATE players SET status = ?, statusReason = ?, 
+	          exclusionUntil = CASE WHEN ? = 'self_excluded' THEN exclusionUntil ELSE NULL END WHERE id = ?;`
+	_, err = DB.Exec(query, newStatus, reason, newStatus, id)
+	if err != nil {
+		return fmt.Errorf("player status was not updated: %v", err)
+	}
+
+	query = `INSERT INTO player_status_changes (playerId, oldStatus, newStatus, reason, changedBy) 
+	          VALUES (?, ?, ?, ?, ?);`
+	_, err = DB.Exec(query, id, player.Status, newStatus, reason, changedBy)
+	if err != nil {
+		return fmt.Errorf("error recording status change: %v", err)
+	}
+
+	return nil
+}
+
+// SetPlayerSelfExclusion self-excludes the player until the given time (nil = permanently)
+func SetPlayerSelfExclusion(id int, until *time.Time, reason string) error {
+	if err := UpdatePlayerStatus(id, StatusSelfExcluded, reason, id); err != nil {
+		return err
+	}
+
+	_, err := DB.Exec(`UPDATE players SET exclusionUntil = ? WHERE id = ?;`, until, id)
+	if err != nil {
+		return fmt.Errorf("player self-exclusion was not updated: %v", err)
+	}
+
+	return nil
+}
+
+// EndPlayerSelfExclusion reactivates an account whose timed self-exclusion is over (see SelfExclusionEnded)
+// Only if it's still self-excluded, a status set by an admin meanwhile (e.g. frozen) is kept
+func EndPlayerSelfExclusion(id int) error {
+	reason := "Self-exclusion period ended"
+
+	query := `UPDATE players SET status = ?, statusReason = ?, exclusionUntil = NULL
+	          WHERE id = ? AND status = ? AND exclusionUntil IS NOT NULL;`
+	result, err := DB.Exec(query, StatusActive, reason, id, StatusSelfExcluded)
+	if err != nil {
+		return fmt.Errorf("player self-exclusion was not updated: %v", err)
+	}
+
+	if ended, err := result.RowsAffected(); err != nil || ended == 0 {
+		return err
+	}
+
+	query = `INSERT INTO player_status_changes (playerId, oldStatus, newStatus, reason, changedBy) 
+	          VALUES (?, ?, ?, ?, ?);`
+	_, err = DB.Exec(query, id, StatusSelfExcluded, StatusActive, reason, id)
+	if err != nil {
+		return fmt.Errorf("error recording status change: %v", err)
+	}
+
+	return nil
+}
+
+func SetPlayerCoolOff(id int, until time.Time) error {
+	_, err := DB.Exec(`UPDATE players SET coolOffUntil = ? WHERE id = ?;`, until, id)
+	if err != nil {
+		return fmt.Errorf("player cool-off was not updated: %v", err)
+	}
+
+	return nil
+}
+
+func UpdatePlayerPassword(id int, hashedPassword string) error {
+	_, err := DB.Exec(`UPDATE players SET password = ? WHERE id = ?;`, hashedPassword, id)
+	if err != nil {
+		return fmt.Errorf("player password was not updated: %v", err)
+	}
+
+	return nil
+}
+
+// TrySetPlayerBetting sets the betting status only if it isn't set already (check and set in one query)
+// Returns true if this call set it, the caller then has to reset it with UpdatePlayerBettingStatus
+func TrySetPlayerBetting(id int) (bool, error) {
+	result, err := DB.Exec(`UPDATE players SET isBetting = true WHERE id = ? AND isBetting = false;`, id)
+	if err != nil {
+		return false, fmt.Errorf("player betting status was not updated")
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected == 1, nil
+}
+
+func UpdatePlayerMaskName(id int, maskName bool) error {
+	_, err := DB.Exec(`UPDATE players SET maskName = ? WHERE id = ?;`, maskName, id)
+	if err != nil {
+		return fmt.Errorf("player privacy was not updated: %v", err)
+	}
+
+	return nil
+}
+
+func UpdatePlayerDeviceID(id int, deviceID string) error {
+	_, err := DB.Exec(`UPDATE players SET deviceId = ? WHERE id = ?;`, deviceID, id)
+	if err != nil {
+		return fmt.Errorf("player device was not updated: %v", err)
+	}
 
 	return nil
 }
@@ -155,3 +475,36 @@ func UpdatePlayerBettingStatus(id int, isBetting bool) error {
 
 	return nil
 }
+
+type PlayerStatusChange struct {
+	ID        int       `json:"id"`
+	PlayerID  int       `json:"playerId"`
+	OldStatus string    `json:"oldStatus"`
+	NewStatus string    `json:"newStatus"`
+	Reason    string    `json:"reason"`
+	ChangedBy int       `json:"changedBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// GetPlayerStatusChanges returns the player's status history, newest first
+func GetPlayerStatusChanges(playerID int) ([]PlayerStatusChange, error) {
+	query := `SELECT id, playerId, oldStatus, newStatus, reason, changedBy, createdAt 
+	          FROM player_status_changes WHERE playerId = ? ORDER BY id DESC;`
+
+	rows, err := DB.Query(query, playerID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching status changes: %v", err)
+	}
+	defer rows.Close()
+
+	statusChanges := []PlayerStatusChange{}
+	for rows.Next() {
+		var statusChange PlayerStatusChange
+		if err := rows.Scan(&statusChange.ID, &statusChange.PlayerID, &statusChange.OldStatus, &statusChange.NewStatus, &statusChange.Reason, &statusChange.ChangedBy, &statusChange.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error reading status change: %v", err)
+		}
+		statusChanges = append(statusChanges, statusChange)
+	}
+
+	return statusChanges, rows.Err()
+}
@@ -0,0 +1,464 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Promo code types
+const (
+	PromoDepositMatch = "deposit_match" // Bonus of value% of the next deposit (up to maxAmount)
+	PromoFixedCredit  = "fixed_credit"  // Bonus of value
+	PromoFreeBets     = "free_bets"     // freeBetCount free bets of value on game
+)
+
+// Redemption statuses
+const (
+	RedemptionAwaitingDeposit = "awaiting_deposit" // Deposit match waiting for the next deposit
+	RedemptionCompleted       = "completed"
+)
+
+// Free bet statuses
+const (
+	FreeBetAvailable = "available"
+	FreeBetUsed      = "used"
+)
+
+// Games free bets can be used on
+const (
+	GameDice  = "dice"
+	GameCrash = "crash"
+)
+
+var PromoTypes = []string{PromoDepositMatch, PromoFixedCredit, PromoFreeBets}
+var Games = []string{GameDice, GameCrash}
+
+type PromoCode struct {
+	ID                 int       `json:"id"`
+	Code               string    `json:"code"` // Stored uppercase, redeeming is case insensitive
+	Type               string    `json:"type"`
+	Value              float32   `json:"value"`        // Match percentage, credit amount or free bet stake depending on the type
+	MaxAmount          float32   `json:"maxAmount"`    // Deposit match cap (0 = no cap)
+	FreeBetCount       int       `json:"freeBetCount"` // Free bets given per redemption
+	Game               string    `json:"game"`         // Game the free bets can be used on
+	WageringMultiplier float32   `json:"wageringMultiplier"`
+	RewardExpiryDays   float32   `json:"rewardExpiryDays"` // Bonuses / free bets given expire after this period
+	ValidFrom          time.Time `json:"validFrom"`
+	ValidUntil         time.Time `json:"validUntil"`
+	MaxRedemptions     int       `json:"maxRedemptions"` // Total redemptions allowed (0 = unlimited)
+	Redemptions        int       `json:"redemptions"`
+	CreatedBy          int       `json:"createdBy"`
+	CreatedAt          time.Time `json:"createdAt"`
+}
+
+type PromoRedemption struct {
+	ID          int        `json:"id"`
+	PromoID     int        `json:"promoId"`
+	Code        string     `json:"code"`
+	PlayerID    int        `json:"playerId"`
+	Status      string     `json:"status"`
+	Amount      float32    `json:"amount"`  // Bonus credited (deposit match / fixed credit)
+	BonusID     *int       `json:"bonusId"` // Bonus created by the redemption
+	FreeBets    []FreeBet  `json:"freeBets,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	CompletedAt *time.Time `json:"completedAt"`
+}
+
+type FreeBet struct {
+	ID           int        `json:"id"`
+	PlayerID     int        `json:"playerId"`
+	RedemptionID int        `json:"redemptionId"`
+	Game         string     `json:"game"`
+	Amount       float32    `json:"amount"` // Stake of the bet, only the winnings are paid out
+	Status       string     `json:"status"`
+	BetID        *int       `json:"betId"`
+	ExpiresAt    time.Time  `json:"expiresAt"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UsedAt       *time.Time `json:"usedAt"`
+}
+
+// PromoError is returned when a promo code can't be redeemed
+// Code is sent to clients as "errorCode"
+type PromoError struct {
+	Code    string
+	Message string
+}
+
+func (e *PromoError) Error() string {
+	return e.Message
+}
+
+func IsValidPromoType(promoType string) bool {
+	return slices.Contains(PromoTypes, promoType)
+}
+
+func IsValidGame(game string) bool {
+	return slices.Contains(Games, game)
+}
+
+// CreatePromoCode stores a new promo code and returns its ID
+func CreatePromoCode(promo PromoCode) (int, error) {
+	query := `INSERT INTO promo_codes (code, type, value, maxAmount, freeBetCount, game, wageringMultiplier, rewardExpiryDays, validFrom, validUntil, maxRedemptions, createdBy)
+	          VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+
+	result, err := DB.Exec(query, strings.ToUpper(promo.Code), promo.Type, roundToCents(promo.Value), roundToCents(promo.MaxAmount), promo.FreeBetCount, promo.Game,
+		promo.WageringMultiplier, promo.RewardExpiryDays, promo.ValidFrom.UTC().Format(sqliteTimeFormat), promo.ValidUntil.UTC().Format(sqliteTimeFormat),
+		promo.MaxRedemptions, promo.CreatedBy)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return 0, fmt.Errorf("promo code %s already exists", strings.ToUpper(promo.Code))
+		}
+		return 0, fmt.Errorf("error creating promo code: %v", err)
+	}
+
+	promoID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("unable to retrieve last inserted ID: %v", err)
+	}
+
+	return int(promoID), nil
+}
+
+// Columns read by scanPromoCode, in order
+const promoColumns = `id, code, type, value, maxAmount, freeBetCount, game, wageringMultiplier, rewardExpiryDays, validFrom, validUntil, maxRedemptions, redemptions, createdBy, createdAt`
+
+func scanPromoCode(row scanner) (*PromoCode, error) {
+	var promo PromoCode
+	err := row.Scan(&promo.ID, &promo.Code, &promo.Type, &promo.Value, &promo.MaxAmount, &promo.FreeBetCount, &promo.Game, &promo.WageringMultiplier, &promo.RewardExpiryDays,
+		&promo.ValidFrom, &promo.ValidUntil, &promo.MaxRedemptions, &promo.Redemptions, &promo.CreatedBy, &promo.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &promo, nil
+}
+
+func GetPromoCodeByID(id int) (*PromoCode, error) {
+	promo, err := scanPromoCode(DB.QueryRow(`SELECT `+promoColumns+` FROM promo_codes WHERE id = ?;`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("promo code with ID %d not found", id)
+		}
+		return nil, fmt.Errorf("error fetching promo code: %v", err)
+	}
+
+	return promo, nil
+}
+
+// GetPromoCodes returns a page of promo codes, newest first
+func GetPromoCodes(limit int, offset int) ([]PromoCode, error) {
+	rows, err := DB.Query(`SELECT `+promoColumns+` FROM promo_codes ORDER BY id DESC LIMIT ? OFFSET ?;`, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching promo codes: %v", err)
+	}
+	defer rows.Close()
+
+	promos := []PromoCode{}
+	for rows.Next() {
+		promo, err := scanPromoCode(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading promo code: %v", err)
+		}
+		promos = append(promos, *promo)
+	}
+
+	return promos, rows.Err()
+}
+
+// RedeemPromoCode redeems a code for the player in a single transaction (usage cap, redemption and rewards)
+// Redeeming the same code again is a no-op, returns the existing redemption and true in that case
+func RedeemPromoCode(code string, playerID int) (*PromoRedemption, bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, false, fmt.Errorf("error starting redemption: %v", err)
+	}
+	defer tx.Rollback() // No-op once committed
+
+	promo, err := scanPromoCode(tx.QueryRow(`SELECT `+promoColumns+` FROM promo_codes WHERE code = ?;`, strings.ToUpper(strings.TrimSpace(code))))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, false, &PromoError{Code: "PROMO_NOT_FOUND", Message: "Promo code not found"}
+		}
+		return nil, false, fmt.Errorf("error fetching promo code: %v", err)
+	}
+
+	var redemptionID int
+	err = tx.QueryRow(`SELECT id FROM promo_redemptions WHERE promoId = ? AND playerId = ?;`, promo.ID, playerID).Scan(&redemptionID)
+	if err == nil {
+		tx.Rollback()
+		redemption, err := GetPromoRedemptionByID(redemptionID)
+		return redemption, true, err
+	}
+	if err != sql.ErrNoRows {
+		return nil, false, fmt.Errorf("error fetching redemption: %v", err)
+	}
+
+	now := time.Now()
+	if now.Before(promo.ValidFrom) {
+		return nil, false, &PromoError{Code: "PROMO_NOT_STARTED", Message: "Promo code is valid from " + promo.ValidFrom.Format(time.RFC3339)}
+	}
+	if !now.Before(promo.ValidUntil) {
+		return nil, false, &PromoError{Code: "PROMO_EXPIRED", Message: "Promo code expired on " + promo.ValidUntil.Format(time.RFC3339)}
+	}
+
+	// Only counts if the cap wasn't reached yet
+	result, err := tx.Exec(`UPDATE promo_codes SET redemptions = redemptions + 1 WHERE id = ? AND (maxRedemptions = 0 OR redemptions < maxRedemptions);`, promo.ID)
+	if err != nil {
+		return nil, false, fmt.Errorf("error redeeming promo code: %v", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return nil, false, &PromoError{Code: "PROMO_EXHAUSTED", Message: "Promo code reached its redemption limit"}
+	}
+
+	status := RedemptionCompleted
+	if promo.Type == PromoDepositMatch {
+		status = RedemptionAwaitingDeposit
+	}
+
+	result, err = tx.Exec(`INSERT INTO promo_redemptions (promoId, playerId, status, completedAt) VALUES (?, ?, ?, CASE WHEN ? = 'completed' THEN CURRENT_TIMESTAMP END);`,
+		promo.ID, playerID, status, status)
+	if err != nil {
+		return nil, false, fmt.Errorf("error recording redemption: %v", err)
+	}
+	lastID, _ := result.LastInsertId()
+	redemptionID = int(lastID)
+
+	rewardExpiresAt := now.Add(time.Duration(promo.RewardExpiryDays * float32(24*time.Hour)))
+	reference := fmt.Sprintf("promo:%s", promo.Code)
+
+	switch promo.Type {
+	case PromoFixedCredit:
+		bonusID, err := grantBonus(tx, playerID, promo.Value, promo.WageringMultiplier, rewardExpiresAt, reference)
+		if err != nil {
+			return nil, false, err
+		}
+
+		_, err = tx.Exec(`UPDATE promo_redemptions SET amount = ?, bonusId = ? WHERE id = ?;`, roundToCents(promo.Value), bonusID, redemptionID)
+		if err != nil {
+			return nil, false, fmt.Errorf("error recording redemption: %v", err)
+		}
+	case PromoFreeBets:
+		for range promo.FreeBetCount {
+			_, err = tx.Exec(`INSERT INTO free_bets (playerId, redemptionId, game, amount, expiresAt) VALUES (?, ?, ?, ?, ?);`,
+				playerID, redemptionID, promo.Game, roundToCents(promo.Value), rewardExpiresAt.UTC().Format(sqliteTimeFormat))
+			if err != nil {
+				return nil, false, fmt.Errorf("error creating free bet: %v", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("error committing redemption: %v", err)
+	}
+
+	redemption, err := GetPromoRedemptionByID(redemptionID)
+	return redemption, false, err
+}
+
+// ApplyDepositMatches grants the bonuses of the player's deposit match redemptions waiting for a deposit
+// Returns the total bonus granted
+func ApplyDepositMatches(playerID int, depositAmount float32) (float32, error) {
+	rows, err := DB.Query(`SELECT id, promoId FROM promo_redemptions WHERE playerId = ? AND status = ? ORDER BY id;`, playerID, RedemptionAwaitingDeposit)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching deposit matches: %v", err)
+	}
+
+	type pendingMatch struct {
+		redemptionID int
+		promoID      int
+	}
+
+	pendingMatches := []pendingMatch{}
+	for rows.Next() {
+		var match pendingMatch
+		if err := rows.Scan(&match.redemptionID, &match.promoID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error reading deposit match: %v", err)
+		}
+		pendingMatches = append(pendingMatches, match)
+	}
+	rows.Close()
+
+	// Rows must be closed before running other queries (single connection)
+	var granted float32
+	for _, match := range pendingMatches {
+		promo, err := GetPromoCodeByID(match.promoID)
+		if err != nil {
+			return granted, err
+		}
+
+		amount := depositAmount * promo.Value / 100
+		if promo.MaxAmount > 0 {
+			amount = min(amount, promo.MaxAmount)
+		}
+
+		expiresAt := time.Now().Add(time.Duration(promo.RewardExpiryDays * float32(24*time.Hour)))
+		bonusID, err := GrantBonus(playerID, amount, promo.WageringMultiplier, expiresAt, fmt.Sprintf("promo:%s", promo.Code))
+		if err != nil {
+			return granted, err
+		}
+
+		query := `UPDATE promo_redemptions SET status = ?, amount = ?, bonusId = ?, completedAt = CURRENT_TIMESTAMP WHERE id = ?;`
+		_, err = DB.Exec(query, RedemptionCompleted, roundToCents(amount), bonusID, match.redemptionID)
+		if err != nil {
+			return granted, fmt.Errorf("error recording redemption: %v", err)
+		}
+
+		granted += roundToCents(amount)
+	}
+
+	return granted, nil
+}
+
+// GetPromoRedemptionByID returns a redemption with the free bets it gave
+func GetPromoRedemptionByID(id int) (*PromoRedemption, error) {
+	query := `SELECT r.id, r.promoId, p.code, r.playerId, r.status, r.amount, r.bonusId, r.createdAt, r.completedAt
+	          FROM promo_redemptions r JOIN promo_codes p ON p.id = r.promoId WHERE r.id = ?;`
+
+	redemption, err := scanPromoRedemption(DB.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("redemption with ID %d not found", id)
+		}
+		return nil, fmt.Errorf("error fetching redemption: %v", err)
+	}
+
+	redemption.FreeBets, err = queryFreeBets(`SELECT `+freeBetColumns+` FROM free_bets WHERE redemptionId = ? ORDER BY id;`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return redemption, nil
+}
+
+// GetPromoRedemptions returns a page of the redemptions of a promo code, newest first
+func GetPromoRedemptions(promoID int, limit int, offset int) ([]PromoRedemption, error) {
+	query := `SELECT r.id, r.promoId, p.code, r.playerId, r.status, r.amount, r.bonusId, r.createdAt, r.completedAt
+	          FROM promo_redemptions r JOIN promo_codes p ON p.id = r.promoId
+	          WHERE r.promoId = ? ORDER BY r.id DESC LIMIT ? OFFSET ?;`
+
+	rows, err := DB.Query(query, promoID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching redemptions: %v", err)
+	}
+	defer rows.Close()
+
+	redemptions := []PromoRedemption{}
+	for rows.Next() {
+		redemption, err := scanPromoRedemption(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading redemption: %v", err)
+		}
+		redemptions = append(redemptions, *redemption)
+	}
+
+	return redemptions, rows.Err()
+}
+
+func scanPromoRedemption(row scanner) (*PromoRedemption, error) {
+	var redemption PromoRedemption
+	var bonusID sql.NullInt64
+	var completedAt sql.NullTime
+	err := row.Scan(&redemption.ID, &redemption.PromoID, &redemption.Code, &redemption.PlayerID, &redemption.Status, &redemption.Amount, &bonusID,
+		&redemption.CreatedAt, &completedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if bonusID.Valid {
+		id := int(bonusID.Int64)
+		redemption.BonusID = &id
+	}
+	if completedAt.Valid {
+		redemption.CompletedAt = &completedAt.Time
+	}
+
+	return &redemption, nil
+}
+
+// GetAvailableFreeBets returns the player's free bets that can still be used, oldest first
+func GetAvailableFreeBets(playerID int) ([]FreeBet, error) {
+	query := `SELECT ` + freeBetColumns + ` FROM free_bets WHERE playerId = ? AND status = ? AND expiresAt > CURRENT_TIMESTAMP ORDER BY id;`
+	return queryFreeBets(query, playerID, FreeBetAvailable)
+}
+
+// UseFreeBet marks one of the player's available free bets for the game as used and returns it
+// Only one bet can claim it, even with concurrent requests
+func UseFreeBet(id int, playerID int, game string) (*FreeBet, error) {
+	query := `UPDATE free_bets SET status = ?, usedAt = CURRENT_TIMESTAMP
+	          WHERE id = ? AND playerId = ? AND game = ? AND status = ? AND expiresAt > CURRENT_TIMESTAMP;`
+
+	result, err := DB.Exec(query, FreeBetUsed, id, playerID, game, FreeBetAvailable)
+	if err != nil {
+		return nil, fmt.Errorf("error using free bet: %v", err)
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return nil, fmt.Errorf("free bet %d is not available for %s", id, game)
+	}
+
+	freeBets, err := queryFreeBets(`SELECT `+freeBetColumns+` FROM free_bets WHERE id = ?;`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &freeBets[0], nil
+}
+
+// SetFreeBetBet links a used free bet to the bet it was placed on
+func SetFreeBetBet(id int, betID int) error {
+	_, err := DB.Exec(`UPDATE free_bets SET betId = ? WHERE id = ?;`, betID, id)
+	if err != nil {
+		return fmt.Errorf("error updating free bet: %v", err)
+	}
+
+	return nil
+}
+
+// ReopenFreeBet makes a used free bet available again (the bet couldn't be settled)
+func ReopenFreeBet(id int) error {
+	_, err := DB.Exec(`UPDATE free_bets SET status = ?, usedAt = NULL WHERE id = ?;`, FreeBetAvailable, id)
+	if err != nil {
+		return fmt.Errorf("error reopening free bet: %v", err)
+	}
+
+	return nil
+}
+
+// Columns read by queryFreeBets, in order
+const freeBetColumns = `id, playerId, redemptionId, game, amount, status, betId, expiresAt, createdAt, usedAt`
+
+func queryFreeBets(query string, args ...any) ([]FreeBet, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching free bets: %v", err)
+	}
+	defer rows.Close()
+
+	freeBets := []FreeBet{}
+	for rows.Next() {
+		var freeBet FreeBet
+		var betID sql.NullInt64
+		var usedAt sql.NullTime
+		err := rows.Scan(&freeBet.ID, &freeBet.PlayerID, &freeBet.RedemptionID, &freeBet.Game, &freeBet.Amount, &freeBet.Status, &betID,
+			&freeBet.ExpiresAt, &freeBet.CreatedAt, &usedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error reading free bet: %v", err)
+		}
+
+		if betID.Valid {
+			id := int(betID.Int64)
+			freeBet.BetID = &id
+		}
+		if usedAt.Valid {
+			freeBet.UsedAt = &usedAt.Time
+		}
+
+		freeBets = append(freeBets, freeBet)
+	}
+
+	return freeBets, rows.Err()
+}
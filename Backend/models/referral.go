@@ -0,0 +1,293 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Referral statuses
+const (
+	ReferralPending   = "pending"   // Referred player didn't meet the qualifying condition yet
+	ReferralQualified = "qualified" // Rewards granted (paid once the players' balances are free, see the *RewardedAt fields)
+	ReferralRejected  = "rejected"  // Looked like a self-referral, no rewards
+)
+
+// Sides of a referral that get a reward
+const (
+	ReferralSideReferrer = "referrer"
+	ReferralSideReferred = "referred"
+)
+
+type Referral struct {
+	ID                 int        `json:"id"`
+	ReferrerID         int        `json:"referrerId"`
+	ReferredID         int        `json:"referredId"`
+	ReferredName       string     `json:"referredName"`
+	Status             string     `json:"status"`
+	RejectReason       string     `json:"rejectReason,omitempty"`
+	ReferrerReward     float32    `json:"referrerReward"`
+	ReferredReward     float32    `json:"referredReward"`
+	ReferrerRewardedAt *time.Time `json:"referrerRewardedAt"`
+	ReferredRewardedAt *time.Time `json:"referredRewardedAt"`
+	CreatedAt          time.Time  `json:"createdAt"`
+	QualifiedAt        *time.Time `json:"qualifiedAt"`
+}
+
+type ReferralStats struct {
+	Total         int     `json:"total"`
+	Pending       int     `json:"pending"`
+	Qualified     int     `json:"qualified"`
+	Rejected      int     `json:"rejected"`
+	RewardsEarned float32 `json:"rewardsEarned"` // Referrer rewards already paid
+}
+
+// Unambiguous characters (no 0 / O / 1 / I)
+const referralCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+const referralCodeLength = 8
+
+// AssignReferralCode gives the player a referral code if they don't have one yet and returns it
+func AssignReferralCode(playerID int) (string, error) {
+	var existingCode sql.NullString
+	err := DB.QueryRow(`SELECT referralCode FROM players WHERE id = ?;`, playerID).Scan(&existingCode)
+	if err != nil {
+		return "", fmt.Errorf("error fetching referral code: %v", err)
+	}
+
+	if existingCode.Valid {
+		return existingCode.String, nil
+	}
+
+	// Retry on the (unlikely) collisions with the unique index
+	for range 5 {
+		code, err := generateReferralCode()
+		if err != nil {
+			return "", err
+		}
+
+		_, err = DB.Exec(`UPDATE players SET referralCode = ? WHERE id = ? AND referralCode IS NULL;`, code, playerID)
+		if err == nil {
+			return code, nil
+		}
+		if !strings.Contains(err.Error(), "UNIQUE") {
+			return "", fmt.Errorf("error assigning referral code: %v", err)
+		}
+	}
+
+	return "", fmt.Errorf("could not generate a unique referral code")
+}
+
+func generateReferralCode() (string, error) {
+	randomBytes := make([]byte, referralCodeLength)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", fmt.Errorf("error generating referral code: %v", err)
+	}
+
+	code := make([]byte, referralCodeLength)
+	for i, randomByte := range randomBytes {
+		code[i] = referralCodeAlphabet[int(randomByte)%len(referralCodeAlphabet)]
+	}
+
+	return string(code), nil
+}
+
+func GetPlayerByReferralCode(code string) (*Player, error) {
+	query := `SELECT ` + playerColumns + ` FROM players WHERE referralCode = ?;`
+	player, err := scanPlayer(DB.QueryRow(query, strings.ToUpper(strings.TrimSpace(code))))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("referral code '%s' not found", code)
+		}
+		return nil, fmt.Errorf("error fetching player: %v", err)
+	}
+
+	return player, nil
+}
+
+// CreateReferral records that the referred player registered with the referrer's code
+// A rejectReason marks it as rejected right away (self-referral)
+func CreateReferral(referrerID int, referredID int, rejectReason string, ip string, deviceID string) error {
+	status := ReferralPending
+	if rejectReason != "" {
+		status = ReferralRejected
+	}
+
+	query := `INSERT INTO referrals (referrerId, referredId, status, rejectReason, ip, deviceId) VALUES (?, ?, ?, ?, ?, ?);`
+	_, err := DB.Exec(query, referrerID, referredID, status, rejectReason, ip, deviceID)
+	if err != nil {
+		return fmt.Errorf("error recording referral: %v", err)
+	}
+
+	return nil
+}
+
+// QualifyReferral checks the qualifying condition of the player's pending referral
+// (total wagered >= qualifyingWager, or any deposit when qualifyingWager is 0) and grants the rewards if it's met
+// Returns the qualified referral, nil if there was nothing to qualify
+func QualifyReferral(referredID int, qualifyingWager float32, referrerReward float32, referredReward float32) (*Referral, error) {
+	var referralID int
+	err := DB.QueryRow(`SELECT id FROM referrals WHERE referredId = ? AND status = ?;`, referredID, ReferralPending).Scan(&referralID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching referral: %v", err)
+	}
+
+	var qualifies bool
+	if qualifyingWager > 0 {
+		var wagered float64
+		err = DB.QueryRow(`SELECT COALESCE(SUM(betAmount * exchangeRate), 0) FROM bets WHERE playerId = ?;`, referredID).Scan(&wagered)
+		qualifies = float32(wagered) >= qualifyingWager
+	} else {
+		var deposits int
+		err = DB.QueryRow(`SELECT COUNT(*) FROM transactions WHERE playerId = ? AND type = ?;`, referredID, TransactionDeposit).Scan(&deposits)
+		qualifies = deposits > 0
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error checking referral condition: %v", err)
+	}
+
+	if !qualifies {
+		return nil, nil
+	}
+
+	// Only one caller can move it out of pending
+	query := `UPDATE referrals SET status = ?, referrerReward = ?, referredReward = ?, qualifiedAt = CURRENT_TIMESTAMP WHERE id = ? AND status = ?;`
+	result, err := DB.Exec(query, ReferralQualified, roundToCents(referrerReward), roundToCents(referredReward), referralID, ReferralPending)
+	if err != nil {
+		return nil, fmt.Errorf("error qualifying referral: %v", err)
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return nil, nil
+	}
+
+	return GetReferralByID(referralID)
+}
+
+// MarkReferralRewarded flags one side's reward as paid, returns false if it was already paid
+func MarkReferralRewarded(id int, side string) (bool, error) {
+	column := "referredRewardedAt"
+	if side == ReferralSideReferrer {
+		column = "referrerRewardedAt"
+	}
+
+	result, err := DB.Exec(fmt.Sprintf(`UPDATE referrals SET %s = CURRENT_TIMESTAMP WHERE id = ? AND %s IS NULL;`, column, column), id)
+	if err != nil {
+		return false, fmt.Errorf("error updating referral: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected == 1, nil
+}
+
+// UnmarkReferralRewarded reverts MarkReferralRewarded when the reward couldn't be credited
+func UnmarkReferralRewarded(id int, side string) error {
+	column := "referredRewardedAt"
+	if side == ReferralSideReferrer {
+		column = "referrerRewardedAt"
+	}
+
+	_, err := DB.Exec(fmt.Sprintf(`UPDATE referrals SET %s = NULL WHERE id = ?;`, column), id)
+	if err != nil {
+		return fmt.Errorf("error updating referral: %v", err)
+	}
+
+	return nil
+}
+
+// Columns read by scanReferral, in order
+const referralColumns = `r.id, r.referrerId, r.referredId, p.name, r.status, r.rejectReason, r.referrerReward, r.referredReward,
+	r.referrerRewardedAt, r.referredRewardedAt, r.createdAt, r.qualifiedAt`
+
+func scanReferral(row scanner) (*Referral, error) {
+	var referral Referral
+	var referrerRewardedAt, referredRewardedAt, qualifiedAt sql.NullTime
+	err := row.Scan(&referral.ID, &referral.ReferrerID, &referral.ReferredID, &referral.ReferredName, &referral.Status, &referral.RejectReason,
+		&referral.ReferrerReward, &referral.ReferredReward, &referrerRewardedAt, &referredRewardedAt, &referral.CreatedAt, &qualifiedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if referrerRewardedAt.Valid {
+		referral.ReferrerRewardedAt = &referrerRewardedAt.Time
+	}
+	if referredRewardedAt.Valid {
+		referral.ReferredRewardedAt = &referredRewardedAt.Time
+	}
+	if qualifiedAt.Valid {
+		referral.QualifiedAt = &qualifiedAt.Time
+	}
+
+	return &referral, nil
+}
+
+func GetReferralByID(id int) (*Referral, error) {
+	query := `SELECT ` + referralColumns + ` FROM referrals r JOIN players p ON p.id = r.referredId WHERE r.id = ?;`
+	referral, err := scanReferral(DB.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("referral with ID %d not found", id)
+		}
+		return nil, fmt.Errorf("error fetching referral: %v", err)
+	}
+
+	return referral, nil
+}
+
+// GetReferralsByReferrer returns the players referred by the player with the stats over all of them, newest first
+func GetReferralsByReferrer(referrerID int) ([]Referral, ReferralStats, error) {
+	query := `SELECT ` + referralColumns + ` FROM referrals r JOIN players p ON p.id = r.referredId WHERE r.referrerId = ? ORDER BY r.id DESC;`
+	referrals, err := queryReferrals(query, referrerID)
+	if err != nil {
+		return nil, ReferralStats{}, err
+	}
+
+	stats := ReferralStats{Total: len(referrals)}
+	for _, referral := range referrals {
+		switch referral.Status {
+		case ReferralPending:
+			stats.Pending++
+		case ReferralQualified:
+			stats.Qualified++
+			if referral.ReferrerRewardedAt != nil {
+				stats.RewardsEarned += referral.ReferrerReward
+			}
+		case ReferralRejected:
+			stats.Rejected++
+		}
+	}
+	stats.RewardsEarned = roundToCents(stats.RewardsEarned)
+
+	return referrals, stats, nil
+}
+
+// GetUnpaidReferrals returns the qualified referrals with a reward still to pay to the player (either side)
+func GetUnpaidReferrals(playerID int) ([]Referral, error) {
+	query := `SELECT ` + referralColumns + ` FROM referrals r JOIN players p ON p.id = r.referredId
+	          WHERE r.status = ? AND ((r.referrerId = ? AND r.referrerRewardedAt IS NULL) OR (r.referredId = ? AND r.referredRewardedAt IS NULL))
+	          ORDER BY r.id;`
+	return queryReferrals(query, ReferralQualified, playerID, playerID)
+}
+
+func queryReferrals(query string, args ...any) ([]Referral, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching referrals: %v", err)
+	}
+	defer rows.Close()
+
+	referrals := []Referral{}
+	for rows.Next() {
+		referral, err := scanReferral(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading referral: %v", err)
+		}
+		referrals = append(referrals, *referral)
+	}
+
+	return referrals, rows.Err()
+}
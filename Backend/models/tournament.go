@@ -0,0 +1,450 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Tournament statuses, in order
+const (
+	TournamentScheduled = "scheduled" // Players can join, bets don't score yet
+	TournamentRunning   = "running"   // Players can join, bets on the game score
+	TournamentSettling  = "settling"  // Over, the standings are final and the prizes are being paid
+	TournamentFinished  = "finished"  // Every prize was paid
+)
+
+// Tournament scoring
+const (
+	ScoringWagered   = "wagered"    // Total staked
+	ScoringNetResult = "net_result" // Total won minus total staked
+)
+
+var TournamentStatuses = []string{TournamentScheduled, TournamentRunning, TournamentSettling, TournamentFinished}
+var TournamentScorings = []string{ScoringWagered, ScoringNetResult}
+
+type Tournament struct {
+	ID              int        `json:"id"`
+	Name            string     `json:"name"`
+	Game            string     `json:"game"`
+	Scoring         string     `json:"scoring"`
+	EntryFee        float32    `json:"entryFee"`
+	GuaranteedPrize float32    `json:"guaranteedPrize"` // Put in the prize pool by the house
+	PrizePool       float32    `json:"prizePool"`       // Guaranteed prize + entry fees
+	PayoutTable     []float32  `json:"payoutTable"`     // % of the prize pool by rank (first = 1st place)
+	Status          string     `json:"status"`
+	StartsAt        time.Time  `json:"startsAt"`
+	EndsAt          time.Time  `json:"endsAt"`
+	Entrants        int        `json:"entrants"`
+	CreatedBy       int        `json:"createdBy"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	SettledAt       *time.Time `json:"settledAt"`
+}
+
+type TournamentEntry struct {
+	ID           int        `json:"-"`
+	TournamentID int        `json:"tournamentId"`
+	PlayerID     int        `json:"-"` // Standings are public
+	Name         string     `json:"name"`
+	Score        float32    `json:"score"`
+	Rank         *int       `json:"rank"` // Set once the tournament is over
+	Prize        float32    `json:"prize"`
+	PrizePaidAt  *time.Time `json:"prizePaidAt"`
+	JoinedAt     time.Time  `json:"joinedAt"`
+}
+
+var ErrTournamentInsufficientFunds = errors.New("Insufficient funds in wallet for the entry fee")
+
+// TournamentError is returned when a player can't join a tournament
+type TournamentError struct {
+	Code    string
+	Message string
+}
+
+func (e *TournamentError) Error() string {
+	return e.Message
+}
+
+func IsValidTournamentStatus(status string) bool {
+	return slices.Contains(TournamentStatuses, status)
+}
+
+func IsValidTournamentScoring(scoring string) bool {
+	return slices.Contains(TournamentScorings, scoring)
+}
+
+// CreateTournament stores a new scheduled tournament and returns its ID
+func CreateTournament(tournament Tournament) (int, error) {
+	payoutTable, err := json.Marshal(tournament.PayoutTable)
+	if err != nil {
+		return 0, fmt.Errorf("error encoding payout table: %v", err)
+	}
+
+	query := `INSERT INTO tournaments (name, game, scoring, entryFee, guaranteedPrize, payoutTable, startsAt, endsAt, createdBy)
+	          VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`
+
+	result, err := DB.Exec(query, tournament.Name, tournament.Game, tournament.Scoring, roundToCents(tournament.EntryFee), roundToCents(tournament.GuaranteedPrize),
+		string(payoutTable), tournament.StartsAt.UTC().Format(sqliteTimeFormat), tournament.EndsAt.UTC().Format(sqliteTimeFormat), tournament.CreatedBy)
+	if err != nil {
+		return 0, fmt.Errorf("error creating tournament: %v", err)
+	}
+
+	tournamentID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("unable to retrieve last inserted ID: %v", err)
+	}
+
+	return int(tournamentID), nil
+}
+
+// Columns read by scanTournament, in order (prize pool and entrants come from the entries)
+const tournamentColumns = `id, name, game, scoring, entryFee, guaranteedPrize,
+	guaranteedPrize + (SELECT COALESCE(SUM(entryFee), 0) FROM tournament_entries WHERE tournamentId = tournaments.id),
+	payoutTable, status, startsAt, endsAt,
+	(SELECT COUNT(*) FROM tournament_entries WHERE tournamentId = tournaments.id),
+	createdBy, createdAt, settledAt`
+
+func scanTournament(row scanner) (*Tournament, error) {
+	var tournament Tournament
+	var payoutTable string
+	var settledAt sql.NullTime
+	err := row.Scan(&tournament.ID, &tournament.Name, &tournament.Game, &tournament.Scoring, &tournament.EntryFee, &tournament.GuaranteedPrize,
+		&tournament.PrizePool, &payoutTable, &tournament.Status, &tournament.StartsAt, &tournament.EndsAt, &tournament.Entrants,
+		&tournament.CreatedBy, &tournament.CreatedAt, &settledAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(payoutTable), &tournament.PayoutTable); err != nil {
+		return nil, fmt.Errorf("error decoding payout table: %v", err)
+	}
+	tournament.PrizePool = roundToCents(tournament.PrizePool)
+	if settledAt.Valid {
+		tournament.SettledAt = &settledAt.Time
+	}
+
+	return &tournament, nil
+}
+
+func GetTournamentByID(id int) (*Tournament, error) {
+	tournament, err := scanTournament(DB.QueryRow(`SELECT `+tournamentColumns+` FROM tournaments WHERE id = ?;`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("tournament with ID %d not found", id)
+		}
+		return nil, fmt.Errorf("error fetching tournament: %v", err)
+	}
+
+	return tournament, nil
+}
+
+// GetTournaments returns a page of the tournaments with one of the statuses (all if none given), the latest start first
+func GetTournaments(statuses []string, limit int, offset int) ([]Tournament, error) {
+	where := "1 = 1"
+	args := []any{}
+	if len(statuses) > 0 {
+		where = "status IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(statuses)), ", ") + ")"
+		for _, status := range statuses {
+			args = append(args, status)
+		}
+	}
+
+	query := `SELECT ` + tournamentColumns + ` FROM tournaments WHERE ` + where + ` ORDER BY startsAt DESC, id DESC LIMIT ? OFFSET ?;`
+	return queryTournaments(query, append(args, limit, offset)...)
+}
+
+// GetTournamentsDue returns the tournaments whose status has to move on: scheduled ones that started,
+// running ones that ended and the ones still settling
+func GetTournamentsDue(now time.Time) ([]Tournament, error) {
+	nowText := now.UTC().Format(sqliteTimeFormat)
+	query := `SELECT ` + tournamentColumns + ` FROM tournaments
+	          WHERE (status = ? AND startsAt <= ?) OR (status = ? AND endsAt <= ?) OR status = ?
+	          ORDER BY id;`
+	return queryTournaments(query, TournamentScheduled, nowText, TournamentRunning, nowText, TournamentSettling)
+}
+
+func queryTournaments(query string, args ...any) ([]Tournament, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching tournaments: %v", err)
+	}
+	defer rows.Close()
+
+	tournaments := []Tournament{}
+	for rows.Next() {
+		tournament, err := scanTournament(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading tournament: %v", err)
+		}
+		tournaments = append(tournaments, *tournament)
+	}
+
+	return tournaments, rows.Err()
+}
+
+// SetTournamentStatus moves the tournament from one status to the next, returns false if it wasn't in the from status
+func SetTournamentStatus(id int, from string, to string) (bool, error) {
+	query := `UPDATE tournaments SET status = ?, settledAt = CASE WHEN ? = 'finished' THEN CURRENT_TIMESTAMP ELSE settledAt END WHERE id = ? AND status = ?;`
+	result, err := DB.Exec(query, to, to, id, from)
+	if err != nil {
+		return false, fmt.Errorf("error updating tournament status: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected == 1, nil
+}
+
+// JoinTournament adds the player to a tournament that didn't end yet and takes the entry fee from the wallet, all or nothing
+// Returns the fee taken (in the player's currency, the tournament's is in the base currency) and the currency
+func JoinTournament(tournament *Tournament, playerID int) (float32, string, error) {
+	if tournament.Status != TournamentScheduled && tournament.Status != TournamentRunning || !time.Now().Before(tournament.EndsAt) {
+		return 0, "", &TournamentError{Code: "TOURNAMENT_CLOSED", Message: "Tournament is over"}
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, "", fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var currency string
+	if err := tx.QueryRow(`SELECT currency FROM players WHERE id = ?;`, playerID).Scan(&currency); err != nil {
+		return 0, "", fmt.Errorf("error fetching player: %v", err)
+	}
+
+	query := `INSERT INTO tournament_entries (tournamentId, playerId, entryFee) VALUES (?, ?, ?);`
+	_, err = tx.Exec(query, tournament.ID, playerID, tournament.EntryFee)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return 0, "", &TournamentError{Code: "TOURNAMENT_ALREADY_JOINED", Message: "Player already joined the tournament"}
+		}
+		return 0, "", fmt.Errorf("error joining tournament: %v", err)
+	}
+
+	entryFee := FromBaseCurrency(tournament.EntryFee, currency)
+	var wallet, betBalance float32
+	if entryFee > 0 {
+		query = `UPDATE players SET wallet = ROUND(wallet - ?, 2) WHERE id = ? AND currency = ? AND wallet >= ? RETURNING wallet, betBalance;`
+		err = tx.QueryRow(query, entryFee, playerID, currency, entryFee).Scan(&wallet, &betBalance)
+		if err == sql.ErrNoRows {
+			return 0, "", ErrTournamentInsufficientFunds
+		}
+		if err != nil {
+			return 0, "", fmt.Errorf("error taking entry fee: %v", err)
+		}
+
+		err = recordTransaction(tx, playerID, TransactionTournamentEntry, -entryFee, wallet, betBalance, fmt.Sprintf("tournament:%d", tournament.ID), currency)
+		if err != nil {
+			return 0, "", err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, "", fmt.Errorf("error committing tournament entry: %v", err)
+	}
+
+	if entryFee > 0 {
+		emitBalanceUpdate(playerID, wallet, betBalance)
+	}
+
+	return entryFee, currency, nil
+}
+
+// AddTournamentScore adds a settled bet to the player's entries of the running tournaments on the game
+// Returns the IDs of the tournaments whose standings changed
+func AddTournamentScore(playerID int, game string, stake float32, netResult float32, settledAt time.Time) ([]int, error) {
+	settledAtText := settledAt.UTC().Format(sqliteTimeFormat)
+	query := `UPDATE tournament_entries
+	          SET score = ROUND(score + (SELECT CASE scoring WHEN ? THEN ? ELSE ? END FROM tournaments WHERE id = tournamentId), 2)
+	          WHERE playerId = ? AND tournamentId IN (SELECT id FROM tournaments WHERE status = ? AND game = ? AND startsAt <= ? AND endsAt > ?)
+	          RETURNING tournamentId;`
+
+	rows, err := DB.Query(query, ScoringWagered, stake, netResult, playerID, TournamentRunning, game, settledAtText, settledAtText)
+	if err != nil {
+		return nil, fmt.Errorf("error updating tournament scores: %v", err)
+	}
+	defer rows.Close()
+
+	tournamentIDs := []int{}
+	for rows.Next() {
+		var tournamentID int
+		if err := rows.Scan(&tournamentID); err != nil {
+			return nil, fmt.Errorf("error reading tournament: %v", err)
+		}
+		tournamentIDs = append(tournamentIDs, tournamentID)
+	}
+
+	return tournamentIDs, rows.Err()
+}
+
+// Columns read by scanTournamentEntry, in order
+const tournamentEntryColumns = `e.id, e.tournamentId, e.playerId, CASE WHEN p.maskName THEN '' ELSE p.name END, p.name, e.score, e.rank, e.prize, e.prizePaidAt, e.joinedAt`
+
+func scanTournamentEntry(row scanner) (*TournamentEntry, error) {
+	var entry TournamentEntry
+	var publicName, name string
+	var rank sql.NullInt64
+	var prizePaidAt sql.NullTime
+	err := row.Scan(&entry.ID, &entry.TournamentID, &entry.PlayerID, &publicName, &name, &entry.Score, &rank, &entry.Prize, &prizePaidAt, &entry.JoinedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Players who asked for it are masked like on the leaderboards
+	entry.Name = publicName
+	if publicName == "" {
+		entry.Name = MaskPlayerName(name)
+	}
+	if rank.Valid {
+		entryRank := int(rank.Int64)
+		entry.Rank = &entryRank
+	}
+	if prizePaidAt.Valid {
+		entry.PrizePaidAt = &prizePaidAt.Time
+	}
+
+	return &entry, nil
+}
+
+// GetTournamentStandings returns the tournament's entries from the best score (ties go to who joined first)
+func GetTournamentStandings(tournamentID int, limit int) ([]TournamentEntry, error) {
+	query := `SELECT ` + tournamentEntryColumns + ` FROM tournament_entries e JOIN players p ON p.id = e.playerId
+	          WHERE e.tournamentId = ?
+	          ORDER BY e.rank IS NULL, e.rank, e.score DESC, e.joinedAt, e.id
+	          LIMIT ?;`
+
+	rows, err := DB.Query(query, tournamentID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching standings: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []TournamentEntry{}
+	for rows.Next() {
+		entry, err := scanTournamentEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading tournament entry: %v", err)
+		}
+		entries = append(entries, *entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// RankTournament sets the final ranks and the prizes of the entries (payout table % of the prize pool)
+// Ranks that nobody reached aren't paid, runs once per tournament
+func RankTournament(tournament *Tournament) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var ranked int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM tournament_entries WHERE tournamentId = ? AND rank IS NOT NULL;`, tournament.ID).Scan(&ranked)
+	if err != nil {
+		return fmt.Errorf("error checking tournament ranks: %v", err)
+	}
+	if ranked > 0 {
+		return nil
+	}
+
+	rows, err := tx.Query(`SELECT id FROM tournament_entries WHERE tournamentId = ? ORDER BY score DESC, joinedAt, id;`, tournament.ID)
+	if err != nil {
+		return fmt.Errorf("error fetching tournament entries: %v", err)
+	}
+
+	entryIDs := []int{}
+	for rows.Next() {
+		var entryID int
+		if err := rows.Scan(&entryID); err != nil {
+			rows.Close()
+			return fmt.Errorf("error reading tournament entry: %v", err)
+		}
+		entryIDs = append(entryIDs, entryID)
+	}
+	rows.Close()
+
+	for i, entryID := range entryIDs {
+		var prize float32
+		if i < len(tournament.PayoutTable) {
+			prize = roundToCents(tournament.PrizePool * tournament.PayoutTable[i] / 100)
+		}
+
+		if _, err := tx.Exec(`UPDATE tournament_entries SET rank = ?, prize = ? WHERE id = ?;`, i+1, prize, entryID); err != nil {
+			return fmt.Errorf("error ranking tournament entry: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetUnpaidTournamentPrizes returns the entries with a prize that wasn't paid yet
+func GetUnpaidTournamentPrizes(tournamentID int) ([]TournamentEntry, error) {
+	query := `SELECT ` + tournamentEntryColumns + ` FROM tournament_entries e JOIN players p ON p.id = e.playerId
+	          WHERE e.tournamentId = ? AND e.prize > 0 AND e.prizePaidAt IS NULL
+	          ORDER BY e.rank;`
+
+	rows, err := DB.Query(query, tournamentID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching unpaid prizes: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []TournamentEntry{}
+	for rows.Next() {
+		entry, err := scanTournamentEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading tournament entry: %v", err)
+		}
+		entries = append(entries, *entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// PayTournamentPrize marks the entry's prize as paid and credits it to the player's wallet, all or nothing
+// Returns false if it was already paid, and the prize paid (in the player's currency, the entry's is in the base currency) and the currency
+func PayTournamentPrize(entry *TournamentEntry) (bool, float32, string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, 0, "", fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE tournament_entries SET prizePaidAt = CURRENT_TIMESTAMP WHERE id = ? AND prizePaidAt IS NULL;`, entry.ID)
+	if err != nil {
+		return false, 0, "", fmt.Errorf("error updating tournament entry: %v", err)
+	}
+	if updated, _ := result.RowsAffected(); updated != 1 {
+		return false, 0, "", nil
+	}
+
+	var currency string
+	if err := tx.QueryRow(`SELECT currency FROM players WHERE id = ?;`, entry.PlayerID).Scan(&currency); err != nil {
+		return false, 0, "", fmt.Errorf("error fetching player: %v", err)
+	}
+
+	prize := FromBaseCurrency(entry.Prize, currency)
+	wallet, betBalance, active, err := creditWallet(tx, entry.PlayerID, currency, prize)
+	if err != nil {
+		return false, 0, "", err
+	}
+
+	err = recordTransaction(tx, entry.PlayerID, TransactionTournamentPrize, prize, wallet, betBalance, fmt.Sprintf("tournament:%d", entry.TournamentID), currency)
+	if err != nil {
+		return false, 0, "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, 0, "", fmt.Errorf("error committing tournament prize: %v", err)
+	}
+
+	notifyWalletUpdate(entry.PlayerID, wallet, betBalance, active)
+
+	return true, prize, currency, nil
+}
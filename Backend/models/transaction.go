@@ -0,0 +1,87 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Transaction types
+const (
+	TransactionDeposit    = "deposit"
+	TransactionWithdraw   = "withdraw"
+	TransactionBet        = "bet"        // Stake taken from wallet / bet balance
+	TransactionWin        = "win"        // Winnings added to the bet balance
+	TransactionCashIn     = "cash_in"    // Bet balance moved to the wallet
+	TransactionAdjustment = "adjustment" // Manual credit / debit made by an admin
+
+	TransactionBonusRelease    = "bonus_release"    // Bonus whose wagering was completed moved to the bet balance
+	TransactionReferralReward  = "referral_reward"  // Reward of a qualified referral added to the wallet
+	TransactionCashback        = "cashback"         // Weekly cashback on net losses added to the wallet
+	TransactionTournamentEntry = "tournament_entry" // Tournament entry fee taken from the wallet
+	TransactionTournamentPrize = "tournament_prize" // Tournament prize added to the wallet
+	TransactionRoundRefund     = "round_refund"     // Stake of a room / crash bet given back when its round never ended
+	TransactionJackpotWin      = "jackpot_win"      // Progressive jackpot added to the bet balance
+	TransactionTransferOut     = "transfer_out"     // Wallet funds sent to another player
+	TransactionTransferIn      = "transfer_in"      // Wallet funds received from another player
+	TransactionWithdrawRefund  = "withdraw_refund"  // Withdrawal given back to the wallet (cancelled, rejected or payout failed)
+)
+
+type Transaction struct {
+	ID         int       `json:"id"`
+	PlayerID   int       `json:"playerId"`
+	Type       string    `json:"type"`
+	Amount     float32   `json:"amount"`
+	Wallet     float32   `json:"wallet"`     // Wallet after the transaction
+	BetBalance float32   `json:"betBalance"` // Bet balance after the transaction
+	Reference  string    `json:"reference"`  // e.g. "bet:12"
+	Currency   string    `json:"currency"`   // Currency of the amount and balances
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// RecordTransaction stores a balance movement in the transaction history, in the currency the player is playing in
+func RecordTransaction(playerID int, transactionType string, amount float32, wallet float32, betBalance float32, reference string) error {
+	query := `INSERT INTO transactions (playerId, type, amount, wallet, betBalance, reference, currency, exchangeRate)
+	          SELECT ?, ?, ?, ?, ?, ?, p.currency, COALESCE(c.rate, 1) FROM players p LEFT JOIN currencies c ON c.code = p.currency WHERE p.id = ?;`
+
+	_, err := DB.Exec(query, playerID, transactionType, roundToCents(amount), roundToCents(wallet), roundToCents(betBalance), reference, playerID)
+	if err != nil {
+		return fmt.Errorf("error recording transaction: %v", err)
+	}
+
+	return nil
+}
+
+// recordTransaction stores a balance movement in a given currency, inside a transaction
+func recordTransaction(db execer, playerID int, transactionType string, amount float32, wallet float32, betBalance float32, reference string, currency string) error {
+	query := `INSERT INTO transactions (playerId, type, amount, wallet, betBalance, reference, currency, exchangeRate) VALUES (?, ?, ?, ?, ?, ?, ?, ?);`
+
+	_, err := db.Exec(query, playerID, transactionType, roundToCents(amount), roundToCents(wallet), roundToCents(betBalance), reference, currency, ExchangeRate(currency))
+	if err != nil {
+		return fmt.Errorf("error recording transaction: %v", err)
+	}
+
+	return nil
+}
+
+// GetTransactionsByPlayerID returns a page of the player's transactions, newest first
+func GetTransactionsByPlayerID(playerID int, limit int, offset int) ([]Transaction, error) {
+	query := `SELECT id, playerId, type, amount, wallet, betBalance, reference, currency, createdAt 
+	          FROM transactions WHERE playerId = ? ORDER BY id DESC LIMIT ? OFFSET ?;`
+
+	rows, err := DB.Query(query, playerID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching transactions: %v", err)
+	}
+	defer rows.Close()
+
+	transactions := []Transaction{}
+	for rows.Next() {
+		var transaction Transaction
+		if err := rows.Scan(&transaction.ID, &transaction.PlayerID, &transaction.Type, &transaction.Amount, &transaction.Wallet, &transaction.BetBalance, &transaction.Reference, &transaction.Currency, &transaction.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error reading transaction: %v", err)
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	return transactions, rows.Err()
+}
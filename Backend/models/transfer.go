@@ -0,0 +1,152 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Error code sent to clients when a transfer would go over the daily cap
+const TransferLimitExceeded = "TRANSFER_LIMIT_EXCEEDED"
+
+var ErrTransferInsufficientFunds = errors.New("Insufficient funds in wallet for the transfer")
+
+type Transfer struct {
+	ID            int       `json:"id"`
+	SenderID      int       `json:"-"`
+	SenderName    string    `json:"senderName"`
+	RecipientID   int       `json:"-"`
+	RecipientName string    `json:"recipientName"`
+	Amount        float32   `json:"amount"`
+	Currency      string    `json:"currency"`
+	Message       string    `json:"message"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// TransferResult is a completed transfer with both players' balances right after it
+type TransferResult struct {
+	Transfer            Transfer
+	SenderWallet        float32
+	SenderBetBalance    float32
+	RecipientWallet     float32 // Recipient's wallet in the transfer's currency
+	RecipientBetBalance float32
+	RecipientActive     bool // The recipient plays in the transfer's currency
+}
+
+// CreateTransfer moves the amount from the sender's wallet to the recipient's wallet in the same currency and records it, all or nothing
+// The sender sends in the currency they play in. Returns ErrTransferInsufficientFunds if the sender's wallet is short
+// and a LimitError over the daily cap (base currency, 0 = no cap)
+func CreateTransfer(senderID int, recipientID int, amount float32, message string, dailyCap float32) (*TransferResult, error) {
+	amount = roundToCents(amount)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var currency string
+	if err := tx.QueryRow(`SELECT currency FROM players WHERE id = ?;`, senderID).Scan(&currency); err != nil {
+		return nil, fmt.Errorf("error fetching sender: %v", err)
+	}
+
+	if dailyCap > 0 {
+		var sent float64
+		query := `SELECT COALESCE(SUM(amount * exchangeRate), 0) FROM transfers WHERE senderId = ? AND createdAt >= ?;`
+		err := tx.QueryRow(query, senderID, periodStart(PeriodDaily, time.Now()).Format(sqliteTimeFormat)).Scan(&sent)
+		if err != nil {
+			return nil, fmt.Errorf("error computing transferred amount: %v", err)
+		}
+
+		if remaining := roundToCents(max(dailyCap-float32(sent), 0)); ToBaseCurrency(amount, currency) > remaining {
+			return nil, &LimitError{Code: TransferLimitExceeded, LimitType: "transfer", Period: PeriodDaily, Limit: dailyCap, Remaining: remaining}
+		}
+	}
+
+	result := TransferResult{Transfer: Transfer{SenderID: senderID, RecipientID: recipientID, Amount: amount, Currency: currency, Message: message}}
+
+	query := `UPDATE players SET wallet = ROUND(wallet - ?, 2) WHERE id = ? AND currency = ? AND wallet >= ? RETURNING wallet, betBalance;`
+	err = tx.QueryRow(query, amount, senderID, currency, amount).Scan(&result.SenderWallet, &result.SenderBetBalance)
+	if err == sql.ErrNoRows {
+		return nil, ErrTransferInsufficientFunds
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error debiting sender: %v", err)
+	}
+
+	result.RecipientWallet, result.RecipientBetBalance, result.RecipientActive, err = creditWallet(tx, recipientID, currency, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `INSERT INTO transfers (senderId, recipientId, amount, currency, exchangeRate, message) VALUES (?, ?, ?, ?, ?, ?) RETURNING id, createdAt;`
+	err = tx.QueryRow(query, senderID, recipientID, amount, currency, ExchangeRate(currency), message).Scan(&result.Transfer.ID, &result.Transfer.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error recording transfer: %v", err)
+	}
+
+	reference := fmt.Sprintf("transfer:%d", result.Transfer.ID)
+	err = recordTransaction(tx, senderID, TransactionTransferOut, -amount, result.SenderWallet, result.SenderBetBalance, reference, currency)
+	if err != nil {
+		return nil, err
+	}
+	err = recordTransaction(tx, recipientID, TransactionTransferIn, amount, result.RecipientWallet, result.RecipientBetBalance, reference, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transfer: %v", err)
+	}
+
+	emitBalanceUpdate(senderID, result.SenderWallet, result.SenderBetBalance)
+	notifyWalletUpdate(recipientID, result.RecipientWallet, result.RecipientBetBalance, result.RecipientActive)
+
+	return &result, nil
+}
+
+// GetTransfersByPlayerID returns a page of the transfers the player sent or received, newest first
+func GetTransfersByPlayerID(playerID int, limit int, offset int) ([]Transfer, error) {
+	query := `SELECT t.id, t.senderId, s.name, t.recipientId, r.name, t.amount, t.currency, t.message, t.createdAt
+	          FROM transfers t JOIN players s ON s.id = t.senderId JOIN players r ON r.id = t.recipientId
+	          WHERE t.senderId = ? OR t.recipientId = ? ORDER BY t.id DESC LIMIT ? OFFSET ?;`
+
+	rows, err := DB.Query(query, playerID, playerID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching transfers: %v", err)
+	}
+	defer rows.Close()
+
+	transfers := []Transfer{}
+	for rows.Next() {
+		var transfer Transfer
+		err := rows.Scan(&transfer.ID, &transfer.SenderID, &transfer.SenderName, &transfer.RecipientID, &transfer.RecipientName, &transfer.Amount, &transfer.Currency, &transfer.Message, &transfer.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error reading transfer: %v", err)
+		}
+		transfers = append(transfers, transfer)
+	}
+
+	return transfers, rows.Err()
+}
+
+// GetTransferredToday returns how much the player sent since the start of the day, in the base currency
+func GetTransferredToday(playerID int) (float32, error) {
+	var sent float32
+	query := `SELECT ROUND(COALESCE(SUM(amount * exchangeRate), 0), 2) FROM transfers WHERE senderId = ? AND createdAt >= ?;`
+	if err := DB.QueryRow(query, playerID, periodStart(PeriodDaily, time.Now()).Format(sqliteTimeFormat)).Scan(&sent); err != nil {
+		return 0, fmt.Errorf("error computing transferred amount: %v", err)
+	}
+
+	return sent, nil
+}
+
+func UpdatePlayerAcceptTransfers(id int, acceptTransfers bool) error {
+	_, err := DB.Exec(`UPDATE players SET acceptTransfers = ? WHERE id = ?;`, acceptTransfers, id)
+	if err != nil {
+		return fmt.Errorf("player transfer settings were not updated: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// A player has a wallet per currency but plays in one at a time: the balances of that currency are the players' wallet /
+// betBalance columns (what every balance update works on), the others are parked in the wallets table until the player switches
+
+// Error code sent to clients when the player can't switch currency yet
+const CurrencySwitchBlocked = "CURRENCY_SWITCH_BLOCKED"
+
+// Wallet is a player's balances in one currency
+type Wallet struct {
+	Currency           string  `json:"currency"`
+	Wallet             float32 `json:"wallet"`
+	BetBalance         float32 `json:"betBalance"`
+	PendingWithdrawals float32 `json:"pendingWithdrawals"` // Reserved by withdrawals not paid yet
+	Active             bool    `json:"active"`             // Currency the player is playing in
+}
+
+// CurrencySwitchError is returned when the player has bets still riding on the current currency
+type CurrencySwitchError struct {
+	Message string
+}
+
+func (e *CurrencySwitchError) Error() string {
+	return e.Message
+}
+
+// GetPlayerWallets returns the player's wallets in every supported currency (empty ones included), the active one first
+func GetPlayerWallets(playerID int) ([]Wallet, error) {
+	query := `SELECT currency, wallet, betBalance, true FROM players WHERE id = ?1
+	          UNION ALL
+	          SELECT w.currency, w.wallet, w.betBalance, false FROM wallets w JOIN players p ON p.id = w.playerId
+	          WHERE w.playerId = ?1 AND w.currency != p.currency;`
+
+	rows, err := DB.Query(query, playerID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching wallets: %v", err)
+	}
+
+	byCurrency := make(map[string]Wallet)
+	for rows.Next() {
+		var wallet Wallet
+		if err := rows.Scan(&wallet.Currency, &wallet.Wallet, &wallet.BetBalance, &wallet.Active); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error reading wallet: %v", err)
+		}
+		byCurrency[wallet.Currency] = wallet
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	pending, err := getPendingWithdrawalsByCurrency(playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	wallets := []Wallet{}
+	for _, wallet := range byCurrency {
+		if wallet.Active {
+			wallet.PendingWithdrawals = pending[wallet.Currency]
+			wallets = append(wallets, wallet)
+		}
+	}
+	for _, currency := range GetCurrencies() {
+		wallet, found := byCurrency[currency.Code]
+		if wallet.Active {
+			continue
+		}
+		if !found {
+			wallet = Wallet{Currency: currency.Code}
+		}
+		wallet.PendingWithdrawals = pending[wallet.Currency]
+		wallets = append(wallets, wallet)
+	}
+
+	return wallets, nil
+}
+
+// SwitchPlayerCurrency parks the balances of the player's current currency and makes the currency's wallet the one played with
+// Must be called with the player's processing lock held. Returns a CurrencySwitchError while round / crash bets are open
+func SwitchPlayerCurrency(playerID int, currency string) error {
+	if !IsSupportedCurrency(currency) {
+		return fmt.Errorf("currency %s is not supported", currency)
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var current string
+	var wallet, betBalance float32
+	err = tx.QueryRow(`SELECT currency, wallet, betBalance FROM players WHERE id = ?;`, playerID).Scan(&current, &wallet, &betBalance)
+	if err != nil {
+		return fmt.Errorf("error fetching player: %v", err)
+	}
+	if current == currency {
+		return nil
+	}
+
+	// Bets of the rounds / crash game are settled on the live balances later, they must be settled in their currency
+	var openBets int
+	query := `SELECT (SELECT COUNT(*) FROM dice_round_bets WHERE playerId = ?1 AND status = ?2)
+	               + (SELECT COUNT(*) FROM crash_bets WHERE playerId = ?1 AND (status = ?3 OR (status IN (?4, ?5) AND settledAt IS NULL)));`
+	err = tx.QueryRow(query, playerID, RoundBetPlaced, CrashBetPlaced, CrashBetCashedOut, CrashBetLost).Scan(&openBets)
+	if err != nil {
+		return fmt.Errorf("error checking open bets: %v", err)
+	}
+	if openBets > 0 {
+		return &CurrencySwitchError{Message: fmt.Sprintf("Can't switch to %s while round / crash bets in %s are open", currency, current)}
+	}
+
+	query = `INSERT INTO wallets (playerId, currency, wallet, betBalance) VALUES (?, ?, ?, ?)
+	         ON CONFLICT (playerId, currency) DO UPDATE SET wallet = excluded.wallet, betBalance = excluded.betBalance;`
+	if _, err := tx.Exec(query, playerID, current, wallet, betBalance); err != nil {
+		return fmt.Errorf("error parking wallet: %v", err)
+	}
+
+	// A currency never used before starts empty
+	wallet, betBalance = 0, 0
+	err = tx.QueryRow(`DELETE FROM wallets WHERE playerId = ? AND currency = ? RETURNING wallet, betBalance;`, playerID, currency).Scan(&wallet, &betBalance)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("error fetching wallet: %v", err)
+	}
+
+	if _, err := tx.Exec(`UPDATE players SET currency = ?, wallet = ?, betBalance = ? WHERE id = ?;`, currency, wallet, betBalance, playerID); err != nil {
+		return fmt.Errorf("error switching currency: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing currency switch: %v", err)
+	}
+
+	emitBalanceUpdate(playerID, wallet, betBalance)
+
+	return nil
+}
+
+// creditWallet adds the amount to the player's wallet in the currency, the live one or a parked one
+// Returns the wallet's balances and whether it's the one the player plays with
+func creditWallet(tx *sql.Tx, playerID int, currency string, amount float32) (float32, float32, bool, error) {
+	var wallet, betBalance float32
+	err := tx.QueryRow(`UPDATE players SET wallet = ROUND(wallet + ?, 2) WHERE id = ? AND currency = ? RETURNING wallet, betBalance;`, amount, playerID, currency).
+		Scan(&wallet, &betBalance)
+	if err == nil {
+		return wallet, betBalance, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, 0, false, fmt.Errorf("error crediting wallet: %v", err)
+	}
+
+	query := `INSERT INTO wallets (playerId, currency, wallet) VALUES (?, ?, ROUND(?, 2))
+	          ON CONFLICT (playerId, currency) DO UPDATE SET wallet = ROUND(wallet + excluded.wallet, 2) RETURNING wallet, betBalance;`
+	if err := tx.QueryRow(query, playerID, currency, amount).Scan(&wallet, &betBalance); err != nil {
+		return 0, 0, false, fmt.Errorf("error crediting wallet: %v", err)
+	}
+
+	return wallet, betBalance, false, nil
+}
+
+// notifyWalletUpdate sends the balances to the wallet listeners after creditWallet
+func notifyWalletUpdate(playerID int, wallet float32, betBalance float32, active bool) {
+	if active {
+		emitBalanceUpdate(playerID, wallet, betBalance)
+	} else {
+		NotifyBalanceUpdate(playerID)
+	}
+}
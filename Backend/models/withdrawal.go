@@ -0,0 +1,383 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Withdrawal statuses: requested -> approved -> processing -> paid, or rejected / cancelled before being paid
+const (
+	WithdrawalRequested  = "requested"  // Amount reserved, waiting for approval
+	WithdrawalApproved   = "approved"   // Approved, the payout wasn't sent to the provider yet
+	WithdrawalProcessing = "processing" // Payout sent, waiting for the provider's confirmation
+	WithdrawalPaid       = "paid"       // Payout confirmed by the provider
+	WithdrawalRejected   = "rejected"   // Rejected by an admin or payout failed, the amount went back to the wallet
+	WithdrawalCancelled  = "cancelled"  // Cancelled by the player, the amount went back to the wallet
+)
+
+// Statuses in which the amount is still reserved (pending)
+var openWithdrawalStatuses = []string{WithdrawalRequested, WithdrawalApproved, WithdrawalProcessing}
+
+var ErrWithdrawalInsufficientFunds = errors.New("Insufficient funds for withdrawal")
+
+type Withdrawal struct {
+	ID           int        `json:"id"`
+	PlayerID     int        `json:"playerId"`
+	Amount       float32    `json:"amount"`
+	Currency     string     `json:"currency"`
+	ExchangeRate float32    `json:"exchangeRate"` // Rate to the base currency when it was requested
+	Status       string     `json:"status"`
+	AutoApproved bool       `json:"autoApproved"` // Below WITHDRAWAL_AUTO_APPROVE_THRESHOLD, no admin reviewed it
+	ReviewedBy   *int       `json:"reviewedBy"`
+	ReviewNote   string     `json:"reviewNote"`
+	PaymentID    *int       `json:"paymentId"` // Payout at the provider
+	CreatedAt    time.Time  `json:"createdAt"`
+	ReviewedAt   *time.Time `json:"reviewedAt"`
+	CompletedAt  *time.Time `json:"completedAt"`
+}
+
+const withdrawalColumns = `id, playerId, amount, currency, exchangeRate, status, autoApproved, reviewedBy, reviewNote, paymentId, createdAt, reviewedAt, completedAt`
+
+func scanWithdrawal(row scanner) (*Withdrawal, error) {
+	var withdrawal Withdrawal
+	var reviewedBy, paymentID sql.NullInt64
+	var reviewedAt, completedAt sql.NullTime
+
+	err := row.Scan(&withdrawal.ID, &withdrawal.PlayerID, &withdrawal.Amount, &withdrawal.Currency, &withdrawal.ExchangeRate, &withdrawal.Status, &withdrawal.AutoApproved, &reviewedBy,
+		&withdrawal.ReviewNote, &paymentID, &withdrawal.CreatedAt, &reviewedAt, &completedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if reviewedBy.Valid {
+		reviewerID := int(reviewedBy.Int64)
+		withdrawal.ReviewedBy = &reviewerID
+	}
+	if paymentID.Valid {
+		id := int(paymentID.Int64)
+		withdrawal.PaymentID = &id
+	}
+	if reviewedAt.Valid {
+		withdrawal.ReviewedAt = &reviewedAt.Time
+	}
+	if completedAt.Valid {
+		withdrawal.CompletedAt = &completedAt.Time
+	}
+
+	return &withdrawal, nil
+}
+
+// RequestWithdrawal reserves the amount (takes it from the wallet of the currency the player plays in) and records the request,
+// withdrawing before the wagering requirements are met forfeits the active bonuses. All or nothing
+// Returns the new wallet balance and the forfeited bonus money (base currency), ErrWithdrawalInsufficientFunds if the wallet is short
+func RequestWithdrawal(playerID int, amount float32) (*Withdrawal, float32, float32, error) {
+	amount = roundToCents(amount)
+
+	// Expired bonuses aren't forfeited, they're already gone
+	if err := expirePlayerBonuses(playerID); err != nil {
+		return nil, 0, 0, err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var wallet, betBalance float32
+	var currency string
+	err = tx.QueryRow(`UPDATE players SET wallet = ROUND(wallet - ?, 2) WHERE id = ? AND wallet >= ? RETURNING wallet, betBalance, currency;`, amount, playerID, amount).
+		Scan(&wallet, &betBalance, &currency)
+	if err == sql.ErrNoRows {
+		return nil, 0, 0, ErrWithdrawalInsufficientFunds
+	}
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("error reserving withdrawal: %v", err)
+	}
+
+	query := `INSERT INTO withdrawals (playerId, amount, currency, exchangeRate) VALUES (?, ?, ?, ?) RETURNING ` + withdrawalColumns + `;`
+	withdrawal, err := scanWithdrawal(tx.QueryRow(query, playerID, amount, currency, ExchangeRate(currency)))
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("error creating withdrawal: %v", err)
+	}
+
+	err = recordTransaction(tx, playerID, TransactionWithdraw, -amount, wallet, betBalance, fmt.Sprintf("withdrawal:%d", withdrawal.ID), currency)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	forfeitedBonus, err := forfeitPlayerBonuses(tx, playerID)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, 0, 0, fmt.Errorf("error committing withdrawal: %v", err)
+	}
+
+	emitBalanceUpdate(playerID, wallet, betBalance)
+
+	return withdrawal, wallet, forfeitedBonus, nil
+}
+
+// ApproveWithdrawal moves a requested withdrawal to approved (reviewerID nil = approved automatically)
+// Returns false if it wasn't requested anymore
+func ApproveWithdrawal(id int, reviewerID *int, reviewNote string) (bool, error) {
+	query := `UPDATE withdrawals SET status = ?, autoApproved = ?, reviewedBy = ?, reviewNote = ?, reviewedAt = CURRENT_TIMESTAMP
+	          WHERE id = ? AND status = ?;`
+
+	result, err := DB.Exec(query, WithdrawalApproved, reviewerID == nil, reviewerID, reviewNote, id, WithdrawalRequested)
+	if err != nil {
+		return false, fmt.Errorf("error approving withdrawal: %v", err)
+	}
+
+	updated, _ := result.RowsAffected()
+	return updated == 1, nil
+}
+
+// StartWithdrawalPayout links an approved withdrawal to its payout and moves it to processing, before the payout is sent
+// (so it can't be cancelled meanwhile). Returns false if it wasn't approved anymore
+func StartWithdrawalPayout(id int, paymentID int) (bool, error) {
+	result, err := DB.Exec(`UPDATE withdrawals SET status = ?, paymentId = ? WHERE id = ? AND status = ?;`, WithdrawalProcessing, paymentID, id, WithdrawalApproved)
+	if err != nil {
+		return false, fmt.Errorf("error updating withdrawal: %v", err)
+	}
+
+	updated, _ := result.RowsAffected()
+	return updated == 1, nil
+}
+
+// ReleaseWithdrawalPayout puts a withdrawal whose payout couldn't be sent back to approved
+func ReleaseWithdrawalPayout(id int) error {
+	_, err := DB.Exec(`UPDATE withdrawals SET status = ?, paymentId = NULL WHERE id = ? AND status = ?;`, WithdrawalApproved, id, WithdrawalProcessing)
+	if err != nil {
+		return fmt.Errorf("error updating withdrawal: %v", err)
+	}
+
+	return nil
+}
+
+// ReturnWithdrawal closes a withdrawal that's in one of the fromStatuses as rejected / cancelled and gives the amount
+// back to the wallet, all or nothing. Returns false if the withdrawal wasn't in one of those statuses
+func ReturnWithdrawal(id int, fromStatuses []string, status string, reviewerID *int, reviewNote string) (bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	playerID, err := returnWithdrawal(tx, id, fromStatuses, status, reviewerID, reviewNote)
+	if err != nil || playerID == 0 {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing withdrawal: %v", err)
+	}
+
+	return true, NotifyBalanceUpdate(playerID)
+}
+
+// returnWithdrawal does ReturnWithdrawal's work in the transaction, returns the withdrawal's player (0 if it wasn't returned)
+func returnWithdrawal(tx *sql.Tx, id int, fromStatuses []string, status string, reviewerID *int, reviewNote string) (int, error) {
+	args := []any{status, reviewerID, reviewerID, reviewNote, id}
+	for _, fromStatus := range fromStatuses {
+		args = append(args, fromStatus)
+	}
+
+	// The reviewer is only set when an admin closes it
+	var playerID int
+	var amount float32
+	var currency string
+	query := `UPDATE withdrawals SET status = ?, reviewedBy = COALESCE(?, reviewedBy), reviewedAt = IIF(? IS NULL, reviewedAt, CURRENT_TIMESTAMP),
+	          reviewNote = ?, completedAt = CURRENT_TIMESTAMP
+	          WHERE id = ? AND status IN (?` + strings.Repeat(", ?", len(fromStatuses)-1) + `) RETURNING playerId, amount, currency;`
+	err := tx.QueryRow(query, args...).Scan(&playerID, &amount, &currency)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error closing withdrawal: %v", err)
+	}
+
+	// Back to the wallet of the withdrawal's currency, even if the player switched since
+	wallet, betBalance, _, err := creditWallet(tx, playerID, currency, amount)
+	if err != nil {
+		return 0, err
+	}
+
+	err = recordTransaction(tx, playerID, TransactionWithdrawRefund, amount, wallet, betBalance, fmt.Sprintf("withdrawal:%d", id), currency)
+	if err != nil {
+		return 0, err
+	}
+
+	return playerID, nil
+}
+
+// CompletePayout records the provider's final status of a withdrawal's payout, all or nothing:
+// succeeded -> the withdrawal is paid, failed -> it's rejected and the amount goes back to the wallet
+// Returns false if the payout wasn't pending anymore
+func CompletePayout(paymentID int, succeeded bool) (bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	status := PaymentFailed
+	if succeeded {
+		status = PaymentSucceeded
+	}
+
+	result, err := tx.Exec(`UPDATE payments SET status = ?, completedAt = CURRENT_TIMESTAMP WHERE id = ? AND kind = ? AND status = ?;`, status, paymentID, PaymentPayout, PaymentPending)
+	if err != nil {
+		return false, fmt.Errorf("error completing payment: %v", err)
+	}
+	if updated, _ := result.RowsAffected(); updated != 1 {
+		return false, nil
+	}
+
+	var withdrawalID, playerID int
+	err = tx.QueryRow(`SELECT id, playerId FROM withdrawals WHERE paymentId = ?;`, paymentID).Scan(&withdrawalID, &playerID)
+	if err != nil {
+		return false, fmt.Errorf("error fetching payout's withdrawal: %v", err)
+	}
+
+	if succeeded {
+		_, err = tx.Exec(`UPDATE withdrawals SET status = ?, completedAt = CURRENT_TIMESTAMP WHERE id = ? AND status = ?;`, WithdrawalPaid, withdrawalID, WithdrawalProcessing)
+	} else {
+		_, err = returnWithdrawal(tx, withdrawalID, []string{WithdrawalProcessing}, WithdrawalRejected, nil, "Payout failed at the payment provider")
+	}
+	if err != nil {
+		return false, fmt.Errorf("error updating withdrawal: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing payout: %v", err)
+	}
+
+	// The pending amount changed in both cases
+	return true, NotifyBalanceUpdate(playerID)
+}
+
+func GetWithdrawalByID(id int) (*Withdrawal, error) {
+	withdrawal, err := scanWithdrawal(DB.QueryRow(`SELECT `+withdrawalColumns+` FROM withdrawals WHERE id = ?;`, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("withdrawal with ID %d not found", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching withdrawal: %v", err)
+	}
+
+	return withdrawal, nil
+}
+
+// GetWithdrawalByPaymentID returns the withdrawal paid out by that payment
+func GetWithdrawalByPaymentID(paymentID int) (*Withdrawal, error) {
+	withdrawal, err := scanWithdrawal(DB.QueryRow(`SELECT `+withdrawalColumns+` FROM withdrawals WHERE paymentId = ?;`, paymentID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no withdrawal for payment %d", paymentID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching withdrawal: %v", err)
+	}
+
+	return withdrawal, nil
+}
+
+// GetWithdrawals returns a page of withdrawals, oldest first for the approval queue
+// status and playerID are optional filters (empty / 0 to ignore them)
+func GetWithdrawals(status string, playerID int, limit int, offset int) ([]Withdrawal, error) {
+	query := `SELECT ` + withdrawalColumns + ` FROM withdrawals
+	          WHERE (? = '' OR status = ?) AND (? = 0 OR playerId = ?)
+	          ORDER BY id LIMIT ? OFFSET ?;`
+
+	rows, err := DB.Query(query, status, status, playerID, playerID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching withdrawals: %v", err)
+	}
+	defer rows.Close()
+
+	withdrawals := []Withdrawal{}
+	for rows.Next() {
+		withdrawal, err := scanWithdrawal(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading withdrawal: %v", err)
+		}
+		withdrawals = append(withdrawals, *withdrawal)
+	}
+
+	return withdrawals, rows.Err()
+}
+
+// GetWithdrawalsByPlayerID returns a page of the player's withdrawals, newest first
+func GetWithdrawalsByPlayerID(playerID int, limit int, offset int) ([]Withdrawal, error) {
+	query := `SELECT ` + withdrawalColumns + ` FROM withdrawals WHERE playerId = ? ORDER BY id DESC LIMIT ? OFFSET ?;`
+
+	rows, err := DB.Query(query, playerID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching withdrawals: %v", err)
+	}
+	defer rows.Close()
+
+	withdrawals := []Withdrawal{}
+	for rows.Next() {
+		withdrawal, err := scanWithdrawal(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading withdrawal: %v", err)
+		}
+		withdrawals = append(withdrawals, *withdrawal)
+	}
+
+	return withdrawals, rows.Err()
+}
+
+// GetPendingWithdrawals returns the total the player has reserved in withdrawals that aren't paid / closed yet,
+// in the currency the player plays in
+func GetPendingWithdrawals(playerID int) (float32, error) {
+	var pending float32
+	query := `SELECT ROUND(COALESCE(SUM(amount), 0), 2) FROM withdrawals
+	          WHERE playerId = ?1 AND status IN (?2, ?3, ?4) AND currency = (SELECT currency FROM players WHERE id = ?1);`
+	err := DB.QueryRow(query, playerID, openWithdrawalStatuses[0], openWithdrawalStatuses[1], openWithdrawalStatuses[2]).Scan(&pending)
+	if err != nil {
+		return 0, fmt.Errorf("error computing pending withdrawals: %v", err)
+	}
+
+	return pending, nil
+}
+
+// getPendingWithdrawalsByCurrency returns GetPendingWithdrawals for every currency the player has some in
+func getPendingWithdrawalsByCurrency(playerID int) (map[string]float32, error) {
+	query := `SELECT currency, ROUND(SUM(amount), 2) FROM withdrawals WHERE playerId = ? AND status IN (?, ?, ?) GROUP BY currency;`
+	rows, err := DB.Query(query, playerID, openWithdrawalStatuses[0], openWithdrawalStatuses[1], openWithdrawalStatuses[2])
+	if err != nil {
+		return nil, fmt.Errorf("error computing pending withdrawals: %v", err)
+	}
+	defer rows.Close()
+
+	pending := make(map[string]float32)
+	for rows.Next() {
+		var currency string
+		var amount float32
+		if err := rows.Scan(&currency, &amount); err != nil {
+			return nil, fmt.Errorf("error reading pending withdrawals: %v", err)
+		}
+		pending[currency] = amount
+	}
+
+	return pending, rows.Err()
+}
+
+// GetWithdrawnTotal returns everything the player withdrew or has pending in the base currency, cancelled / rejected withdrawals excluded
+func GetWithdrawnTotal(playerID int) (float32, error) {
+	var withdrawn float32
+	query := `SELECT ROUND(COALESCE(SUM(amount * exchangeRate), 0), 2) FROM withdrawals WHERE playerId = ? AND status NOT IN (?, ?);`
+	if err := DB.QueryRow(query, playerID, WithdrawalRejected, WithdrawalCancelled).Scan(&withdrawn); err != nil {
+		return 0, fmt.Errorf("error computing withdrawn amount: %v", err)
+	}
+
+	return withdrawn, nil
+}
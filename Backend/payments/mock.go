@@ -0,0 +1,314 @@
+package payments
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Mock payment provider: a local HTTP stand-in for a real gateway, started next to the server (MOCK_GATEWAY_PORT)
+
+POST /v1/payments             -> Creates a payment {"kind": "deposit", "amount": 10, "currency": "EUR", "merchantReference": "12"}
+GET  /v1/payments/{reference} -> Status of a payment
+POST /checkout/{reference}    -> Completes a pending payment as the player / bank would {"result": "succeeded" | "failed"}
+
+! Every completed payment is sent to the webhook URL, signed with the shared secret:
+! X-Mock-Signature: t=<unix timestamp>,v1=<hex HMAC-SHA256(secret, timestamp + "." + body)>
+! Webhooks that don't get a 2xx are retried with a growing delay
+? Pending payments are completed as succeeded after MOCK_GATEWAY_AUTO_CONFIRM_SECONDS (0 = only through /checkout)
+*/
+
+// Name of the mock provider (PAYMENT_PROVIDER=mock)
+const MockProviderName = "mock"
+
+// Signed webhooks older than this are rejected (replays)
+const mockWebhookTolerance = 5 * time.Minute
+
+// Webhook deliveries, the delay doubles after each failed attempt
+const (
+	mockWebhookAttempts   = 6
+	mockWebhookFirstDelay = time.Second
+)
+
+// signMockPayload returns the signature of a webhook body sent at the timestamp
+func signMockPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// mockWebhookPayload is the body of the gateway's webhooks
+type mockWebhookPayload struct {
+	Reference         string  `json:"reference"`
+	MerchantReference string  `json:"merchantReference"`
+	Kind              string  `json:"kind"`
+	Amount            float32 `json:"amount"`
+	Currency          string  `json:"currency"`
+	Status            string  `json:"status"`
+}
+
+// MockProvider is the client side of the mock gateway
+type MockProvider struct {
+	gatewayURL string
+	secret     string
+	client     *http.Client
+}
+
+func NewMockProvider(gatewayURL string, secret string) *MockProvider {
+	return &MockProvider{gatewayURL: gatewayURL, secret: secret, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (p *MockProvider) Name() string {
+	return MockProviderName
+}
+
+func (p *MockProvider) CreateDepositIntent(merchantReference string, amount float32, currency string) (Intent, error) {
+	return p.createPayment(KindDeposit, merchantReference, amount, currency)
+}
+
+func (p *MockProvider) InitiatePayout(merchantReference string, amount float32, currency string) (Intent, error) {
+	return p.createPayment(KindPayout, merchantReference, amount, currency)
+}
+
+func (p *MockProvider) createPayment(kind string, merchantReference string, amount float32, currency string) (Intent, error) {
+	body, _ := json.Marshal(map[string]interface{}{"kind": kind, "amount": amount, "currency": currency, "merchantReference": merchantReference})
+
+	response, err := p.client.Post(p.gatewayURL+"/v1/payments", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return Intent{}, fmt.Errorf("error reaching payment provider: %v", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusCreated {
+		return Intent{}, fmt.Errorf("payment provider refused the payment (HTTP %d)", response.StatusCode)
+	}
+
+	var intent struct {
+		Reference   string `json:"reference"`
+		Status      string `json:"status"`
+		CheckoutURL string `json:"checkoutUrl"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&intent); err != nil {
+		return Intent{}, fmt.Errorf("invalid payment provider response: %v", err)
+	}
+
+	return Intent{Reference: intent.Reference, Status: intent.Status, CheckoutURL: intent.CheckoutURL}, nil
+}
+
+func (p *MockProvider) GetStatus(reference string) (string, error) {
+	response, err := p.client.Get(p.gatewayURL + "/v1/payments/" + reference)
+	if err != nil {
+		return "", fmt.Errorf("error reaching payment provider: %v", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("payment provider couldn't find the payment (HTTP %d)", response.StatusCode)
+	}
+
+	var payment mockWebhookPayload
+	if err := json.NewDecoder(response.Body).Decode(&payment); err != nil {
+		return "", fmt.Errorf("invalid payment provider response: %v", err)
+	}
+
+	return payment.Status, nil
+}
+
+func (p *MockProvider) ParseWebhook(r *http.Request) (WebhookEvent, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return WebhookEvent{}, fmt.Errorf("error reading webhook: %v", err)
+	}
+
+	// t=<timestamp>,v1=<signature>
+	var timestamp int64
+	var signature string
+	for _, part := range strings.Split(r.Header.Get("X-Mock-Signature"), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signature = value
+		}
+	}
+
+	if timestamp == 0 || signature == "" {
+		return WebhookEvent{}, errors.New("missing webhook signature")
+	}
+	if age := time.Since(time.Unix(timestamp, 0)); age > mockWebhookTolerance || age < -mockWebhookTolerance {
+		return WebhookEvent{}, errors.New("webhook timestamp is outside the tolerance")
+	}
+	if !hmac.Equal([]byte(signature), []byte(signMockPayload(p.secret, timestamp, body))) {
+		return WebhookEvent{}, errors.New("invalid webhook signature")
+	}
+
+	var payload mockWebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return WebhookEvent{}, fmt.Errorf("invalid webhook body: %v", err)
+	}
+
+	return WebhookEvent{Reference: payload.Reference, Status: payload.Status, Amount: payload.Amount, Currency: payload.Currency}, nil
+}
+
+// mockGateway is the stand-in gateway, payments are only kept in memory
+type mockGateway struct {
+	baseURL     string
+	webhookURL  string
+	secret      string
+	autoConfirm time.Duration
+	payments    map[string]*mockWebhookPayload // Reference -> Payment
+	nextID      int
+	client      *http.Client
+	mu          sync.Mutex
+}
+
+// StartMockGateway serves the mock gateway on the address (e.g. ":8090") and returns its base URL
+// Completed payments are sent to webhookURL, signed with the secret
+func StartMockGateway(address string, webhookURL string, secret string, autoConfirm time.Duration) string {
+	gateway := &mockGateway{
+		baseURL:     "http://localhost" + address,
+		webhookURL:  webhookURL,
+		secret:      secret,
+		autoConfirm: autoConfirm,
+		payments:    make(map[string]*mockWebhookPayload),
+		nextID:      1,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/payments", gateway.handleCreatePayment)
+	mux.HandleFunc("GET /v1/payments/{reference}", gateway.handleGetPayment)
+	mux.HandleFunc("POST /checkout/{reference}", gateway.handleCheckout)
+
+	go func() {
+		if err := http.ListenAndServe(address, mux); err != nil {
+			log.Println("Mock payment gateway stopped:", err)
+		}
+	}()
+
+	return gateway.baseURL
+}
+
+func (g *mockGateway) handleCreatePayment(w http.ResponseWriter, r *http.Request) {
+	var payment mockWebhookPayload
+	if err := json.NewDecoder(r.Body).Decode(&payment); err != nil || payment.Amount <= 0 || payment.Currency == "" ||
+		(payment.Kind != KindDeposit && payment.Kind != KindPayout) {
+		writeMockJSON(w, http.StatusBadRequest, map[string]interface{}{"message": "kind (deposit / payout), a positive amount and a currency are required"})
+		return
+	}
+
+	g.mu.Lock()
+	payment.Reference = fmt.Sprintf("mock_%s_%d", payment.Kind, g.nextID)
+	payment.Status = StatusPending
+	g.payments[payment.Reference] = &payment
+	g.nextID++
+	g.mu.Unlock()
+
+	if g.autoConfirm > 0 {
+		time.AfterFunc(g.autoConfirm, func() { g.complete(payment.Reference, StatusSucceeded) })
+	}
+
+	response := map[string]interface{}{"reference": payment.Reference, "status": payment.Status, "checkoutUrl": ""}
+	if payment.Kind == KindDeposit {
+		response["checkoutUrl"] = g.baseURL + "/checkout/" + payment.Reference
+	}
+	writeMockJSON(w, http.StatusCreated, response)
+}
+
+func (g *mockGateway) handleGetPayment(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	payment, found := g.payments[r.PathValue("reference")]
+	var snapshot mockWebhookPayload
+	if found {
+		snapshot = *payment
+	}
+	g.mu.Unlock()
+
+	if !found {
+		writeMockJSON(w, http.StatusNotFound, map[string]interface{}{"message": "Payment not found"})
+		return
+	}
+
+	writeMockJSON(w, http.StatusOK, snapshot)
+}
+
+func (g *mockGateway) handleCheckout(w http.ResponseWriter, r *http.Request) {
+	var checkout struct {
+		Result string `json:"result"`
+	}
+	json.NewDecoder(r.Body).Decode(&checkout)
+	if checkout.Result != StatusSucceeded && checkout.Result != StatusFailed {
+		writeMockJSON(w, http.StatusBadRequest, map[string]interface{}{"message": "result must be 'succeeded' or 'failed'"})
+		return
+	}
+
+	if !g.complete(r.PathValue("reference"), checkout.Result) {
+		writeMockJSON(w, http.StatusConflict, map[string]interface{}{"message": "Payment not found or not pending anymore"})
+		return
+	}
+
+	writeMockJSON(w, http.StatusOK, map[string]interface{}{"message": "Payment " + checkout.Result})
+}
+
+// complete sets the final status of a pending payment and sends its webhook, false if it wasn't pending
+func (g *mockGateway) complete(reference string, status string) bool {
+	g.mu.Lock()
+	payment, found := g.payments[reference]
+	if !found || payment.Status != StatusPending {
+		g.mu.Unlock()
+		return false
+	}
+	payment.Status = status
+	body, _ := json.Marshal(payment)
+	g.mu.Unlock()
+
+	go g.sendWebhook(body)
+
+	return true
+}
+
+// sendWebhook delivers a signed webhook, retrying until it's acknowledged with a 2xx
+func (g *mockGateway) sendWebhook(body []byte) {
+	delay := mockWebhookFirstDelay
+	for attempt := 1; attempt <= mockWebhookAttempts; attempt++ {
+		timestamp := time.Now().Unix()
+
+		request, _ := http.NewRequest(http.MethodPost, g.webhookURL, bytes.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("X-Mock-Signature", fmt.Sprintf("t=%d,v1=%s", timestamp, signMockPayload(g.secret, timestamp, body)))
+
+		response, err := g.client.Do(request)
+		if err == nil {
+			response.Body.Close()
+			if response.StatusCode >= 200 && response.StatusCode < 300 {
+				return
+			}
+			err = fmt.Errorf("HTTP %d", response.StatusCode)
+		}
+
+		log.Printf("Mock gateway webhook attempt %d failed: %v\n", attempt, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func writeMockJSON(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
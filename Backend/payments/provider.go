@@ -0,0 +1,81 @@
+package payments
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+/*
+Payment providers: deposits and payouts go through a provider, money only moves on our side once the provider
+confirms the payment with a signed webhook (see controllers/paymentController.go)
+
+A provider is registered once at startup with Register and looked up by name (the name is part of the webhook route)
+*/
+
+// Payment statuses, on the provider's side and ours
+const (
+	StatusPending   = "pending"   // Waiting for the provider's confirmation
+	StatusSucceeded = "succeeded" // Confirmed by the provider
+	StatusFailed    = "failed"    // Declined / cancelled at the provider
+)
+
+// Kinds of payments
+const (
+	KindDeposit = "deposit" // Money coming from the player
+	KindPayout  = "payout"  // Money sent to the player
+)
+
+// Intent is a payment created at the provider
+type Intent struct {
+	Reference   string // Provider's ID of the payment
+	Status      string
+	CheckoutURL string // Where the player completes a deposit (empty for payouts)
+}
+
+// WebhookEvent is a verified notification of the provider about one of its payments
+type WebhookEvent struct {
+	Reference string
+	Status    string
+	Amount    float32
+	Currency  string
+}
+
+type PaymentProvider interface {
+	// Name of the provider, as used in the webhook route (/payments/webhook/{name})
+	Name() string
+	// CreateDepositIntent creates a deposit the player then completes at the provider, merchantReference is our payment ID
+	CreateDepositIntent(merchantReference string, amount float32, currency string) (Intent, error)
+	// InitiatePayout asks the provider to send the amount (in the currency, ISO 4217 code) to the player
+	InitiatePayout(merchantReference string, amount float32, currency string) (Intent, error)
+	// GetStatus returns the current status of a payment at the provider
+	GetStatus(reference string) (string, error)
+	// ParseWebhook verifies the signature of a webhook request and returns its event
+	ParseWebhook(r *http.Request) (WebhookEvent, error)
+}
+
+var providers = struct {
+	byName map[string]PaymentProvider
+	mu     sync.Mutex
+}{byName: make(map[string]PaymentProvider)}
+
+// Register makes a provider available by its name
+func Register(provider PaymentProvider) {
+	providers.mu.Lock()
+	defer providers.mu.Unlock()
+
+	providers.byName[provider.Name()] = provider
+}
+
+// Get returns the registered provider with that name
+func Get(name string) (PaymentProvider, error) {
+	providers.mu.Lock()
+	defer providers.mu.Unlock()
+
+	provider, found := providers.byName[name]
+	if !found {
+		return nil, fmt.Errorf("payment provider '%s' not found", name)
+	}
+
+	return provider, nil
+}
@@ -0,0 +1,310 @@
+package risk
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"main/models"
+	"slices"
+	"strings"
+	"time"
+)
+
+/*
+Rules-based risk checks, evaluated on deposits, withdrawals and every bet (see rules.go for the rule set)
+
+Each triggered rule adds its score, the action taken is the most severe of the triggered rules' actions
+and of the action reached by the total score (flagScore / blockScore)
+Flagged and blocked actions are stored in risk_flags for staff to review, flagged ones still go through
+*/
+
+// Events the rules are evaluated on
+const (
+	EventDeposit  = "deposit"
+	EventWithdraw = "withdraw"
+	EventPlay     = "play"
+)
+
+// Actions, from the least to the most severe
+const (
+	ActionAllow = "allow"
+	ActionFlag  = "flag"  // Goes through, stored for review (withdrawals wait for an admin)
+	ActionBlock = "block" // Refused, stored for review
+)
+
+var Actions = []string{ActionAllow, ActionFlag, ActionBlock}
+
+// Flag review statuses
+const (
+	FlagOpen      = "open"
+	FlagConfirmed = "confirmed" // Staff confirmed the suspicion
+	FlagDismissed = "dismissed" // False positive
+)
+
+var FlagStatuses = []string{FlagOpen, FlagConfirmed, FlagDismissed}
+
+// Error code sent to clients whose action was blocked
+const BlockedCode = "RISK_BLOCKED"
+
+// Context is the action being evaluated
+type Context struct {
+	Event    string
+	PlayerID int
+	IP       string  // Empty for bets, the player's known IPs are used instead
+	Amount   float32 // In the base currency
+	Game     string  // Bets only
+}
+
+// Hit is a rule that triggered, with what it measured
+type Hit struct {
+	Rule    string                 `json:"rule"`
+	Type    string                 `json:"type"`
+	Score   int                    `json:"score"`
+	Action  string                 `json:"action"`
+	Details map[string]interface{} `json:"details"`
+}
+
+type Decision struct {
+	Action string
+	Score  int
+	Hits   []Hit
+	FlagID int // Stored flag (0 if allowed)
+}
+
+// BlockedError is returned when the risk checks refused an action
+type BlockedError struct {
+	FlagID int
+}
+
+func (e *BlockedError) Error() string {
+	return "This action was blocked by our risk checks, please contact support"
+}
+
+type Flag struct {
+	ID         int        `json:"id"`
+	PlayerID   int        `json:"playerId"`
+	Event      string     `json:"event"`
+	Action     string     `json:"action"`
+	Score      int        `json:"score"`
+	Hits       []Hit      `json:"hits"`
+	IP         string     `json:"ip"`
+	Amount     float32    `json:"amount"` // In the base currency
+	Status     string     `json:"status"`
+	ReviewedBy *int       `json:"reviewedBy"`
+	ReviewNote string     `json:"reviewNote"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ReviewedAt *time.Time `json:"reviewedAt"`
+}
+
+type FlagFilter struct {
+	Status   string
+	PlayerID int
+	Event    string
+	Limit    int
+	Offset   int
+}
+
+// InitializeTable creates the table of flagged / blocked actions
+func InitializeTable() {
+	query := `
+	CREATE TABLE IF NOT EXISTS risk_flags (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		playerId INTEGER NOT NULL REFERENCES players(id),
+		event TEXT NOT NULL,
+		action TEXT NOT NULL,
+		score INTEGER NOT NULL,
+		rules TEXT NOT NULL,
+		hits TEXT NOT NULL,
+		ip TEXT NOT NULL,
+		amount DECIMAL(10,2) NOT NULL,
+		status TEXT NOT NULL DEFAULT 'open',
+		reviewedBy INTEGER REFERENCES players(id),
+		reviewNote TEXT NOT NULL DEFAULT '',
+		createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		reviewedAt DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS risk_flags_status ON risk_flags (status);
+	CREATE INDEX IF NOT EXISTS risk_flags_player ON risk_flags (playerId);`
+
+	_, err := models.DB.Exec(query)
+	if err != nil {
+		log.Fatal("Error creating risk_flags table:", err)
+	}
+
+	fmt.Println("TABLE Risk Flags Initialized Successfully")
+}
+
+// Evaluate runs the rules of the event and stores the action if it isn't allowed
+// Failing rules are logged and skipped, the checks never stop an action because of their own errors
+func Evaluate(ctx Context) Decision {
+	set := currentRules()
+	decision := Decision{Action: ActionAllow, Hits: []Hit{}}
+
+	for _, rule := range set.Rules {
+		if rule.Disabled || !slices.Contains(rule.Events, ctx.Event) {
+			continue
+		}
+
+		triggered, details, err := evaluateRule(rule, ctx)
+		if err != nil {
+			log.Printf("Error evaluating risk rule %s: %v\n", rule.Name, err)
+			continue
+		}
+		if !triggered {
+			continue
+		}
+
+		decision.Score += rule.Score
+		decision.Hits = append(decision.Hits, Hit{Rule: rule.Name, Type: rule.Type, Score: rule.Score, Action: rule.Action, Details: details})
+		decision.Action = moreSevere(decision.Action, rule.Action)
+	}
+
+	if set.BlockScore > 0 && decision.Score >= set.BlockScore {
+		decision.Action = ActionBlock
+	} else if set.FlagScore > 0 && decision.Score >= set.FlagScore {
+		decision.Action = moreSevere(decision.Action, ActionFlag)
+	}
+
+	if decision.Action != ActionAllow {
+		flagID, err := recordFlag(ctx, decision)
+		if err != nil {
+			log.Println("Error recording risk flag:", err)
+		}
+		decision.FlagID = flagID
+	}
+
+	return decision
+}
+
+// Check evaluates the action and returns a BlockedError if it was blocked
+func Check(ctx Context) error {
+	return Evaluate(ctx).Err()
+}
+
+// Err returns a BlockedError if the action was blocked
+func (d Decision) Err() error {
+	if d.Action == ActionBlock {
+		return &BlockedError{FlagID: d.FlagID}
+	}
+
+	return nil
+}
+
+func moreSevere(action string, other string) string {
+	if slices.Index(Actions, other) > slices.Index(Actions, action) {
+		return other
+	}
+
+	return action
+}
+
+// recordFlag stores the decision, unless the same rules already have an open flag for the player and event
+// (rules evaluated on every bet would otherwise flag each one)
+func recordFlag(ctx Context, decision Decision) (int, error) {
+	ruleNames := []string{}
+	for _, hit := range decision.Hits {
+		ruleNames = append(ruleNames, hit.Rule)
+	}
+	ruleList := strings.Join(ruleNames, ",")
+
+	var id int
+	query := `SELECT id FROM risk_flags WHERE playerId = ? AND event = ? AND action = ? AND rules = ? AND status = ? ORDER BY id DESC LIMIT 1;`
+	err := models.DB.QueryRow(query, ctx.PlayerID, ctx.Event, decision.Action, ruleList, FlagOpen).Scan(&id)
+	if err != sql.ErrNoRows {
+		return id, err
+	}
+
+	hits, err := json.Marshal(decision.Hits)
+	if err != nil {
+		return 0, err
+	}
+
+	query = `INSERT INTO risk_flags (playerId, event, action, score, rules, hits, ip, amount) VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING id;`
+	err = models.DB.QueryRow(query, ctx.PlayerID, ctx.Event, decision.Action, decision.Score, ruleList, string(hits), ctx.IP, ctx.Amount).Scan(&id)
+
+	return id, err
+}
+
+const flagColumns = `id, playerId, event, action, score, hits, ip, amount, status, reviewedBy, reviewNote, createdAt, reviewedAt`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanFlag(row scanner) (*Flag, error) {
+	var flag Flag
+	var hits string
+	var reviewedBy sql.NullInt64
+	var reviewedAt sql.NullTime
+
+	err := row.Scan(&flag.ID, &flag.PlayerID, &flag.Event, &flag.Action, &flag.Score, &hits, &flag.IP, &flag.Amount, &flag.Status, &reviewedBy,
+		&flag.ReviewNote, &flag.CreatedAt, &reviewedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	flag.Hits = []Hit{}
+	json.Unmarshal([]byte(hits), &flag.Hits)
+	if reviewedBy.Valid {
+		reviewerID := int(reviewedBy.Int64)
+		flag.ReviewedBy = &reviewerID
+	}
+	if reviewedAt.Valid {
+		flag.ReviewedAt = &reviewedAt.Time
+	}
+
+	return &flag, nil
+}
+
+// GetFlags returns the flags matching the filter, newest first
+func GetFlags(filter FlagFilter) ([]Flag, error) {
+	query := `SELECT ` + flagColumns + ` FROM risk_flags
+	          WHERE (?1 = '' OR status = ?1) AND (?2 = 0 OR playerId = ?2) AND (?3 = '' OR event = ?3)
+	          ORDER BY id DESC LIMIT ?4 OFFSET ?5;`
+
+	rows, err := models.DB.Query(query, filter.Status, filter.PlayerID, filter.Event, filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching risk flags: %v", err)
+	}
+	defer rows.Close()
+
+	flags := []Flag{}
+	for rows.Next() {
+		flag, err := scanFlag(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading risk flag: %v", err)
+		}
+		flags = append(flags, *flag)
+	}
+
+	return flags, nil
+}
+
+func GetFlagByID(id int) (*Flag, error) {
+	flag, err := scanFlag(models.DB.QueryRow(`SELECT `+flagColumns+` FROM risk_flags WHERE id = ?;`, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("risk flag with ID %d not found", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching risk flag: %v", err)
+	}
+
+	return flag, nil
+}
+
+// ReviewFlag closes an open flag as confirmed or dismissed, false if it wasn't open anymore
+func ReviewFlag(id int, status string, reviewerID int, note string) (bool, error) {
+	query := `UPDATE risk_flags SET status = ?, reviewedBy = ?, reviewNote = ?, reviewedAt = CURRENT_TIMESTAMP WHERE id = ? AND status = ?;`
+	result, err := models.DB.Exec(query, status, reviewerID, note, id, FlagOpen)
+	if err != nil {
+		return false, fmt.Errorf("error reviewing risk flag: %v", err)
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error reviewing risk flag: %v", err)
+	}
+
+	return updated == 1, nil
+}
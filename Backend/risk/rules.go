@@ -0,0 +1,263 @@
+package risk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"main/audit"
+	"main/models"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+/*
+Rule set, read from a JSON file (RISK_RULES_FILE) and read again whenever the file changes, no restart needed
+A file that doesn't parse / validate is logged and the previous rules stay in use
+
+{
+	"flagScore": 50,   // Total score from which the action is at least "flag" (0 = never from the score)
+	"blockScore": 100, // Total score from which the action is "block" (0 = never from the score)
+	"rules": [
+		{"name": "bet_velocity", "type": "velocity", "events": ["play"], "score": 40, "action": "flag", "windowSeconds": 60, "limit": 30}
+	]
+}
+
+Rule types (limit and windowSeconds are required, amounts are in the base currency):
+velocity         -> play: more than limit bets in the window
+deposit_withdraw -> withdraw: deposits made in the window wagered less than limit times (e.g. 1 = once) before withdrawing
+accounts_per_ip  -> deposit / withdraw / play: more than limit accounts registered / logged in from the player's IPs in the window
+win_rate         -> play: win rate above limit (0-1) over the settled bets of the window, once there are minBets of them (game optional)
+*/
+
+// Rule types
+const (
+	RuleVelocity        = "velocity"
+	RuleDepositWithdraw = "deposit_withdraw"
+	RuleAccountsPerIP   = "accounts_per_ip"
+	RuleWinRate         = "win_rate"
+)
+
+// Events each rule type can be evaluated on
+var ruleTypeEvents = map[string][]string{
+	RuleVelocity:        {EventPlay},
+	RuleDepositWithdraw: {EventWithdraw},
+	RuleAccountsPerIP:   {EventDeposit, EventWithdraw, EventPlay},
+	RuleWinRate:         {EventPlay},
+}
+
+type Rule struct {
+	Name          string   `json:"name"`
+	Type          string   `json:"type"`
+	Events        []string `json:"events"`
+	Disabled      bool     `json:"disabled"`
+	Score         int      `json:"score"`  // Added to the total score when the rule triggers
+	Action        string   `json:"action"` // Least action taken when the rule triggers (empty = only the score counts)
+	WindowSeconds int      `json:"windowSeconds"`
+	Limit         float64  `json:"limit"`
+	MinBets       int      `json:"minBets,omitempty"` // win_rate only
+	Game          string   `json:"game,omitempty"`    // win_rate only (empty = every game)
+}
+
+type RuleSet struct {
+	FlagScore  int    `json:"flagScore"`
+	BlockScore int    `json:"blockScore"`
+	Rules      []Rule `json:"rules"`
+}
+
+// Rules in use and the file they were read from
+var rules = struct {
+	set      RuleSet
+	path     string
+	modTime  time.Time
+	loadedAt time.Time
+	lastErr  string // Why the last change of the file was ignored (empty if it was applied)
+	mu       sync.Mutex
+}{}
+
+// LoadRules reads the rule set from the file, the server can't evaluate anything without one
+func LoadRules(path string) error {
+	rules.mu.Lock()
+	defer rules.mu.Unlock()
+
+	rules.path = path
+	return reloadRules()
+}
+
+// currentRules returns the rule set in use, reading the file again if it changed
+func currentRules() RuleSet {
+	rules.mu.Lock()
+	defer rules.mu.Unlock()
+
+	if info, err := os.Stat(rules.path); err == nil && !info.ModTime().Equal(rules.modTime) {
+		if err := reloadRules(); err != nil {
+			log.Println("Risk rules not reloaded, keeping the previous ones:", err)
+		}
+	}
+
+	return rules.set
+}
+
+// reloadRules reads and validates the file, rules.mu must be held
+func reloadRules() error {
+	info, err := os.Stat(rules.path)
+	if err != nil {
+		return fmt.Errorf("error reading risk rules: %v", err)
+	}
+
+	// Not read again until it changes, even if it's invalid
+	rules.modTime = info.ModTime()
+
+	content, err := os.ReadFile(rules.path)
+	if err != nil {
+		rules.lastErr = err.Error()
+		return fmt.Errorf("error reading risk rules: %v", err)
+	}
+
+	var set RuleSet
+	if err := json.Unmarshal(content, &set); err != nil {
+		rules.lastErr = err.Error()
+		return fmt.Errorf("invalid risk rules file: %v", err)
+	}
+	if err := validateRuleSet(set); err != nil {
+		rules.lastErr = err.Error()
+		return fmt.Errorf("invalid risk rules: %v", err)
+	}
+
+	rules.set = set
+	rules.loadedAt = time.Now().UTC()
+	rules.lastErr = ""
+
+	return nil
+}
+
+// RulesStatus returns the rule set in use, the file it was read from, when and why the last change was ignored (if it was)
+func RulesStatus() (RuleSet, string, time.Time, string) {
+	set := currentRules()
+
+	rules.mu.Lock()
+	defer rules.mu.Unlock()
+
+	return set, rules.path, rules.loadedAt, rules.lastErr
+}
+
+func validateRuleSet(set RuleSet) error {
+	if set.FlagScore < 0 || set.BlockScore < 0 {
+		return errors.New("flagScore and blockScore can't be negative")
+	}
+
+	names := []string{}
+	for i, rule := range set.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d has no name", i+1)
+		}
+		if slices.Contains(names, rule.Name) {
+			return fmt.Errorf("rule name %s is used twice", rule.Name)
+		}
+		names = append(names, rule.Name)
+
+		supportedEvents, known := ruleTypeEvents[rule.Type]
+		if !known {
+			return fmt.Errorf("rule %s: unknown type %s", rule.Name, rule.Type)
+		}
+		if len(rule.Events) == 0 {
+			return fmt.Errorf("rule %s: events are required", rule.Name)
+		}
+		for _, event := range rule.Events {
+			if !slices.Contains(supportedEvents, event) {
+				return fmt.Errorf("rule %s: %s rules can only be evaluated on %v", rule.Name, rule.Type, supportedEvents)
+			}
+		}
+
+		if rule.Action != "" && !slices.Contains(Actions, rule.Action) {
+			return fmt.Errorf("rule %s: action must be one of %v", rule.Name, Actions)
+		}
+		if rule.Score < 0 {
+			return fmt.Errorf("rule %s: score can't be negative", rule.Name)
+		}
+		if rule.WindowSeconds <= 0 || rule.Limit <= 0 {
+			return fmt.Errorf("rule %s: windowSeconds and limit must be greater than 0", rule.Name)
+		}
+		if rule.Type == RuleWinRate && (rule.Limit > 1 || rule.MinBets <= 0) {
+			return fmt.Errorf("rule %s: win_rate rules need a limit between 0 and 1 and minBets", rule.Name)
+		}
+		if rule.Game != "" && !slices.Contains(models.Games, rule.Game) {
+			return fmt.Errorf("rule %s: game must be one of %v", rule.Name, models.Games)
+		}
+	}
+
+	return nil
+}
+
+// Format of DATETIME columns filled by CURRENT_TIMESTAMP
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+// evaluateRule checks whether the rule triggers for the context, with what it measured
+func evaluateRule(rule Rule, ctx Context) (bool, map[string]interface{}, error) {
+	since := time.Now().UTC().Add(-time.Duration(rule.WindowSeconds) * time.Second)
+
+	switch rule.Type {
+	case RuleVelocity:
+		var bets int
+		err := models.DB.QueryRow(`SELECT COUNT(*) FROM bets WHERE playerId = ? AND createdAt >= ?;`, ctx.PlayerID, since.Format(sqliteTimeFormat)).Scan(&bets)
+		if err != nil {
+			return false, nil, fmt.Errorf("error counting bets: %v", err)
+		}
+
+		// The bet being placed counts too
+		bets++
+		return float64(bets) > rule.Limit, map[string]interface{}{"bets": bets}, nil
+
+	case RuleDepositWithdraw:
+		var deposited float64
+		var firstDeposit *string
+		query := `SELECT COALESCE(SUM(amount * exchangeRate), 0), MIN(createdAt) FROM transactions WHERE playerId = ? AND type = 'deposit' AND createdAt >= ?;`
+		err := models.DB.QueryRow(query, ctx.PlayerID, since.Format(sqliteTimeFormat)).Scan(&deposited, &firstDeposit)
+		if err != nil {
+			return false, nil, fmt.Errorf("error summing deposits: %v", err)
+		}
+		if firstDeposit == nil || deposited <= 0 {
+			return false, nil, nil
+		}
+
+		var wagered float64
+		err = models.DB.QueryRow(`SELECT COALESCE(SUM(betAmount * exchangeRate), 0) FROM bets WHERE playerId = ? AND createdAt >= ?;`, ctx.PlayerID, *firstDeposit).Scan(&wagered)
+		if err != nil {
+			return false, nil, fmt.Errorf("error summing wagers: %v", err)
+		}
+
+		return wagered < rule.Limit*deposited, map[string]interface{}{"deposited": deposited, "wagered": wagered}, nil
+
+	case RuleAccountsPerIP:
+		// Other accounts seen on any IP the player registered / logged in from (or is using now)
+		query := `SELECT COUNT(DISTINCT actorId) FROM audit_log
+		          WHERE action IN (?1, ?2) AND actorId NOT IN (0, ?3) AND createdAt >= ?4 AND ip != ''
+		            AND ip IN (SELECT ip FROM audit_log WHERE actorId = ?3 AND action IN (?1, ?2) AND createdAt >= ?4 UNION SELECT ?5);`
+		var otherAccounts int
+		err := models.DB.QueryRow(query, audit.ActionRegister, audit.ActionLoginSuccess, ctx.PlayerID, since.Format(time.RFC3339), ctx.IP).Scan(&otherAccounts)
+		if err != nil {
+			return false, nil, fmt.Errorf("error counting accounts per IP: %v", err)
+		}
+
+		accounts := otherAccounts + 1
+		return float64(accounts) > rule.Limit, map[string]interface{}{"accounts": accounts}, nil
+
+	case RuleWinRate:
+		var bets, wins int
+		query := `SELECT COUNT(*), COALESCE(SUM(playerWin), 0) FROM bets WHERE playerId = ?1 AND createdAt >= ?2 AND (?3 = '' OR game = ?3);`
+		err := models.DB.QueryRow(query, ctx.PlayerID, since.Format(sqliteTimeFormat), rule.Game).Scan(&bets, &wins)
+		if err != nil {
+			return false, nil, fmt.Errorf("error computing win rate: %v", err)
+		}
+		if bets < rule.MinBets {
+			return false, nil, nil
+		}
+
+		winRate := float64(wins) / float64(bets)
+		return winRate > rule.Limit, map[string]interface{}{"bets": bets, "wins": wins, "winRate": winRate}, nil
+	}
+
+	return false, nil, fmt.Errorf("unknown rule type %s", rule.Type)
+}